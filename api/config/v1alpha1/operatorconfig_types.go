@@ -0,0 +1,76 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+// OperandImages defines the default images used for the DirectPV operand.
+// The environment variables set on the manager take precedence over these values.
+type OperandImages struct {
+	// DirectPV is the default image of the node-server, node-controller and controller containers
+	DirectPV string `json:"directpv,omitempty"`
+
+	// CSIResizer is the default image of the csi-resizer sidecar
+	CSIResizer string `json:"csiResizer,omitempty"`
+
+	// CSIProvisioner is the default image of the csi-provisioner sidecar
+	CSIProvisioner string `json:"csiProvisioner,omitempty"`
+
+	// CSINodeDriverRegistrar is the default image of the node-driver-registrar sidecar
+	CSINodeDriverRegistrar string `json:"csiNodeDriverRegistrar,omitempty"`
+
+	// CSISnapshotter is the default image of the csi-snapshotter sidecar
+	CSISnapshotter string `json:"csiSnapshotter,omitempty"`
+
+	// CSIHealthMonitor is the default image of the csi-external-health-monitor-controller sidecar
+	CSIHealthMonitor string `json:"csiHealthMonitor,omitempty"`
+
+	// SnapshotController is the default image of the snapshot-controller installed with the
+	// VolumeSnapshot CRDs
+	SnapshotController string `json:"snapshotController,omitempty"`
+
+	// LivenessProbe is the default image of the liveness-probe sidecar
+	LivenessProbe string `json:"livenessProbe,omitempty"`
+
+	// TLSProxy is the default image of the proxy sidecars terminating TLS for the operand
+	// metrics and readiness endpoints
+	TLSProxy string `json:"tlsProxy,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OperatorConfig is the Schema for the operator's component config file
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Images defines the default operand images
+	Images OperandImages `json:"images,omitempty"`
+
+	// FIPSImages defines the default FIPS-validated operand images, used for the Deployers
+	// setting spec.fips. The environment variables suffixed with _FIPS take precedence.
+	FIPSImages OperandImages `json:"fipsImages,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeHealth is the health of the DirectPV storage of a node
+type NodeHealth string
+
+const (
+	// NodeHealthHealthy means the node is ready and all its drives are Ready
+	NodeHealthHealthy NodeHealth = "Healthy"
+	// NodeHealthDegraded means some drives of the node are not Ready, or DirectPV did not
+	// report the node yet
+	NodeHealthDegraded NodeHealth = "Degraded"
+	// NodeHealthNotReady means the node itself is not ready or was deleted
+	NodeHealthNotReady NodeHealth = "NotReady"
+)
+
+// DeployerSummary reports a Deployer of the cluster
+type DeployerSummary struct {
+	// Namespace of the Deployer
+	Namespace string `json:"namespace"`
+
+	// Name of the Deployer
+	Name string `json:"name"`
+
+	// InstalledVersion is the DirectPV version run by the node-server pods of the Deployer
+	InstalledVersion string `json:"installedVersion,omitempty"`
+
+	// Available reports whether the Available condition of the Deployer is true
+	Available bool `json:"available"`
+}
+
+// NodeStorageStatus reports the DirectPV storage of a node
+type NodeStorageStatus struct {
+	// Name of the node
+	Name string `json:"name"`
+
+	// Health of the storage of the node
+	Health NodeHealth `json:"health"`
+
+	// Drives is the number of DirectPV drives of the node
+	Drives int32 `json:"drives"`
+
+	// DrivesReady is the number of drives in the Ready state
+	DrivesReady int32 `json:"drivesReady"`
+
+	// CapacityTotal is the total capacity of the drives of the node
+	CapacityTotal resource.Quantity `json:"capacityTotal"`
+
+	// CapacityFree is the capacity of the drives of the node not allocated to volumes
+	CapacityFree resource.Quantity `json:"capacityFree"`
+}
+
+// DriveUtilization reports the usage of a DirectPV drive
+type DriveUtilization struct {
+	// Name is the DirectPV identifier of the drive
+	Name string `json:"name"`
+
+	// Node of the drive
+	Node string `json:"node"`
+
+	// Device is the device name of the drive
+	Device string `json:"device,omitempty"`
+
+	// Status is the DirectPV status of the drive, e.g. Ready or Lost
+	Status string `json:"status,omitempty"`
+
+	// CapacityTotal is the capacity of the drive
+	CapacityTotal resource.Quantity `json:"capacityTotal"`
+
+	// CapacityAllocated is the capacity of the drive allocated to volumes
+	CapacityAllocated resource.Quantity `json:"capacityAllocated"`
+
+	// UsagePercent is the allocated capacity of the drive in percent of its capacity
+	UsagePercent int32 `json:"usagePercent"`
+}
+
+// DirectPVClusterStatusSpec is empty, the object is maintained by the operator
+type DirectPVClusterStatusSpec struct{}
+
+// DirectPVClusterStatusStatus defines the aggregated state of DirectPV in the cluster
+type DirectPVClusterStatusStatus struct {
+	// LastUpdateTime is the time the aggregated state last changed
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// Deployers are the Deployers of the cluster with their operand versions
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Deployers []DeployerSummary `json:"deployers,omitempty"`
+
+	// Nodes reports the health and the capacity of the nodes with DirectPV storage
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Nodes []NodeStorageStatus `json:"nodes,omitempty"`
+
+	// Drives reports the utilization of the DirectPV drives
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Drives []DriveUtilization `json:"drives,omitempty"`
+
+	// Volumes is the number of DirectPV volumes
+	Volumes int32 `json:"volumes"`
+
+	// CapacityTotal is the total capacity of the drives of the cluster
+	CapacityTotal resource.Quantity `json:"capacityTotal"`
+
+	// CapacityFree is the capacity of the drives of the cluster not allocated to volumes
+	CapacityFree resource.Quantity `json:"capacityFree"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Volumes",type=integer,JSONPath=`.status.volumes`
+//+kubebuilder:printcolumn:name="Total",type=string,JSONPath=`.status.capacityTotal`
+//+kubebuilder:printcolumn:name="Free",type=string,JSONPath=`.status.capacityFree`
+//+kubebuilder:printcolumn:name="Updated",type=date,JSONPath=`.status.lastUpdateTime`
+
+// DirectPVClusterStatus aggregates the state of DirectPV across the cluster, so dashboards
+// can watch a single object. The operator maintains the one named cluster.
+type DirectPVClusterStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DirectPVClusterStatusSpec   `json:"spec,omitempty"`
+	Status DirectPVClusterStatusStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DirectPVClusterStatusList contains a list of DirectPVClusterStatus
+type DirectPVClusterStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DirectPVClusterStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DirectPVClusterStatus{}, &DirectPVClusterStatusList{})
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EvacuationPolicy defines how the volumes of a decommissioned drive are evacuated
+type EvacuationPolicy string
+
+const (
+	// EvacuationPolicyWait waits for the volumes to be deleted, e.g. by the workloads
+	// moving their data off the drive
+	EvacuationPolicyWait EvacuationPolicy = "Wait"
+	// EvacuationPolicyDeleteClaims deletes the claims of the volumes and the pods using them.
+	// The data of the volumes is lost, the workloads provision new volumes on other drives.
+	EvacuationPolicyDeleteClaims EvacuationPolicy = "DeleteClaims"
+)
+
+// DriveReference references a DirectPV drive, by ID or by node and device name
+type DriveReference struct {
+	// ID is the DirectPV identifier of the drive
+	ID string `json:"id,omitempty"`
+
+	// Node of the drive, used with Device
+	Node string `json:"node,omitempty"`
+
+	// Device is the device name of the drive on the node, e.g. nvme1n1
+	Device string `json:"device,omitempty"`
+}
+
+// DriveDecommissionSpec defines the drive to decommission
+type DriveDecommissionSpec struct {
+	// Drive is the drive to decommission
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Drive DriveReference `json:"drive"`
+
+	// Evacuation defines how the volumes of the drive are evacuated before releasing it
+	// +kubebuilder:default=Wait
+	// +kubebuilder:validation:Enum=Wait;DeleteClaims
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Evacuation EvacuationPolicy `json:"evacuation,omitempty"`
+}
+
+// DriveDecommissionPhase is the phase of a DriveDecommission
+type DriveDecommissionPhase string
+
+const (
+	// DriveDecommissionPhasePending means the drive was not found
+	DriveDecommissionPhasePending DriveDecommissionPhase = "Pending"
+	// DriveDecommissionPhaseEvacuating means the drive is cordoned and still has volumes
+	DriveDecommissionPhaseEvacuating DriveDecommissionPhase = "Evacuating"
+	// DriveDecommissionPhaseReleasing means DirectPV is releasing the drive
+	DriveDecommissionPhaseReleasing DriveDecommissionPhase = "Releasing"
+	// DriveDecommissionPhaseWiping means the device of the released drive is wiped
+	DriveDecommissionPhaseWiping DriveDecommissionPhase = "Wiping"
+	// DriveDecommissionPhaseCompleted means the drive was released and can be replaced
+	DriveDecommissionPhaseCompleted DriveDecommissionPhase = "Completed"
+)
+
+// DriveDecommissionStatus defines the observed state of DriveDecommission
+type DriveDecommissionStatus struct {
+	// Phase of the decommission
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase DriveDecommissionPhase `json:"phase,omitempty"`
+
+	// DriveID is the DirectPV identifier of the decommissioned drive
+	DriveID string `json:"driveID,omitempty"`
+
+	// Node of the decommissioned drive
+	Node string `json:"node,omitempty"`
+
+	// Volumes is the number of volumes left on the drive
+	Volumes int32 `json:"volumes"`
+
+	// Wipe reports the wipe of the device of the drive, when the Deployer of its node has a
+	// wipe policy
+	Wipe *DriveWipeStatus `json:"wipe,omitempty"`
+
+	// Conditions report the steps of the decommission: Cordoned, Evacuated, Released and Wiped
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// Message describes the last error encountered, if any
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Drive",type=string,JSONPath=`.status.driveID`
+//+kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.node`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DriveDecommission decommissions a DirectPV drive, e.g. to replace it. The operator cordons
+// the drive, evacuates its volumes and releases it, like kubectl directpv cordon and remove,
+// and wipes its device according to the wipe policy of the Deployer of the node.
+type DriveDecommission struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DriveDecommissionSpec   `json:"spec,omitempty"`
+	Status DriveDecommissionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DriveDecommissionList contains a list of DriveDecommission
+type DriveDecommissionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DriveDecommission `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DriveDecommission{}, &DriveDecommissionList{})
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DriveInitSpec defines the devices to initialize as DirectPV drives
+type DriveInitSpec struct {
+	// Selectors select the devices to initialize among the devices discovered by DirectPV,
+	// a device is initialized when it matches any of them
+	// +kubebuilder:validation:MinItems=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Selectors []DriveSelector `json:"selectors"`
+
+	// Force formats the devices even if they already contain a filesystem, their data is lost
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Force bool `json:"force,omitempty"`
+}
+
+// DriveSelector selects devices discovered by DirectPV. All the fields of the selector must
+// match, an empty selector matches all the available devices.
+type DriveSelector struct {
+	// Node is a glob pattern of the names of the nodes of the devices
+	Node string `json:"node,omitempty"`
+
+	// Device is a glob pattern of the names of the devices, e.g. nvme*
+	Device string `json:"device,omitempty"`
+
+	// MinSize is the minimum size of the devices
+	MinSize *resource.Quantity `json:"minSize,omitempty"`
+}
+
+// DriveInitPhase is the phase of a DriveInit
+type DriveInitPhase string
+
+const (
+	// DriveInitPhasePending means no available device matches the selectors yet
+	DriveInitPhasePending DriveInitPhase = "Pending"
+	// DriveInitPhaseInitializing means selected devices are being encrypted or formatted by DirectPV
+	DriveInitPhaseInitializing DriveInitPhase = "Initializing"
+	// DriveInitPhaseCompleted means all the selected devices were initialized
+	DriveInitPhaseCompleted DriveInitPhase = "Completed"
+	// DriveInitPhaseFailed means some selected devices failed to initialize
+	DriveInitPhaseFailed DriveInitPhase = "Failed"
+)
+
+// DriveInitState is the state of the initialization of a device
+type DriveInitState string
+
+const (
+	// DriveInitStateEncrypting means the device is encrypted before DirectPV formats it
+	DriveInitStateEncrypting DriveInitState = "Encrypting"
+	// DriveInitStatePending means the device waits for DirectPV to format it
+	DriveInitStatePending DriveInitState = "Pending"
+	// DriveInitStateInitialized means the device was formatted and is a DirectPV drive
+	DriveInitStateInitialized DriveInitState = "Initialized"
+	// DriveInitStateFailed means DirectPV failed to format the device
+	DriveInitStateFailed DriveInitState = "Failed"
+)
+
+// DriveInitResult reports the initialization of a selected device
+type DriveInitResult struct {
+	// Node of the device
+	Node string `json:"node"`
+
+	// Device is the name of the device
+	Device string `json:"device"`
+
+	// ID is the DirectPV identifier of the device
+	ID string `json:"id"`
+
+	// EncryptedDevice is the name of the device mapper device of the encrypted device, the one
+	// DirectPV formats
+	EncryptedDevice string `json:"encryptedDevice,omitempty"`
+
+	// Request is the DirectPVInitRequest initializing the device, or the Job encrypting it
+	Request string `json:"request,omitempty"`
+
+	// State of the initialization
+	State DriveInitState `json:"state"`
+
+	// Error reported by DirectPV when the initialization failed
+	Error string `json:"error,omitempty"`
+}
+
+// DriveInitStatus defines the observed state of DriveInit
+type DriveInitStatus struct {
+	// ObservedGeneration is the generation of the spec the devices were selected for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase of the initialization
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase DriveInitPhase `json:"phase,omitempty"`
+
+	// Drives reports the initialization of the selected devices
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Drives []DriveInitResult `json:"drives,omitempty"`
+
+	// Message describes the last error encountered, if any
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DriveInit declares devices to initialize as DirectPV drives. The operator creates the
+// DirectPVInitRequests of the selected devices, replacing manual kubectl directpv init runs.
+// It must be in the namespace of the Deployer running DirectPV on the nodes of the devices,
+// the devices of the other nodes fail.
+type DriveInit struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DriveInitSpec   `json:"spec,omitempty"`
+	Status DriveInitStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DriveInitList contains a list of DriveInit
+type DriveInitList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DriveInit `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DriveInit{}, &DriveInitList{})
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -34,13 +37,1328 @@ type DeployerSpec struct {
 	// +kubebuilder:validation:Maximum=5
 	// +kubebuilder:validation:ExclusiveMaximum=false
 
-	// Size defines the number of Deployer instances
+	// Size is the number of controller pods when controller.replicas is not set.
+	// Deprecated: use controller.replicas, the DeprecatedFields condition reports its use.
+	// +kubebuilder:validation:Minimum=0
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	Size int32 `json:"size,omitempty"`
 
 	// Port defines the port that will be used to init the container with the image
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	ContainerPort int32 `json:"containerPort,omitempty"`
+
+	// NodeSelector restricts the node-server DaemonSet to the nodes matching these labels.
+	// Several Deployer instances with disjoint selectors can be used to run independent
+	// node pools, each with its own DirectPV version or configuration.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// KubeconfigSecret deploys DirectPV into the remote cluster of the kubeconfig held by the
+	// Secret, in the namespace of the Deployer. The Deployer, its status and events stay in
+	// this cluster. The objects of the remote cluster are not watched, they are reconciled
+	// every minute.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	KubeconfigSecret *KubeconfigSecretReference `json:"kubeconfigSecret,omitempty"`
+
+	// NodePools restricts the nodes of the Deployer to the nodes of the given Cluster API
+	// MachineDeployments, in addition to nodeSelector. The node-server DaemonSet is not rolled
+	// out while one of them is rolling out its machines.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	NodePools []NodePoolSpec `json:"nodePools,omitempty"`
+
+	// Upgrade defines how new operand images are rolled out to the nodes
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Upgrade *UpgradeSpec `json:"upgrade,omitempty"`
+
+	// UpgradePolicy defines whether new operand images are rolled out automatically or
+	// held until approved through approvedImage
+	// +kubebuilder:default=Automatic
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	UpgradePolicy UpgradePolicy `json:"upgradePolicy,omitempty"`
+
+	// Remediation defines whether the operator reverts the changes made to the operand
+	// workloads outside of the Deployer. In Warn mode the workloads are not updated, their
+	// differences with the rendered ones are reported by the DriftDetected condition.
+	// +kubebuilder:default=Enforce
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Remediation RemediationMode `json:"remediation,omitempty"`
+
+	// FeatureGates toggle experimental features: StorageCapacity publishes the capacity of
+	// the drives for the scheduler, VolumeSnapshots and HealthMonitor enable the CSI features
+	// of the same name with their default settings, DriveEncryption set to false disables
+	// drives.encryption. A gate set to false disables its feature whatever the rest of the
+	// spec, an unset gate leaves it to the spec. Unknown gates are ignored.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+	// MaintenanceWindows restrict the changes restarting the operand pods, e.g. new images or
+	// arguments, to the given windows. They are queued in status.pendingChanges until the next
+	// window, the other changes are applied right away. Without window changes are applied
+	// as soon as they are made.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	MaintenanceWindows []MaintenanceWindowSpec `json:"maintenanceWindows,omitempty"`
+
+	// IgnoreDifferences are fields of the operand workloads managed by other controllers, e.g.
+	// the resources set by a VerticalPodAutoscaler or the annotations of a service mesh. The
+	// operator keeps their live values and does not report them as drift.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	IgnoreDifferences []IgnoreDifferenceSpec `json:"ignoreDifferences,omitempty"`
+
+	// ApprovedImage approves the rollout of the operand images when the upgrade policy is
+	// Manual. It is set to the hash of the images of all the containers of the node-server
+	// DaemonSets and of the controller Deployment reported by the UpgradePending condition,
+	// the images are listed in status.pendingImages.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ApprovedImage string `json:"approvedImage,omitempty"`
+
+	// AdoptionPolicy defines what happens when DirectPV was already installed without the
+	// operator, e.g. via the krew plugin or Helm. Refuse blocks the installation, Adopt
+	// manages the existing objects instead of creating duplicates.
+	// +kubebuilder:default=Refuse
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// MigrateLegacyDirectCSI converts the drives and volumes of a legacy direct-csi installation
+	// to DirectPV and drops the legacy /var/lib/direct-csi mount once done
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	MigrateLegacyDirectCSI bool `json:"migrateLegacyDirectCSI,omitempty"`
+
+	// MountLegacyDirectCSI keeps the legacy /var/lib/direct-csi mount of the node-server
+	// pods. It is otherwise only mounted while migrating the legacy direct-csi objects.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	MountLegacyDirectCSI bool `json:"mountLegacyDirectCSI,omitempty"`
+
+	// Uninstall defines what happens to the drives and volumes when the Deployer is deleted
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Uninstall *UninstallSpec `json:"uninstall,omitempty"`
+
+	// PodSecurity defines the Pod Security Admission labels the operator maintains on the
+	// namespace of the Deployer. The node-server pods are privileged and are rejected unless
+	// the namespace enforces the privileged level.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PodSecurity *PodSecuritySpec `json:"podSecurity,omitempty"`
+
+	// SELinux defines the SELinux configuration of the operand pods and of the CSI driver,
+	// needed on nodes with SELinux enforcing like RHEL or OpenShift
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	SELinux *SELinuxSpec `json:"seLinux,omitempty"`
+
+	// CommonLabels are added to every object the operator renders and to the operand pods,
+	// e.g. for cost attribution or backup selectors
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+
+	// CommonAnnotations are added to every object the operator renders and to the operand pods
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+
+	// SecurityProfile defines how the operand containers are hardened. Legacy renders every
+	// container privileged like the DirectPV manifests, Restricted keeps only the node-server
+	// containers privileged and runs the others with read-only root filesystems, without
+	// capabilities and, where they do not share a socket with a privileged container, as non-root.
+	// +kubebuilder:default=Legacy
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// PodSecurityContext defines pod level security settings of the operand pods
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PodSecurityContext *PodSecurityContextSpec `json:"podSecurityContext,omitempty"`
+
+	// NodeServer defines settings specific to the node-server DaemonSet
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	NodeServer *NodeServerSpec `json:"nodeServer,omitempty"`
+
+	// Controller defines settings specific to the controller Deployment
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Controller *ControllerSpec `json:"controller,omitempty"`
+
+	// LogLevel defines the klog verbosity of the operand containers. DirectPV and the CSI
+	// sidecars read it only at startup, changing it rolls out the operand pods.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	LogLevel *LogLevelSpec `json:"logLevel,omitempty"`
+
+	// LogFormat is the format of the operand logs. JSON renders --logging-format=json and
+	// needs DirectPV and CSI sidecar versions supporting the flag. The operator logs are
+	// configured with its --log-format flag.
+	// +kubebuilder:default=Text
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	LogFormat LogFormat `json:"logFormat,omitempty"`
+
+	// Monitoring defines the Prometheus Operator objects scraping the node-server metrics
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+
+	// Sidecars defines settings of the CSI sidecar containers
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Sidecars *SidecarsSpec `json:"sidecars,omitempty"`
+
+	// DNSPolicy is the DNS policy of the operand pods. It defaults to ClusterFirst, or to
+	// ClusterFirstWithHostNet for the node-server pods when nodeServer.hostNetwork is set.
+	// +kubebuilder:validation:Enum=ClusterFirstWithHostNet;ClusterFirst;Default;None
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DNSPolicy corev1.DNSPolicy `json:"dnsPolicy,omitempty"`
+
+	// DNSConfig defines the DNS parameters of the operand pods merged with the ones generated
+	// from the DNS policy, e.g. the resolvers of nodes with a custom DNS setup
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty"`
+
+	// Proxy defines the egress proxy of the operand containers, set in clusters where
+	// outbound connections must go through a proxy
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Proxy *ProxySpec `json:"proxy,omitempty"`
+
+	// TrustedCA mounts a CA bundle into the operand containers and points SSL_CERT_FILE to it,
+	// needed with TLS-intercepting proxies or private registries signed by an internal CA
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	TrustedCA *TrustedCASpec `json:"trustedCA,omitempty"`
+
+	// TLS serves the node-server metrics and the readiness endpoints of the operand pods over
+	// TLS, terminated by a proxy sidecar with certificates provisioned by the operator
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// CSI defines optional CSI features of the driver
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	CSI *CSISpec `json:"csi,omitempty"`
+
+	// StorageClasses are the StorageClasses provisioning DirectPV volumes rendered for the
+	// Deployer. StorageClasses removed from the list are deleted, the provisioned volumes stay.
+	// +listType=map
+	// +listMapKey=name
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	StorageClasses []StorageClassSpec `json:"storageClasses,omitempty"`
+
+	// Topology defines the CSI topology of the node pool and how the provisioner uses it
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Topology *TopologySpec `json:"topology,omitempty"`
+
+	// Architectures are the CPU architectures of the nodes running DirectPV, matched on the
+	// kubernetes.io/arch node label. Nodes of other architectures are skipped. Defaults to
+	// amd64, arm64 and ppc64le, the architectures DirectPV images are published for.
+	// +listType=map
+	// +listMapKey=name
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Architectures []ArchitectureSpec `json:"architectures,omitempty"`
+
+	// ExcludedNodeLabels leaves out the nodes carrying any of the labels from the node-server
+	// DaemonSet. An empty value excludes the nodes with the label whatever its value. Only
+	// Linux nodes run DirectPV, other operating systems are always excluded. Single nodes are
+	// excluded without editing the Deployer by labelling them directpv.min.io/exclude=true.
+	// Their drives and volumes stay in place and are served again once the label is removed.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ExcludedNodeLabels map[string]string `json:"excludedNodeLabels,omitempty"`
+
+	// Drives defines the desired state of the DirectPV drives on the nodes of the Deployer
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Drives *DrivesSpec `json:"drives,omitempty"`
+
+	// VolumeCleanup purges the DirectPV volumes left behind by deleted claims, i.e. whose
+	// PersistentVolume was released or deleted, like kubectl directpv clean
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	VolumeCleanup *VolumeCleanupSpec `json:"volumeCleanup,omitempty"`
+
+	// VolumeLimits caps the number of volumes provisioned on a drive and on a node
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	VolumeLimits *VolumeLimitsSpec `json:"volumeLimits,omitempty"`
+
+	// Alerts defines the thresholds raising the CapacityPressure condition
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Alerts *AlertsSpec `json:"alerts,omitempty"`
+
+	// Maintenance defines the periodic checks of the DirectPV drives
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Maintenance *MaintenanceSpec `json:"maintenance,omitempty"`
+
+	// CapacityRefreshInterval is how often status.capacity is refreshed from the DirectPV
+	// drives, 5m by default
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	CapacityRefreshInterval *metav1.Duration `json:"capacityRefreshInterval,omitempty"`
+
+	// Telemetry periodically reports anonymized install metadata to the maintainers. It is
+	// disabled unless explicitly enabled.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Telemetry *TelemetrySpec `json:"telemetry,omitempty"`
+
+	// NodeRemoval defines the removal of the DirectPV objects of the nodes deleted from the cluster
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	NodeRemoval *NodeRemovalSpec `json:"nodeRemoval,omitempty"`
+
+	// Recovery defines how the operator recovers the operand pods
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Recovery *RecoverySpec `json:"recovery,omitempty"`
+
+	// ProgressDeadline is how long the rollout of a generation of the Deployer may take before
+	// the Progressing condition turns False with the ProgressDeadlineExceeded reason, 10m by
+	// default. A canary rollout pauses it.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+
+	// FIPS runs the FIPS-validated operand images configured on the operator, with the Go
+	// FIPS 140 mode enabled and the TLS proxies restricted to the FIPS-approved ciphers.
+	// Reconciling fails while a FIPS image is not configured.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	FIPS bool `json:"fips,omitempty"`
+
+	// ImageVerification verifies the cosign signatures of the operand images in their
+	// registries before they are rendered into the workloads
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ImageVerification *ImageVerificationSpec `json:"imageVerification,omitempty"`
+}
+
+// ImageVerificationSpec defines the verification of the signatures of the operand images. The
+// signatures are read anonymously from the registries of the images. The workloads run the
+// images pinned to the verified digests, the tags are resolved again every few minutes.
+//
+// Known gap: only the signatures made with a key are verified. The keyless signatures, bound to
+// a Fulcio certificate identity and recorded in the Rekor transparency log, are not supported,
+// the images signed that way fail the verification.
+type ImageVerificationSpec struct {
+	// PublicKey is the PEM-encoded ECDSA, RSA or Ed25519 public key, e.g. the content of
+	// cosign.pub, the operand images must carry a cosign signature of
+	// +kubebuilder:validation:MinLength=1
+	PublicKey string `json:"publicKey"`
+}
+
+// VerifiedImage is the digest of the signed manifest of an operand image
+type VerifiedImage struct {
+	// Image is the operand image as configured
+	Image string `json:"image"`
+
+	// Digest of the verified manifest of the image
+	Digest string `json:"digest"`
+}
+
+// NodeRemovalSpec defines the removal of the DirectPV objects of the deleted nodes. The DirectPV
+// objects are cluster-scoped: the deleted nodes are cleaned up when a Deployer enables it, after
+// the longest grace period of the Deployers enabling it.
+type NodeRemovalSpec struct {
+	// RemoveDeletedNodes force-deletes the DirectPV drives, volumes and node objects of the
+	// nodes deleted from the cluster for longer than the grace period. Their DirectPV metadata
+	// is lost, recreating a node with the same drives needs them initialized again.
+	RemoveDeletedNodes bool `json:"removeDeletedNodes,omitempty"`
+
+	// GracePeriod is how long a node stays deleted before its DirectPV objects are removed, so
+	// that the nodes deleted and recreated by cloud node controllers or autoscalers keep them
+	// +kubebuilder:default="1h"
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// RecoverySpec defines the recovery of the operand pods
+type RecoverySpec struct {
+	// ForceDeleteStuckPods force-deletes the operand pods stuck Terminating on nodes which are
+	// not Ready, e.g. after a node crash, so that their replacements start without waiting for
+	// the node to come back
+	ForceDeleteStuckPods *ForceDeleteStuckPodsSpec `json:"forceDeleteStuckPods,omitempty"`
+}
+
+// ForceDeleteStuckPodsSpec defines the force deletion of the operand pods stuck Terminating
+type ForceDeleteStuckPodsSpec struct {
+	// Enabled force-deletes the stuck pods. Their containers may still run on the node until
+	// it is restarted.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Timeout is how long a pod stays Terminating past its grace period before it is
+	// force-deleted
+	// +kubebuilder:default="5m"
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// TelemetrySpec defines the reports of anonymized install metadata. A report is a JSON
+// document with a hash of the UID of the kube-system namespace identifying the cluster, the
+// operator and DirectPV versions and the numbers of nodes and drives of the Deployer. It
+// contains no name, address or label.
+type TelemetrySpec struct {
+	// Enabled opts in to the reports
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Endpoint is the URL the reports are posted to
+	// +kubebuilder:validation:Pattern=`^https?://.+`
+	Endpoint string `json:"endpoint"`
+
+	// Interval between the reports, 24h by default
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// MaintenanceSpec defines the periodic checks of the DirectPV drives
+type MaintenanceSpec struct {
+	// ScrubSchedule is the cron schedule of the filesystem checks of the drives, e.g.
+	// "0 3 * * 0". A CronJob per node runs xfs_scrub in check-only mode on the Ready drives
+	// without staged or published volumes, the results are reported in status.driveConditions.
+	// +kubebuilder:validation:MinLength=9
+	ScrubSchedule string `json:"scrubSchedule,omitempty"`
+}
+
+// AlertsSpec defines the thresholds the operator checks the DirectPV drives against
+type AlertsSpec struct {
+	// DriveUsagePercent is the percentage of the capacity of a drive allocated to volumes from
+	// which the drive is under capacity pressure. An event is emitted for each drive crossing
+	// it and the CapacityPressure condition lists the drives above it.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	DriveUsagePercent *int32 `json:"driveUsagePercent,omitempty"`
+}
+
+// VolumeLimitsSpec defines the maximum numbers of DirectPV volumes. The limits need a DirectPV
+// version supporting them, changing them rolls out the operand pods.
+type VolumeLimitsSpec struct {
+	// MaxPerDrive is the maximum number of volumes the controller provisions on a drive
+	// +kubebuilder:validation:Minimum=1
+	MaxPerDrive *int32 `json:"maxPerDrive,omitempty"`
+
+	// MaxPerNode is the maximum number of volumes of a node. The node-server reports it to the
+	// kubelet, it is the allocatable count of the CSINode so the scheduler does not place more
+	// DirectPV volumes on the node.
+	// +kubebuilder:validation:Minimum=1
+	MaxPerNode *int32 `json:"maxPerNode,omitempty"`
+}
+
+// VolumeCleanupSpec defines the garbage collection of released DirectPV volumes
+type VolumeCleanupSpec struct {
+	// Enabled purges the released volumes. Their data is lost.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// TTL is how long a volume stays released before it is purged, leaving time to recover
+	// its data or bind its PersistentVolume again
+	// +kubebuilder:default="24h"
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+}
+
+// KubeconfigSecretReference references a Secret in the namespace of the Deployer holding the
+// kubeconfig of a remote cluster, e.g. the <cluster>-kubeconfig Secret of Cluster API. The
+// kubeconfig must authenticate with an inline token or client certificate, exec plugins, auth
+// providers and credential files are refused.
+type KubeconfigSecretReference struct {
+	// Name of the Secret
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key of the kubeconfig in the Secret, value by default
+	Key string `json:"key,omitempty"`
+}
+
+// NodePoolSpec references a Cluster API MachineDeployment running DirectPV. Its nodes are the
+// ones labelled with cluster.x-k8s.io/deployment-name, the label has to be propagated from the
+// machines to the nodes.
+type NodePoolSpec struct {
+	// MachineDeployment is the name of the MachineDeployment
+	// +kubebuilder:validation:MinLength=1
+	MachineDeployment string `json:"machineDeployment"`
+
+	// Namespace of the MachineDeployment. When it is not found, e.g. managed from another
+	// cluster, rollouts are not coordinated with it.
+	// +kubebuilder:default=default
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// DrivesSpec defines the desired state of the DirectPV drives
+type DrivesSpec struct {
+	// Cordon selects the drives excluded from volume scheduling, like kubectl directpv cordon.
+	// The existing volumes of the drives stay in place. Drives which no longer match any of
+	// the selectors are uncordoned, unless they were cordoned outside of the operator.
+	Cordon []DriveCordonSelector `json:"cordon,omitempty"`
+
+	// CordonUnschedulableNodes cordons the drives of the cordoned nodes, e.g. being drained,
+	// so that no new volume is provisioned on them. They are uncordoned with their node.
+	CordonUnschedulableNodes bool `json:"cordonUnschedulableNodes,omitempty"`
+
+	// LabelRules label the drives matching them, e.g. tier=nvme. The labels are applied with
+	// the directpv.min.io/ prefix DirectPV selects drives with, see storageClasses.driveLabels.
+	// Later rules override the values of earlier ones. Labels no longer set by any rule are
+	// removed, labels set outside of the operator are left alone.
+	LabelRules []DriveLabelRule `json:"labelRules,omitempty"`
+
+	// WipePolicy defines how the devices of the drives released by a DriveDecommission or by
+	// the uninstallation are wiped. The wipe Jobs check that the device still holds the
+	// filesystem of the released drive and record their completion in the status.
+	// +kubebuilder:default=None
+	WipePolicy WipePolicy `json:"wipePolicy,omitempty"`
+
+	// Encryption encrypts the devices initialized by the DriveInits with LUKS before DirectPV
+	// formats them. The node-server pods unlock the encrypted devices when they start.
+	Encryption *DriveEncryptionSpec `json:"encryption,omitempty"`
+
+	// Quota defines the XFS quota enforcement and the reserved space of every drive, passed
+	// to the node-server. The StorageClasses can override it for their volumes.
+	Quota *QuotaSpec `json:"quota,omitempty"`
+}
+
+// QuotaSpec defines how the capacity of the drives is shared between their volumes. The
+// settings need a DirectPV version supporting them.
+type QuotaSpec struct {
+	// Enforce limits the volumes to their requested capacity with XFS project quotas, so a
+	// runaway volume cannot fill a shared drive. DirectPV enforces them by default.
+	Enforce *bool `json:"enforce,omitempty"`
+
+	// ReservedPercent is the percentage of the capacity of the drives kept free, no volume
+	// is provisioned in it
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=50
+	ReservedPercent *int32 `json:"reservedPercent,omitempty"`
+}
+
+// DriveEncryptionSpec defines the encryption of the DirectPV drives at rest
+type DriveEncryptionSpec struct {
+	// KeySecretRef references the key of a Secret of the namespace of the Deployer holding the
+	// passphrase of the drives. The drives cannot be unlocked anymore if it is lost or changed.
+	KeySecretRef corev1.SecretKeySelector `json:"keySecretRef"`
+}
+
+// WipePolicy defines how the devices of released drives are wiped
+// +kubebuilder:validation:Enum=None;Quick;Secure
+type WipePolicy string
+
+const (
+	// WipePolicyNone leaves the data of released drives on the devices
+	WipePolicyNone WipePolicy = "None"
+	// WipePolicyQuick erases the filesystem signatures with wipefs and discards the blocks of
+	// the devices supporting it with blkdiscard
+	WipePolicyQuick WipePolicy = "Quick"
+	// WipePolicySecure overwrites the whole devices with shred, which takes hours on large drives
+	WipePolicySecure WipePolicy = "Secure"
+)
+
+// DriveWipeState is the state of the wipe of a released drive
+type DriveWipeState string
+
+const (
+	// DriveWipeStatePending means the drive waits to be released or wiped
+	DriveWipeStatePending DriveWipeState = "Pending"
+	// DriveWipeStateCompleted means the device of the drive was wiped
+	DriveWipeStateCompleted DriveWipeState = "Completed"
+	// DriveWipeStateFailed means the device of the drive could not be wiped
+	DriveWipeStateFailed DriveWipeState = "Failed"
+)
+
+// DriveWipeStatus reports the wipe of the device of a released drive
+type DriveWipeStatus struct {
+	// Drive is the DirectPV identifier of the released drive
+	Drive string `json:"drive"`
+
+	// Node of the drive
+	Node string `json:"node"`
+
+	// Device is the device name of the drive
+	Device string `json:"device"`
+
+	// FSUUID is the UUID of the filesystem of the drive the device must hold to be wiped
+	FSUUID string `json:"fsuuid"`
+
+	// Policy the device is wiped with
+	Policy WipePolicy `json:"policy"`
+
+	// State of the wipe
+	State DriveWipeState `json:"state"`
+
+	// Message explains why the wipe failed
+	Message string `json:"message,omitempty"`
+
+	// CompletionTime is the time the device was wiped
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// DriveLabelRule defines labels of the DirectPV drives matching the rule. All the fields of the
+// rule must match, an empty rule matches all the drives on the nodes of the Deployer.
+type DriveLabelRule struct {
+	// Node is a glob pattern of the names of the nodes of the drives
+	Node string `json:"node,omitempty"`
+
+	// Drive is a glob pattern of the device names of the drives, e.g. nvme* for NVMe drives
+	Drive string `json:"drive,omitempty"`
+
+	// Make is a glob pattern of the make of the drives reported by DirectPV
+	Make string `json:"make,omitempty"`
+
+	// MinSize is the minimum capacity of the drives
+	MinSize *resource.Quantity `json:"minSize,omitempty"`
+
+	// MaxSize is the maximum capacity of the drives
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+
+	// Labels are the labels of the matching drives, without the directpv.min.io/ prefix. The
+	// labels maintained by DirectPV, like node or access-tier, cannot be set.
+	// +kubebuilder:validation:MinProperties=1
+	Labels map[string]string `json:"labels"`
+}
+
+// DriveCordonSelector selects DirectPV drives. All the fields of the selector must match, an
+// empty selector matches all the drives on the nodes of the Deployer.
+type DriveCordonSelector struct {
+	// Node is a glob pattern of the names of the nodes of the drives
+	Node string `json:"node,omitempty"`
+
+	// Drive is a glob pattern of the device names of the drives, e.g. nvme*
+	Drive string `json:"drive,omitempty"`
+
+	// ID is the DirectPV identifier of a drive
+	ID string `json:"id,omitempty"`
+}
+
+// ArchitectureSpec defines a CPU architecture of the nodes running DirectPV
+type ArchitectureSpec struct {
+	// Name is the architecture, as reported by the kubernetes.io/arch node label
+	// +kubebuilder:validation:Enum=amd64;arm64;ppc64le;s390x
+	Name string `json:"name"`
+
+	// Image overrides the DirectPV image on the nodes of the architecture, e.g. with an image
+	// not published as a multi-architecture manifest. The nodes run a node-server DaemonSet of
+	// their own, updated in place without the canary and approval of upgrades.
+	Image string `json:"image,omitempty"`
+}
+
+// TopologySpec defines the CSI topology reported by the node-server pods of the Deployer and
+// how the provisioner uses it. The values apply to all nodes of the node pool, a Deployer per
+// zone or rack selecting its nodes describes a cluster spanning several.
+type TopologySpec struct {
+	// Strict makes the provisioner only consider the topology of the selected node when
+	// provisioning a volume, instead of all the nodes of the cluster
+	// +kubebuilder:default=true
+	Strict *bool `json:"strict,omitempty"`
+
+	// Zone is the value of the directpv.min.io/zone topology key of the nodes
+	Zone string `json:"zone,omitempty"`
+
+	// Rack is the value of the directpv.min.io/rack topology key of the nodes
+	Rack string `json:"rack,omitempty"`
+
+	// Region is the value of the directpv.min.io/region topology key of the nodes
+	Region string `json:"region,omitempty"`
+}
+
+// StorageClassSpec defines a StorageClass provisioning DirectPV volumes. The StorageClasses
+// bind volumes on first consumer, allow their expansion and format them with XFS.
+type StorageClassSpec struct {
+	// Name is the name of the StorageClass
+	Name string `json:"name"`
+
+	// Default marks the StorageClass as the default one of the cluster
+	Default bool `json:"default,omitempty"`
+
+	// ReclaimPolicy is the reclaim policy of the provisioned volumes
+	// +kubebuilder:default=Delete
+	// +kubebuilder:validation:Enum=Delete;Retain
+	ReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+
+	// AllowedTopologies restricts the nodes the volumes are provisioned on. The keys are the
+	// topology keys reported by DirectPV: directpv.min.io/node, directpv.min.io/zone,
+	// directpv.min.io/rack and directpv.min.io/region. A term matches when all its
+	// expressions match, the DirectPV identity is added to every term.
+	AllowedTopologies []corev1.TopologySelectorTerm `json:"allowedTopologies,omitempty"`
+
+	// DriveLabels restricts the volumes to the drives carrying these labels, without the
+	// directpv.min.io/ prefix, e.g. the ones applied by drives.labelRules
+	DriveLabels map[string]string `json:"driveLabels,omitempty"`
+
+	// Quota overrides drives.quota for the volumes of the StorageClass
+	Quota *QuotaSpec `json:"quota,omitempty"`
+}
+
+// CSISpec defines optional CSI features of the driver
+type CSISpec struct {
+	// KubeletDir is the root directory of the kubelet of the nodes, e.g. on distributions
+	// using another one than /var/lib/kubelet. The CSI sockets of the node-server and the
+	// controller are created in its plugins directory, the one of the node-server is
+	// registered with the kubelet. Defaults to /var/lib/kubelet.
+	// +kubebuilder:validation:Pattern=`^/.+`
+	KubeletDir string `json:"kubeletDir,omitempty"`
+
+	// Snapshots defines the volume snapshot support
+	Snapshots *SnapshotsSpec `json:"snapshots,omitempty"`
+
+	// HealthMonitor defines the volume health monitoring
+	HealthMonitor *HealthMonitorSpec `json:"healthMonitor,omitempty"`
+
+	// Driver defines the settings of the generated CSIDriver
+	Driver *CSIDriverSpec `json:"driver,omitempty"`
+}
+
+// CSIDriverSpec defines the settings of the generated CSIDriver. FSGroupPolicy and
+// PodInfoOnMount are immutable, changing them recreates the CSIDriver.
+type CSIDriverSpec struct {
+	// FSGroupPolicy defines whether the volume ownership and permissions are changed to the
+	// fsGroup of the pods, the Kubernetes default when not set
+	// +kubebuilder:validation:Enum=ReadWriteOnceWithFSType;File;None
+	FSGroupPolicy *storagev1.FSGroupPolicy `json:"fsGroupPolicy,omitempty"`
+
+	// PodInfoOnMount passes the pod information to the mount calls, DirectPV defaults to true
+	PodInfoOnMount *bool `json:"podInfoOnMount,omitempty"`
+
+	// RequiresRepublish makes the kubelet call NodePublishVolume periodically, e.g. to
+	// refresh the service account tokens
+	RequiresRepublish *bool `json:"requiresRepublish,omitempty"`
+
+	// TokenRequests are the service account tokens of the pods passed to the mount calls
+	TokenRequests []storagev1.TokenRequest `json:"tokenRequests,omitempty"`
+}
+
+// HealthMonitorSpec defines the volume health monitoring of the driver
+type HealthMonitorSpec struct {
+	// Enabled injects the csi-external-health-monitor-controller sidecar into the controller
+	// pods, which reports abnormal volume conditions as events on the PVCs. It needs a DirectPV
+	// version reporting the volume condition.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Interval is how often the volumes are checked
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h))+$`
+	Interval string `json:"interval,omitempty"`
+}
+
+// SnapshotsSpec defines the volume snapshot support of the driver
+type SnapshotsSpec struct {
+	// Enabled injects the csi-snapshotter sidecar into the controller pods and creates a
+	// VolumeSnapshotClass for the driver. It needs the VolumeSnapshot CRDs and the
+	// snapshot-controller in the cluster, and a DirectPV version implementing snapshots.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// DeletionPolicy is the deletion policy of the VolumeSnapshotClass
+	// +kubebuilder:default=Delete
+	// +kubebuilder:validation:Enum=Delete;Retain
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+
+	// InstallController installs the VolumeSnapshot CRDs missing in the cluster and a
+	// snapshot-controller Deployment in the namespace of the Deployer, for clusters whose
+	// distribution does not provide them. VolumeSnapshot CRDs installed otherwise must serve
+	// the v1 API.
+	InstallController bool `json:"installController,omitempty"`
+}
+
+// TLSSpec defines the TLS settings of the operand metrics and readiness endpoints
+type TLSSpec struct {
+	// Enabled serves the metrics and readiness endpoints over TLS
+	Enabled bool `json:"enabled,omitempty"`
+
+	// CertManager requests the serving certificate from cert-manager. The operator
+	// generates a self-signed certificate when not set.
+	CertManager *CertManagerSpec `json:"certManager,omitempty"`
+
+	// AuthorizeMetrics requires the node-server metrics scrapers to present a bearer token
+	// authorized, through a SubjectAccessReview, to get the /metrics non-resource URL, e.g. by
+	// binding them to the directpv-operator-metrics-reader ClusterRole. The readiness
+	// endpoints stay open to the kubelet probes. It is refused with spec.nodeServer.hostNetwork,
+	// the upstream metrics port would be reachable on the node addresses.
+	AuthorizeMetrics bool `json:"authorizeMetrics,omitempty"`
+}
+
+// CertManagerSpec defines how the serving certificate is requested from cert-manager
+type CertManagerSpec struct {
+	// IssuerRef references the cert-manager Issuer or ClusterIssuer signing the certificate
+	IssuerRef IssuerReference `json:"issuerRef"`
+}
+
+// IssuerReference references a cert-manager issuer
+type IssuerReference struct {
+	// Name is the name of the issuer
+	Name string `json:"name"`
+
+	// Kind is the kind of the issuer
+	// +kubebuilder:default=Issuer
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	Kind string `json:"kind,omitempty"`
+}
+
+// TrustedCASpec defines the CA bundle trusted by the operand containers
+type TrustedCASpec struct {
+	// ConfigMapRef references the ConfigMap holding the PEM encoded CA bundle in the
+	// namespace of the Deployer
+	ConfigMapRef corev1.LocalObjectReference `json:"configMapRef"`
+
+	// Key is the key of the CA bundle in the ConfigMap
+	// +kubebuilder:default=ca-bundle.crt
+	Key string `json:"key,omitempty"`
+}
+
+// ProxySpec defines the proxy environment variables of the operand containers
+type ProxySpec struct {
+	// HTTPProxy is the URL of the proxy for HTTP requests
+	HTTPProxy string `json:"httpProxy,omitempty"`
+
+	// HTTPSProxy is the URL of the proxy for HTTPS requests
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+
+	// NoProxy is a comma-separated list of hosts, domains and CIDRs not to proxy,
+	// it should include the Kubernetes service and pod networks
+	NoProxy string `json:"noProxy,omitempty"`
+}
+
+// NodeServerSpec defines the settings of the node-server DaemonSet
+type NodeServerSpec struct {
+	// HostNetwork runs the node-server pods in the network namespace of the node, needed
+	// with CNIs breaking the registration of the plugin with the kubelet. The container
+	// ports are then bound on the node and must be free there.
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+
+	// TerminationGracePeriodSeconds is how long the kubelet waits for a node-server pod to
+	// stop, e.g. during upgrades, so in-flight unmount operations can complete
+	// +kubebuilder:validation:Minimum=0
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// Probes overrides the timings of the node-server liveness and readiness probes
+	Probes *ProbesSpec `json:"probes,omitempty"`
+
+	// Env are extra environment variables of the node-server containers, they replace the
+	// generated variables of the same name
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// ExtraVolumes are appended to the volumes of the node-server pods
+	ExtraVolumes []corev1.Volume `json:"extraVolumes,omitempty"`
+
+	// ExtraVolumeMounts are appended to the volume mounts of every node-server container
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts,omitempty"`
+
+	// ExtraArgs are appended to the arguments of the node-server container, e.g. to use new
+	// DirectPV flags
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+
+	// ServiceAccountName is the service account of the node-server pods, e.g. one
+	// pre-provisioned with a bound cloud identity. The operator binds it to the node-server
+	// permissions, by default it creates one named <deployer>-node-server.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// PodAnnotations are added to the node-server pods, e.g. prometheus.io/scrape or
+	// sidecar.istio.io/inject: "false" as injected sidecars break the CSI socket
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// ExtraContainers are appended as they are to the containers of the node-server pods,
+	// e.g. a vendor SMART exporter
+	ExtraContainers []corev1.Container `json:"extraContainers,omitempty"`
+
+	// Ports defines the ports of the node-server pods, they must differ from the ports of
+	// the other node pools sharing nodes with this one when hostNetwork is set
+	Ports *NodeServerPortsSpec `json:"ports,omitempty"`
+
+	// DeviceFilters are glob patterns of the names of the devices discovered by the
+	// node-server, e.g. nvme*. Patterns starting with ! exclude the matching devices, e.g.
+	// !sda to never pick up the system disk. Without include patterns every device not
+	// excluded is discovered. Needs a DirectPV release supporting the --include-devices and
+	// --exclude-devices flags.
+	// +kubebuilder:validation:items:Pattern=`^!?[a-zA-Z0-9_.*?\[\]-]+$`
+	DeviceFilters []string `json:"deviceFilters,omitempty"`
+
+	// DataPath is the host directory of the DirectPV state, e.g. on a mount other than a small
+	// /var partition. Defaults to /var/lib/directpv. The state is not moved when the path
+	// changes, drain the node pool first.
+	// +kubebuilder:validation:Pattern=`^/.+`
+	DataPath string `json:"dataPath,omitempty"`
+
+	// Udev defines where the node-server reads the udev data of the devices
+	Udev *UdevSpec `json:"udev,omitempty"`
+}
+
+// UdevSpec defines the udev data used by the node-server to probe the devices
+type UdevSpec struct {
+	// DataPath is the udev data directory of the nodes
+	// +kubebuilder:default="/run/udev/data"
+	// +kubebuilder:validation:Pattern=`^/.+`
+	DataPath string `json:"dataPath,omitempty"`
+
+	// Optional installs DirectPV on nodes without udev data instead of failing the preflight
+	// checks. The nodes found without it are reported through the UdevDataUnavailable condition.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// NodeServerPortsSpec defines the ports of the node-server pods
+type NodeServerPortsSpec struct {
+	// Readiness is the port of the readiness endpoint
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=30443
+	Readiness int32 `json:"readiness,omitempty"`
+
+	// Healthz is the port of the liveness probe sidecar, checked by the liveness probe of the
+	// node-server container. It must not be overridden by the extra arguments of the sidecar.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=9898
+	Healthz int32 `json:"healthz,omitempty"`
+
+	// Metrics is the port of the metrics endpoint
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=10443
+	Metrics int32 `json:"metrics,omitempty"`
+}
+
+// ControllerSpec defines the settings of the controller Deployment
+type ControllerSpec struct {
+	// Replicas is the number of controller pods, the deprecated size or 1 when not set. With
+	// several replicas the leader election of the sidecars is always enabled, even when
+	// disabled by their extraArgs.
+	// +kubebuilder:validation:Minimum=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// LeaderElection defines the leases of the leader election of the controller sidecars.
+	// The leases are held in the namespace of the Deployer.
+	LeaderElection *LeaderElectionSpec `json:"leaderElection,omitempty"`
+
+	// Probes overrides the timings of the controller readiness probe
+	Probes *ProbesSpec `json:"probes,omitempty"`
+
+	// ServiceAccountName is the service account of the controller pods. The operator binds it
+	// to the controller permissions, by default it creates one named <deployer>-controller.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// PodAnnotations are added to the controller pods
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// ExtraArgs are appended to the arguments of the controller container
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+
+	// Env are extra environment variables of the controller containers, they replace the
+	// generated variables of the same name
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// ExtraVolumes are appended to the volumes of the controller pods
+	ExtraVolumes []corev1.Volume `json:"extraVolumes,omitempty"`
+
+	// ExtraVolumeMounts are appended to the volume mounts of every controller container
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts,omitempty"`
+}
+
+// LeaderElectionSpec defines the timings of the leader election leases, the sidecar defaults
+// apply to the ones not set
+type LeaderElectionSpec struct {
+	// LeaseDuration is how long the other candidates wait before taking over the lease of a
+	// leader which stopped renewing it, 15s by default
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+
+	// RenewDeadline is how long the leader retries renewing its lease before giving it up,
+	// 10s by default. It must be shorter than the lease duration.
+	RenewDeadline *metav1.Duration `json:"renewDeadline,omitempty"`
+
+	// RetryPeriod is how long the candidates wait between attempts to acquire or renew the
+	// lease, 5s by default. It must be shorter than the renew deadline.
+	RetryPeriod *metav1.Duration `json:"retryPeriod,omitempty"`
+}
+
+// MonitoringSpec defines how the node-server metrics are scraped by the Prometheus Operator
+type MonitoringSpec struct {
+	// Enabled creates the monitor object when the Prometheus Operator CRDs are installed
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Kind is the kind of monitor object, a ServiceMonitor selecting the metrics Service or
+	// a PodMonitor selecting the node-server pods
+	// +kubebuilder:default=ServiceMonitor
+	Kind MonitorKind `json:"kind,omitempty"`
+
+	// Interval is the scrape interval, the Prometheus default when not set
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h))+$`
+	Interval string `json:"interval,omitempty"`
+
+	// Relabelings are applied to the scraped targets before ingestion
+	Relabelings []RelabelConfig `json:"relabelings,omitempty"`
+
+	// BearerTokenSecret selects the token presented to the node-server metrics endpoints when
+	// tls.authorizeMetrics is set, in the namespace of the Deployer. A ServiceMonitor presents
+	// the token of the Prometheus service account when not set, a PodMonitor needs it.
+	BearerTokenSecret *corev1.SecretKeySelector `json:"bearerTokenSecret,omitempty"`
+}
+
+// MonitorKind defines the kind of Prometheus Operator monitor object
+// +kubebuilder:validation:Enum=ServiceMonitor;PodMonitor
+type MonitorKind string
+
+const (
+	// MonitorKindServiceMonitor scrapes the endpoints of the metrics Service
+	MonitorKindServiceMonitor MonitorKind = "ServiceMonitor"
+	// MonitorKindPodMonitor scrapes the node-server pods directly
+	MonitorKindPodMonitor MonitorKind = "PodMonitor"
+)
+
+// RelabelConfig defines a Prometheus relabeling rule
+type RelabelConfig struct {
+	// SourceLabels are the labels whose values are concatenated
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+
+	// Separator is placed between the concatenated source label values
+	Separator string `json:"separator,omitempty"`
+
+	// TargetLabel is the label the result is written to
+	TargetLabel string `json:"targetLabel,omitempty"`
+
+	// Regex is matched against the concatenated source label values
+	Regex string `json:"regex,omitempty"`
+
+	// Replacement is the value written to the target label when the regex matches
+	Replacement string `json:"replacement,omitempty"`
+
+	// Action is the relabeling action, replace by default
+	// +kubebuilder:validation:Enum=replace;keep;drop;hashmod;labelmap;labeldrop;labelkeep
+	Action string `json:"action,omitempty"`
+}
+
+// LogFormat defines the format of the operand logs
+// +kubebuilder:validation:Enum=Text;JSON
+type LogFormat string
+
+const (
+	// LogFormatText logs human readable text
+	LogFormatText LogFormat = "Text"
+	// LogFormatJSON logs JSON objects
+	LogFormatJSON LogFormat = "JSON"
+)
+
+// LogLevelSpec defines the verbosity of the operand containers per component
+type LogLevelSpec struct {
+	// Default is the verbosity of the components without an override
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default=3
+	Default int32 `json:"default,omitempty"`
+
+	// NodeServer overrides the verbosity of the node-server and node-controller containers
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	NodeServer *int32 `json:"nodeServer,omitempty"`
+
+	// Controller overrides the verbosity of the controller container
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	Controller *int32 `json:"controller,omitempty"`
+
+	// Sidecars overrides the verbosity of the CSI sidecar containers
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	Sidecars *int32 `json:"sidecars,omitempty"`
+}
+
+// SidecarsSpec defines the settings of the CSI sidecar containers
+type SidecarsSpec struct {
+	// NodeDriverRegistrar defines the settings of the node-driver-registrar sidecar
+	NodeDriverRegistrar *SidecarSpec `json:"nodeDriverRegistrar,omitempty"`
+
+	// LivenessProbe defines the settings of the liveness-probe sidecar
+	LivenessProbe *SidecarSpec `json:"livenessProbe,omitempty"`
+
+	// Provisioner defines the settings of the csi-provisioner sidecar
+	Provisioner *ProvisioningSidecarSpec `json:"provisioner,omitempty"`
+
+	// Resizer defines the settings of the csi-resizer sidecar
+	Resizer *ProvisioningSidecarSpec `json:"resizer,omitempty"`
+
+	// Snapshotter defines the settings of the csi-snapshotter sidecar
+	Snapshotter *SidecarSpec `json:"snapshotter,omitempty"`
+
+	// HealthMonitor defines the settings of the csi-external-health-monitor-controller sidecar
+	HealthMonitor *SidecarSpec `json:"healthMonitor,omitempty"`
+}
+
+// SidecarSpec defines the settings of a CSI sidecar container
+type SidecarSpec struct {
+	// ExtraArgs are appended to the arguments of the sidecar
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+}
+
+// ProvisioningSidecarSpec defines the settings of a CSI sidecar calling the controller server for
+// volume operations, with its throughput
+type ProvisioningSidecarSpec struct {
+	SidecarSpec `json:",inline"`
+
+	// Timeout is how long the sidecar waits for a call to the controller server, 300s by default
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// WorkerThreads is the number of volume operations the sidecar runs concurrently, the
+	// sidecar default applies when not set
+	// +kubebuilder:validation:Minimum=1
+	WorkerThreads *int32 `json:"workerThreads,omitempty"`
+
+	// KubeAPIQPS is the rate of the requests of the sidecar to the API server, the sidecar
+	// default applies when not set
+	// +kubebuilder:validation:Minimum=1
+	KubeAPIQPS *int32 `json:"kubeAPIQPS,omitempty"`
+
+	// KubeAPIBurst is the burst of the requests of the sidecar to the API server, the sidecar
+	// default applies when not set
+	// +kubebuilder:validation:Minimum=1
+	KubeAPIBurst *int32 `json:"kubeAPIBurst,omitempty"`
+}
+
+// ProbesSpec defines overrides of the probes of an operand container
+type ProbesSpec struct {
+	// Liveness overrides the liveness probe
+	Liveness *ProbeSpec `json:"liveness,omitempty"`
+
+	// Readiness overrides the readiness probe
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+}
+
+// ProbeSpec defines the timings of a probe, unset fields keep the rendered defaults
+type ProbeSpec struct {
+	// InitialDelaySeconds is the delay after the container started before probing it
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+
+	// PeriodSeconds is the interval between probes
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+
+	// TimeoutSeconds is the time after which a probe times out
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+
+	// SuccessThreshold is the number of consecutive successes for the probe to pass
+	// after having failed. It must be 1 for liveness probes.
+	// +kubebuilder:validation:Minimum=1
+	SuccessThreshold *int32 `json:"successThreshold,omitempty"`
+
+	// FailureThreshold is the number of consecutive failures for the probe to fail
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+}
+
+// PodSecurityContextSpec defines the pod level security settings of the operand pods
+type PodSecurityContextSpec struct {
+	// SeccompProfile is the seccomp profile of the node-server and controller pods
+	SeccompProfile *SeccompProfileSpec `json:"seccompProfile,omitempty"`
+}
+
+// SeccompProfileSpec defines the seccomp profile of the operand pods
+type SeccompProfileSpec struct {
+	// Type is the kind of seccomp profile, RuntimeDefault uses the profile of the container
+	// runtime, Localhost a profile stored on the node
+	// +kubebuilder:validation:Enum=RuntimeDefault;Localhost
+	Type corev1.SeccompProfileType `json:"type"`
+
+	// LocalhostProfile is the path of the profile relative to the kubelet seccomp directory,
+	// required when the type is Localhost
+	LocalhostProfile *string `json:"localhostProfile,omitempty"`
+}
+
+// SecurityProfile defines the hardening of the operand containers
+// +kubebuilder:validation:Enum=Legacy;Restricted
+type SecurityProfile string
+
+const (
+	// SecurityProfileLegacy renders every operand container privileged
+	SecurityProfileLegacy SecurityProfile = "Legacy"
+	// SecurityProfileRestricted grants privileges only to the containers needing them
+	SecurityProfileRestricted SecurityProfile = "Restricted"
+)
+
+// SELinuxSpec defines the SELinux configuration
+type SELinuxSpec struct {
+	// PodOptions are the SELinux options of the operand pods
+	PodOptions *corev1.SELinuxOptions `json:"podOptions,omitempty"`
+
+	// ContainerOptions are the SELinux options of the operand containers
+	ContainerOptions *corev1.SELinuxOptions `json:"containerOptions,omitempty"`
+
+	// Mount sets seLinuxMount on the CSIDriver so the kubelet mounts the volumes with the
+	// SELinux context of the pod instead of relabelling them recursively
+	Mount *bool `json:"mount,omitempty"`
+}
+
+// PodSecuritySpec defines the Pod Security Admission labels of the namespace
+type PodSecuritySpec struct {
+	// Unmanaged leaves the Pod Security Admission labels of the namespace to the user
+	Unmanaged bool `json:"unmanaged,omitempty"`
+
+	// Level set for the enforce, audit and warn modes
+	// +kubebuilder:default=privileged
+	// +kubebuilder:validation:Enum=privileged;baseline;restricted
+	Level string `json:"level,omitempty"`
+}
+
+// UninstallSpec defines how DirectPV is uninstalled
+type UninstallSpec struct {
+	// Policy applied to the DirectPV drives and volumes of the nodes of the Deployer.
+	// Retain leaves them and the on-disk data intact, Delete removes the volumes not
+	// referenced by a PVC and the drives without volumes, Force removes everything.
+	// +kubebuilder:default=Retain
+	Policy UninstallPolicy `json:"policy,omitempty"`
+}
+
+// UninstallPolicy defines what is removed when DirectPV is uninstalled
+// +kubebuilder:validation:Enum=Retain;Delete;Force
+type UninstallPolicy string
+
+const (
+	// UninstallPolicyRetain keeps the drives, volumes and data
+	UninstallPolicyRetain UninstallPolicy = "Retain"
+	// UninstallPolicyDelete removes the drives and volumes no longer in use
+	UninstallPolicyDelete UninstallPolicy = "Delete"
+	// UninstallPolicyForce removes all the drives and volumes
+	UninstallPolicyForce UninstallPolicy = "Force"
+)
+
+// AdoptionPolicy defines how existing DirectPV installations are handled
+// +kubebuilder:validation:Enum=Refuse;Adopt
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyRefuse blocks the installation while DirectPV objects not managed by the operator exist
+	AdoptionPolicyRefuse AdoptionPolicy = "Refuse"
+	// AdoptionPolicyAdopt manages the existing DirectPV objects in place
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+)
+
+// UpgradePolicy defines how new operand versions are rolled out
+// +kubebuilder:validation:Enum=Automatic;Manual
+type UpgradePolicy string
+
+const (
+	// UpgradePolicyAutomatic rolls out new operand versions as soon as they are available
+	UpgradePolicyAutomatic UpgradePolicy = "Automatic"
+	// UpgradePolicyManual holds new operand versions until they are approved
+	UpgradePolicyManual UpgradePolicy = "Manual"
+)
+
+// RemediationMode defines how the operator handles operand workloads diverging from the
+// rendered ones
+// +kubebuilder:validation:Enum=Enforce;Warn
+type RemediationMode string
+
+const (
+	// RemediationEnforce updates the operand workloads to the rendered ones
+	RemediationEnforce RemediationMode = "Enforce"
+	// RemediationWarn reports the differences without updating the operand workloads
+	RemediationWarn RemediationMode = "Warn"
+)
+
+// MaintenanceWindowSpec defines recurring windows in which the operand pods may be restarted
+type MaintenanceWindowSpec struct {
+	// Schedule is the cron schedule of the start of the windows in UTC, e.g. "0 2 * * 6"
+	// +kubebuilder:validation:Pattern=`^\S+(\s+\S+){4}$`
+	Schedule string `json:"schedule"`
+
+	// Duration of the windows, e.g. 4h
+	Duration metav1.Duration `json:"duration"`
+}
+
+// IgnoreDifferenceSpec selects a field of the operand workloads of a kind left to other
+// controllers
+type IgnoreDifferenceSpec struct {
+	// Kind of the operand workloads
+	// +kubebuilder:validation:Enum=DaemonSet;Deployment
+	Kind string `json:"kind"`
+
+	// JSONPath of the field, made of .field, [index], [*] for all the items of a list and
+	// ['key'] for keys with dots, e.g. .spec.template.spec.containers[*].resources or
+	// .spec.template.metadata.annotations['sidecar.istio.io/status']
+	// +kubebuilder:validation:Pattern=`^(\.[^.\[\]]+|\[\*\]|\[[0-9]+\]|\['[^']*'\])+$`
+	JSONPath string `json:"jsonPath"`
+}
+
+// UpgradeSpec defines how new operand images are rolled out
+type UpgradeSpec struct {
+	// Canary rolls new node-server images to a subset of the nodes first and
+	// only proceeds with the remaining nodes once they stayed healthy for the soak period
+	Canary *CanarySpec `json:"canary,omitempty"`
+}
+
+// CanarySpec defines the canary nodes and how long they are observed
+type CanarySpec struct {
+	// NodeSelector selects the canary nodes among the nodes of the Deployer
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// Percentage of the nodes of the Deployer used as canaries when no nodeSelector is set,
+	// a single node is used when neither is set
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Percentage int32 `json:"percentage,omitempty"`
+
+	// SoakPeriod is how long the canary pods must stay healthy before the rollout proceeds
+	// +kubebuilder:default="10m"
+	SoakPeriod *metav1.Duration `json:"soakPeriod,omitempty"`
+}
+
+// UpgradePhase is the phase of a canary rollout
+// +kubebuilder:validation:Enum=Canary;Halted
+type UpgradePhase string
+
+const (
+	// UpgradePhaseCanary means the new images run on the canary nodes during the soak period
+	UpgradePhaseCanary UpgradePhase = "Canary"
+	// UpgradePhaseHalted means the canary nodes were unhealthy and the rollout stopped
+	UpgradePhaseHalted UpgradePhase = "Halted"
+)
+
+// UpgradeStatus reports the progress of a canary rollout
+type UpgradeStatus struct {
+	// Phase of the rollout
+	Phase UpgradePhase `json:"phase,omitempty"`
+
+	// TargetImage is the node-server image being rolled out
+	TargetImage string `json:"targetImage,omitempty"`
+
+	// CanaryNodes are the nodes which received the new images first
+	CanaryNodes []string `json:"canaryNodes,omitempty"`
+
+	// StartTime is when the canary pods were restarted with the new images
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+}
+
+// AdoptedObjects lists the pre-existing DirectPV objects managed instead of the rendered ones
+type AdoptedObjects struct {
+	// NodeServer is the name of the adopted node-server DaemonSet
+	NodeServer string `json:"nodeServer,omitempty"`
+
+	// Controller is the name of the adopted controller Deployment
+	Controller string `json:"controller,omitempty"`
+
+	// CSIDriver is the name of the adopted CSIDriver
+	CSIDriver string `json:"csiDriver,omitempty"`
+}
+
+// MigrationPhase is the phase of the legacy direct-csi migration
+// +kubebuilder:validation:Enum=Running;Blocked;Completed
+type MigrationPhase string
+
+const (
+	// MigrationPhaseRunning means legacy objects are being converted
+	MigrationPhaseRunning MigrationPhase = "Running"
+	// MigrationPhaseBlocked means some legacy volumes failed the safety checks and nothing was converted
+	MigrationPhaseBlocked MigrationPhase = "Blocked"
+	// MigrationPhaseCompleted means all the legacy objects were converted
+	MigrationPhaseCompleted MigrationPhase = "Completed"
+)
+
+// MigrationStatus reports the progress of the legacy direct-csi migration
+type MigrationStatus struct {
+	// Phase of the migration
+	Phase MigrationPhase `json:"phase,omitempty"`
+
+	// TotalDrives is the number of legacy drives to migrate
+	TotalDrives int32 `json:"totalDrives,omitempty"`
+
+	// MigratedDrives is the number of legacy drives converted to DirectPV drives
+	MigratedDrives int32 `json:"migratedDrives,omitempty"`
+
+	// TotalVolumes is the number of legacy volumes to migrate
+	TotalVolumes int32 `json:"totalVolumes,omitempty"`
+
+	// MigratedVolumes is the number of legacy volumes converted to DirectPV volumes
+	MigratedVolumes int32 `json:"migratedVolumes,omitempty"`
+
+	// InvalidVolumes is the number of legacy volumes failing the safety checks, the
+	// per-volume results are reported in the <name>-migration-report ConfigMap
+	InvalidVolumes int32 `json:"invalidVolumes,omitempty"`
+
+	// Message describes the last error encountered, if any
+	Message string `json:"message,omitempty"`
+}
+
+// RolloutStatus defines the rollout of a generation of the Deployer
+type RolloutStatus struct {
+	// Generation of the Deployer being rolled out
+	Generation int64 `json:"generation"`
+
+	// StartTime is when the rollout of the generation started, or resumed after a canary
+	StartTime metav1.Time `json:"startTime"`
+
+	// CompletionTime is when the operand workloads all ran the generation, unset while rolling out
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// InventoryEntry identifies an object rendered by the operator for the Deployer
+type InventoryEntry struct {
+	// Kind of the object
+	Kind string `json:"kind"`
+
+	// Namespace of the object, empty for cluster-scoped objects
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the object
+	Name string `json:"name"`
 }
 
 // DeployerStatus defines the observed state of Deployer
@@ -57,6 +1375,168 @@ type DeployerStatus struct {
 	// Conditions store the status conditions of the Deployer instances
 	// +operator-sdk:csv:customresourcedefinitions:type=status
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// Upgrade reports the progress of an ongoing canary rollout
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+
+	// InstalledVersion is the DirectPV version running on the nodes
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	InstalledVersion string `json:"installedVersion,omitempty"`
+
+	// Adopted lists the DirectPV objects installed without the operator which are now managed by it
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Adopted *AdoptedObjects `json:"adopted,omitempty"`
+
+	// Migration reports the progress of the legacy direct-csi migration
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Migration *MigrationStatus `json:"migration,omitempty"`
+
+	// Inventory lists the objects rendered for the Deployer by the last reconciliation.
+	// Objects owned by the Deployer which are no longer rendered are pruned.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Inventory []InventoryEntry `json:"inventory,omitempty"`
+
+	// Storage summarizes the DirectPV drives and volumes on the nodes of the Deployer
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Storage *StorageSummary `json:"storage,omitempty"`
+
+	// Telemetry reports the last telemetry report
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Telemetry *TelemetryStatus `json:"telemetry,omitempty"`
+
+	// PendingChanges reports the changes of the operand workloads queued until the next
+	// maintenance window
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	PendingChanges *PendingChanges `json:"pendingChanges,omitempty"`
+
+	// PendingImages lists the operand images, as workload/container=image, of an upgrade
+	// waiting for approval in Manual upgrade policy
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	PendingImages []string `json:"pendingImages,omitempty"`
+
+	// Rollout reports the rollout of the last generation of the Deployer to the operand workloads
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// VerifiedImages are the digests of the operand images whose signatures were verified with
+	// spec.imageVerification, the workloads run the images pinned to them
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	VerifiedImages []VerifiedImage `json:"verifiedImages,omitempty"`
+
+	// Capacity reports the capacity of the DirectPV drives of each node of the Deployer
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Capacity []NodeCapacity `json:"capacity,omitempty"`
+
+	// TLS reports the serving certificate of the operand TLS proxies
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	TLS *TLSStatus `json:"tls,omitempty"`
+
+	// DriveWipes reports the wipes of the drives released by the uninstallation
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DriveWipes []DriveWipeStatus `json:"driveWipes,omitempty"`
+
+	// DriveConditions reports the conditions of the drives observed by the operator, e.g. the
+	// result of their last filesystem check
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DriveConditions []DriveConditions `json:"driveConditions,omitempty"`
+}
+
+// DriveConditions reports the conditions of a DirectPV drive
+type DriveConditions struct {
+	// Drive is the DirectPV identifier of the drive
+	Drive string `json:"drive"`
+
+	// Node of the drive
+	Node string `json:"node"`
+
+	// Conditions of the drive, e.g. FilesystemHealthy
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TLSStatus reports the serving certificate of the operand TLS proxies
+type TLSStatus struct {
+	// SecretName is the name of the Secret holding the certificate
+	SecretName string `json:"secretName,omitempty"`
+
+	// NotBefore is the time from which the certificate is valid
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+
+	// NotAfter is the expiry time of the certificate
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+
+	// RenewalTime is the time the certificate is renewed at, by the operator for generated
+	// certificates or by cert-manager
+	RenewalTime *metav1.Time `json:"renewalTime,omitempty"`
+}
+
+// StorageSummary reports aggregates of the DirectPV drives and volumes
+type StorageSummary struct {
+	// DrivesOnline is the number of drives in the Ready state
+	DrivesOnline int32 `json:"drivesOnline"`
+
+	// DrivesDegraded is the number of drives in any other state, e.g. Lost or Error
+	DrivesDegraded int32 `json:"drivesDegraded"`
+
+	// Volumes is the number of volumes
+	Volumes int32 `json:"volumes"`
+
+	// VolumesBound is the number of volumes whose PersistentVolume is bound to a claim
+	VolumesBound int32 `json:"volumesBound"`
+
+	// CapacityTotal is the total capacity of the drives
+	CapacityTotal resource.Quantity `json:"capacityTotal"`
+
+	// CapacityFree is the capacity of the drives not allocated to volumes
+	CapacityFree resource.Quantity `json:"capacityFree"`
+
+	// DrivesUnderPressure are the drives whose usage is above alerts.driveUsagePercent
+	DrivesUnderPressure []string `json:"drivesUnderPressure,omitempty"`
+}
+
+// TelemetryStatus reports the last telemetry report
+type TelemetryStatus struct {
+	// LastReportTime is the time of the last report attempt
+	LastReportTime *metav1.Time `json:"lastReportTime,omitempty"`
+
+	// Error of the last report attempt, empty when it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// PendingChanges reports the changes queued until the next maintenance window
+type PendingChanges struct {
+	// NextWindow is the start of the next maintenance window
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+
+	// Workloads are the operand workloads with queued changes
+	Workloads []PendingWorkloadChanges `json:"workloads,omitempty"`
+}
+
+// PendingWorkloadChanges reports the queued changes of an operand workload
+type PendingWorkloadChanges struct {
+	// Kind of the workload, DaemonSet or Deployment
+	Kind string `json:"kind"`
+
+	// Name of the workload
+	Name string `json:"name"`
+
+	// Changes describe the fields of the pod template to change
+	Changes []string `json:"changes,omitempty"`
+}
+
+// NodeCapacity reports the capacity of the DirectPV drives of a node
+type NodeCapacity struct {
+	// Node is the name of the node
+	Node string `json:"node"`
+
+	// Total is the total capacity of the drives of the node
+	Total resource.Quantity `json:"total"`
+
+	// Allocated is the capacity of the drives allocated to volumes
+	Allocated resource.Quantity `json:"allocated"`
+
+	// Free is the capacity of the drives not allocated to volumes
+	Free resource.Quantity `json:"free"`
 }
 
 //+kubebuilder:object:root=true
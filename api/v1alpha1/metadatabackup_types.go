@@ -0,0 +1,110 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ObjectStoreSpec defines a bucket of an S3-compatible object storage
+type ObjectStoreSpec struct {
+	// Endpoint is the URL of the object storage, e.g. https://s3.us-east-1.amazonaws.com or
+	// the URL of a MinIO deployment. Buckets are addressed path-style.
+	// +kubebuilder:validation:Pattern=`^https?://.+`
+	Endpoint string `json:"endpoint"`
+
+	// Bucket is the name of the bucket
+	Bucket string `json:"bucket"`
+
+	// Region is the region of the bucket used to sign the requests
+	// +kubebuilder:default=us-east-1
+	Region string `json:"region,omitempty"`
+
+	// Prefix is prepended to the names of the backup objects, e.g. directpv/cluster-a
+	Prefix string `json:"prefix,omitempty"`
+
+	// CredentialsSecretRef references a Secret of the namespace holding the access key in
+	// its accessKey key and the secret key in its secretKey key
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+}
+
+// MetadataBackupSpec defines the periodic backup of the DirectPV metadata
+type MetadataBackupSpec struct {
+	// Deployer is the name of the Deployer of the namespace saved with the DirectPV objects
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Deployer string `json:"deployer"`
+
+	// ObjectStore is the bucket the backups are uploaded to
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ObjectStore ObjectStoreSpec `json:"objectStore"`
+
+	// Interval is the time between two backups
+	// +kubebuilder:default="24h"
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// MetadataBackupStatus defines the observed state of MetadataBackup
+type MetadataBackupStatus struct {
+	// LastBackupTime is the time of the last successful backup
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+
+	// LastBackupObject is the name of the object of the last successful backup in the bucket
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastBackupObject string `json:"lastBackupObject,omitempty"`
+
+	// Drives is the number of DirectPV drives of the last successful backup
+	Drives int32 `json:"drives,omitempty"`
+
+	// Volumes is the number of DirectPV volumes of the last successful backup
+	Volumes int32 `json:"volumes,omitempty"`
+
+	// Message describes the last error encountered, if any
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Deployer",type=string,JSONPath=`.spec.deployer`
+//+kubebuilder:printcolumn:name="Last Backup",type=date,JSONPath=`.status.lastBackupTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MetadataBackup periodically exports the DirectPVDrives and DirectPVVolumes of the cluster,
+// with the Deployer, to an S3-compatible bucket. Each backup is uploaded as a timestamped
+// object and as the latest object restored by default by a MetadataRestore.
+type MetadataBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MetadataBackupSpec   `json:"spec,omitempty"`
+	Status MetadataBackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetadataBackupList contains a list of MetadataBackup
+type MetadataBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MetadataBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MetadataBackup{}, &MetadataBackupList{})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetadataExportSpec defines the destinations of the export. At least one of them must be set.
+type MetadataExportSpec struct {
+	// Deployer is the name of the Deployer of the namespace exported with the DirectPV objects
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Deployer string `json:"deployer"`
+
+	// ConfigMap is the name of the ConfigMap created in the namespace with the gzipped archive
+	// in its directpv-metadata.json.gz binary data. It must not exist yet, it is kept when the
+	// MetadataExport is deleted.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// ObjectStore is the bucket the archive is uploaded to, as <name of the export>.json
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ObjectStore *ObjectStoreSpec `json:"objectStore,omitempty"`
+}
+
+// MetadataExportPhase is the phase of a MetadataExport
+type MetadataExportPhase string
+
+const (
+	// MetadataExportPhasePending means the archive was not written yet, e.g. while the object
+	// storage is unavailable
+	MetadataExportPhasePending MetadataExportPhase = "Pending"
+	// MetadataExportPhaseCompleted means the archive was written to all the destinations
+	MetadataExportPhaseCompleted MetadataExportPhase = "Completed"
+	// MetadataExportPhaseFailed means the export is invalid, e.g. without destination or
+	// with an existing ConfigMap
+	MetadataExportPhaseFailed MetadataExportPhase = "Failed"
+)
+
+// MetadataExportStatus defines the observed state of MetadataExport
+type MetadataExportStatus struct {
+	// Phase of the export
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase MetadataExportPhase `json:"phase,omitempty"`
+
+	// CompletionTime is the time the archive was taken
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Nodes is the number of DirectPV nodes of the archive
+	Nodes int32 `json:"nodes,omitempty"`
+
+	// Drives is the number of DirectPV drives of the archive
+	Drives int32 `json:"drives,omitempty"`
+
+	// Volumes is the number of DirectPV volumes of the archive
+	Volumes int32 `json:"volumes,omitempty"`
+
+	// Message describes the state of the export
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Deployer",type=string,JSONPath=`.spec.deployer`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MetadataExport writes a single portable archive of the DirectPV nodes, drives and volumes
+// of the cluster, with the Deployer, once. A MetadataRestore imports it on another cluster.
+type MetadataExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MetadataExportSpec   `json:"spec,omitempty"`
+	Status MetadataExportStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetadataExportList contains a list of MetadataExport
+type MetadataExportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MetadataExport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MetadataExport{}, &MetadataExportList{})
+}
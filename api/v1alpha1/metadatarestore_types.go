@@ -0,0 +1,115 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetadataRestoreSpec defines the backup or the export to restore, from an object store or
+// from a ConfigMap
+type MetadataRestoreSpec struct {
+	// ObjectStore is the bucket holding the backup
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ObjectStore *ObjectStoreSpec `json:"objectStore,omitempty"`
+
+	// Object is the name of the backup object in the bucket, without the prefix of the
+	// object store. Defaults to the latest backup of the MetadataBackup named by backup.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Object string `json:"object,omitempty"`
+
+	// Backup is the name of the MetadataBackup the latest backup is restored of, when object
+	// is not set
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Backup string `json:"backup,omitempty"`
+
+	// ConfigMap is the name of a ConfigMap of the namespace holding an export, used instead
+	// of an object store
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// SkipIdentityCheck restores the drives without checking that their nodes exist and that
+	// the DirectPVNodes, when already created, report devices with their filesystems
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	SkipIdentityCheck bool `json:"skipIdentityCheck,omitempty"`
+}
+
+// MetadataRestorePhase is the phase of a MetadataRestore
+type MetadataRestorePhase string
+
+const (
+	// MetadataRestorePhasePending means the backup was not restored yet, e.g. while the
+	// DirectPV CRDs are not installed
+	MetadataRestorePhasePending MetadataRestorePhase = "Pending"
+	// MetadataRestorePhaseCompleted means the objects of the backup were restored
+	MetadataRestorePhaseCompleted MetadataRestorePhase = "Completed"
+	// MetadataRestorePhaseFailed means the backup could not be read or its drives do not
+	// match the nodes of the cluster, nothing was restored
+	MetadataRestorePhaseFailed MetadataRestorePhase = "Failed"
+)
+
+// MetadataRestoreStatus defines the observed state of MetadataRestore
+type MetadataRestoreStatus struct {
+	// Phase of the restore
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase MetadataRestorePhase `json:"phase,omitempty"`
+
+	// BackupTime is the time the restored backup was taken
+	BackupTime *metav1.Time `json:"backupTime,omitempty"`
+
+	// Drives is the number of DirectPV drives created from the backup
+	Drives int32 `json:"drives,omitempty"`
+
+	// Volumes is the number of DirectPV volumes created from the backup
+	Volumes int32 `json:"volumes,omitempty"`
+
+	// Skipped is the number of objects of the backup which already existed, they are left
+	// as they are
+	Skipped int32 `json:"skipped,omitempty"`
+
+	// Message describes the state of the restore
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MetadataRestore recreates the Deployer and the DirectPV drives and volumes of a backup or of
+// an export on a fresh cluster, before the workloads claim their volumes again. Existing
+// objects are kept.
+type MetadataRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MetadataRestoreSpec   `json:"spec,omitempty"`
+	Status MetadataRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetadataRestoreList contains a list of MetadataRestore
+type MetadataRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MetadataRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MetadataRestore{}, &MetadataRestoreList{})
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeMigrationSpec defines the volume to move and its target drive
+type VolumeMigrationSpec struct {
+	// Volume is the name of the DirectPV volume, i.e. of its PersistentVolume
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Volume string `json:"volume"`
+
+	// TargetDrive is the DirectPV identifier of the drive the volume is moved to. It must be a
+	// Ready drive of the node of the volume with enough free capacity.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	TargetDrive string `json:"targetDrive"`
+}
+
+// VolumeMigrationPhase is the phase of a VolumeMigration
+type VolumeMigrationPhase string
+
+const (
+	// VolumeMigrationPhasePending means the migration waits for the volume to be unused
+	VolumeMigrationPhasePending VolumeMigrationPhase = "Pending"
+	// VolumeMigrationPhaseCopying means the data of the volume is copied to the target drive
+	VolumeMigrationPhaseCopying VolumeMigrationPhase = "Copying"
+	// VolumeMigrationPhaseCleaningUp means the volume was switched to the target drive and
+	// its data is removed from the source drive
+	VolumeMigrationPhaseCleaningUp VolumeMigrationPhase = "CleaningUp"
+	// VolumeMigrationPhaseCompleted means the volume was moved to the target drive
+	VolumeMigrationPhaseCompleted VolumeMigrationPhase = "Completed"
+	// VolumeMigrationPhaseFailed means the volume could not be moved
+	VolumeMigrationPhaseFailed VolumeMigrationPhase = "Failed"
+)
+
+// VolumeMigrationStatus defines the observed state of VolumeMigration
+type VolumeMigrationStatus struct {
+	// Phase of the migration
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase VolumeMigrationPhase `json:"phase,omitempty"`
+
+	// Node of the volume
+	Node string `json:"node,omitempty"`
+
+	// SourceDrive is the DirectPV identifier of the drive the volume is moved from
+	SourceDrive string `json:"sourceDrive,omitempty"`
+
+	// Message describes the state of the migration
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Volume",type=string,JSONPath=`.spec.volume`
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.targetDrive`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VolumeMigration moves a DirectPV volume to another drive of its node, e.g. to retire a
+// drive without deleting the data of the workloads. The volume must not be used by pods
+// while it is migrated. It is created in the namespace of the Deployer of the node.
+type VolumeMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeMigrationSpec   `json:"spec,omitempty"`
+	Status VolumeMigrationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VolumeMigrationList contains a list of VolumeMigration
+type VolumeMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VolumeMigration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VolumeMigration{}, &VolumeMigrationList{})
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+
+	configv1alpha1 "github.com/example/directpv-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+	"github.com/example/directpv-operator/internal/controller"
+)
+
+// render prints the objects the operator creates for a Deployer as a multi-document YAML,
+// without a cluster, to review them before applying the Deployer
+func render(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s render [flags]\n\n"+
+			"Prints the objects the operator creates for the Deployer of the given YAML file.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	file := flags.String("f", "-", "The YAML file of the Deployer, - for the standard input.")
+	namespace := flags.String("namespace", "default", "The namespace of the Deployer when its metadata does not set it.")
+	configFile := flags.String("config", "", "The operator config file the default operand images are loaded from. "+
+		"The image environment variables take precedence, as for the operator.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(in)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	deployer := &cachev1alpha1.Deployer{}
+	if _, _, err := serializer.NewCodecFactory(scheme).UniversalDeserializer().Decode(data, nil, deployer); err != nil {
+		return fmt.Errorf("unable to decode the Deployer: %w", err)
+	}
+	if deployer.Namespace == "" {
+		deployer.Namespace = *namespace
+	}
+
+	operatorConfig := configv1alpha1.OperatorConfig{}
+	if *configFile != "" {
+		if _, err := (ctrl.Options{Scheme: scheme}).AndFrom(ctrl.ConfigFile().AtPath(*configFile).OfKind(&operatorConfig)); err != nil {
+			return fmt.Errorf("unable to load the config file: %w", err)
+		}
+	}
+
+	objects, err := controller.Render(deployer, scheme, operatorConfig.Images, operatorConfig.FIPSImages)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		unstructured.RemoveNestedField(content, "status")
+		unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+		// The Deployer has no UID yet, the operator sets the owner references when applying
+		unstructured.RemoveNestedField(content, "metadata", "ownerReferences")
+		document, err := yaml.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// csiDriverName is the name DirectPV registers its CSI driver with
+const csiDriverName = "directpv-min-io"
+
+// checkExistingInstallation looks for DirectPV objects created without the operator, e.g. by
+// the krew plugin or Helm. With the Refuse adoption policy they are reported as a preflight
+// failure, with the Adopt policy they are recorded in the status to be managed in place of
+// the rendered objects and true is returned.
+func (r *DeployerReconciler) checkExistingInstallation(ctx context.Context,
+	deployer *cachev1alpha1.Deployer) (bool, *preflightFailure, error) {
+	existing, err := r.detectExistingInstallation(ctx, deployer)
+	if err != nil || existing == nil {
+		return false, nil, err
+	}
+
+	var found []string
+	if existing.NodeServer != "" {
+		found = append(found, "DaemonSet "+existing.NodeServer)
+	}
+	if existing.Controller != "" {
+		found = append(found, "Deployment "+existing.Controller)
+	}
+	if existing.CSIDriver != "" {
+		found = append(found, "CSIDriver "+existing.CSIDriver)
+	}
+
+	if deployer.Spec.AdoptionPolicy != cachev1alpha1.AdoptionPolicyAdopt {
+		return false, &preflightFailure{reason: "ExistingInstallation",
+			message: fmt.Sprintf("DirectPV is already installed without the operator (%s), "+
+				"set spec.adoptionPolicy to Adopt to manage it or uninstall it first", strings.Join(found, ", "))}, nil
+	}
+
+	log.FromContext(ctx).Info("Adopting existing DirectPV installation", "Objects", found)
+	if existing.CSIDriver != "" {
+		// The CSIDriver is cluster-scoped and cannot be owned by the Deployer, it is only labelled
+		csiDriver := &storagev1.CSIDriver{}
+		if err := r.Get(ctx, types.NamespacedName{Name: existing.CSIDriver}, csiDriver); err != nil {
+			return false, nil, err
+		}
+		patch := client.MergeFrom(csiDriver.DeepCopy())
+		csiDriver.Labels = mergeLabels(csiDriver.Labels, r.labelsForMemcached(deployer.Name))
+		if err := r.Patch(ctx, csiDriver, patch); err != nil {
+			return false, nil, err
+		}
+	}
+	deployer.Status.Adopted = existing
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		return false, nil, err
+	}
+	r.Recorder.Event(deployer, "Normal", "Adopted",
+		fmt.Sprintf("Managing the existing DirectPV installation: %s", strings.Join(found, ", ")))
+	return true, nil, nil
+}
+
+// ensureAdopted makes sure an existing operand object is controlled by the Deployer. Objects
+// not created by the operator are adopted with the Adopt policy by patching the owner reference
+// and labels onto them, leaving the pod template untouched so the pods keep running. With the
+// Refuse policy the reconciliation is blocked until the object is removed.
+func (r *DeployerReconciler) ensureAdopted(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, obj client.Object) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if metav1.IsControlledBy(obj, deployer) {
+		return ctrl.Result{}, nil
+	}
+
+	kind := reflect.TypeOf(obj).Elem().Name()
+	if deployer.Spec.AdoptionPolicy != cachev1alpha1.AdoptionPolicyAdopt || isManagedByDeployer(obj) {
+		message := fmt.Sprintf("%s %s already exists and is not managed by this Deployer, "+
+			"set spec.adoptionPolicy to Adopt to manage it or remove it", kind, obj.GetName())
+		log.Info("Refusing to manage existing object", "Kind", kind, "Name", obj.GetName())
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typePreflightDeployer,
+			Status: metav1.ConditionFalse, Reason: "ExistingInstallation", Message: message})
+		if err := r.Status().Update(ctx, deployer); err != nil {
+			log.Error(err, "Failed to update Deployer status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: preflightRetryInterval}, nil
+	}
+
+	log.Info("Adopting existing object", "Kind", kind, "Name", obj.GetName())
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if err := ctrl.SetControllerReference(deployer, obj, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	obj.SetLabels(mergeLabels(obj.GetLabels(), r.labelsForMemcached(deployer.Name)))
+	if err := r.Patch(ctx, obj, patch); err != nil {
+		log.Error(err, "Failed to adopt existing object", "Kind", kind, "Name", obj.GetName())
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(deployer, "Normal", "Adopted", fmt.Sprintf("Adopted existing %s %s", kind, obj.GetName()))
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// mergeLabels returns the labels with the extra labels added
+func mergeLabels(labels, extra map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+// detectExistingInstallation returns the DirectPV objects in the namespace of the Deployer
+// which are not managed by any Deployer, or nil when there are none.
+func (r *DeployerReconciler) detectExistingInstallation(ctx context.Context,
+	deployer *cachev1alpha1.Deployer) (*cachev1alpha1.AdoptedObjects, error) {
+	existing := &cachev1alpha1.AdoptedObjects{}
+
+	daemonSetList := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, daemonSetList, client.InNamespace(deployer.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range daemonSetList.Items {
+		daemonSet := &daemonSetList.Items[i]
+		if !isManagedByDeployer(daemonSet) && hasContainer(&daemonSet.Spec.Template, "node-server") {
+			existing.NodeServer = daemonSet.Name
+			break
+		}
+	}
+
+	deploymentList := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deploymentList, client.InNamespace(deployer.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deploymentList.Items {
+		deployment := &deploymentList.Items[i]
+		if !isManagedByDeployer(deployment) && hasContainer(&deployment.Spec.Template, "controller") &&
+			hasContainer(&deployment.Spec.Template, "csi-provisioner") {
+			existing.Controller = deployment.Name
+			break
+		}
+	}
+
+	csiDriver := &storagev1.CSIDriver{}
+	err := r.Get(ctx, types.NamespacedName{Name: csiDriverName}, csiDriver)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && csiDriver.Labels["app.kubernetes.io/part-of"] != "directpv-operator" {
+		existing.CSIDriver = csiDriver.Name
+	}
+
+	if *existing == (cachev1alpha1.AdoptedObjects{}) {
+		return nil, nil
+	}
+	return existing, nil
+}
+
+// isManagedByDeployer reports whether the object is controlled by a Deployer
+func isManagedByDeployer(obj metav1.Object) bool {
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && owner.Kind == "Deployer" &&
+		strings.HasPrefix(owner.APIVersion, cachev1alpha1.GroupVersion.Group+"/")
+}
+
+// hasContainer reports whether the pod template has a container with the given name
+func hasContainer(template *corev1.PodTemplateSpec, name string) bool {
+	for _, container := range template.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
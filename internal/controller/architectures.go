@@ -0,0 +1,234 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// archPodLabel selects the node-server pods of an architecture DaemonSet
+const archPodLabel = "cache.example.com/arch"
+
+// defaultArchitectures are the architectures DirectPV images are published for
+var defaultArchitectures = []string{"amd64", "arm64", "ppc64le"}
+
+// architecturesForDeployer returns the architectures of the nodes running DirectPV
+func architecturesForDeployer(deployer *cachev1alpha1.Deployer) []string {
+	if len(deployer.Spec.Architectures) == 0 {
+		return defaultArchitectures
+	}
+	var archs []string
+	for _, arch := range deployer.Spec.Architectures {
+		archs = append(archs, arch.Name)
+	}
+	return archs
+}
+
+// archImageOverrides returns the architectures of the Deployer overriding the DirectPV image,
+// in the order of the spec
+func archImageOverrides(deployer *cachev1alpha1.Deployer) []cachev1alpha1.ArchitectureSpec {
+	var overrides []cachev1alpha1.ArchitectureSpec
+	for _, arch := range deployer.Spec.Architectures {
+		if arch.Image != "" {
+			overrides = append(overrides, arch)
+		}
+	}
+	return overrides
+}
+
+// defaultImageArchitectures returns the architectures of the Deployer running the default
+// DirectPV image
+func defaultImageArchitectures(deployer *cachev1alpha1.Deployer) []string {
+	overridden := map[string]bool{}
+	for _, arch := range archImageOverrides(deployer) {
+		overridden[arch.Name] = true
+	}
+	var archs []string
+	for _, arch := range architecturesForDeployer(deployer) {
+		if !overridden[arch] {
+			archs = append(archs, arch)
+		}
+	}
+	return archs
+}
+
+// imageForArchitecture returns the DirectPV image of the nodes of the given architecture
+func imageForArchitecture(deployer *cachev1alpha1.Deployer, arch, defaultImage string) string {
+	for _, override := range archImageOverrides(deployer) {
+		if override.Name == arch {
+			return override.Image
+		}
+	}
+	return defaultImage
+}
+
+// nodeArchitectureSupported reports whether the node has one of the architectures of the Deployer
+func nodeArchitectureSupported(deployer *cachev1alpha1.Deployer, node *corev1.Node) bool {
+	for _, arch := range architecturesForDeployer(deployer) {
+		if node.Labels[corev1.LabelArchStable] == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNodeServerArchitectures restricts the node-server DaemonSet to the architectures of the
+// Deployer, leaving out the ones with an image override which run a DaemonSet of their own
+func applyNodeServerArchitectures(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	requirements := []corev1.NodeSelectorRequirement{{
+		Key:      corev1.LabelArchStable,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   architecturesForDeployer(deployer),
+	}}
+	if overrides := archImageOverrides(deployer); len(overrides) > 0 {
+		var archs []string
+		for _, arch := range overrides {
+			archs = append(archs, arch.Name)
+		}
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      corev1.LabelArchStable,
+			Operator: corev1.NodeSelectorOpNotIn,
+			Values:   archs,
+		})
+	}
+	requireNodeSelectorRequirements(spec, requirements...)
+}
+
+// applyControllerArchitectures restricts the controller pods to the architectures running the
+// default DirectPV image. When every architecture overrides the image, the controller runs on
+// the first one with its image.
+func applyControllerArchitectures(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec, defaultImage string) {
+	archs := defaultImageArchitectures(deployer)
+	if len(archs) == 0 {
+		arch := deployer.Spec.Architectures[0]
+		archs = []string{arch.Name}
+		setContainerImages(spec, defaultImage, arch.Image)
+	}
+	requireNodeSelectorRequirements(spec, corev1.NodeSelectorRequirement{
+		Key:      corev1.LabelArchStable,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   archs,
+	})
+}
+
+// setContainerImages replaces the image of the containers running the given image
+func setContainerImages(spec *corev1.PodSpec, image, replacement string) {
+	for i := range spec.InitContainers {
+		if spec.InitContainers[i].Image == image {
+			spec.InitContainers[i].Image = replacement
+		}
+	}
+	for i := range spec.Containers {
+		if spec.Containers[i].Image == image {
+			spec.Containers[i].Image = replacement
+		}
+	}
+}
+
+// archDaemonSetName returns the name of the node-server DaemonSet of an architecture
+func archDaemonSetName(deployer *cachev1alpha1.Deployer, arch string) string {
+	return daemonSetNameForDeployer(deployer) + "-" + arch
+}
+
+// archDaemonSetForDeployer returns the node-server DaemonSet of an architecture overriding the
+// DirectPV image. It is the node-server DaemonSet restricted to the nodes of the architecture.
+func (r *DeployerReconciler) archDaemonSetForDeployer(deployer *cachev1alpha1.Deployer,
+	arch cachev1alpha1.ArchitectureSpec) (*appsv1.DaemonSet, error) {
+	daemonSet, err := r.daemonSetForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	defaultImage, err := r.imageForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	daemonSet.Name = archDaemonSetName(deployer, arch.Name)
+	daemonSet.Spec.Selector = &metav1.LabelSelector{
+		MatchLabels: mergeLabels(daemonSet.Spec.Selector.MatchLabels, map[string]string{archPodLabel: arch.Name}),
+	}
+	template := &daemonSet.Spec.Template
+	template.Labels = mergeLabels(template.Labels, map[string]string{archPodLabel: arch.Name})
+	setContainerImages(&template.Spec, verifiedImage(deployer, defaultImage), verifiedImage(deployer, arch.Image))
+	removeNodeSelectorRequirements(&template.Spec, corev1.LabelArchStable)
+	requireNodeSelectorRequirements(&template.Spec, corev1.NodeSelectorRequirement{
+		Key:      corev1.LabelArchStable,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{arch.Name},
+	})
+	if err := setPodTemplateHash(template); err != nil {
+		return nil, err
+	}
+	return daemonSet, nil
+}
+
+// reconcileArchDaemonSets creates and updates the node-server DaemonSets of the architectures
+// overriding the DirectPV image. They are updated in place unless in Warn remediation, new
+// images wait for the approval of the upgrade in Manual upgrade policy, the DaemonSets of the architectures no longer overriding the image are pruned.
+func (r *DeployerReconciler) reconcileArchDaemonSets(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	for _, arch := range archImageOverrides(deployer) {
+		desired, err := r.archDaemonSetForDeployer(deployer, arch)
+		if err != nil {
+			return err
+		}
+		daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: desired.Name, Namespace: deployer.Namespace}}
+		if err := r.createOrUpdateOwned(ctx, deployer, daemonSet, func() error {
+			if daemonSet.ResourceVersion != "" {
+				if !remediationEnforced(deployer) {
+					return nil
+				}
+				if err := preserveIgnoredFields(deployer, "DaemonSet", daemonSet, desired); err != nil {
+					return err
+				}
+			}
+			daemonSet.Labels = mergeLabels(daemonSet.Labels, desired.Labels)
+			applyCommonMetadata(deployer, daemonSet)
+			if daemonSet.Spec.Selector == nil {
+				daemonSet.Spec.Selector = desired.Spec.Selector
+			}
+			drifted, err := podTemplateDrifted(daemonSet, desired)
+			if err != nil {
+				return err
+			}
+			if !podTemplateHashEqual(&daemonSet.Spec.Template, &desired.Spec.Template) || drifted {
+				if daemonSet.ResourceVersion != "" {
+					if allowed, err := r.allowDisruptiveChange(deployer, "DaemonSet", daemonSet, desired); err != nil || !allowed {
+						return err
+					}
+					if held, err := r.holdRolloutForNodePools(ctx, deployer, daemonSet, desired); err != nil || held {
+						return err
+					}
+					if !podTemplateImagesEqual(&daemonSet.Spec.Template, &desired.Spec.Template) {
+						if held, err := r.holdUpgrade(ctx, deployer); err != nil || held {
+							return err
+						}
+					}
+				}
+				daemonSet.Spec.Template = desired.Spec.Template
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
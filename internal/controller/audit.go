@@ -0,0 +1,287 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// auditLogKey is the key of the audit log in the audit ConfigMap, one JSON entry per line
+	auditLogKey = "audit.log"
+	// maxAuditEntries is the number of entries kept in the audit ConfigMap, the oldest are dropped
+	maxAuditEntries = 200
+	// maxAuditChanges is the maximum number of changed fields recorded per entry
+	maxAuditChanges = 5
+	// auditEventReason is the reason of the events recording operand mutations
+	auditEventReason = "OperandMutated"
+)
+
+// auditEntry records a mutation of an operand object performed by the operator
+type auditEntry struct {
+	Time       metav1.Time `json:"time"`
+	Generation int64       `json:"generation"`
+	Operation  string      `json:"operation"`
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name"`
+	Changes    []string    `json:"changes,omitempty"`
+}
+
+// object identifies the mutated object, e.g. DaemonSet directpv/node-server
+func (e auditEntry) object() string {
+	if e.Namespace != "" {
+		return e.Kind + " " + e.Namespace + "/" + e.Name
+	}
+	return e.Kind + " " + e.Name
+}
+
+// String describes the entry, e.g. Updated DaemonSet directpv/node-server: spec.replicas: 1 -> 3
+func (e auditEntry) String() string {
+	description := e.Operation + " " + e.object()
+	if len(e.Changes) > 0 {
+		description += ": " + strings.Join(e.Changes, ", ")
+	}
+	return description
+}
+
+// auditTrail collects the mutations performed while reconciling a Deployer
+type auditTrail struct {
+	deployer *cachev1alpha1.Deployer
+	entries  []auditEntry
+}
+
+// auditTrailKey is the context key of the audit trail of the Deployer being reconciled
+type auditTrailKey struct{}
+
+// withAuditTrail returns a context recording the mutations performed through the auditing
+// client in a new audit trail of the Deployer
+func withAuditTrail(ctx context.Context, deployer *cachev1alpha1.Deployer) (context.Context, *auditTrail) {
+	trail := &auditTrail{deployer: deployer}
+	return context.WithValue(ctx, auditTrailKey{}, trail), trail
+}
+
+// auditTrailFromContext returns the audit trail of the context, nil if none
+func auditTrailFromContext(ctx context.Context) *auditTrail {
+	trail, _ := ctx.Value(auditTrailKey{}).(*auditTrail)
+	return trail
+}
+
+// auditConfigMapName returns the name of the ConfigMap holding the audit log of the Deployer
+func auditConfigMapName(deployer *cachev1alpha1.Deployer) string {
+	return deployer.Name + "-audit"
+}
+
+// auditingClient records the creations, updates, patches and deletions of objects in the audit
+// trail of the context and as events on its Deployer. The Deployer itself, its status and its
+// audit ConfigMap are not audited.
+type auditingClient struct {
+	client.Client
+	recorder record.EventRecorder
+}
+
+// Create creates the object and records it
+func (c *auditingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := c.Client.Create(ctx, obj, opts...)
+	c.record(ctx, "Created", obj, nil, err)
+	return err
+}
+
+// Update updates the object and records the fields it changed
+func (c *auditingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	changes := c.changes(ctx, obj)
+	err := c.Client.Update(ctx, obj, opts...)
+	c.record(ctx, "Updated", obj, changes, err)
+	return err
+}
+
+// Patch patches the object and records the fields it changed
+func (c *auditingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	changes := c.changes(ctx, obj)
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.record(ctx, "Patched", obj, changes, err)
+	return err
+}
+
+// Delete deletes the object and records it
+func (c *auditingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	c.record(ctx, "Deleted", obj, nil, err)
+	return err
+}
+
+// audited reports whether the mutations of the object are recorded in the audit trail, and
+// returns its kind
+func (c *auditingClient) audited(ctx context.Context, obj client.Object) (*auditTrail, string, bool) {
+	trail := auditTrailFromContext(ctx)
+	if trail == nil {
+		return nil, "", false
+	}
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return nil, "", false
+	}
+	switch {
+	case gvk.Group == cachev1alpha1.GroupVersion.Group && gvk.Kind == "Deployer":
+		return nil, "", false
+	case gvk.Kind == "ConfigMap" && obj.GetNamespace() == trail.deployer.Namespace &&
+		obj.GetName() == auditConfigMapName(trail.deployer):
+		return nil, "", false
+	}
+	return trail, gvk.Kind, true
+}
+
+// changes returns the fields of the live object the given one changes, e.g.
+// spec.replicas: 1 -> 3. It is computed before the object is written.
+func (c *auditingClient) changes(ctx context.Context, obj client.Object) []string {
+	if _, _, ok := c.audited(ctx, obj); !ok {
+		return nil
+	}
+	live, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil
+	}
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+		return nil
+	}
+	drift, err := objectDrift(live, obj)
+	if err != nil {
+		return nil
+	}
+	var changes []string
+	for _, d := range drift {
+		path := strings.TrimPrefix(d.path, ".")
+		if strings.HasPrefix(path, "status") || path == "metadata.resourceVersion" {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", path, driftValue(d.live), driftValue(d.desired)))
+	}
+	return changes
+}
+
+// record appends the mutation of the object to the audit trail and reports it as events, a
+// failed mutation is only reported by a lifecycle event
+func (c *auditingClient) record(ctx context.Context, operation string, obj client.Object, changes []string, err error) {
+	trail, kind, ok := c.audited(ctx, obj)
+	if !ok {
+		return
+	}
+	entry := auditEntry{
+		Time:       metav1.Now(),
+		Generation: trail.deployer.Generation,
+		Operation:  operation,
+		Kind:       kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Changes:    changes,
+	}
+	if err != nil {
+		c.recordLifecycleFailure(trail.deployer, entry, err)
+		return
+	}
+	c.recordLifecycleEvent(trail.deployer, entry)
+	if len(entry.Changes) > maxAuditChanges {
+		entry.Changes = append(entry.Changes[:maxAuditChanges:maxAuditChanges],
+			fmt.Sprintf("and %d more", len(changes)-maxAuditChanges))
+	}
+	trail.entries = append(trail.entries, entry)
+	c.recorder.Event(trail.deployer, "Normal", auditEventReason, entry.String())
+}
+
+// flushAuditTrail appends the entries of the audit trail to the audit ConfigMap of the Deployer,
+// keeping the last maxAuditEntries. Failures are logged, they do not fail the reconciliation.
+func (r *DeployerReconciler) flushAuditTrail(ctx context.Context, trail *auditTrail) {
+	if len(trail.entries) == 0 {
+		return
+	}
+	deployer := trail.deployer
+	c := r.hubClient()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: auditConfigMapName(deployer), Namespace: deployer.Namespace}, configMap)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		auditLog, err := appendAuditEntries(configMap.Data[auditLogKey], trail.entries)
+		if err != nil {
+			return err
+		}
+		if configMap.ResourceVersion == "" {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      auditConfigMapName(deployer),
+					Namespace: deployer.Namespace,
+				},
+				Data: map[string]string{auditLogKey: auditLog},
+			}
+			applyCommonMetadata(deployer, configMap)
+			if err := ctrl.SetControllerReference(deployer, configMap, r.Scheme); err != nil {
+				return err
+			}
+			return c.Create(ctx, configMap)
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[auditLogKey] = auditLog
+		return c.Update(ctx, configMap)
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to write the audit log", "ConfigMap", auditConfigMapName(deployer))
+		return
+	}
+	trail.entries = nil
+}
+
+// appendAuditEntries appends the entries to the audit log, dropping the oldest entries beyond
+// maxAuditEntries
+func appendAuditEntries(auditLog string, entries []auditEntry) (string, error) {
+	var lines []string
+	for _, line := range strings.Split(auditLog, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, string(data))
+	}
+	if len(lines) > maxAuditEntries {
+		lines = lines[len(lines)-maxAuditEntries:]
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
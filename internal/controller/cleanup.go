@@ -0,0 +1,237 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// nodeCleanupScript unmounts everything below the DirectPV data directory of the host and
+// removes it. The parent of the data directory is mounted as /host with bidirectional
+// propagation so the unmounts reach the host, the data directory name is the argument. Only
+// the data directory and the paths below it match, not its siblings sharing its prefix.
+const nodeCleanupScript = `set -e
+awk -v p="/host/$1" '$2 == p || index($2, p "/") == 1 { print $2 }' /proc/mounts | sort -r | while read -r mountpoint; do
+  umount "$mountpoint"
+done
+rm -rf "/host/$1"`
+
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+
+// cleanupNodes wipes the DirectPV state of the nodes of the Deployer with one Job per node.
+// The node-server DaemonSets are removed first so nothing uses the state anymore. It reports
+// whether all the Jobs completed, they are removed once they did.
+func (r *DeployerReconciler) cleanupNodes(ctx context.Context, deployer *cachev1alpha1.Deployer) (bool, error) {
+	log := log.FromContext(ctx)
+
+	daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{
+		Name: daemonSetNameForDeployer(deployer), Namespace: deployer.Namespace}}
+	if err := r.Delete(ctx, daemonSet); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	for _, arch := range archImageOverrides(deployer) {
+		daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{
+			Name: archDaemonSetName(deployer, arch.Name), Namespace: deployer.Namespace}}
+		if err := r.Delete(ctx, daemonSet); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return false, err
+	}
+	defaultImage, err := r.imageForDeployer(deployer)
+	if err != nil {
+		return false, err
+	}
+
+	done := true
+	var jobs []*batchv1.Job
+	var failed []string
+	for nodeName := range nodes {
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: cleanupJobName(deployer, nodeName), Namespace: deployer.Namespace}, job)
+		if apierrors.IsNotFound(err) {
+			image, err := imageForNode(ctx, r.Client, deployer, nodeName, defaultImage)
+			if err != nil {
+				return false, err
+			}
+			job = cleanupJobForNode(deployer, nodeName, image)
+			if err := ctrl.SetControllerReference(deployer, job, r.Scheme); err != nil {
+				return false, err
+			}
+			log.Info("Creating node cleanup Job", "Job.Name", job.Name, "Node", nodeName)
+			if err := r.Create(ctx, job); err != nil {
+				return false, err
+			}
+			done = false
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		jobs = append(jobs, job)
+
+		switch {
+		case jobConditionTrue(job, batchv1.JobComplete):
+		case jobConditionTrue(job, batchv1.JobFailed):
+			failed = append(failed, nodeName)
+		default:
+			done = false
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return false, fmt.Errorf("cleanup failed on nodes %s, check the logs of the cleanup Jobs "+
+			"or set spec.uninstall.policy to Retain to skip it", strings.Join(failed, ", "))
+	}
+	if !done {
+		return false, nil
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		if err := r.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation}); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	log.Info("Cleaned up the DirectPV state on the nodes", "Nodes", len(nodes))
+	return true, nil
+}
+
+// maxJobNameLength is the longest name of a Job, its name is the value of the job-name label
+// of its pods
+const maxJobNameLength = validation.LabelValueMaxLength
+
+// truncatedName shortens the name to the maximum length, replacing its end with a hash of the
+// whole name so that the truncated names stay unique
+func truncatedName(name string, maxLength int) string {
+	if len(name) <= maxLength {
+		return name
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+	return strings.TrimRight(name[:maxLength-len(hash)-1], "-.") + "-" + hash
+}
+
+// cleanupJobName returns the name of the cleanup Job of the Deployer on the given node
+func cleanupJobName(deployer *cachev1alpha1.Deployer, nodeName string) string {
+	return truncatedName(fmt.Sprintf("%s-cleanup-%s", deployer.Name, nodeName), maxJobNameLength)
+}
+
+// imageForNode returns the DirectPV image of the node, according to its architecture, pinned
+// to its verified digest
+func imageForNode(ctx context.Context, c client.Client, deployer *cachev1alpha1.Deployer,
+	nodeName, defaultImage string) (string, error) {
+	if len(archImageOverrides(deployer)) == 0 {
+		return verifiedImage(deployer, defaultImage), nil
+	}
+	node := &corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		return "", err
+	}
+	return verifiedImage(deployer, imageForArchitecture(deployer, node.Labels[corev1.LabelArchStable], defaultImage)), nil
+}
+
+// cleanupJobForNode returns a privileged Job running nodeCleanupScript on the given node
+func cleanupJobForNode(deployer *cachev1alpha1.Deployer, nodeName, image string) *batchv1.Job {
+	dataPath := path.Clean(dataPathForDeployer(deployer))
+	return nodeJobForDeployer(deployer, cleanupJobName(deployer, nodeName), "directpv-cleanup", nodeName, image,
+		path.Dir(dataPath), corev1.MountPropagationBidirectional,
+		[]string{"/bin/sh", "-c", nodeCleanupScript, "cleanup", path.Base(dataPath)})
+}
+
+// nodeJobForDeployer returns a privileged Job of the Deployer running the command on the given
+// node with the host directory mounted as /host
+func nodeJobForDeployer(deployer *cachev1alpha1.Deployer, name, appName, nodeName, image, hostPath string,
+	mountPropagation corev1.MountPropagationMode, command []string) *batchv1.Job {
+	privileged := true
+	backoffLimit := int32(3)
+	hostPathType := corev1.HostPathDirectory
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: deployer.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       appName,
+				"app.kubernetes.io/instance":   deployer.Name,
+				"app.kubernetes.io/part-of":    "directpv-operator",
+				"app.kubernetes.io/created-by": "controller-manager",
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					NodeName:           nodeName,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: nodeServerServiceAccountName(deployer),
+					Tolerations:        []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					Volumes: []corev1.Volume{{
+						Name: "host",
+						VolumeSource: corev1.VolumeSource{
+							HostPath: &corev1.HostPathVolumeSource{Path: hostPath, Type: &hostPathType},
+						},
+					}},
+					Containers: []corev1.Container{{
+						Name:            appName,
+						Image:           image,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Command:         command,
+						SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:             "host",
+							MountPath:        "/host",
+							MountPropagation: &mountPropagation,
+						}},
+					}},
+				},
+			},
+		},
+	}
+	applyCommonMetadata(deployer, job)
+	applyCommonMetadata(deployer, &job.Spec.Template)
+	return job
+}
+
+// jobConditionTrue reports whether the Job has the given condition set to true
+func jobConditionTrue(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
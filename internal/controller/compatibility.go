@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// kubernetesCompatibility lists the Kubernetes minor versions supported by each DirectPV
+// major version. A zero maxKubeMinor means there is no known upper bound.
+var kubernetesCompatibility = []struct {
+	operandMajor uint
+	minKubeMinor uint
+	maxKubeMinor uint
+}{
+	// DirectPV v3 relies on PodSecurityPolicy which was removed in Kubernetes 1.25
+	{operandMajor: 3, minKubeMinor: 18, maxKubeMinor: 24},
+	{operandMajor: 4, minKubeMinor: 20},
+}
+
+// checkKubernetesVersion verifies the given operand image is known to work with the
+// Kubernetes version of the cluster. Images without a semantic version tag are not checked.
+func (r *DeployerReconciler) checkKubernetesVersion(image string) (*preflightFailure, error) {
+	if r.SkipKubernetesVersionCheck || r.ServerVersion == nil {
+		return nil, nil
+	}
+	operandVersion, err := version.ParseGeneric(imageVersion(image))
+	if err != nil {
+		return nil, nil
+	}
+
+	info, err := r.ServerVersion.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	kubeVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, compat := range kubernetesCompatibility {
+		if compat.operandMajor != operandVersion.Major() {
+			continue
+		}
+		if kubeVersion.Minor() < compat.minKubeMinor ||
+			(compat.maxKubeMinor != 0 && kubeVersion.Minor() > compat.maxKubeMinor) {
+			supported := fmt.Sprintf("1.%d or newer", compat.minKubeMinor)
+			if compat.maxKubeMinor != 0 {
+				supported = fmt.Sprintf("1.%d to 1.%d", compat.minKubeMinor, compat.maxKubeMinor)
+			}
+			return &preflightFailure{reason: "IncompatibleKubernetesVersion",
+				message: fmt.Sprintf("DirectPV %s supports Kubernetes %s but the cluster runs %s, "+
+					"use a compatible DirectPV image or start the operator with --skip-kubernetes-version-check",
+					imageVersion(image), supported, info.GitVersion)}, nil
+		}
+	}
+	return nil, nil
+}
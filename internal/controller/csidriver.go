@@ -0,0 +1,162 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// csiSocketDir is where the directory of the CSI socket is mounted in the operand containers
+	csiSocketDir = "/csi"
+	// csiSocketName is the file name of the CSI socket
+	csiSocketName = "csi.sock"
+	// csiSocketPath is the CSI socket in the operand containers
+	csiSocketPath = csiSocketDir + "/" + csiSocketName
+	// csiEndpoint is the CSI endpoint served by DirectPV and used by the sidecars
+	csiEndpoint = "unix://" + csiSocketPath
+)
+
+// defaultKubeletDir is the root directory of the kubelet
+const defaultKubeletDir = "/var/lib/kubelet"
+
+// kubeletDirForDeployer returns the root directory of the kubelet of the nodes. The pods and
+// plugins directories below it are mounted at the same path in the node-server containers as
+// the kubelet passes their paths in the CSI calls.
+func kubeletDirForDeployer(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Spec.CSI != nil && deployer.Spec.CSI.KubeletDir != "" {
+		return path.Clean(deployer.Spec.CSI.KubeletDir)
+	}
+	return defaultKubeletDir
+}
+
+// kubeletPluginsDirForDeployer returns the plugins directory of the kubelet of the nodes
+func kubeletPluginsDirForDeployer(deployer *cachev1alpha1.Deployer) string {
+	return path.Join(kubeletDirForDeployer(deployer), "plugins")
+}
+
+// nodeSocketDirForDeployer returns the host directory of the CSI socket of the node-server,
+// registered with the kubelet
+func nodeSocketDirForDeployer(deployer *cachev1alpha1.Deployer) string {
+	return path.Join(kubeletPluginsDirForDeployer(deployer), csiDriverName)
+}
+
+// controllerSocketDirForDeployer returns the host directory of the CSI socket of the controller
+func controllerSocketDirForDeployer(deployer *cachev1alpha1.Deployer) string {
+	return path.Join(kubeletPluginsDirForDeployer(deployer), csiDriverName+"-controller")
+}
+
+// csiDriverForDeployer returns the DirectPV CSIDriver object. It is cluster-scoped and
+// therefore labelled for the Deployer instead of being owned by it.
+func (r *DeployerReconciler) csiDriverForDeployer(deployer *cachev1alpha1.Deployer) *storagev1.CSIDriver {
+	var seLinuxMount *bool
+	if deployer.Spec.SELinux != nil {
+		seLinuxMount = deployer.Spec.SELinux.Mount
+	}
+	csiDriver := &storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   csiDriverName,
+			Labels: r.labelsForMemcached(deployer.Name),
+		},
+		Spec: storagev1.CSIDriverSpec{
+			AttachRequired: &[]bool{false}[0],
+			PodInfoOnMount: &[]bool{true}[0],
+			// The API server default, set so that unsetting the override reverts it
+			RequiresRepublish: &[]bool{false}[0],
+			VolumeLifecycleModes: []storagev1.VolumeLifecycleMode{
+				storagev1.VolumeLifecyclePersistent,
+				storagev1.VolumeLifecycleEphemeral,
+			},
+			SELinuxMount:    seLinuxMount,
+			StorageCapacity: &[]bool{storageCapacityEnabledForDeployer(deployer)}[0],
+		},
+	}
+	if deployer.Spec.CSI != nil && deployer.Spec.CSI.Driver != nil {
+		driver := deployer.Spec.CSI.Driver
+		csiDriver.Spec.FSGroupPolicy = driver.FSGroupPolicy
+		if driver.PodInfoOnMount != nil {
+			csiDriver.Spec.PodInfoOnMount = driver.PodInfoOnMount
+		}
+		if driver.RequiresRepublish != nil {
+			csiDriver.Spec.RequiresRepublish = driver.RequiresRepublish
+		}
+		csiDriver.Spec.TokenRequests = driver.TokenRequests
+	}
+	applyCommonMetadata(deployer, csiDriver)
+	return csiDriver
+}
+
+// csiDriverImmutableFieldsChanged reports whether the immutable settings of the found CSIDriver
+// differ from the desired ones. An unset FSGroupPolicy keeps the one defaulted by the API server.
+func csiDriverImmutableFieldsChanged(found, desired *storagev1.CSIDriver) bool {
+	if desired.Spec.FSGroupPolicy != nil && !reflect.DeepEqual(found.Spec.FSGroupPolicy, desired.Spec.FSGroupPolicy) {
+		return true
+	}
+	return !reflect.DeepEqual(found.Spec.PodInfoOnMount, desired.Spec.PodInfoOnMount)
+}
+
+// reconcileCSIDriver creates the DirectPV CSIDriver and keeps its mutable settings in sync. The
+// CSIDriver is recreated to change its immutable settings, the mounted volumes are not affected.
+func (r *DeployerReconciler) reconcileCSIDriver(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	log := log.FromContext(ctx)
+	desired := r.csiDriverForDeployer(deployer)
+
+	found := &storagev1.CSIDriver{}
+	err := r.Get(ctx, types.NamespacedName{Name: desired.Name}, found)
+	if apierrors.IsNotFound(err) {
+		log.Info("Creating a new CSIDriver", "CSIDriver.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	if csiDriverImmutableFieldsChanged(found, desired) {
+		log.Info("Recreating the CSIDriver to change its immutable settings", "CSIDriver.Name", found.Name)
+		if err := r.Delete(ctx, found, client.Preconditions{UID: &found.UID}); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.Recorder.Event(deployer, "Normal", "CSIDriverRecreated",
+			fmt.Sprintf("Recreated the CSIDriver %s to change its fsGroupPolicy or podInfoOnMount", found.Name))
+		return r.Create(ctx, desired)
+	}
+
+	original := found.DeepCopy()
+	found.Spec.SELinuxMount = desired.Spec.SELinuxMount
+	found.Spec.RequiresRepublish = desired.Spec.RequiresRepublish
+	found.Spec.TokenRequests = desired.Spec.TokenRequests
+	found.Spec.StorageCapacity = desired.Spec.StorageCapacity
+	mergeObjectMetadata(found, desired)
+	if reflect.DeepEqual(found, original) {
+		return nil
+	}
+	patch := client.MergeFrom(original)
+	log.Info("Updating the CSIDriver", "CSIDriver.Name", found.Name)
+	return r.Patch(ctx, found, patch)
+}
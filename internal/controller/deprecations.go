@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// defaultControllerReplicas is the number of controller pods when neither controller.replicas
+// nor the deprecated size is set
+const defaultControllerReplicas = 1
+
+// controllerReplicasForDeployer returns the number of controller pods of the Deployer:
+// controller.replicas, else the deprecated size, else defaultControllerReplicas
+func controllerReplicasForDeployer(deployer *cachev1alpha1.Deployer) int32 {
+	if controller := deployer.Spec.Controller; controller != nil && controller.Replicas != nil {
+		return *controller.Replicas
+	}
+	if deployer.Spec.Size > 0 {
+		return deployer.Spec.Size
+	}
+	return defaultControllerReplicas
+}
+
+// deprecatedFields describes the deprecated fields set on the Deployer
+func deprecatedFields(deployer *cachev1alpha1.Deployer) []string {
+	var fields []string
+	if deployer.Spec.Size > 0 {
+		if controller := deployer.Spec.Controller; controller != nil && controller.Replicas != nil {
+			fields = append(fields, "spec.size is ignored, spec.controller.replicas is set")
+		} else {
+			fields = append(fields, fmt.Sprintf("spec.size is deprecated, set spec.controller.replicas to %d instead", deployer.Spec.Size))
+		}
+	}
+	return fields
+}
+
+// updateDeprecatedFields reports the deprecated fields set on the Deployer in the
+// DeprecatedFields condition, with a Warning event when they are first set. The status is
+// persisted with the other status changes.
+func (r *DeployerReconciler) updateDeprecatedFields(deployer *cachev1alpha1.Deployer) {
+	fields := deprecatedFields(deployer)
+	if len(fields) == 0 {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeDeprecatedFieldsDeployer)
+		return
+	}
+	message := strings.Join(fields, "; ")
+	if condition := meta.FindStatusCondition(deployer.Status.Conditions, typeDeprecatedFieldsDeployer); condition == nil ||
+		condition.Message != message {
+		r.Recorder.Event(deployer, "Warning", "DeprecatedFields", message)
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDeprecatedFieldsDeployer,
+		Status: metav1.ConditionTrue, Reason: "DeprecatedFieldsSet", Message: message})
+}
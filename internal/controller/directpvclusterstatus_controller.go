@@ -0,0 +1,232 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// clusterStatusName is the name of the DirectPVClusterStatus maintained by the operator
+const clusterStatusName = "cluster"
+
+// clusterStatusInterval is how often the aggregated state is recomputed, on top of the watched
+// changes
+const clusterStatusInterval = time.Minute
+
+// DirectPVClusterStatusReconciler aggregates the Deployers, the nodes and the DirectPV drives
+// and volumes of the cluster into the DirectPVClusterStatus
+type DirectPVClusterStatusReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=directpvclusterstatuses,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=directpvclusterstatuses/status,verbs=get;update;patch
+
+// Reconcile creates the DirectPVClusterStatus if needed and updates its status when the
+// aggregated state changed
+func (r *DirectPVClusterStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if req.Name != clusterStatusName {
+		return ctrl.Result{}, nil
+	}
+
+	clusterStatus := &cachev1alpha1.DirectPVClusterStatus{}
+	err := r.Get(ctx, types.NamespacedName{Name: clusterStatusName}, clusterStatus)
+	if apierrors.IsNotFound(err) {
+		clusterStatus = &cachev1alpha1.DirectPVClusterStatus{ObjectMeta: metav1.ObjectMeta{
+			Name:   clusterStatusName,
+			Labels: map[string]string{"app.kubernetes.io/part-of": "directpv-operator"},
+		}}
+		log.Info("Creating the DirectPVClusterStatus", "Name", clusterStatusName)
+		err = r.Create(ctx, clusterStatus)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status, err := r.aggregate(ctx)
+	if err != nil {
+		log.Error(err, "Failed to aggregate the DirectPV state")
+		return ctrl.Result{}, err
+	}
+	status.LastUpdateTime = clusterStatus.Status.LastUpdateTime
+	if status.LastUpdateTime != nil && equality.Semantic.DeepEqual(*status, clusterStatus.Status) {
+		return ctrl.Result{RequeueAfter: clusterStatusInterval}, nil
+	}
+	now := metav1.Now()
+	status.LastUpdateTime = &now
+	clusterStatus.Status = *status
+	if err := r.Status().Update(ctx, clusterStatus); err != nil {
+		log.Error(err, "Failed to update DirectPVClusterStatus status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: clusterStatusInterval}, nil
+}
+
+// aggregate computes the state of DirectPV in the cluster
+func (r *DirectPVClusterStatusReconciler) aggregate(ctx context.Context) (*cachev1alpha1.DirectPVClusterStatusStatus, error) {
+	status := &cachev1alpha1.DirectPVClusterStatusStatus{}
+
+	deployers := &cachev1alpha1.DeployerList{}
+	if err := r.List(ctx, deployers); err != nil {
+		return nil, err
+	}
+	for _, deployer := range deployers.Items {
+		status.Deployers = append(status.Deployers, cachev1alpha1.DeployerSummary{
+			Namespace:        deployer.Namespace,
+			Name:             deployer.Name,
+			InstalledVersion: deployer.Status.InstalledVersion,
+			Available:        meta.IsStatusConditionTrue(deployer.Status.Conditions, typeAvailableDeployer),
+		})
+	}
+	sort.Slice(status.Deployers, func(i, j int) bool {
+		a, b := status.Deployers[i], status.Deployers[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+
+	objects := map[string][]unstructured.Unstructured{}
+	for _, gvk := range []string{directPVNodeGVK.Kind, directPVDriveGVK.Kind, directPVVolumeGVK.Kind} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(directPVNodeGVK.GroupVersion().WithKind(gvk + "List"))
+		if err := r.List(ctx, list); err != nil && !meta.IsNoMatchError(err) {
+			return nil, err
+		}
+		objects[gvk] = list.Items
+	}
+	status.Volumes = int32(len(objects[directPVVolumeGVK.Kind]))
+
+	nodes := map[string]*cachev1alpha1.NodeStorageStatus{}
+	totals := map[string][2]int64{}
+	nodeStatus := func(name string) *cachev1alpha1.NodeStorageStatus {
+		if nodes[name] == nil {
+			nodes[name] = &cachev1alpha1.NodeStorageStatus{Name: name, Health: cachev1alpha1.NodeHealthDegraded}
+		}
+		return nodes[name]
+	}
+	for _, directPVNode := range objects[directPVNodeGVK.Kind] {
+		nodeStatus(directPVNode.GetName()).Health = cachev1alpha1.NodeHealthHealthy
+	}
+	var total, free int64
+	for _, drive := range objects[directPVDriveGVK.Kind] {
+		node := drive.GetLabels()["directpv.min.io/node"]
+		driveStatus, _, _ := unstructured.NestedString(drive.Object, "status", "status")
+		driveTotal, _, _ := unstructured.NestedInt64(drive.Object, "status", "totalCapacity")
+		driveFree, _, _ := unstructured.NestedInt64(drive.Object, "status", "freeCapacity")
+		utilization := cachev1alpha1.DriveUtilization{
+			Name:              drive.GetName(),
+			Node:              node,
+			Device:            drive.GetLabels()["directpv.min.io/drive-name"],
+			Status:            driveStatus,
+			CapacityTotal:     *resource.NewQuantity(driveTotal, resource.BinarySI),
+			CapacityAllocated: *resource.NewQuantity(driveTotal-driveFree, resource.BinarySI),
+		}
+		if driveTotal > 0 {
+			utilization.UsagePercent = int32((driveTotal - driveFree) * 100 / driveTotal)
+		}
+		status.Drives = append(status.Drives, utilization)
+
+		nodeStorage := nodeStatus(node)
+		nodeStorage.Drives++
+		if driveStatus == "Ready" {
+			nodeStorage.DrivesReady++
+		}
+		totals[node] = [2]int64{totals[node][0] + driveTotal, totals[node][1] + driveFree}
+		total += driveTotal
+		free += driveFree
+	}
+	sort.Slice(status.Drives, func(i, j int) bool {
+		a, b := status.Drives[i], status.Drives[j]
+		return a.Node < b.Node || (a.Node == b.Node && a.Name < b.Name)
+	})
+	status.CapacityTotal = *resource.NewQuantity(total, resource.BinarySI)
+	status.CapacityFree = *resource.NewQuantity(free, resource.BinarySI)
+
+	var names []string
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nodeStorage := nodes[name]
+		nodeStorage.CapacityTotal = *resource.NewQuantity(totals[name][0], resource.BinarySI)
+		nodeStorage.CapacityFree = *resource.NewQuantity(totals[name][1], resource.BinarySI)
+		if nodeStorage.DrivesReady < nodeStorage.Drives {
+			nodeStorage.Health = cachev1alpha1.NodeHealthDegraded
+		}
+		node := &corev1.Node{}
+		err := r.Get(ctx, types.NamespacedName{Name: name}, node)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		if err != nil || !nodeReady(node) {
+			nodeStorage.Health = cachev1alpha1.NodeHealthNotReady
+		}
+		status.Nodes = append(status.Nodes, *nodeStorage)
+	}
+	return status, nil
+}
+
+// nodeReady reports whether the Ready condition of the node is true
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager. Every watched change recomputes
+// the single DirectPVClusterStatus.
+func (r *DirectPVClusterStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	toClusterStatus := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: clusterStatusName}}}
+	})
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.DirectPVClusterStatus{}).
+		Watches(&source.Kind{Type: &cachev1alpha1.Deployer{}}, toClusterStatus).
+		Watches(&source.Kind{Type: &corev1.Node{}}, toClusterStatus)
+	for _, gvk := range []string{directPVNodeGVK.Kind, directPVDriveGVK.Kind, directPVVolumeGVK.Kind} {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(directPVNodeGVK.GroupVersion().WithKind(gvk))
+		builder = builder.Watches(&source.Kind{Type: obj}, toClusterStatus)
+	}
+	return builder.Complete(r)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// maxDriftDescriptions is the maximum number of differences listed by the DriftDetected condition
+const maxDriftDescriptions = 10
+
+// remediationEnforced reports whether the operand workloads are updated to the rendered ones
+func remediationEnforced(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.Remediation != cachev1alpha1.RemediationWarn
+}
+
+// fieldDrift is a field of a live object differing from the rendered one
+type fieldDrift struct {
+	path    string
+	live    interface{}
+	desired interface{}
+}
+
+// String describes the difference, e.g. spec.replicas is 3 instead of 1
+func (d fieldDrift) String() string {
+	return fmt.Sprintf("%s is %s instead of %s", d.path, driftValue(d.live), driftValue(d.desired))
+}
+
+// driftValue formats a field value of a drift description, shortening long values
+func driftValue(value interface{}) string {
+	if value == nil {
+		return "unset"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	if len(data) > 64 {
+		return string(data[:61]) + "..."
+	}
+	return string(data)
+}
+
+// objectDrift returns the fields under the given path of the rendered object the live one
+// differs from. Fields only set on the live object, e.g. defaulted by the API server, are not
+// drift. The pod template hash annotation is left out, the fields it covers are compared. The
+// ignored fields are taken from the live object by preserveIgnoredFields beforehand.
+func objectDrift(live, desired client.Object, path ...string) ([]fieldDrift, error) {
+	liveContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return nil, err
+	}
+	desiredContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return nil, err
+	}
+	liveValue, _, _ := unstructured.NestedFieldNoCopy(liveContent, path...)
+	desiredValue, _, _ := unstructured.NestedFieldNoCopy(desiredContent, path...)
+	var drift []fieldDrift
+	diffValues(strings.Join(path, "."), liveValue, desiredValue, &drift)
+	return drift, nil
+}
+
+// diffValues appends the differences between the live and the desired value of a field.
+// Lists of named items, e.g. containers or env, are compared by name.
+func diffValues(path string, live, desired interface{}, drift *[]fieldDrift) {
+	switch desired := desired.(type) {
+	case nil:
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok && live != nil {
+			*drift = append(*drift, fieldDrift{path: path, live: live, desired: desired})
+			return
+		}
+		keys := make([]string, 0, len(desired))
+		for key := range desired {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == podTemplateHashAnnotation {
+				continue
+			}
+			diffValues(path+"."+key, liveMap[key], desired[key], drift)
+		}
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok && live != nil {
+			*drift = append(*drift, fieldDrift{path: path, live: live, desired: desired})
+			return
+		}
+		if !namedItems(desired) || !namedItems(liveList) {
+			if len(liveList) != len(desired) {
+				*drift = append(*drift, fieldDrift{path: path, live: live, desired: desired})
+				return
+			}
+			for i := range desired {
+				diffValues(fmt.Sprintf("%s[%d]", path, i), liveList[i], desired[i], drift)
+			}
+			return
+		}
+		liveItems := map[string]interface{}{}
+		for _, item := range liveList {
+			liveItems[itemName(item)] = item
+		}
+		desiredItems := map[string]bool{}
+		for _, item := range desired {
+			name := itemName(item)
+			desiredItems[name] = true
+			diffValues(fmt.Sprintf("%s[name=%s]", path, name), liveItems[name], item, drift)
+		}
+		for _, item := range liveList {
+			if name := itemName(item); !desiredItems[name] {
+				*drift = append(*drift, fieldDrift{path: fmt.Sprintf("%s[name=%s]", path, name), live: item})
+			}
+		}
+	default:
+		if !reflect.DeepEqual(live, desired) {
+			*drift = append(*drift, fieldDrift{path: path, live: live, desired: desired})
+		}
+	}
+}
+
+// namedItems reports whether the list items are objects identified by their name, volume
+// mounts may share the name of their volume and are then compared by position
+func namedItems(items []interface{}) bool {
+	names := map[string]bool{}
+	for _, item := range items {
+		name := itemName(item)
+		if name == "" || names[name] {
+			return false
+		}
+		names[name] = true
+	}
+	return len(items) > 0
+}
+
+// itemName returns the name of a list item, empty if it has none
+func itemName(item interface{}) string {
+	object, _ := item.(map[string]interface{})
+	name, _ := object["name"].(string)
+	return name
+}
+
+// podTemplateDrifted reports whether the pod template of the live workload differs from the
+// rendered one
+func podTemplateDrifted(live, desired client.Object) (bool, error) {
+	drift, err := objectDrift(live, desired, "spec", "template")
+	return len(drift) > 0, err
+}
+
+// reconcileDrift compares the operand workloads with the rendered ones in Warn remediation
+// and publishes their differences in the DriftDetected condition, with an event when they
+// change. The status is persisted at the end of the reconciliation.
+func (r *DeployerReconciler) reconcileDrift(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	if remediationEnforced(deployer) {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeDriftDetectedDeployer)
+		return nil
+	}
+
+	type workload struct {
+		kind    string
+		live    client.Object
+		desired func() (client.Object, error)
+	}
+	workloads := []workload{
+		{"DaemonSet", &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: daemonSetNameForDeployer(deployer)}},
+			func() (client.Object, error) { return r.daemonSetForDeployer(deployer) }},
+	}
+	for _, arch := range archImageOverrides(deployer) {
+		arch := arch
+		workloads = append(workloads, workload{"DaemonSet",
+			&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: archDaemonSetName(deployer, arch.Name)}},
+			func() (client.Object, error) { return r.archDaemonSetForDeployer(deployer, arch) }})
+	}
+	workloads = append(workloads, workload{"Deployment",
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: deploymentNameForDeployer(deployer)}},
+		func() (client.Object, error) { return r.deploymentForDeployer(deployer) }})
+
+	var descriptions []string
+	for _, w := range workloads {
+		err := r.Get(ctx, types.NamespacedName{Name: w.live.GetName(), Namespace: deployer.Namespace}, w.live)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+		desired, err := w.desired()
+		if err != nil {
+			return err
+		}
+		if err := preserveIgnoredFields(deployer, w.kind, w.live, desired); err != nil {
+			return err
+		}
+		drift, err := objectDrift(w.live, desired, "spec")
+		if err != nil {
+			return err
+		}
+		for _, d := range drift {
+			descriptions = append(descriptions, fmt.Sprintf("%s %s: %s", w.kind, w.live.GetName(), d))
+		}
+	}
+
+	previous := meta.FindStatusCondition(deployer.Status.Conditions, typeDriftDetectedDeployer)
+	if len(descriptions) == 0 {
+		if previous != nil && previous.Status == metav1.ConditionTrue {
+			r.Recorder.Event(deployer, "Normal", "DriftResolved", "The operand workloads match the Deployer again")
+		}
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDriftDetectedDeployer,
+			Status: metav1.ConditionFalse, Reason: "InSync", Message: "The operand workloads match the Deployer"})
+		return nil
+	}
+
+	if len(descriptions) > maxDriftDescriptions {
+		descriptions = append(descriptions[:maxDriftDescriptions],
+			fmt.Sprintf("and %d more differences", len(descriptions)-maxDriftDescriptions))
+	}
+	message := "The operand workloads differ from the Deployer and are not updated in Warn remediation: " +
+		strings.Join(descriptions, "; ")
+	if previous == nil || previous.Message != message {
+		r.Recorder.Event(deployer, "Warning", "DriftDetected", message)
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDriftDetectedDeployer,
+		Status: metav1.ConditionTrue, Reason: "DriftDetected", Message: message})
+	return nil
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1alpha1 "github.com/example/directpv-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// Conditions reporting the steps of a DriveDecommission
+const (
+	typeCordonedDecommission  = "Cordoned"
+	typeEvacuatedDecommission = "Evacuated"
+	typeReleasedDecommission  = "Released"
+	typeWipedDecommission     = "Wiped"
+)
+
+// driveStatusRemoved is the status kubectl directpv remove sets on a drive for DirectPV to
+// release it
+const driveStatusRemoved = "Removed"
+
+// driveDecommissionPollInterval is how often the evacuation and release of a drive are checked
+const driveDecommissionPollInterval = 10 * time.Second
+
+// DriveDecommissionReconciler cordons, evacuates and releases the drives of the DriveDecommissions
+type DriveDecommissionReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// DefaultImages are the operand images loaded from the manager's config file
+	DefaultImages configv1alpha1.OperandImages
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=drivedecommissions,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=drivedecommissions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=delete
+
+// Reconcile moves the DriveDecommission through its steps: the drive is cordoned, its volumes
+// are evacuated according to the policy and the drive is then marked removed for DirectPV to
+// release it. The decommission completes once DirectPV deleted the drive and its device was
+// wiped according to the wipe policy of the Deployer of the node. A DriveDecommission
+// deleted before completing leaves the drive cordoned.
+func (r *DriveDecommissionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	decommission := &cachev1alpha1.DriveDecommission{}
+	if err := r.Get(ctx, req.NamespacedName, decommission); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if decommission.Status.Phase == cachev1alpha1.DriveDecommissionPhaseCompleted {
+		return ctrl.Result{}, nil
+	}
+
+	before := decommission.Status.Phase
+	err := r.decommission(ctx, decommission)
+	decommission.Status.Message = ""
+	if err != nil {
+		log.Error(err, "Failed to decommission the drive")
+		decommission.Status.Message = err.Error()
+	}
+	if decommission.Status.Phase != before && decommission.Status.Phase == cachev1alpha1.DriveDecommissionPhaseCompleted {
+		r.Recorder.Event(decommission, "Normal", "DriveReleased",
+			fmt.Sprintf("Released the drive %s of the node %s", decommission.Status.DriveID, decommission.Status.Node))
+	}
+	if updateErr := r.Status().Update(ctx, decommission); updateErr != nil {
+		log.Error(updateErr, "Failed to update DriveDecommission status")
+		return ctrl.Result{}, updateErr
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	switch decommission.Status.Phase {
+	case cachev1alpha1.DriveDecommissionPhaseEvacuating, cachev1alpha1.DriveDecommissionPhaseReleasing,
+		cachev1alpha1.DriveDecommissionPhaseWiping:
+		return ctrl.Result{RequeueAfter: driveDecommissionPollInterval}, nil
+	case cachev1alpha1.DriveDecommissionPhasePending:
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// decommission runs the next steps of the DriveDecommission and updates its status
+func (r *DriveDecommissionReconciler) decommission(ctx context.Context, decommission *cachev1alpha1.DriveDecommission) error {
+	status := &decommission.Status
+	drive, err := r.findDrive(ctx, decommission)
+	if err != nil {
+		return err
+	}
+	if drive == nil {
+		if meta.IsStatusConditionTrue(status.Conditions, typeEvacuatedDecommission) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeReleasedDecommission,
+				Status: metav1.ConditionTrue, Reason: "DriveDeleted", Message: "DirectPV released the drive"})
+			return r.wipeReleasedDrive(ctx, decommission)
+		}
+		status.Phase = cachev1alpha1.DriveDecommissionPhasePending
+		return fmt.Errorf("no DirectPV drive matches the drive reference")
+	}
+	status.DriveID = drive.GetName()
+	status.Node = drive.GetLabels()["directpv.min.io/node"]
+
+	if err := r.cordonDrive(ctx, decommission, drive); err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeCordonedDecommission,
+		Status: metav1.ConditionTrue, Reason: "DriveCordoned", Message: "The drive is excluded from volume scheduling"})
+
+	volumes, err := r.volumesOfDrive(ctx, drive.GetName())
+	if err != nil {
+		return err
+	}
+	status.Volumes = int32(len(volumes))
+	if len(volumes) > 0 {
+		status.Phase = cachev1alpha1.DriveDecommissionPhaseEvacuating
+		message := fmt.Sprintf("Waiting for the %d volumes of the drive to be deleted", len(volumes))
+		if decommission.Spec.Evacuation == cachev1alpha1.EvacuationPolicyDeleteClaims {
+			message = fmt.Sprintf("Deleting the claims of the %d volumes of the drive", len(volumes))
+			for i := range volumes {
+				if err := r.deleteVolumeClaim(ctx, volumes[i].GetName()); err != nil {
+					return err
+				}
+			}
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeEvacuatedDecommission,
+			Status: metav1.ConditionFalse, Reason: "VolumesRemaining", Message: message})
+		return nil
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeEvacuatedDecommission,
+		Status: metav1.ConditionTrue, Reason: "NoVolumes", Message: "The drive has no volumes left"})
+
+	status.Phase = cachev1alpha1.DriveDecommissionPhaseReleasing
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeReleasedDecommission,
+		Status: metav1.ConditionFalse, Reason: "Releasing", Message: "Waiting for DirectPV to release the drive"})
+
+	// The device of the drive is identified before DirectPV deletes it
+	if status.Wipe == nil {
+		deployer, err := deployerForNode(ctx, r.Client, decommission.Namespace, status.Node)
+		if err != nil {
+			return err
+		}
+		if deployer != nil {
+			status.Wipe = driveWipeForDeployer(deployer, drive)
+		}
+	}
+	if driveStatus, _, _ := unstructured.NestedString(drive.Object, "status", "status"); driveStatus == driveStatusRemoved {
+		return nil
+	}
+	log.FromContext(ctx).Info("Releasing DirectPV drive", "Drive.Name", drive.GetName())
+	patch := client.MergeFrom(drive.DeepCopy())
+	if err := unstructured.SetNestedField(drive.Object, driveStatusRemoved, "status", "status"); err != nil {
+		return err
+	}
+	return r.Patch(ctx, drive, patch)
+}
+
+// wipeReleasedDrive wipes the device of the released drive when the Deployer of its node has a
+// wipe policy, the decommission then completes
+func (r *DriveDecommissionReconciler) wipeReleasedDrive(ctx context.Context, decommission *cachev1alpha1.DriveDecommission) error {
+	status := &decommission.Status
+	if status.Wipe == nil {
+		status.Phase = cachev1alpha1.DriveDecommissionPhaseCompleted
+		return nil
+	}
+	deployer, err := deployerForNode(ctx, r.Client, decommission.Namespace, status.Node)
+	if err != nil {
+		return err
+	}
+	if deployer == nil {
+		return fmt.Errorf("no Deployer of the namespace runs DirectPV on the node %s to wipe the drive", status.Node)
+	}
+	defaultImage, err := imageFromEnv("DIRECTPV_IMAGE", r.DefaultImages.DirectPV)
+	if err != nil {
+		return err
+	}
+	if err := wipeDrive(ctx, r.Client, r.Scheme, decommission, deployer, status.Wipe, defaultImage); err != nil {
+		return err
+	}
+
+	switch status.Wipe.State {
+	case cachev1alpha1.DriveWipeStatePending:
+		status.Phase = cachev1alpha1.DriveDecommissionPhaseWiping
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeWipedDecommission,
+			Status: metav1.ConditionFalse, Reason: "Wiping", Message: fmt.Sprintf("Wiping the device %s with the %s policy",
+				status.Wipe.Device, status.Wipe.Policy)})
+	case cachev1alpha1.DriveWipeStateCompleted:
+		status.Phase = cachev1alpha1.DriveDecommissionPhaseCompleted
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeWipedDecommission,
+			Status: metav1.ConditionTrue, Reason: "Wiped", Message: fmt.Sprintf("Wiped the device %s", status.Wipe.Device)})
+	case cachev1alpha1.DriveWipeStateFailed:
+		status.Phase = cachev1alpha1.DriveDecommissionPhaseCompleted
+		message := fmt.Sprintf("The device %s could not be wiped, it may not hold the filesystem of the drive anymore",
+			status.Wipe.Device)
+		if status.Wipe.FSUUID == "" {
+			message = fmt.Sprintf("The device %s was not wiped: %s", status.Wipe.Device, status.Wipe.Message)
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: typeWipedDecommission,
+			Status: metav1.ConditionFalse, Reason: "WipeFailed", Message: message})
+		r.Recorder.Event(decommission, "Warning", "DriveWipeFailed", meta.FindStatusCondition(status.Conditions,
+			typeWipedDecommission).Message)
+	}
+	return nil
+}
+
+// findDrive returns the DirectPV drive referenced by the DriveDecommission, nil when it does
+// not exist
+func (r *DriveDecommissionReconciler) findDrive(ctx context.Context,
+	decommission *cachev1alpha1.DriveDecommission) (*unstructured.Unstructured, error) {
+	ref := decommission.Spec.Drive
+	id := ref.ID
+	if id == "" {
+		// Keep following the drive found first, the device name may be reused by its replacement
+		id = decommission.Status.DriveID
+	}
+	if id != "" {
+		drive := &unstructured.Unstructured{}
+		drive.SetGroupVersionKind(directPVDriveGVK)
+		err := r.Get(ctx, types.NamespacedName{Name: id}, drive)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return drive, err
+	}
+	if ref.Node == "" || ref.Device == "" {
+		return nil, fmt.Errorf("the drive reference needs an id, or a node and a device")
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(directPVDriveGVK.GroupVersion().WithKind(directPVDriveGVK.Kind + "List"))
+	if err := r.List(ctx, list, client.MatchingLabels{
+		"directpv.min.io/node":       ref.Node,
+		"directpv.min.io/drive-name": ref.Device,
+	}); err != nil {
+		return nil, err
+	}
+	switch len(list.Items) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &list.Items[0], nil
+	}
+	return nil, fmt.Errorf("%d DirectPV drives match the device %s of the node %s, reference the drive by id",
+		len(list.Items), ref.Device, ref.Node)
+}
+
+// cordonDrive excludes the drive from volume scheduling. The drive is annotated for the
+// DriveDecommission so that the cordon selectors of the Deployers do not uncordon it.
+func (r *DriveDecommissionReconciler) cordonDrive(ctx context.Context, decommission *cachev1alpha1.DriveDecommission,
+	drive *unstructured.Unstructured) error {
+	if unschedulable, _, _ := unstructured.NestedBool(drive.Object, "spec", "unschedulable"); unschedulable {
+		return nil
+	}
+	log.FromContext(ctx).Info("Cordoning DirectPV drive", "Drive.Name", drive.GetName())
+	patch := client.MergeFrom(drive.DeepCopy())
+	if err := unstructured.SetNestedField(drive.Object, true, "spec", "unschedulable"); err != nil {
+		return err
+	}
+	annotations := drive.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[driveCordonedByAnnotation] = "DriveDecommission/" + decommission.Namespace + "/" + decommission.Name
+	drive.SetAnnotations(annotations)
+	return r.Patch(ctx, drive, patch)
+}
+
+// volumesOfDrive returns the DirectPV volumes of the drive
+func (r *DriveDecommissionReconciler) volumesOfDrive(ctx context.Context, driveID string) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(directPVVolumeGVK.GroupVersion().WithKind(directPVVolumeGVK.Kind + "List"))
+	if err := r.List(ctx, list, client.MatchingLabels{"directpv.min.io/drive": driveID}); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// deleteVolumeClaim deletes the claim bound to the PersistentVolume of the DirectPV volume and
+// the pods using it, the claim is otherwise protected until they terminate
+func (r *DriveDecommissionReconciler) deleteVolumeClaim(ctx context.Context, volumeName string) error {
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, types.NamespacedName{Name: volumeName}, pv); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	claimRef := pv.Spec.ClaimRef
+	if claimRef == nil {
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(claimRef.Namespace)); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		for _, volume := range pods.Items[i].Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != claimRef.Name {
+				continue
+			}
+			log.FromContext(ctx).Info("Deleting pod using an evacuated volume",
+				"Pod.Namespace", pods.Items[i].Namespace, "Pod.Name", pods.Items[i].Name)
+			if err := r.Delete(ctx, &pods.Items[i]); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: claimRef.Name, Namespace: claimRef.Namespace}}
+	log.FromContext(ctx).Info("Deleting claim of an evacuated volume",
+		"PersistentVolumeClaim.Namespace", claim.Namespace, "PersistentVolumeClaim.Name", claim.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, claim, client.Preconditions{UID: &claimRef.UID}))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DriveDecommissionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.DriveDecommission{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,628 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1alpha1 "github.com/example/directpv-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const driveInitFinalizer = "cache.example.com/driveinit-finalizer"
+
+// driveInitNamespaceLabel records the namespace of the DriveInit on its DirectPVInitRequests
+const driveInitNamespaceLabel = "cache.example.com/driveinit-namespace"
+
+// driveInitPollInterval is how often the DirectPVInitRequests being processed are checked
+const driveInitPollInterval = 10 * time.Second
+
+var (
+	directPVNodeGVK        = schema.GroupVersionKind{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVNode"}
+	directPVInitRequestGVK = schema.GroupVersionKind{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVInitRequest"}
+)
+
+// DriveInitReconciler initializes the devices selected by the DriveInits through DirectPVInitRequests
+type DriveInitReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// DefaultImages are the images of the operands, the DirectPV image runs the encryption Jobs
+	DefaultImages configv1alpha1.OperandImages
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=driveinits,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=driveinits/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=driveinits/finalizers,verbs=update
+//+kubebuilder:rbac:groups=directpv.min.io,resources=directpvnodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=directpv.min.io,resources=directpvinitrequests,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile creates a DirectPVInitRequest per node for the devices matching the selectors of
+// the DriveInit and records their results in its status. The processed requests are deleted.
+// Devices are selected again when the spec changes, the ones which failed are then retried.
+// When the Deployer of the node encrypts its drives, the devices are first encrypted by a Job
+// and DirectPV formats the opened encrypted devices. The devices of the nodes without a
+// Deployer in the namespace of the DriveInit fail.
+func (r *DriveInitReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	driveInit := &cachev1alpha1.DriveInit{}
+	if err := r.Get(ctx, req.NamespacedName, driveInit); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !driveInit.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(driveInit, driveInitFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.deleteInitRequests(ctx, driveInit); err != nil {
+			log.Error(err, "Failed to delete the DirectPVInitRequests")
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(driveInit, driveInitFinalizer)
+		return ctrl.Result{}, r.Update(ctx, driveInit)
+	}
+	if controllerutil.AddFinalizer(driveInit, driveInitFinalizer) {
+		if err := r.Update(ctx, driveInit); err != nil {
+			log.Error(err, "Failed to add the finalizer to the DriveInit")
+			return ctrl.Result{}, err
+		}
+	}
+
+	before := driveInit.Status.DeepCopy()
+	err := r.initialize(ctx, driveInit)
+	driveInit.Status.Message = ""
+	if err != nil {
+		log.Error(err, "Failed to initialize the drives")
+		driveInit.Status.Message = err.Error()
+	}
+	driveInit.Status.Phase = driveInitPhase(driveInit.Status.Drives)
+	if driveInit.Status.Phase != before.Phase {
+		switch driveInit.Status.Phase {
+		case cachev1alpha1.DriveInitPhaseCompleted:
+			r.Recorder.Event(driveInit, "Normal", "DrivesInitialized",
+				fmt.Sprintf("Initialized %d drives", len(driveInit.Status.Drives)))
+		case cachev1alpha1.DriveInitPhaseFailed:
+			r.Recorder.Event(driveInit, "Warning", "DriveInitFailed",
+				"Some drives failed to initialize, see status.drives")
+		}
+	}
+	if updateErr := r.Status().Update(ctx, driveInit); updateErr != nil {
+		log.Error(updateErr, "Failed to update DriveInit status")
+		return ctrl.Result{}, updateErr
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	switch driveInit.Status.Phase {
+	case cachev1alpha1.DriveInitPhaseInitializing:
+		return ctrl.Result{RequeueAfter: driveInitPollInterval}, nil
+	case cachev1alpha1.DriveInitPhasePending:
+		// Wait for DirectPV to discover matching devices
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// initialize records the results of the requests being processed and, once none is left,
+// creates the requests of the selected devices not initialized yet
+func (r *DriveInitReconciler) initialize(ctx context.Context, driveInit *cachev1alpha1.DriveInit) error {
+	pending, err := r.updateResults(ctx, driveInit)
+	if err != nil || pending {
+		return err
+	}
+
+	if driveInit.Status.ObservedGeneration != driveInit.Generation {
+		// Retry the failed devices with the new spec
+		var drives []cachev1alpha1.DriveInitResult
+		for _, drive := range driveInit.Status.Drives {
+			if drive.State != cachev1alpha1.DriveInitStateFailed {
+				drives = append(drives, drive)
+			}
+		}
+		driveInit.Status.Drives = drives
+		driveInit.Status.ObservedGeneration = driveInit.Generation
+	} else if len(driveInit.Status.Drives) > 0 {
+		return nil
+	}
+
+	known := map[string]bool{}
+	encrypted := map[string]bool{}
+	for _, drive := range driveInit.Status.Drives {
+		known[drive.Node+"/"+drive.ID] = true
+		if drive.EncryptedDevice != "" {
+			encrypted[drive.Node+"/"+drive.EncryptedDevice] = true
+		}
+	}
+	devices, err := r.selectDevices(ctx, driveInit)
+	if err != nil {
+		return err
+	}
+	var nodes []string
+	for node := range devices {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	for _, node := range nodes {
+		var selected []discoveredDevice
+		for _, device := range devices[node] {
+			if !known[node+"/"+device.id] && !encrypted[node+"/"+device.name] {
+				selected = append(selected, device)
+			}
+		}
+		if len(selected) == 0 {
+			continue
+		}
+
+		// The Deployer of any namespace is looked up, a DriveInit elsewhere must not bypass
+		// the encryption of the drives of the node
+		deployer, err := deployerForNode(ctx, r.Client, "", node)
+		if err != nil {
+			return err
+		}
+		if deployer == nil || deployer.Namespace != driveInit.Namespace {
+			reason := "no Deployer runs DirectPV on the node"
+			if deployer != nil {
+				reason = fmt.Sprintf("DirectPV runs on the node with the Deployer %s/%s, the DriveInit must be in its namespace",
+					deployer.Namespace, deployer.Name)
+			}
+			for _, device := range selected {
+				driveInit.Status.Drives = append(driveInit.Status.Drives, cachev1alpha1.DriveInitResult{Node: node,
+					Device: device.name, ID: device.id, State: cachev1alpha1.DriveInitStateFailed, Error: reason})
+			}
+			continue
+		}
+		if encryptionForDeployer(deployer) != nil {
+			results, err := r.encryptDevices(ctx, driveInit, deployer, node, selected)
+			if err != nil {
+				return err
+			}
+			driveInit.Status.Drives = append(driveInit.Status.Drives, results...)
+			continue
+		}
+
+		var results []cachev1alpha1.DriveInitResult
+		name := initRequestName(driveInit, node)
+		for _, device := range selected {
+			results = append(results, cachev1alpha1.DriveInitResult{Node: node, Device: device.name,
+				ID: device.id, Request: name, State: cachev1alpha1.DriveInitStatePending})
+		}
+		if err := r.createInitRequest(ctx, driveInit, node, selected); err != nil {
+			return err
+		}
+		driveInit.Status.Drives = append(driveInit.Status.Drives, results...)
+	}
+	return nil
+}
+
+// createInitRequest creates the DirectPVInitRequest of the DriveInit formatting the devices of
+// the node
+func (r *DriveInitReconciler) createInitRequest(ctx context.Context, driveInit *cachev1alpha1.DriveInit,
+	node string, devices []discoveredDevice) error {
+	var initDevices []interface{}
+	for _, device := range devices {
+		initDevices = append(initDevices, map[string]interface{}{
+			"id":    device.id,
+			"name":  device.name,
+			"force": driveInit.Spec.Force,
+		})
+	}
+	name := initRequestName(driveInit, node)
+	request := &unstructured.Unstructured{}
+	request.SetGroupVersionKind(directPVInitRequestGVK)
+	request.SetName(name)
+	request.SetLabels(map[string]string{
+		"app.kubernetes.io/instance": driveInit.Name,
+		"app.kubernetes.io/part-of":  "directpv-operator",
+		driveInitNamespaceLabel:      driveInit.Namespace,
+		"directpv.min.io/node":       node,
+		"directpv.min.io/created-by": "directpv-operator",
+	})
+	request.Object["spec"] = map[string]interface{}{
+		"nodeID":  node,
+		"devices": initDevices,
+	}
+	log.FromContext(ctx).Info("Creating DirectPVInitRequest", "Name", name, "Node", node, "Devices", len(initDevices))
+	return client.IgnoreAlreadyExists(r.Create(ctx, request))
+}
+
+// encryptDevices creates the Job encrypting the devices of the node and returns their results,
+// DirectPV formats them once they are encrypted. Like DirectPV, the devices with a filesystem
+// fail without force.
+func (r *DriveInitReconciler) encryptDevices(ctx context.Context, driveInit *cachev1alpha1.DriveInit,
+	deployer *cachev1alpha1.Deployer, node string, devices []discoveredDevice) ([]cachev1alpha1.DriveInitResult, error) {
+	defaultImage, err := imageFromEnv("DIRECTPV_IMAGE", r.DefaultImages.DirectPV)
+	if err != nil {
+		return nil, err
+	}
+	image, err := imageForNode(ctx, r.Client, deployer, node, defaultImage)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var results []cachev1alpha1.DriveInitResult
+	name := encryptJobName(driveInit, node)
+	for _, device := range devices {
+		if device.fsType != "" && !driveInit.Spec.Force {
+			results = append(results, cachev1alpha1.DriveInitResult{Node: node, Device: device.name,
+				ID: device.id, State: cachev1alpha1.DriveInitStateFailed, Error: fmt.Sprintf(
+					"the device holds a %s filesystem, set force to encrypt it", device.fsType)})
+			continue
+		}
+		names = append(names, device.name)
+		results = append(results, cachev1alpha1.DriveInitResult{Node: node, Device: device.name,
+			ID: device.id, Request: name, State: cachev1alpha1.DriveInitStateEncrypting})
+	}
+	if len(names) == 0 {
+		return results, nil
+	}
+	job := driveEncryptJobForNode(deployer, name, node, image, names, driveInit.Spec.Force)
+	job.Labels["app.kubernetes.io/instance"] = driveInit.Name
+	if err := ctrl.SetControllerReference(driveInit, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("Creating drive encryption Job", "Job.Name", name, "Node", node, "Devices", len(names))
+	if err := r.Create(ctx, job); client.IgnoreAlreadyExists(err) != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// updateEncryptions records the device mapper devices of the devices encrypted by the completed
+// Jobs and creates the DirectPVInitRequests formatting them, once DirectPV discovered them. It
+// reports whether devices are still being encrypted.
+func (r *DriveInitReconciler) updateEncryptions(ctx context.Context, driveInit *cachev1alpha1.DriveInit) (bool, error) {
+	encrypting := map[string][]*cachev1alpha1.DriveInitResult{}
+	var jobs []string
+	for i := range driveInit.Status.Drives {
+		drive := &driveInit.Status.Drives[i]
+		if drive.State != cachev1alpha1.DriveInitStateEncrypting {
+			continue
+		}
+		if _, found := encrypting[drive.Request]; !found {
+			jobs = append(jobs, drive.Request)
+		}
+		encrypting[drive.Request] = append(encrypting[drive.Request], drive)
+	}
+
+	pending := false
+	for _, name := range jobs {
+		drives := encrypting[name]
+		fail := func(format string, args ...interface{}) {
+			for _, drive := range drives {
+				drive.State = cachev1alpha1.DriveInitStateFailed
+				drive.Error = fmt.Sprintf(format, args...)
+			}
+		}
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: driveInit.Namespace}, job)
+		if apierrors.IsNotFound(err) {
+			fail("the encryption Job %s was deleted before completing", name)
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if jobConditionTrue(job, batchv1.JobFailed) {
+			// The Job is kept for its logs
+			fail("failed to encrypt the device, see the logs of the Job %s", name)
+			continue
+		}
+		if !jobConditionTrue(job, batchv1.JobComplete) {
+			pending = true
+			continue
+		}
+
+		if drives[0].EncryptedDevice == "" {
+			encrypted, err := r.encryptedDevices(ctx, job)
+			if err != nil {
+				return false, err
+			}
+			for _, drive := range drives {
+				drive.EncryptedDevice = encrypted[drive.Device]
+			}
+		}
+		var devices []discoveredDevice
+		for _, drive := range drives {
+			if drive.EncryptedDevice == "" {
+				drive.State = cachev1alpha1.DriveInitStateFailed
+				drive.Error = fmt.Sprintf("the encryption Job %s did not report the encrypted device", name)
+				continue
+			}
+			devices = append(devices, discoveredDevice{name: drive.EncryptedDevice})
+		}
+		node := drives[0].Node
+		discovered, err := r.discoverDevices(ctx, node, devices)
+		if err != nil {
+			return false, err
+		}
+		if !discovered {
+			pending = true
+			continue
+		}
+
+		if len(devices) > 0 {
+			if err := r.createInitRequest(ctx, driveInit, node, devices); err != nil {
+				return false, err
+			}
+		}
+		for _, drive := range drives {
+			if drive.State == cachev1alpha1.DriveInitStateEncrypting {
+				drive.State = cachev1alpha1.DriveInitStatePending
+				drive.Request = initRequestName(driveInit, node)
+			}
+		}
+		propagation := metav1.DeletePropagationBackground
+		if err := r.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation}); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	return pending, nil
+}
+
+// encryptedDevices returns the device mapper devices reported by the completed encryption Job,
+// by device
+func (r *DriveInitReconciler) encryptedDevices(ctx context.Context, job *batchv1.Job) (map[string]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodSucceeded {
+			return parseEncryptedDevices(terminationMessage(&pods.Items[i]))
+		}
+	}
+	return nil, nil
+}
+
+// discoverDevices sets the identifiers of the devices from the DirectPVNode of the node. When
+// DirectPV did not discover them all yet, the devices of the node are refreshed.
+func (r *DriveInitReconciler) discoverDevices(ctx context.Context, node string, devices []discoveredDevice) (bool, error) {
+	directPVNode := &unstructured.Unstructured{}
+	directPVNode.SetGroupVersionKind(directPVNodeGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: node}, directPVNode); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	ids := map[string]string{}
+	items, _, _ := unstructured.NestedSlice(directPVNode.Object, "status", "devices")
+	for _, item := range items {
+		if fields, ok := item.(map[string]interface{}); ok {
+			name, _ := fields["name"].(string)
+			ids[name], _ = fields["id"].(string)
+		}
+	}
+	discovered := true
+	for i := range devices {
+		devices[i].id = ids[devices[i].name]
+		if devices[i].id == "" {
+			discovered = false
+		}
+	}
+	if discovered {
+		return true, nil
+	}
+	patch := client.MergeFrom(directPVNode.DeepCopy())
+	if err := unstructured.SetNestedField(directPVNode.Object, true, "spec", "refresh"); err != nil {
+		return false, err
+	}
+	log.FromContext(ctx).Info("Refreshing the devices of the DirectPVNode", "Node", node)
+	return false, r.Patch(ctx, directPVNode, patch)
+}
+
+// encryptJobName returns the name of the Job encrypting the devices of the DriveInit on the
+// given node for the current generation of its spec
+func encryptJobName(driveInit *cachev1alpha1.DriveInit, node string) string {
+	return truncatedName(fmt.Sprintf("%s-encrypt-%s-%d", driveInit.Name, node, driveInit.Generation), maxJobNameLength)
+}
+
+// updateResults records the results of the processed requests of the pending devices and
+// deletes the requests. It reports whether requests are still being processed.
+func (r *DriveInitReconciler) updateResults(ctx context.Context, driveInit *cachev1alpha1.DriveInit) (bool, error) {
+	pending, err := r.updateEncryptions(ctx, driveInit)
+	if err != nil {
+		return false, err
+	}
+	requests := map[string]*unstructured.Unstructured{}
+	for i := range driveInit.Status.Drives {
+		drive := &driveInit.Status.Drives[i]
+		if drive.State != cachev1alpha1.DriveInitStatePending {
+			continue
+		}
+		request, found := requests[drive.Request]
+		if !found {
+			request = &unstructured.Unstructured{}
+			request.SetGroupVersionKind(directPVInitRequestGVK)
+			err := r.Get(ctx, types.NamespacedName{Name: drive.Request}, request)
+			if apierrors.IsNotFound(err) {
+				request = nil
+			} else if err != nil {
+				return false, err
+			}
+			requests[drive.Request] = request
+		}
+		if request == nil {
+			drive.State = cachev1alpha1.DriveInitStateFailed
+			drive.Error = "the DirectPVInitRequest was deleted before being processed"
+			continue
+		}
+
+		status, _, _ := unstructured.NestedString(request.Object, "status", "status")
+		if status == "" || status == "pending" {
+			pending = true
+			continue
+		}
+		drive.State = cachev1alpha1.DriveInitStateInitialized
+		device := drive.Device
+		if drive.EncryptedDevice != "" {
+			device = drive.EncryptedDevice
+		}
+		results, _, _ := unstructured.NestedSlice(request.Object, "status", "results")
+		for _, result := range results {
+			fields, ok := result.(map[string]interface{})
+			if !ok || fields["name"] != device {
+				continue
+			}
+			if message, _ := fields["error"].(string); message != "" {
+				drive.State = cachev1alpha1.DriveInitStateFailed
+				drive.Error = message
+			}
+		}
+	}
+	if pending {
+		return true, nil
+	}
+
+	for name, request := range requests {
+		if request == nil {
+			continue
+		}
+		log.FromContext(ctx).Info("Deleting processed DirectPVInitRequest", "Name", name)
+		if err := r.Delete(ctx, request); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// discoveredDevice is a device reported by a DirectPVNode
+type discoveredDevice struct {
+	id     string
+	name   string
+	fsType string
+}
+
+// selectDevices returns the available devices discovered by DirectPV matching any selector of
+// the DriveInit, by node
+func (r *DriveInitReconciler) selectDevices(ctx context.Context,
+	driveInit *cachev1alpha1.DriveInit) (map[string][]discoveredDevice, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(directPVNodeGVK.GroupVersion().WithKind(directPVNodeGVK.Kind + "List"))
+	if err := r.List(ctx, list); err != nil {
+		return nil, err
+	}
+
+	selected := map[string][]discoveredDevice{}
+	for _, node := range list.Items {
+		devices, _, _ := unstructured.NestedSlice(node.Object, "status", "devices")
+		for _, item := range devices {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			device := discoveredDevice{}
+			device.id, _ = fields["id"].(string)
+			device.name, _ = fields["name"].(string)
+			device.fsType, _ = fields["fsType"].(string)
+			size, _, _ := unstructured.NestedInt64(fields, "size")
+			// Devices denied by DirectPV, e.g. mounted or too small, cannot be initialized
+			if denied, _ := fields["deniedReason"].(string); denied != "" || device.id == "" {
+				continue
+			}
+			for _, selector := range driveInit.Spec.Selectors {
+				if deviceSelected(selector, node.GetName(), device.name, size) {
+					selected[node.GetName()] = append(selected[node.GetName()], device)
+					break
+				}
+			}
+		}
+	}
+	return selected, nil
+}
+
+// deviceSelected reports whether the device matches the selector
+func deviceSelected(selector cachev1alpha1.DriveSelector, node, device string, size int64) bool {
+	if matched, _ := path.Match(selector.Node, node); selector.Node != "" && !matched {
+		return false
+	}
+	if matched, _ := path.Match(selector.Device, device); selector.Device != "" && !matched {
+		return false
+	}
+	return selector.MinSize == nil || size >= selector.MinSize.Value()
+}
+
+// initRequestName returns the name of the DirectPVInitRequest of the DriveInit on the given
+// node for the current generation of its spec
+func initRequestName(driveInit *cachev1alpha1.DriveInit, node string) string {
+	return fmt.Sprintf("%s-%s-%s-%d", driveInit.Namespace, driveInit.Name, node, driveInit.Generation)
+}
+
+// deleteInitRequests deletes the DirectPVInitRequests of the DriveInit, they are cluster-scoped
+// and not garbage collected with it
+func (r *DriveInitReconciler) deleteInitRequests(ctx context.Context, driveInit *cachev1alpha1.DriveInit) error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(directPVInitRequestGVK.GroupVersion().WithKind(directPVInitRequestGVK.Kind + "List"))
+	if err := r.List(ctx, list, client.MatchingLabels{
+		"app.kubernetes.io/instance": driveInit.Name,
+		"app.kubernetes.io/part-of":  "directpv-operator",
+		driveInitNamespaceLabel:      driveInit.Namespace,
+	}); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	for i := range list.Items {
+		if err := r.Delete(ctx, &list.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// driveInitPhase returns the phase of a DriveInit from the state of its devices
+func driveInitPhase(drives []cachev1alpha1.DriveInitResult) cachev1alpha1.DriveInitPhase {
+	if len(drives) == 0 {
+		return cachev1alpha1.DriveInitPhasePending
+	}
+	phase := cachev1alpha1.DriveInitPhaseCompleted
+	for _, drive := range drives {
+		switch drive.State {
+		case cachev1alpha1.DriveInitStateEncrypting, cachev1alpha1.DriveInitStatePending:
+			return cachev1alpha1.DriveInitPhaseInitializing
+		case cachev1alpha1.DriveInitStateFailed:
+			phase = cachev1alpha1.DriveInitPhaseFailed
+		}
+	}
+	return phase
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DriveInitReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.DriveInit{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// driveCordonedByAnnotation records the Deployer which cordoned a DirectPV drive, only
+	// those drives are uncordoned by the operator
+	driveCordonedByAnnotation = "cache.example.com/cordoned-by"
+	// driveLabelsAnnotation records the drive labels set by the label rules, only those labels
+	// are removed by the operator
+	driveLabelsAnnotation = "cache.example.com/drive-labels"
+	// driveLabelPrefix is the prefix of the drive labels DirectPV selects drives with
+	driveLabelPrefix = "directpv.min.io/"
+)
+
+// reservedDriveLabels are the drive labels maintained by DirectPV
+var reservedDriveLabels = map[string]bool{
+	"node":        true,
+	"drive-name":  true,
+	"access-tier": true,
+	"version":     true,
+	"created-by":  true,
+	"migrated":    true,
+}
+
+// driveCordonSelected reports whether the drive matches any of the cordon selectors of
+// the Deployer
+func driveCordonSelected(deployer *cachev1alpha1.Deployer, drive *unstructured.Unstructured) bool {
+	if deployer.Spec.Drives == nil {
+		return false
+	}
+	labels := drive.GetLabels()
+	for _, selector := range deployer.Spec.Drives.Cordon {
+		if matched, _ := path.Match(selector.Node, labels["directpv.min.io/node"]); selector.Node != "" && !matched {
+			continue
+		}
+		if matched, _ := path.Match(selector.Drive, labels["directpv.min.io/drive-name"]); selector.Drive != "" && !matched {
+			continue
+		}
+		if selector.ID != "" && selector.ID != drive.GetName() {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// driveLabelRuleMatches reports whether the drive matches the label rule
+func driveLabelRuleMatches(rule cachev1alpha1.DriveLabelRule, drive *unstructured.Unstructured) bool {
+	labels := drive.GetLabels()
+	if matched, _ := path.Match(rule.Node, labels["directpv.min.io/node"]); rule.Node != "" && !matched {
+		return false
+	}
+	if matched, _ := path.Match(rule.Drive, labels["directpv.min.io/drive-name"]); rule.Drive != "" && !matched {
+		return false
+	}
+	driveMake, _, _ := unstructured.NestedString(drive.Object, "status", "make")
+	if matched, _ := path.Match(rule.Make, driveMake); rule.Make != "" && !matched {
+		return false
+	}
+	size, _, _ := unstructured.NestedInt64(drive.Object, "status", "totalCapacity")
+	if rule.MinSize != nil && size < rule.MinSize.Value() {
+		return false
+	}
+	return rule.MaxSize == nil || size <= rule.MaxSize.Value()
+}
+
+// driveLabelsForDeployer returns the labels the label rules of the Deployer set on the drive,
+// with the DirectPV prefix
+func driveLabelsForDeployer(deployer *cachev1alpha1.Deployer, drive *unstructured.Unstructured) map[string]string {
+	labels := map[string]string{}
+	if deployer.Spec.Drives == nil {
+		return labels
+	}
+	for _, rule := range deployer.Spec.Drives.LabelRules {
+		if !driveLabelRuleMatches(rule, drive) {
+			continue
+		}
+		for key, value := range rule.Labels {
+			if !reservedDriveLabels[key] {
+				labels[driveLabelPrefix+key] = value
+			}
+		}
+	}
+	return labels
+}
+
+// applyDriveLabels sets the labels of the label rules on the drive and removes the ones it
+// previously set which no longer apply. The keys set are recorded in an annotation.
+func applyDriveLabels(deployer *cachev1alpha1.Deployer, drive *unstructured.Unstructured) {
+	desired := driveLabelsForDeployer(deployer, drive)
+	labels := drive.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	annotations := drive.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if applied := annotations[driveLabelsAnnotation]; applied != "" {
+		for _, key := range strings.Split(applied, ",") {
+			if _, found := desired[key]; !found {
+				delete(labels, key)
+			}
+		}
+	}
+	var keys []string
+	for key, value := range desired {
+		labels[key] = value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		annotations[driveLabelsAnnotation] = strings.Join(keys, ",")
+	} else {
+		delete(annotations, driveLabelsAnnotation)
+	}
+	setDriveMetadata(drive, labels, annotations)
+}
+
+// cordonedNodes returns the given nodes which are cordoned when the Deployer cordons their
+// drives, none otherwise
+func (r *DeployerReconciler) cordonedNodes(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	nodes map[string]bool) (map[string]bool, error) {
+	cordoned := map[string]bool{}
+	if deployer.Spec.Drives == nil || !deployer.Spec.Drives.CordonUnschedulableNodes {
+		return cordoned, nil
+	}
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList); err != nil {
+		return nil, err
+	}
+	for i := range nodeList.Items {
+		if node := &nodeList.Items[i]; nodes[node.Name] && node.Spec.Unschedulable {
+			cordoned[node.Name] = true
+		}
+	}
+	return cordoned, nil
+}
+
+// applyDriveCordon cordons the drive when it matches the cordon selectors of the Deployer or
+// its node is cordoned, and uncordons it when the Deployer cordoned it and neither holds anymore
+func applyDriveCordon(deployer *cachev1alpha1.Deployer, drive *unstructured.Unstructured, nodeCordoned bool) error {
+	owner := deployer.Namespace + "/" + deployer.Name
+	unschedulable, _, _ := unstructured.NestedBool(drive.Object, "spec", "unschedulable")
+	annotations := drive.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	cordonedBy, cordonedByOperator := annotations[driveCordonedByAnnotation]
+
+	switch selected := driveCordonSelected(deployer, drive) || nodeCordoned; {
+	case selected && !unschedulable:
+		if err := unstructured.SetNestedField(drive.Object, true, "spec", "unschedulable"); err != nil {
+			return err
+		}
+		annotations[driveCordonedByAnnotation] = owner
+	case !selected && unschedulable && cordonedByOperator && cordonedBy == owner:
+		unstructured.RemoveNestedField(drive.Object, "spec", "unschedulable")
+		delete(annotations, driveCordonedByAnnotation)
+	case !unschedulable && cordonedByOperator:
+		// Uncordoned outside of the operator, forget about it
+		delete(annotations, driveCordonedByAnnotation)
+	}
+	setDriveMetadata(drive, drive.GetLabels(), annotations)
+	return nil
+}
+
+// setDriveMetadata sets the labels and annotations of the drive, dropping empty maps so that
+// unchanged drives are not patched
+func setDriveMetadata(drive *unstructured.Unstructured, labels, annotations map[string]string) {
+	if len(labels) == 0 {
+		labels = nil
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	drive.SetLabels(labels)
+	drive.SetAnnotations(annotations)
+}
+
+// reconcileDrives applies the cordon selectors, the cordoning of the nodes and the label rules
+// of the Deployer to the DirectPV drives on its nodes
+func (r *DeployerReconciler) reconcileDrives(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	log := log.FromContext(ctx)
+
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return err
+	}
+	drives, err := r.listDirectPVObjects(ctx, directPVDriveGVK, nodes)
+	if err != nil {
+		return err
+	}
+
+	cordoned, err := r.cordonedNodes(ctx, deployer, nodes)
+	if err != nil {
+		return err
+	}
+
+	for i := range drives {
+		drive := &drives[i]
+		original := drive.DeepCopy()
+		if err := applyDriveCordon(deployer, drive, cordoned[drive.GetLabels()["directpv.min.io/node"]]); err != nil {
+			return err
+		}
+		applyDriveLabels(deployer, drive)
+		if reflect.DeepEqual(drive, original) {
+			continue
+		}
+		log.Info("Updating DirectPV drive", "Drive.Name", drive.GetName())
+		if err := r.Patch(ctx, drive, client.MergeFrom(original)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
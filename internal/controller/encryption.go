@@ -0,0 +1,159 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// encryptedDriveLabel is the LUKS label of the devices encrypted for DirectPV, the node-server
+// pods only unlock those
+const encryptedDriveLabel = "directpv-encrypted"
+
+// encryptionKeyDir is where the Secret holding the drive passphrase is mounted
+const encryptionKeyDir = "/etc/directpv/encryption"
+
+// driveEncryptScript encrypts the devices of the host, whose /dev is mounted as /host, and
+// opens them as directpv-<LUKS UUID>. It reports the device mapper devices in the termination
+// message, one device=dm-N line per device. Without force no device is encrypted when one holds
+// a filesystem or partition table signature.
+const driveEncryptScript = `set -e
+key="$1"
+force="$2"
+shift 2
+if [ "$force" != "true" ]; then
+  for name in "$@"; do
+    if blkid "/host/$name"; then
+      echo "/host/$name holds a signature, it is not encrypted without force" >&2
+      exit 1
+    fi
+  done
+fi
+for name in "$@"; do
+  device="/host/$name"
+  cryptsetup luksFormat --batch-mode --type luks2 --label ` + encryptedDriveLabel + ` --key-file "$key" "$device"
+  uuid="$(cryptsetup luksUUID "$device")"
+  cryptsetup open --key-file "$key" "$device" "directpv-$uuid"
+  for dm in /sys/block/dm-*; do
+    if [ "$(cat "$dm/dm/name")" = "directpv-$uuid" ]; then
+      echo "$name=$(basename "$dm")" >> /dev/termination-log
+    fi
+  done
+done`
+
+// driveUnlockScript opens the devices encrypted for DirectPV which are not open yet, so that
+// DirectPV finds its drives when the node-server starts
+const driveUnlockScript = `set -e
+for device in $(blkid -o device -t LABEL=` + encryptedDriveLabel + `); do
+  uuid="$(cryptsetup luksUUID "$device")"
+  if [ ! -e "/dev/mapper/directpv-$uuid" ]; then
+    cryptsetup open --key-file "$1" "$device" "directpv-$uuid"
+  fi
+done`
+
+// encryptionForDeployer returns the drive encryption settings of the Deployer, nil when the
+// drives are not encrypted
+func encryptionForDeployer(deployer *cachev1alpha1.Deployer) *cachev1alpha1.DriveEncryptionSpec {
+	if deployer.Spec.Drives == nil {
+		return nil
+	}
+	if enabled, set := featureGate(deployer, featureGateDriveEncryption); set && !enabled {
+		return nil
+	}
+	return deployer.Spec.Drives.Encryption
+}
+
+// encryptionKeyVolume returns the volume of the Secret holding the drive passphrase and the
+// path of the passphrase in the containers mounting it
+func encryptionKeyVolume(encryption *cachev1alpha1.DriveEncryptionSpec) (corev1.Volume, corev1.VolumeMount, string) {
+	volume := corev1.Volume{
+		Name: "drive-encryption-key",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: encryption.KeySecretRef.Name,
+				Items:      []corev1.KeyToPath{{Key: encryption.KeySecretRef.Key, Path: "key"}},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: volume.Name, MountPath: encryptionKeyDir, ReadOnly: true}
+	return volume, mount, path.Join(encryptionKeyDir, "key")
+}
+
+// applyDriveEncryption adds an init container unlocking the encrypted drives to the node-server
+// pod, it runs the node-server image with the /dev of the host
+func applyDriveEncryption(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	encryption := encryptionForDeployer(deployer)
+	if encryption == nil {
+		return
+	}
+	var image string
+	for _, container := range spec.Containers {
+		if container.Name == "node-server" {
+			image = container.Image
+		}
+	}
+	volume, mount, keyFile := encryptionKeyVolume(encryption)
+	spec.Volumes = append(spec.Volumes, volume)
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:            "unlock-drives",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", driveUnlockScript, "unlock-drives", keyFile},
+		SecurityContext: &corev1.SecurityContext{Privileged: &[]bool{true}[0]},
+		VolumeMounts:    []corev1.VolumeMount{mount, {Name: "devfs", MountPath: "/dev"}},
+	})
+}
+
+// driveEncryptJobForNode returns a privileged Job encrypting the devices of the node, the devices
+// holding a signature are only encrypted with force
+func driveEncryptJobForNode(deployer *cachev1alpha1.Deployer, name, nodeName, image string, devices []string,
+	force bool) *batchv1.Job {
+	volume, mount, keyFile := encryptionKeyVolume(encryptionForDeployer(deployer))
+	command := append([]string{"/bin/sh", "-c", driveEncryptScript, "encrypt", keyFile, strconv.FormatBool(force)}, devices...)
+	job := nodeJobForDeployer(deployer, name, "directpv-encrypt", nodeName, image, "/dev", corev1.MountPropagationNone, command)
+	// Retrying would format the devices again, losing the first passphrase header
+	job.Spec.BackoffLimit = &[]int32{0}[0]
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, volume)
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, mount)
+	return job
+}
+
+// parseEncryptedDevices parses the termination message of the encryption Job into the device
+// mapper devices of the encrypted devices
+func parseEncryptedDevices(message string) (map[string]string, error) {
+	devices := map[string]string{}
+	for _, line := range strings.Split(message, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		device, dm, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("unexpected output of the encryption Job: %s", line)
+		}
+		devices[device] = dm
+	}
+	return devices, nil
+}
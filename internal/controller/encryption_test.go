@@ -0,0 +1,82 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+func TestParseEncryptedDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", message: "", want: map[string]string{}},
+		{name: "single device", message: "sdb=dm-0\n", want: map[string]string{"sdb": "dm-0"}},
+		{name: "several devices", message: "sdb=dm-0\nnvme0n1=dm-1", want: map[string]string{"sdb": "dm-0", "nvme0n1": "dm-1"}},
+		{name: "blank lines and spaces", message: "\n  sdb=dm-0  \n\n", want: map[string]string{"sdb": "dm-0"}},
+		{name: "last device wins", message: "sdb=dm-0\nsdb=dm-3", want: map[string]string{"sdb": "dm-3"}},
+		{name: "missing separator", message: "sdb=dm-0\ncryptsetup: failed", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseEncryptedDevices(test.message)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseEncryptedDevices(%q) error = %v, want error %v", test.message, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseEncryptedDevices(%q) = %v, want %v", test.message, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncryptJobName(t *testing.T) {
+	longNode := "ip-10-0-123-45.eu-central-1.compute.internal-" + strings.Repeat("x", 20)
+	tests := []struct {
+		name string
+		node string
+		want string
+	}{
+		{name: "short node", node: "node-1", want: "drives-encrypt-node-1-2"},
+		{name: "long node", node: longNode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driveInit := &cachev1alpha1.DriveInit{ObjectMeta: metav1.ObjectMeta{Name: "drives", Generation: 2}}
+			got := encryptJobName(driveInit, test.node)
+			if len(got) > maxJobNameLength {
+				t.Errorf("encryptJobName() = %q is longer than %d characters", got, maxJobNameLength)
+			}
+			if test.want != "" && got != test.want {
+				t.Errorf("encryptJobName() = %q, want %q", got, test.want)
+			}
+			driveInit.Generation++
+			if next := encryptJobName(driveInit, test.node); next == got {
+				t.Errorf("encryptJobName() = %q for two generations", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// The feature gates of spec.featureGates. A gate set to true enables its feature with the
+// default settings, false disables it whatever the rest of the spec, an unset gate leaves it
+// to the spec.
+const (
+	// featureGateStorageCapacity publishes the capacity of the drives for the scheduler
+	featureGateStorageCapacity = "StorageCapacity"
+	// featureGateVolumeSnapshots is csi.snapshots.enabled
+	featureGateVolumeSnapshots = "VolumeSnapshots"
+	// featureGateHealthMonitor is csi.healthMonitor.enabled
+	featureGateHealthMonitor = "HealthMonitor"
+	// featureGateDriveEncryption disables drives.encryption when false, the encryption needs
+	// the passphrase Secret of the spec to be enabled
+	featureGateDriveEncryption = "DriveEncryption"
+)
+
+// featureGate returns the value of a feature gate of the Deployer and whether it is set
+func featureGate(deployer *cachev1alpha1.Deployer, name string) (enabled, set bool) {
+	enabled, set = deployer.Spec.FeatureGates[name]
+	return enabled, set
+}
+
+// snapshotsSpecForDeployer returns the snapshot settings of the Deployer, empty if unset
+func snapshotsSpecForDeployer(deployer *cachev1alpha1.Deployer) cachev1alpha1.SnapshotsSpec {
+	if deployer.Spec.CSI == nil || deployer.Spec.CSI.Snapshots == nil {
+		return cachev1alpha1.SnapshotsSpec{}
+	}
+	return *deployer.Spec.CSI.Snapshots
+}
+
+// healthMonitorSpecForDeployer returns the health monitor settings of the Deployer, empty if unset
+func healthMonitorSpecForDeployer(deployer *cachev1alpha1.Deployer) cachev1alpha1.HealthMonitorSpec {
+	if deployer.Spec.CSI == nil || deployer.Spec.CSI.HealthMonitor == nil {
+		return cachev1alpha1.HealthMonitorSpec{}
+	}
+	return *deployer.Spec.CSI.HealthMonitor
+}
+
+// storageCapacityEnabledForDeployer reports whether the CSIStorageCapacity objects of the
+// drives are published for the scheduler
+func storageCapacityEnabledForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	enabled, _ := featureGate(deployer, featureGateStorageCapacity)
+	return enabled
+}
+
+// storageCapacityRules are the permissions the provisioner needs to publish the
+// CSIStorageCapacity objects owned by the controller Deployment
+var storageCapacityRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"storage.k8s.io"},
+		Resources: []string{"csistoragecapacities"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{"replicasets", "deployments"},
+		Verbs:     []string{"get"},
+	},
+}
+
+// applyStorageCapacity makes the provisioner of the controller pods publish the capacity of
+// the drives in CSIStorageCapacity objects owned by the controller Deployment
+func applyStorageCapacity(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if !storageCapacityEnabledForDeployer(deployer) {
+		return
+	}
+	appendArgs(spec, "csi-provisioner", []string{"--enable-capacity", "--capacity-ownerref-level=2"})
+	for i := range spec.Containers {
+		if spec.Containers[i].Name != "csi-provisioner" {
+			continue
+		}
+		setEnvVar(&spec.Containers[i], namespaceEnvVar)
+		setEnvVar(&spec.Containers[i], corev1.EnvVar{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"}}})
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// fipsTLSCipherSuites are the FIPS-approved cipher suites of the TLS proxies
+var fipsTLSCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+}
+
+// fipsEnabledForDeployer reports whether the Deployer runs the FIPS-validated operand images
+func fipsEnabledForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.FIPS
+}
+
+// operandImage gets an operand image from the given environment variable, falling back to the
+// default from the manager's config file. With spec.fips the FIPS image is used instead, from
+// the environment variable suffixed with _FIPS or the FIPS default.
+func (r *DeployerReconciler) operandImage(deployer *cachev1alpha1.Deployer,
+	imageEnvVar, defaultImage, fipsImage string) (string, error) {
+	if !fipsEnabledForDeployer(deployer) {
+		return imageFromEnv(imageEnvVar, defaultImage)
+	}
+	image, err := imageFromEnv(imageEnvVar+"_FIPS", fipsImage)
+	if err != nil {
+		return "", fmt.Errorf("spec.fips is set but no FIPS image is configured: %w", err)
+	}
+	return image, nil
+}
+
+// applyFIPS enables the FIPS 140 mode of the Go runtime of the operand containers, the TLS
+// proxies only negotiate FIPS-approved ciphers
+func applyFIPS(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if !fipsEnabledForDeployer(deployer) {
+		return
+	}
+	for i := range spec.InitContainers {
+		setEnvVar(&spec.InitContainers[i], corev1.EnvVar{Name: "GODEBUG", Value: "fips140=on"})
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		setEnvVar(container, corev1.EnvVar{Name: "GODEBUG", Value: "fips140=on"})
+		if strings.HasPrefix(container.Name, tlsProxyContainerPrefix) {
+			container.Args = append(container.Args, "--tls-min-version=VersionTLS12",
+				"--tls-cipher-suites="+strings.Join(fipsTLSCipherSuites, ","))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// healthMonitorRules are the cluster permissions of the csi-external-health-monitor-controller
+// sidecar in addition to the volume and event ones of the controller
+var healthMonitorRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+}
+
+// healthMonitorEnabledForDeployer reports whether the Deployer enables the volume health monitoring
+func healthMonitorEnabledForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	if enabled, set := featureGate(deployer, featureGateHealthMonitor); set {
+		return enabled
+	}
+	return healthMonitorSpecForDeployer(deployer).Enabled
+}
+
+// imageForHealthMonitor gets the csi-external-health-monitor-controller image
+func (r *DeployerReconciler) imageForHealthMonitor(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "CSI_HEALTH_MONITOR", r.DefaultImages.CSIHealthMonitor, r.FIPSImages.CSIHealthMonitor)
+}
+
+// applyHealthMonitor adds the csi-external-health-monitor-controller sidecar to the controller
+// pod spec when the volume health monitoring is enabled
+func (r *DeployerReconciler) applyHealthMonitor(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) error {
+	if !healthMonitorEnabledForDeployer(deployer) {
+		return nil
+	}
+	image, err := r.imageForHealthMonitor(deployer)
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"--v=3",
+		"--timeout=300s",
+		"--csi-address=$(CSI_ENDPOINT)",
+		"--leader-election",
+	}
+	if interval := healthMonitorSpecForDeployer(deployer).Interval; interval != "" {
+		args = append(args, "--monitor-interval="+interval)
+	}
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Image: image,
+		Name:  "csi-health-monitor",
+		Args:  args,
+		Env: []corev1.EnvVar{
+			{
+				Name:  "CSI_ENDPOINT",
+				Value: csiEndpoint,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "socket-dir",
+				MountPath: csiSocketDir,
+			},
+		},
+	})
+	return nil
+}
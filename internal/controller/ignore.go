@@ -0,0 +1,182 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// ignoredPathSegment matches a segment of the JSONPath of an ignored field
+var ignoredPathSegment = regexp.MustCompile(`^(?:\.([^.\[\]]+)|\[(\*)\]|\[([0-9]+)\]|\['([^']*)'\])`)
+
+// pathSegment is a segment of the JSONPath of an ignored field: a key, a list index or all
+// the items of a list
+type pathSegment struct {
+	key      string
+	index    int
+	wildcard bool
+	isIndex  bool
+}
+
+// parseIgnoredPath splits the JSONPath of an ignored field into its segments
+func parseIgnoredPath(path string) ([]pathSegment, error) {
+	if path == "" {
+		// It would select the whole workload
+		return nil, fmt.Errorf("empty JSONPath")
+	}
+	var segments []pathSegment
+	for rest := path; rest != ""; {
+		match := ignoredPathSegment.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("invalid JSONPath %q at %q", path, rest)
+		}
+		rest = rest[len(match[0]):]
+		switch {
+		case match[2] != "":
+			segments = append(segments, pathSegment{wildcard: true})
+		case match[3] != "":
+			index, err := strconv.Atoi(match[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index in JSONPath %q: %w", path, err)
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+		case match[1] != "":
+			segments = append(segments, pathSegment{key: match[1]})
+		default:
+			segments = append(segments, pathSegment{key: match[4]})
+		}
+	}
+	return segments, nil
+}
+
+// fieldIgnored reports whether the field of the operand workloads of the kind is ignored
+func fieldIgnored(deployer *cachev1alpha1.Deployer, kind, path string) bool {
+	for _, ignored := range deployer.Spec.IgnoreDifferences {
+		if ignored.Kind == kind && ignored.JSONPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+// preserveIgnoredFields replaces the ignored fields of the rendered workload with the ones of
+// the live workload, so they are neither reported as drift nor overwritten. A field missing
+// on the live workload is removed from the rendered one.
+func preserveIgnoredFields(deployer *cachev1alpha1.Deployer, kind string, live, desired client.Object) error {
+	var paths [][]pathSegment
+	for _, ignored := range deployer.Spec.IgnoreDifferences {
+		if ignored.Kind != kind {
+			continue
+		}
+		path, err := parseIgnoredPath(ignored.JSONPath)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	liveContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return err
+	}
+	desiredContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return err
+	}
+	var content interface{} = desiredContent
+	for _, path := range paths {
+		content, _ = preserveValue(liveContent, content, path)
+	}
+	preserved := reflect.New(reflect.TypeOf(desired).Elem())
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content.(map[string]interface{}),
+		preserved.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(desired).Elem().Set(preserved.Elem())
+	return nil
+}
+
+// preserveValue returns the desired value with the fields at the path taken from the live
+// value, and whether the returned value is set
+func preserveValue(live, desired interface{}, path []pathSegment) (interface{}, bool) {
+	if len(path) == 0 {
+		return live, live != nil
+	}
+	segment := path[0]
+
+	if !segment.wildcard && !segment.isIndex {
+		liveMap, _ := live.(map[string]interface{})
+		desiredMap, ok := desired.(map[string]interface{})
+		if !ok && desired != nil {
+			return desired, true
+		}
+		preserved := map[string]interface{}{}
+		for key, value := range desiredMap {
+			preserved[key] = value
+		}
+		if value, set := preserveValue(liveMap[segment.key], desiredMap[segment.key], path[1:]); set {
+			preserved[segment.key] = value
+		} else {
+			delete(preserved, segment.key)
+		}
+		if desired == nil && len(preserved) == 0 {
+			return nil, false
+		}
+		return preserved, true
+	}
+
+	liveList, _ := live.([]interface{})
+	desiredList, ok := desired.([]interface{})
+	if !ok {
+		return desired, desired != nil
+	}
+	liveItems := map[string]interface{}{}
+	byName := namedItems(desiredList) && namedItems(liveList)
+	if byName {
+		for _, item := range liveList {
+			liveItems[itemName(item)] = item
+		}
+	}
+	preserved := append([]interface{}{}, desiredList...)
+	for i, item := range preserved {
+		if segment.isIndex && i != segment.index {
+			continue
+		}
+		var liveItem interface{}
+		switch {
+		case byName:
+			liveItem = liveItems[itemName(item)]
+		case i < len(liveList):
+			liveItem = liveList[i]
+		}
+		if value, set := preserveValue(liveItem, item, path[1:]); set {
+			preserved[i] = value
+		}
+	}
+	return preserved, true
+}
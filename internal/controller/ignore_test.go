@@ -0,0 +1,89 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIgnoredPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    []pathSegment
+		wantErr bool
+	}{
+		{
+			name: "keys",
+			path: ".spec.replicas",
+			want: []pathSegment{{key: "spec"}, {key: "replicas"}},
+		},
+		{
+			name: "wildcard",
+			path: ".spec.template.spec.containers[*].resources",
+			want: []pathSegment{{key: "spec"}, {key: "template"}, {key: "spec"}, {key: "containers"},
+				{wildcard: true}, {key: "resources"}},
+		},
+		{
+			name: "index",
+			path: ".spec.template.spec.containers[1].image",
+			want: []pathSegment{{key: "spec"}, {key: "template"}, {key: "spec"}, {key: "containers"},
+				{index: 1, isIndex: true}, {key: "image"}},
+		},
+		{
+			name: "bracket key with dots and slashes",
+			path: ".metadata.annotations['sidecar.istio.io/status']",
+			want: []pathSegment{{key: "metadata"}, {key: "annotations"}, {key: "sidecar.istio.io/status"}},
+		},
+		{
+			name: "bracket key with brackets",
+			path: ".metadata.labels['a[0]b']",
+			want: []pathSegment{{key: "metadata"}, {key: "labels"}, {key: "a[0]b"}},
+		},
+		{
+			name: "empty bracket key",
+			path: ".metadata.labels['']",
+			want: []pathSegment{{key: "metadata"}, {key: "labels"}, {key: ""}},
+		},
+		{
+			name: "bracket key at the root",
+			path: "['spec'].replicas",
+			want: []pathSegment{{key: "spec"}, {key: "replicas"}},
+		},
+		{name: "empty path", path: "", wantErr: true},
+		{name: "missing leading dot", path: "spec.replicas", wantErr: true},
+		{name: "empty key", path: ".spec..replicas", wantErr: true},
+		{name: "trailing dot", path: ".spec.", wantErr: true},
+		{name: "unterminated bracket", path: ".metadata.annotations['a", wantErr: true},
+		{name: "double quoted key", path: `.metadata.annotations["a.b"]`, wantErr: true},
+		{name: "escaped quote", path: `.metadata.annotations['a\'b']`, wantErr: true},
+		{name: "negative index", path: ".spec.containers[-1]", wantErr: true},
+		{name: "index overflow", path: ".spec.containers[99999999999999999999]", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseIgnoredPath(test.path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseIgnoredPath(%q) error = %v, want error %v", test.path, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseIgnoredPath(%q) = %+v, want %+v", test.path, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,505 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// imageVerificationRetryInterval is how often the verification of the operand images is
+	// retried after a failure
+	imageVerificationRetryInterval = 2 * time.Minute
+	// imageTagResolveInterval is how long the digest behind a tag is reused before the tag is
+	// resolved again, the workloads keep running the verified digest meanwhile
+	imageTagResolveInterval = 5 * time.Minute
+	// cosignSignatureAnnotation is the annotation of the layers of a cosign signature manifest
+	// holding the base64 signature of the layer
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// maxRegistryResponseSize bounds the manifests, signature payloads and tokens read from
+	// the registries
+	maxRegistryResponseSize = 4 << 20
+	// manifestMediaTypes are the image manifests and indexes accepted from the registries
+	manifestMediaTypes = "application/vnd.oci.image.index.v1+json, application/vnd.oci.image.manifest.v1+json, " +
+		"application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// authChallengeParam matches the quoted parameters of a WWW-Authenticate challenge
+var authChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// imageDigest matches the sha256 digests of the image references, the only ones verified
+var imageDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// imageReference is a parsed image reference
+type imageReference struct {
+	registry   string
+	repository string
+	// reference is the digest of the image, or its tag
+	reference string
+}
+
+// parseImageReference parses an image reference the way the container runtimes do, the images
+// without a registry are on Docker Hub
+func parseImageReference(image string) (imageReference, error) {
+	name, reference := image, "latest"
+	if i := strings.Index(image, "@"); i >= 0 {
+		name, reference = image[:i], image[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		if !strings.Contains(image, "@") {
+			reference = name[i+1:]
+		}
+		name = name[:i]
+	}
+	if name == "" || reference == "" {
+		return imageReference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+	if strings.Contains(image, "@") && !imageDigest.MatchString(reference) {
+		return imageReference{}, fmt.Errorf("unsupported digest in the image reference %q", image)
+	}
+
+	ref := imageReference{registry: "registry-1.docker.io", repository: name, reference: reference}
+	if first, rest, found := strings.Cut(name, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry, ref.repository = first, rest
+	}
+	if ref.registry == "docker.io" || ref.registry == "index.docker.io" {
+		ref.registry = "registry-1.docker.io"
+	}
+	if ref.registry == "registry-1.docker.io" && !strings.Contains(ref.repository, "/") {
+		ref.repository = "library/" + ref.repository
+	}
+	return ref, nil
+}
+
+// registryStatusError is an unexpected status returned by a registry
+type registryStatusError struct {
+	url    string
+	status int
+}
+
+func (e *registryStatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.url, e.status, http.StatusText(e.status))
+}
+
+// registrySession reads the manifests and blobs of a repository, with the anonymous bearer
+// token of the registry when it requires one
+type registrySession struct {
+	client *http.Client
+	ref    imageReference
+	token  string
+}
+
+// get reads the given path of the repository, e.g. manifests/v4.0.5
+func (s *registrySession) get(ctx context.Context, path, accept string) ([]byte, error) {
+	url := fmt.Sprintf("https://%s/v2/%s/%s", s.ref.registry, s.ref.repository, path)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+		body, resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return body, nil
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0 && s.token == "":
+			if s.token, err = s.authenticate(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &registryStatusError{url: url, status: resp.StatusCode}
+		}
+	}
+}
+
+// do sends the request and reads the response body
+func (s *registrySession) do(req *http.Request) ([]byte, *http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRegistryResponseSize))
+	return body, resp, err
+}
+
+// authenticate gets an anonymous pull token of the repository from the token service of the
+// Bearer challenge of the registry
+func (s *registrySession) authenticate(ctx context.Context, challenge string) (string, error) {
+	scheme, _, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported authentication %q of the registry %s", challenge, s.ref.registry)
+	}
+	params := map[string]string{}
+	for _, match := range authChallengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q of the registry %s", params["realm"], s.ref.registry)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+s.ref.repository+":pull")
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	body, resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &registryStatusError{url: realm.String(), status: resp.StatusCode}
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", fmt.Errorf("no token returned for the registry %s", s.ref.registry)
+}
+
+// parsePublicKey parses a PEM-encoded public key, it returns the key and its fingerprint
+func parsePublicKey(data string) (crypto.PublicKey, string, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, "", errors.New("no PEM-encoded PUBLIC KEY found")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	return publicKey, fmt.Sprintf("%x", sha256.Sum256(block.Bytes)), nil
+}
+
+// verifySignature verifies the signature of the payload the way sigstore does for the type of
+// the key
+func verifySignature(publicKey crypto.PublicKey, payload, signature []byte) error {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		hash := crypto.SHA256
+		switch key.Curve {
+		case elliptic.P384():
+			hash = crypto.SHA384
+		case elliptic.P521():
+			hash = crypto.SHA512
+		}
+		digest := hash.New()
+		digest.Write(payload)
+		if ecdsa.VerifyASN1(key, digest.Sum(nil), signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, payload, signature) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return errors.New("invalid signature")
+}
+
+// resolvedTag is the digest a tag pointed to at the given time
+type resolvedTag struct {
+	digest string
+	time   time.Time
+}
+
+// imageVerifier verifies the cosign signatures of images in their registries. The verified
+// digests are remembered per key, the tags are resolved again after imageTagResolveInterval.
+type imageVerifier struct {
+	client   *http.Client
+	mu       sync.Mutex
+	resolved map[string]resolvedTag
+	verified map[string]bool
+}
+
+// newImageVerifier returns an image verifier, the registries are reached through the proxy of
+// the environment
+func newImageVerifier() *imageVerifier {
+	return &imageVerifier{client: &http.Client{Timeout: 30 * time.Second},
+		resolved: map[string]resolvedTag{}, verified: map[string]bool{}}
+}
+
+// resolve returns the digest of the manifest of the image, the digest of an image referenced
+// by digest is checked against its manifest
+func (v *imageVerifier) resolve(ctx context.Context, session *registrySession, image string) (string, error) {
+	v.mu.Lock()
+	resolved, found := v.resolved[image]
+	v.mu.Unlock()
+	if found && time.Since(resolved.time) < imageTagResolveInterval {
+		return resolved.digest, nil
+	}
+
+	manifest, err := session.get(ctx, "manifests/"+session.ref.reference, manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	if strings.HasPrefix(session.ref.reference, "sha256:") && session.ref.reference != digest {
+		return "", fmt.Errorf("the manifest does not match the digest %s", session.ref.reference)
+	}
+	v.mu.Lock()
+	v.resolved[image] = resolvedTag{digest: digest, time: time.Now()}
+	v.mu.Unlock()
+	return digest, nil
+}
+
+// verify checks that the image carries a cosign signature of the key over its manifest digest
+// and returns the digest, the image is to be pulled by digest. The signature is looked up with
+// the cosign tag convention, sha256-<digest>.sig in the repository of the image.
+func (v *imageVerifier) verify(ctx context.Context, image string, publicKey crypto.PublicKey,
+	fingerprint string) (string, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	session := &registrySession{client: v.client, ref: ref}
+	digest, err := v.resolve(ctx, session, image)
+	if err != nil {
+		return "", err
+	}
+	cacheKey := fingerprint + " " + ref.registry + "/" + ref.repository + "@" + digest
+	v.mu.Lock()
+	verified := v.verified[cacheKey]
+	v.mu.Unlock()
+	if verified {
+		return digest, nil
+	}
+
+	signatureManifest, err := session.get(ctx, "manifests/"+strings.Replace(digest, ":", "-", 1)+".sig",
+		"application/vnd.oci.image.manifest.v1+json")
+	var statusErr *registryStatusError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+		return "", fmt.Errorf("no cosign signature found for %s", digest)
+	}
+	if err != nil {
+		return "", err
+	}
+	var signatures struct {
+		Layers []struct {
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(signatureManifest, &signatures); err != nil {
+		return "", fmt.Errorf("invalid cosign signature manifest: %w", err)
+	}
+	for _, layer := range signatures.Layers {
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+		payload, err := session.get(ctx, "blobs/"+layer.Digest, "")
+		if err != nil {
+			return "", err
+		}
+		if fmt.Sprintf("sha256:%x", sha256.Sum256(payload)) != layer.Digest ||
+			verifySignature(publicKey, payload, signature) != nil {
+			continue
+		}
+		var simpleSigning struct {
+			Critical struct {
+				Image struct {
+					DockerManifestDigest string `json:"docker-manifest-digest"`
+				} `json:"image"`
+			} `json:"critical"`
+		}
+		if json.Unmarshal(payload, &simpleSigning) != nil || simpleSigning.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+		v.mu.Lock()
+		v.verified[cacheKey] = true
+		v.mu.Unlock()
+		return digest, nil
+	}
+	return "", fmt.Errorf("no signature of the public key found for %s", digest)
+}
+
+// verifiedImage returns the image pinned to the digest of its verified manifest, the image
+// itself when it was not verified. The tag is kept for the version reported from the image.
+func verifiedImage(deployer *cachev1alpha1.Deployer, image string) string {
+	if deployer.Spec.ImageVerification == nil {
+		return image
+	}
+	for _, verified := range deployer.Status.VerifiedImages {
+		if verified.Image == image {
+			name, _, _ := strings.Cut(image, "@")
+			return name + "@" + verified.Digest
+		}
+	}
+	return image
+}
+
+// applyVerifiedImages pins the images of the containers to their verified digests, so that the
+// kubelet pulls the verified manifests even when their tags are moved
+func applyVerifiedImages(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].Image = verifiedImage(deployer, spec.InitContainers[i].Image)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].Image = verifiedImage(deployer, spec.Containers[i].Image)
+	}
+}
+
+// operandImagesForDeployer returns the images rendered into the operand workloads of the
+// Deployer before they are pinned to their digests, sorted. The Jobs of the Deployer run the
+// DirectPV image of the node-server.
+func (r *DeployerReconciler) operandImagesForDeployer(deployer *cachev1alpha1.Deployer) ([]string, error) {
+	deployer = deployer.DeepCopy()
+	deployer.Status.VerifiedImages = nil
+	daemonSet, err := r.daemonSetForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	deployment, err := r.deploymentForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	specs := []*corev1.PodSpec{&daemonSet.Spec.Template.Spec, &deployment.Spec.Template.Spec}
+	if snapshotControllerInstalledForDeployer(deployer) {
+		snapshotController, err := r.snapshotControllerDeploymentForDeployer(deployer)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, &snapshotController.Spec.Template.Spec)
+	}
+
+	found := map[string]bool{}
+	for _, spec := range specs {
+		for _, container := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+			found[container.Image] = true
+		}
+	}
+	for _, arch := range archImageOverrides(deployer) {
+		found[arch.Image] = true
+	}
+	var images []string
+	for image := range found {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// reconcileImageVerification verifies the signatures of the operand images with the public key
+// of the Deployer before they are rendered into the workloads, reporting the result through the
+// ImagesVerified condition and recording the verified digests the workloads are pinned to. An
+// unsigned image, or a registry which cannot be read, blocks the reconciliation, a non-zero
+// result is returned while blocked.
+func (r *DeployerReconciler) reconcileImageVerification(ctx context.Context, deployer *cachev1alpha1.Deployer) (ctrl.Result, error) {
+	if deployer.Spec.ImageVerification == nil {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeImagesVerifiedDeployer)
+		deployer.Status.VerifiedImages = nil
+		return ctrl.Result{}, nil
+	}
+	if r.imageVerifier == nil {
+		r.imageVerifier = newImageVerifier()
+	}
+	images, err := r.operandImagesForDeployer(deployer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var failures []string
+	var verified []cachev1alpha1.VerifiedImage
+	publicKey, fingerprint, err := parsePublicKey(deployer.Spec.ImageVerification.PublicKey)
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("invalid spec.imageVerification.publicKey: %v", err))
+	} else {
+		for _, image := range images {
+			digest, err := r.imageVerifier.verify(ctx, image, publicKey, fingerprint)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", image, err))
+				continue
+			}
+			verified = append(verified, cachev1alpha1.VerifiedImage{Image: image, Digest: digest})
+		}
+	}
+	if len(failures) == 0 {
+		deployer.Status.VerifiedImages = verified
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeImagesVerifiedDeployer,
+			Status: metav1.ConditionTrue, Reason: "SignaturesVerified",
+			Message: "The operand images are signed with the public key: " + strings.Join(images, ", ")})
+		return ctrl.Result{}, nil
+	}
+
+	message := "The operand images are not rolled out until their signatures are verified: " + strings.Join(failures, "; ")
+	log.FromContext(ctx).Info("Operand image verification failed", "Message", message)
+	if cond := meta.FindStatusCondition(deployer.Status.Conditions, typeImagesVerifiedDeployer); cond == nil ||
+		cond.Message != message {
+		r.Recorder.Event(deployer, "Warning", "ImageVerificationFailed", message)
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeImagesVerifiedDeployer,
+		Status: metav1.ConditionFalse, Reason: "SignatureVerificationFailed", Message: message})
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: imageVerificationRetryInterval}, nil
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		want    imageReference
+		wantErr bool
+	}{
+		{
+			name:  "docker hub official image",
+			image: "busybox",
+			want:  imageReference{registry: "registry-1.docker.io", repository: "library/busybox", reference: "latest"},
+		},
+		{
+			name:  "docker hub official image with tag",
+			image: "busybox:1.36",
+			want:  imageReference{registry: "registry-1.docker.io", repository: "library/busybox", reference: "1.36"},
+		},
+		{
+			name:  "docker hub user image",
+			image: "minio/directpv:v4.0.5",
+			want:  imageReference{registry: "registry-1.docker.io", repository: "minio/directpv", reference: "v4.0.5"},
+		},
+		{
+			name:  "docker.io official image",
+			image: "docker.io/busybox",
+			want:  imageReference{registry: "registry-1.docker.io", repository: "library/busybox", reference: "latest"},
+		},
+		{
+			name:  "index.docker.io library image",
+			image: "index.docker.io/library/busybox:1.36",
+			want:  imageReference{registry: "registry-1.docker.io", repository: "library/busybox", reference: "1.36"},
+		},
+		{
+			name:  "registry",
+			image: "quay.io/minio/directpv:v4.0.5",
+			want:  imageReference{registry: "quay.io", repository: "minio/directpv", reference: "v4.0.5"},
+		},
+		{
+			name:  "registry without namespace",
+			image: "quay.io/directpv",
+			want:  imageReference{registry: "quay.io", repository: "directpv", reference: "latest"},
+		},
+		{
+			name:  "registry with port",
+			image: "registry.local:5000/minio/directpv",
+			want:  imageReference{registry: "registry.local:5000", repository: "minio/directpv", reference: "latest"},
+		},
+		{
+			name:  "registry with port and tag",
+			image: "registry.local:5000/minio/directpv:v4.0.5",
+			want:  imageReference{registry: "registry.local:5000", repository: "minio/directpv", reference: "v4.0.5"},
+		},
+		{
+			name:  "localhost",
+			image: "localhost/directpv:dev",
+			want:  imageReference{registry: "localhost", repository: "directpv", reference: "dev"},
+		},
+		{
+			name:  "localhost with port",
+			image: "localhost:5000/directpv",
+			want:  imageReference{registry: "localhost:5000", repository: "directpv", reference: "latest"},
+		},
+		{
+			name:  "digest",
+			image: "quay.io/minio/directpv@" + testDigest,
+			want:  imageReference{registry: "quay.io", repository: "minio/directpv", reference: testDigest},
+		},
+		{
+			name:  "tag and digest",
+			image: "quay.io/minio/directpv:v4.0.5@" + testDigest,
+			want:  imageReference{registry: "quay.io", repository: "minio/directpv", reference: testDigest},
+		},
+		{
+			name:  "docker hub official image with digest",
+			image: "busybox@" + testDigest,
+			want:  imageReference{registry: "registry-1.docker.io", repository: "library/busybox", reference: testDigest},
+		},
+		{
+			name:  "registry with port and digest",
+			image: "registry.local:5000/directpv@" + testDigest,
+			want:  imageReference{registry: "registry.local:5000", repository: "directpv", reference: testDigest},
+		},
+		{name: "empty", image: "", wantErr: true},
+		{name: "empty tag", image: "quay.io/minio/directpv:", wantErr: true},
+		{name: "empty digest", image: "quay.io/minio/directpv@", wantErr: true},
+		{name: "missing name", image: "@" + testDigest, wantErr: true},
+		{name: "short digest", image: "quay.io/minio/directpv@sha256:0123", wantErr: true},
+		{name: "unsupported digest algorithm", image: "quay.io/minio/directpv@sha512:" + strings.Repeat("0", 128), wantErr: true},
+		{name: "uppercase digest", image: "quay.io/minio/directpv@" + strings.ToUpper(testDigest), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseImageReference(test.image)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseImageReference(%q) error = %v, want error %v", test.image, err, test.wantErr)
+			}
+			if !test.wantErr && got != test.want {
+				t.Errorf("parseImageReference(%q) = %+v, want %+v", test.image, got, test.want)
+			}
+		})
+	}
+}
+
+// testPublicKeyPEM returns the PEM encoding of the public key
+func testPublicKeyPEM(t *testing.T, publicKey crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, fingerprint, err := parsePublicKey(testPublicKeyPEM(t, &ecdsaKey.PublicKey))
+	if err != nil {
+		t.Fatalf("parsePublicKey() error = %v", err)
+	}
+	if !ecdsaKey.PublicKey.Equal(publicKey) {
+		t.Errorf("parsePublicKey() returned another key")
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(der)); fingerprint != want {
+		t.Errorf("parsePublicKey() fingerprint = %s, want %s", fingerprint, want)
+	}
+
+	for name, data := range map[string]string{
+		"empty":       "",
+		"not PEM":     "cosign.pub",
+		"private key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		"invalid key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("invalid")})),
+	} {
+		if _, _, err := parsePublicKey(data); err == nil {
+			t.Errorf("parsePublicKey() accepted the %s key", name)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"` + testDigest + `"}}}`)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Public, ed25519Private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha256Digest := sha256.Sum256(payload)
+	sha384Digest := sha512.Sum384(payload)
+	p256Signature, err := ecdsa.SignASN1(rand.Reader, p256, sha256Digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Signature, err := ecdsa.SignASN1(rand.Reader, p384, sha384Digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384SHA256Signature, err := ecdsa.SignASN1(rand.Reader, p384, sha256Digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sha256Digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Signature := ed25519.Sign(ed25519Private, payload)
+
+	// flipped returns the signature with its last byte changed
+	flipped := func(signature []byte) []byte {
+		changed := append([]byte{}, signature...)
+		changed[len(changed)-1] ^= 0xff
+		return changed
+	}
+	tests := []struct {
+		name      string
+		publicKey crypto.PublicKey
+		payload   []byte
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "ecdsa p256", publicKey: &p256.PublicKey, payload: payload, signature: p256Signature},
+		{name: "ecdsa p384 with sha384", publicKey: &p384.PublicKey, payload: payload, signature: p384Signature},
+		{name: "rsa", publicKey: &rsaKey.PublicKey, payload: payload, signature: rsaSignature},
+		{name: "ed25519", publicKey: ed25519Public, payload: payload, signature: ed25519Signature},
+		{name: "ecdsa p384 with sha256", publicKey: &p384.PublicKey, payload: payload, signature: p384SHA256Signature,
+			wantErr: true},
+		{name: "ecdsa other key", publicKey: &p384.PublicKey, payload: payload, signature: p256Signature, wantErr: true},
+		{name: "ecdsa other payload", publicKey: &p256.PublicKey, payload: []byte("{}"), signature: p256Signature,
+			wantErr: true},
+		{name: "ecdsa bad signature", publicKey: &p256.PublicKey, payload: payload, signature: flipped(p256Signature),
+			wantErr: true},
+		{name: "ecdsa short signature", publicKey: &p256.PublicKey, payload: payload, signature: p256Signature[:8],
+			wantErr: true},
+		{name: "ecdsa empty signature", publicKey: &p256.PublicKey, payload: payload, wantErr: true},
+		{name: "rsa bad signature", publicKey: &rsaKey.PublicKey, payload: payload, signature: flipped(rsaSignature),
+			wantErr: true},
+		{name: "rsa short signature", publicKey: &rsaKey.PublicKey, payload: payload, signature: rsaSignature[:16],
+			wantErr: true},
+		{name: "rsa empty signature", publicKey: &rsaKey.PublicKey, payload: payload, wantErr: true},
+		{name: "ed25519 bad signature", publicKey: ed25519Public, payload: payload, signature: flipped(ed25519Signature),
+			wantErr: true},
+		{name: "ed25519 short signature", publicKey: ed25519Public, payload: payload, signature: ed25519Signature[:32],
+			wantErr: true},
+		{name: "ed25519 empty signature", publicKey: ed25519Public, payload: payload, wantErr: true},
+		{name: "unsupported key", publicKey: []byte("key"), payload: payload, signature: p256Signature, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifySignature(test.publicKey, test.payload, test.signature)
+			if (err != nil) != test.wantErr {
+				t.Errorf("verifySignature() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifiedImage(t *testing.T) {
+	deployer := &cachev1alpha1.Deployer{}
+	deployer.Spec.ImageVerification = &cachev1alpha1.ImageVerificationSpec{PublicKey: "key"}
+	deployer.Status.VerifiedImages = []cachev1alpha1.VerifiedImage{
+		{Image: "quay.io/minio/directpv:v4.0.5", Digest: testDigest},
+		{Image: "quay.io/minio/directpv@" + testDigest, Digest: testDigest},
+	}
+	tests := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{name: "tag", image: "quay.io/minio/directpv:v4.0.5", want: "quay.io/minio/directpv:v4.0.5@" + testDigest},
+		{name: "digest", image: "quay.io/minio/directpv@" + testDigest, want: "quay.io/minio/directpv@" + testDigest},
+		{name: "not verified", image: "quay.io/minio/directpv:v4.0.6", want: "quay.io/minio/directpv:v4.0.6"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := verifiedImage(deployer, test.image); got != test.want {
+				t.Errorf("verifiedImage(%q) = %q, want %q", test.image, got, test.want)
+			}
+			if got := imageVersion(verifiedImage(deployer, test.image)); got != imageVersion(test.image) {
+				t.Errorf("imageVersion() of the pinned %q = %q, want %q", test.image, got, imageVersion(test.image))
+			}
+		})
+	}
+
+	deployer.Spec.ImageVerification = nil
+	if got := verifiedImage(deployer, "quay.io/minio/directpv:v4.0.5"); got != "quay.io/minio/directpv:v4.0.5" {
+		t.Errorf("verifiedImage() = %q without image verification", got)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// leaderElectedSidecars are the sidecars of the controller pods electing a leader
+var leaderElectedSidecars = map[string]bool{
+	"csi-health-monitor": true,
+	"csi-provisioner":    true,
+	"csi-resizer":        true,
+	"csi-snapshotter":    true,
+}
+
+// namespaceEnvVar exposes the namespace of the pod to its container
+var namespaceEnvVar = corev1.EnvVar{Name: "NAMESPACE", ValueFrom: &corev1.EnvVarSource{
+	FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"}}}
+
+// leaderElectionArgs returns the arguments placing the leases of the sidecars in the namespace
+// of the pod, with the timings of the Deployer
+func leaderElectionArgs(deployer *cachev1alpha1.Deployer) []string {
+	args := []string{"--leader-election-namespace=$(NAMESPACE)"}
+	if deployer.Spec.Controller == nil || deployer.Spec.Controller.LeaderElection == nil {
+		return args
+	}
+	leaderElection := deployer.Spec.Controller.LeaderElection
+	for _, flag := range []struct {
+		name     string
+		duration *metav1.Duration
+	}{
+		{"--leader-election-lease-duration", leaderElection.LeaseDuration},
+		{"--leader-election-renew-deadline", leaderElection.RenewDeadline},
+		{"--leader-election-retry-period", leaderElection.RetryPeriod},
+	} {
+		if flag.duration != nil {
+			args = append(args, flag.name+"="+flag.duration.Duration.String())
+		}
+	}
+	return args
+}
+
+// applyLeaderElection passes the namespace and the lease timings of the leader election to
+// the sidecars of the controller pods
+func applyLeaderElection(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	args := leaderElectionArgs(deployer)
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		if !leaderElectedSidecars[container.Name] {
+			continue
+		}
+		container.Args = append(container.Args, args...)
+		setEnvVar(container, namespaceEnvVar)
+	}
+}
+
+// leaderElectionEnabled reports whether the arguments of a sidecar leave its leader election
+// enabled. The sidecars are started with --leader-election, the last occurrence of the flag wins.
+func leaderElectionEnabled(args []string) bool {
+	enabled := true
+	for _, arg := range args {
+		name, value, found := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "leader-election" {
+			continue
+		}
+		if !found {
+			enabled = true
+			continue
+		}
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			enabled = parsed
+		}
+	}
+	return enabled
+}
+
+// leaderElectionDisabledSidecars returns the sidecars of the controller pods whose leader
+// election is disabled by their extra arguments, sorted by name
+func leaderElectionDisabledSidecars(deployer *cachev1alpha1.Deployer) []string {
+	sidecars := deployer.Spec.Sidecars
+	if sidecars == nil {
+		return nil
+	}
+	var disabled []string
+	for _, sidecar := range []struct {
+		name string
+		spec *cachev1alpha1.SidecarSpec
+	}{
+		{"csi-health-monitor", sidecars.HealthMonitor},
+		{"csi-provisioner", provisioningSidecarOptions(sidecars.Provisioner)},
+		{"csi-resizer", provisioningSidecarOptions(sidecars.Resizer)},
+		{"csi-snapshotter", sidecars.Snapshotter},
+	} {
+		if sidecar.spec != nil && !leaderElectionEnabled(sidecar.spec.ExtraArgs) {
+			disabled = append(disabled, sidecar.name)
+		}
+	}
+	return disabled
+}
+
+// enforceLeaderElection enables the leader election of the sidecars of the controller pods
+// again when several replicas run, several active provisioners would corrupt the provisioning.
+// A single replica may run without it.
+func enforceLeaderElection(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if controllerReplicasForDeployer(deployer) <= 1 {
+		return
+	}
+	for _, name := range leaderElectionDisabledSidecars(deployer) {
+		appendArgs(spec, name, []string{"--leader-election=true"})
+	}
+}
+
+// updateLeaderElectionEnforced reports in the LeaderElectionEnforced condition the sidecars
+// whose disabled leader election is enabled again, with a Warning event when they change. The
+// status is persisted with the other status changes.
+func (r *DeployerReconciler) updateLeaderElectionEnforced(deployer *cachev1alpha1.Deployer) {
+	replicas := controllerReplicasForDeployer(deployer)
+	sidecars := leaderElectionDisabledSidecars(deployer)
+	if replicas <= 1 || len(sidecars) == 0 {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeLeaderElectionEnforcedDeployer)
+		return
+	}
+	message := fmt.Sprintf("The leader election of %s is enabled despite their extraArgs, %d controller replicas run",
+		strings.Join(sidecars, ", "), replicas)
+	if condition := meta.FindStatusCondition(deployer.Status.Conditions, typeLeaderElectionEnforcedDeployer); condition == nil ||
+		condition.Message != message {
+		r.Recorder.Event(deployer, "Warning", "LeaderElectionEnforced", message)
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeLeaderElectionEnforcedDeployer,
+		Status: metav1.ConditionTrue, Reason: "MultipleReplicas", Message: message})
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// rbacKinds are the kinds reported by the RBAC lifecycle events, e.g. RBACUpdated
+var rbacKinds = map[string]bool{
+	"ServiceAccount":     true,
+	"Role":               true,
+	"RoleBinding":        true,
+	"ClusterRole":        true,
+	"ClusterRoleBinding": true,
+}
+
+// lifecycleEventSubject returns the prefix of the lifecycle event reasons of a kind
+func lifecycleEventSubject(kind string) string {
+	if rbacKinds[kind] {
+		return "RBAC"
+	}
+	return kind
+}
+
+// rollingOut reports whether the mutation of a workload changes its pod template, restarting
+// its pods
+func rollingOut(entry auditEntry) bool {
+	if entry.Kind != "DaemonSet" && entry.Kind != "Deployment" {
+		return false
+	}
+	for _, change := range entry.Changes {
+		if strings.HasPrefix(change, "spec.template.") {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLifecycleEvent reports the mutation of an operand object as a Normal event on the
+// Deployer, e.g. NamespaceCreated, RBACUpdated, DaemonSetRollingOut or StorageClassPruned. The
+// objects deleted while the Deployer is not being deleted are no longer rendered, they are
+// reported as pruned, except the pods which are not rendered but restarted.
+func (c *auditingClient) recordLifecycleEvent(deployer *cachev1alpha1.Deployer, entry auditEntry) {
+	subject := lifecycleEventSubject(entry.Kind)
+	object := entry.object()
+	switch {
+	case entry.Operation == "Created":
+		c.recorder.Event(deployer, "Normal", subject+"Created", "Created "+object)
+	case entry.Operation == "Deleted" && (deployer.GetDeletionTimestamp() != nil || entry.Kind == "Pod"):
+		c.recorder.Event(deployer, "Normal", subject+"Deleted", "Deleted "+object)
+	case entry.Operation == "Deleted":
+		c.recorder.Event(deployer, "Normal", subject+"Pruned", "Deleted "+object+" which is no longer rendered")
+	case rollingOut(entry):
+		c.recorder.Event(deployer, "Normal", subject+"RollingOut", "Rolling out the pod template of "+object)
+	default:
+		c.recorder.Event(deployer, "Normal", subject+"Updated", "Updated "+object)
+	}
+}
+
+// recordLifecycleFailure reports the failed mutation of an operand object as a Warning event on
+// the Deployer, e.g. DaemonSetUpdateFailed. Conflicts are retried by the next reconciliation,
+// and creating an existing object or deleting a missing one is expected, they are not reported.
+func (c *auditingClient) recordLifecycleFailure(deployer *cachev1alpha1.Deployer, entry auditEntry, err error) {
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) || apierrors.IsNotFound(err) {
+		return
+	}
+	action := map[string]string{"Created": "Create", "Updated": "Update", "Patched": "Update", "Deleted": "Delete"}[entry.Operation]
+	c.recorder.Event(deployer, "Warning", lifecycleEventSubject(entry.Kind)+action+"Failed",
+		fmt.Sprintf("Failed to %s %s: %v", strings.ToLower(action), entry.object(), err))
+}
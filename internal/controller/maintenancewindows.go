@@ -0,0 +1,264 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// maxMaintenanceWindowSearch bounds the search of the next maintenance window
+const maxMaintenanceWindowSearch = 366 * 24 * time.Hour
+
+// cronSchedule is a parsed cron schedule, the sets of matching minutes, hours, days of the
+// month, months and days of the week
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// anyDay and anyWeekday are set for * day of month and day of week fields, a time then
+	// only has to match the other field as with cron
+	anyDay, anyWeekday bool
+}
+
+// parseCronSchedule parses a cron schedule of five fields
+func parseCronSchedule(schedule string) (*cronSchedule, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 fields", schedule)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", schedule, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &cronSchedule{
+		minutes: sets[0], hours: sets[1], days: sets[2], months: sets[3], weekdays: sets[4],
+		anyDay: strings.HasPrefix(fields[2], "*"), anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps, e.g. 1-5,*/15
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			} else if i >= 0 {
+				// A value with a step starts a range up to the maximum, e.g. 5/15
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			set[value] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the schedule fires at the minute of the time
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// maintenanceWindow reports whether the time is in a maintenance window of the Deployer, and
+// otherwise when the next window starts, zero if none is found. Without window the time is
+// always in a window.
+func maintenanceWindow(deployer *cachev1alpha1.Deployer, now time.Time) (bool, time.Time, error) {
+	if len(deployer.Spec.MaintenanceWindows) == 0 {
+		return true, time.Time{}, nil
+	}
+	now = now.UTC()
+	start := now.Truncate(time.Minute)
+	var next time.Time
+	for _, window := range deployer.Spec.MaintenanceWindows {
+		schedule, err := parseCronSchedule(window.Schedule)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		for t := start; now.Sub(t) < window.Duration.Duration && now.Sub(t) < maxMaintenanceWindowSearch; t = t.Add(-time.Minute) {
+			if schedule.matches(t) {
+				return true, time.Time{}, nil
+			}
+		}
+		for t := start.Add(time.Minute); t.Sub(start) < maxMaintenanceWindowSearch; t = t.Add(time.Minute) {
+			if !next.IsZero() && !t.Before(next) {
+				break
+			}
+			if schedule.matches(t) {
+				next = t
+				break
+			}
+		}
+	}
+	return false, next, nil
+}
+
+// allowDisruptiveChange reports whether the pod template of the live workload may be updated
+// to the rendered one now. Outside of the maintenance windows the changes are queued in the
+// status, which is persisted at the end of the reconciliation. Workloads without changes are
+// removed from the queue.
+func (r *DeployerReconciler) allowDisruptiveChange(deployer *cachev1alpha1.Deployer, kind string,
+	live, desired client.Object) (bool, error) {
+	liveTemplate, desiredTemplate := podTemplate(live), podTemplate(desired)
+	drift, err := objectDrift(live, desired, "spec", "template")
+	if err != nil {
+		return false, err
+	}
+	if len(drift) == 0 && podTemplateHashEqual(liveTemplate, desiredTemplate) &&
+		podTemplateImagesEqual(liveTemplate, desiredTemplate) {
+		setPendingChanges(deployer, kind, live.GetName(), nil)
+		return true, nil
+	}
+	open, next, err := maintenanceWindow(deployer, time.Now())
+	if err != nil || open {
+		setPendingChanges(deployer, kind, live.GetName(), nil)
+		return open, err
+	}
+
+	changes := []string{}
+	for _, d := range drift {
+		changes = append(changes, d.String())
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "the pod template configuration changed")
+	}
+	if !setPendingChanges(deployer, kind, live.GetName(), changes) {
+		r.Recorder.Event(deployer, "Normal", "ChangesQueued",
+			fmt.Sprintf("Changes restarting the pods of the %s %s are queued until the next maintenance window",
+				kind, live.GetName()))
+	}
+	if next.IsZero() {
+		deployer.Status.PendingChanges.NextWindow = nil
+	} else {
+		deployer.Status.PendingChanges.NextWindow = &metav1.Time{Time: next}
+	}
+	return false, nil
+}
+
+// podTemplateChanged reports whether the pod template of the live workload differs from the
+// rendered one
+func podTemplateChanged(live, desired client.Object) (bool, error) {
+	drifted, err := podTemplateDrifted(live, desired)
+	if err != nil {
+		return false, err
+	}
+	liveTemplate, desiredTemplate := podTemplate(live), podTemplate(desired)
+	return drifted || !podTemplateHashEqual(liveTemplate, desiredTemplate) ||
+		!podTemplateImagesEqual(liveTemplate, desiredTemplate), nil
+}
+
+// podTemplate returns the pod template of a DaemonSet or a Deployment
+func podTemplate(obj client.Object) *corev1.PodTemplateSpec {
+	switch workload := obj.(type) {
+	case *appsv1.DaemonSet:
+		return &workload.Spec.Template
+	case *appsv1.Deployment:
+		return &workload.Spec.Template
+	}
+	return &corev1.PodTemplateSpec{}
+}
+
+// setPendingChanges records the queued changes of a workload, nil changes removing them. It
+// reports whether changes of the workload were already queued.
+func setPendingChanges(deployer *cachev1alpha1.Deployer, kind, name string, changes []string) bool {
+	pending := deployer.Status.PendingChanges
+	if pending == nil {
+		if changes == nil {
+			return false
+		}
+		pending = &cachev1alpha1.PendingChanges{}
+		deployer.Status.PendingChanges = pending
+	}
+	found := false
+	workloads := []cachev1alpha1.PendingWorkloadChanges{}
+	for _, workload := range pending.Workloads {
+		if workload.Kind == kind && workload.Name == name {
+			found = true
+			continue
+		}
+		workloads = append(workloads, workload)
+	}
+	if changes != nil {
+		workloads = append(workloads, cachev1alpha1.PendingWorkloadChanges{Kind: kind, Name: name, Changes: changes})
+	}
+	pending.Workloads = workloads
+	if len(workloads) == 0 {
+		deployer.Status.PendingChanges = nil
+	}
+	return found
+}
+
+// maintenanceWindowDelay returns when to apply the queued changes, 0 without queued changes
+func maintenanceWindowDelay(deployer *cachev1alpha1.Deployer, now time.Time) time.Duration {
+	pending := deployer.Status.PendingChanges
+	if pending == nil || pending.NextWindow == nil {
+		return 0
+	}
+	if delay := pending.NextWindow.Sub(now); delay > 0 {
+		return delay
+	}
+	return time.Minute
+}
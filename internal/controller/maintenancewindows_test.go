@@ -0,0 +1,209 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// cronSet returns the set of the values
+func cronSet(values ...int) map[int]bool {
+	set := map[int]bool{}
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		min, max int
+		want     map[int]bool
+		wantErr  bool
+	}{
+		{name: "star", field: "*", min: 0, max: 3, want: cronSet(0, 1, 2, 3)},
+		{name: "value", field: "5", min: 0, max: 59, want: cronSet(5)},
+		{name: "list", field: "1,3,5", min: 0, max: 59, want: cronSet(1, 3, 5)},
+		{name: "range", field: "1-5", min: 0, max: 59, want: cronSet(1, 2, 3, 4, 5)},
+		{name: "star step", field: "*/15", min: 0, max: 59, want: cronSet(0, 15, 30, 45)},
+		{name: "range step", field: "10-30/10", min: 0, max: 59, want: cronSet(10, 20, 30)},
+		{name: "value step", field: "5/20", min: 0, max: 59, want: cronSet(5, 25, 45)},
+		{name: "value step of one", field: "20/1", min: 0, max: 23, want: cronSet(20, 21, 22, 23)},
+		{name: "mixed list", field: "1-2,*/30", min: 0, max: 59, want: cronSet(0, 1, 2, 30)},
+		{name: "step beyond the range", field: "*/100", min: 1, max: 31, want: cronSet(1)},
+		{name: "bounds", field: "0,59", min: 0, max: 59, want: cronSet(0, 59)},
+		{name: "below the minimum", field: "0", min: 1, max: 31, wantErr: true},
+		{name: "above the maximum", field: "60", min: 0, max: 59, wantErr: true},
+		{name: "reversed range", field: "5-1", min: 0, max: 59, wantErr: true},
+		{name: "zero step", field: "*/0", min: 0, max: 59, wantErr: true},
+		{name: "negative step", field: "*/-1", min: 0, max: 59, wantErr: true},
+		{name: "missing step", field: "*/", min: 0, max: 59, wantErr: true},
+		{name: "empty list item", field: "1,,2", min: 0, max: 59, wantErr: true},
+		{name: "empty field", field: "", min: 0, max: 59, wantErr: true},
+		{name: "open range", field: "1-", min: 0, max: 59, wantErr: true},
+		{name: "negative value", field: "-1", min: 0, max: 59, wantErr: true},
+		{name: "name", field: "MON", min: 0, max: 7, wantErr: true},
+		{name: "question mark", field: "?", min: 1, max: 31, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseCronField(test.field, test.min, test.max)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseCronField(%q) error = %v, want error %v", test.field, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		wantErr  bool
+	}{
+		{name: "every minute", schedule: "* * * * *"},
+		{name: "extra spaces", schedule: "  0 2  * * 6 "},
+		{name: "sunday as 7", schedule: "0 2 * * 7"},
+		{name: "four fields", schedule: "0 2 * *", wantErr: true},
+		{name: "six fields", schedule: "0 0 2 * * *", wantErr: true},
+		{name: "empty", schedule: "", wantErr: true},
+		{name: "macro", schedule: "@daily", wantErr: true},
+		{name: "invalid minute", schedule: "60 2 * * *", wantErr: true},
+		{name: "invalid hour", schedule: "0 24 * * *", wantErr: true},
+		{name: "invalid day of month", schedule: "0 2 32 * *", wantErr: true},
+		{name: "invalid month", schedule: "0 2 * 13 *", wantErr: true},
+		{name: "invalid day of week", schedule: "0 2 * * 8", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCronSchedule(test.schedule)
+			if (err != nil) != test.wantErr {
+				t.Errorf("parseCronSchedule(%q) error = %v, want error %v", test.schedule, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2026-10-01 is a Thursday, 2026-10-04 a Sunday and 2026-10-05 a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		schedule string
+		time     time.Time
+		want     bool
+	}{
+		{name: "every minute", schedule: "* * * * *", time: at(14, 13, 37), want: true},
+		{name: "minute and hour", schedule: "30 2 * * *", time: at(14, 2, 30), want: true},
+		{name: "other minute", schedule: "30 2 * * *", time: at(14, 2, 31), want: false},
+		{name: "other month", schedule: "0 2 * 1-9 *", time: at(14, 2, 0), want: false},
+		{name: "day of month only", schedule: "0 2 1 * *", time: at(1, 2, 0), want: true},
+		{name: "other day of month", schedule: "0 2 1 * *", time: at(5, 2, 0), want: false},
+		{name: "day of week only", schedule: "0 2 * * 1", time: at(5, 2, 0), want: true},
+		{name: "other day of week", schedule: "0 2 * * 1", time: at(1, 2, 0), want: false},
+		{name: "both restricted, day of month", schedule: "0 2 1 * 1", time: at(1, 2, 0), want: true},
+		{name: "both restricted, day of week", schedule: "0 2 1 * 1", time: at(5, 2, 0), want: true},
+		{name: "both restricted, neither", schedule: "0 2 1 * 1", time: at(6, 2, 0), want: false},
+		{name: "stepped day of month is a star", schedule: "0 2 */2 * 1", time: at(5, 2, 0), want: true},
+		{name: "stepped day of month is a star, other day of week", schedule: "0 2 */2 * 1", time: at(3, 2, 0),
+			want: false},
+		{name: "stepped day of week is a star", schedule: "0 2 1 * */2", time: at(1, 2, 0), want: true},
+		{name: "stepped day of week is a star, other day of month", schedule: "0 2 1 * */2", time: at(4, 2, 0),
+			want: false},
+		{name: "sunday as 7", schedule: "0 2 * * 7", time: at(4, 2, 0), want: true},
+		{name: "sunday as 0", schedule: "0 2 * * 0", time: at(4, 2, 0), want: true},
+		{name: "range ending on 7", schedule: "0 2 * * 6-7", time: at(4, 2, 0), want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := parseCronSchedule(test.schedule)
+			if err != nil {
+				t.Fatalf("parseCronSchedule(%q) error = %v", test.schedule, err)
+			}
+			if got := schedule.matches(test.time); got != test.want {
+				t.Errorf("%q matches %s = %v, want %v", test.schedule, test.time, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 3, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		windows  []cachev1alpha1.MaintenanceWindowSpec
+		wantOpen bool
+		wantNext time.Time
+		wantErr  bool
+	}{
+		{name: "no window", wantOpen: true},
+		{
+			name:     "in the window",
+			windows:  []cachev1alpha1.MaintenanceWindowSpec{{Schedule: "0 3 * * *", Duration: metav1.Duration{Duration: time.Hour}}},
+			wantOpen: true,
+		},
+		{
+			name:     "after the window",
+			windows:  []cachev1alpha1.MaintenanceWindowSpec{{Schedule: "0 3 * * *", Duration: metav1.Duration{Duration: 30 * time.Minute}}},
+			wantNext: time.Date(2026, time.October, 15, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "earliest next window",
+			windows: []cachev1alpha1.MaintenanceWindowSpec{
+				{Schedule: "0 2 * * *", Duration: metav1.Duration{Duration: time.Hour}},
+				{Schedule: "0 22 * * *", Duration: metav1.Duration{Duration: time.Hour}},
+			},
+			wantNext: time.Date(2026, time.October, 14, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never matching",
+			windows:  []cachev1alpha1.MaintenanceWindowSpec{{Schedule: "0 2 31 2 *", Duration: metav1.Duration{Duration: time.Hour}}},
+			wantNext: time.Time{},
+		},
+		{
+			name:    "invalid schedule",
+			windows: []cachev1alpha1.MaintenanceWindowSpec{{Schedule: "0 2 * *", Duration: metav1.Duration{Duration: time.Hour}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployer := &cachev1alpha1.Deployer{}
+			deployer.Spec.MaintenanceWindows = test.windows
+			open, next, err := maintenanceWindow(deployer, now)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("maintenanceWindow() error = %v, want error %v", err, test.wantErr)
+			}
+			if open != test.wantOpen || !next.Equal(test.wantNext) {
+				t.Errorf("maintenanceWindow() = %v, %s, want %v, %s", open, next, test.wantOpen, test.wantNext)
+			}
+		})
+	}
+}
@@ -20,23 +20,31 @@ import (
 	"context"
 	"fmt"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"os"
-	"strings"
+	"path"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	configv1alpha1 "github.com/example/directpv-operator/api/config/v1alpha1"
 	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
 )
 
@@ -48,6 +56,28 @@ const (
 	typeAvailableDeployer = "Available"
 	// typeDegradedDeployer represents the status used when the custom resource is deleted and the finalizer operations are must to occur.
 	typeDegradedDeployer = "Degraded"
+	// typeUpgradePendingDeployer represents a new operand version waiting for approval in Manual upgrade policy.
+	typeUpgradePendingDeployer = "UpgradePending"
+	// typeUpgradeAvailableDeployer represents a DirectPV version newer than the installed one bundled with the operator.
+	typeUpgradeAvailableDeployer = "UpgradeAvailable"
+	// typePreflightDeployer represents the result of the checks run before the operands are installed.
+	typePreflightDeployer = "PreflightPassed"
+	// typePortConflictDeployer represents node-server ports conflicting with other node pools.
+	typePortConflictDeployer = "PortConflict"
+	// typeUdevDataUnavailableDeployer represents nodes found without udev data when it is optional.
+	typeUdevDataUnavailableDeployer = "UdevDataUnavailable"
+	// typeCapacityPressureDeployer represents drives whose usage is above the alert threshold.
+	typeCapacityPressureDeployer = "CapacityPressure"
+	// typeDriftDetectedDeployer represents operand workloads diverging from the rendered ones in Warn remediation.
+	typeDriftDetectedDeployer = "DriftDetected"
+	// typeDeprecatedFieldsDeployer represents deprecated spec fields set on the Deployer.
+	typeDeprecatedFieldsDeployer = "DeprecatedFields"
+	// typeLeaderElectionEnforcedDeployer represents sidecars whose disabled leader election is enabled for several replicas.
+	typeLeaderElectionEnforcedDeployer = "LeaderElectionEnforced"
+	// typeProgressingDeployer represents the rollout of the last generation to the operand workloads.
+	typeProgressingDeployer = "Progressing"
+	// typeImagesVerifiedDeployer represents the verification of the signatures of the operand images.
+	typeImagesVerifiedDeployer = "ImagesVerified"
 )
 
 // DeployerReconciler reconciles a Deployer object
@@ -55,6 +85,29 @@ type DeployerReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+
+	// DefaultImages are the operand images loaded from the manager's config file.
+	// They are used when the corresponding environment variable is not set.
+	DefaultImages configv1alpha1.OperandImages
+
+	// FIPSImages are the FIPS-validated operand images loaded from the manager's config file,
+	// used for the Deployers setting spec.fips.
+	FIPSImages configv1alpha1.OperandImages
+
+	// ServerVersion reports the Kubernetes version the operand images are checked against.
+	ServerVersion discovery.ServerVersionInterface
+
+	// SkipKubernetesVersionCheck allows installing operand versions not known to support
+	// the Kubernetes version of the cluster.
+	SkipKubernetesVersionCheck bool
+
+	// hub is the client of the cluster of the Deployer when reconciling its operands in a
+	// remote cluster, nil otherwise
+	hub client.Client
+	// remoteClusters caches the clients of the remote clusters
+	remoteClusters *remoteClusterCache
+	// imageVerifier verifies the signatures of the operand images
+	imageVerifier *imageVerifier
 }
 
 // The following markers are used to generate the rules permissions (RBAC) on config/rbac using controller-gen
@@ -71,7 +124,9 @@ type DeployerReconciler struct {
 //+kubebuilder:rbac:groups=directpv.min.io,resources=directpvdrives,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=directpvvolumes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=directpv.min.io,resources=directpvvolumes,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=csidrivers,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 //+kubebuilder:rbac:groups=directpv.min.io,namespace=directpv,resources=directpvdrives,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -107,6 +162,23 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, err
 	}
 
+	// The operands of a Deployer referencing a kubeconfig Secret are deployed into its remote cluster
+	if deployer.Spec.KubeconfigSecret != nil && r.hub == nil {
+		remote, err := r.remoteReconcilerFor(ctx, deployer)
+		if err != nil {
+			log.Error(err, "Failed to connect to the remote cluster")
+			if err := r.setRemoteClusterUnavailable(ctx, deployer, err); err != nil {
+				log.Error(err, "Failed to update Deployer status")
+			}
+			return ctrl.Result{}, err
+		}
+		return remote.Reconcile(ctx, req)
+	}
+
+	// Record the operand mutations of this reconciliation in the audit log of the Deployer
+	ctx, trail := withAuditTrail(ctx, deployer)
+	defer r.flushAuditTrail(ctx, trail)
+
 	// Let's just set the status as Unknown when no status are available
 	if deployer.Status.Conditions == nil || len(deployer.Status.Conditions) == 0 {
 		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeAvailableDeployer, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
@@ -161,11 +233,15 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 			// Perform all operations required before remove the finalizer and allow
 			// the Kubernetes API to remove the custom resource.
-			r.doFinalizerOperationsForDeployer(deployer)
-
-			// TODO(user): If you add operations to the doFinalizerOperationsForDeployer method
-			// then you need to ensure that all worked fine before deleting and updating the Downgrade status
-			// otherwise, you should requeue here.
+			done, err := r.doFinalizerOperationsForDeployer(ctx, deployer)
+			if err != nil {
+				log.Error(err, "Failed to perform the finalizer operations for Deployer")
+				return ctrl.Result{}, err
+			}
+			if !done {
+				// Wait for the cleanup on the nodes to complete
+				return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+			}
 
 			// Re-fetch the deployer Custom Resource before update the status
 			// so that we have the latest state of the resource on the cluster and we will avoid
@@ -199,10 +275,53 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, nil
 	}
 
+	// The deprecated fields in use and the overridden sidecar arguments are reported with the
+	// other status changes
+	r.updateDeprecatedFields(deployer)
+	r.updateLeaderElectionEnforced(deployer)
+
+	if err := r.reconcileRemoteNamespace(ctx, deployer); err != nil {
+		log.Error(err, "Failed to create the namespace in the remote cluster")
+		return ctrl.Result{}, err
+	}
+
+	// The node-server pods are privileged, make sure the namespace admits them
+	if err := r.reconcileNamespaceLabels(ctx, deployer); err != nil {
+		log.Error(err, "Failed to set the Pod Security Admission labels on the namespace")
+		return ctrl.Result{}, err
+	}
+
+	// The operand pods run with dedicated least-privilege service accounts
+	if err := r.reconcileRBAC(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the service accounts and RBAC of the operands")
+		return ctrl.Result{}, err
+	}
+
+	// The TLS proxies of the operand pods mount the serving certificate
+	if err := r.reconcileOperandTLS(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the TLS certificate of the operands")
+		return ctrl.Result{}, err
+	}
+
+	// Host network node pools sharing nodes must not bind the same ports
+	if result, err := r.reconcilePortConflicts(ctx, deployer); err != nil || !result.IsZero() {
+		return result, err
+	}
+
+	// The operand images must be signed before they are rendered into the workloads
+	if result, err := r.reconcileImageVerification(ctx, deployer); err != nil || !result.IsZero() {
+		return result, err
+	}
+
 	// Check if the daemonset already exists, if not create a new one
 	foundDaemonSet := &appsv1.DaemonSet{}
-	err = r.Get(ctx, types.NamespacedName{Name: deployer.Name, Namespace: "directpv"}, foundDaemonSet)
+	err = r.Get(ctx, types.NamespacedName{Name: daemonSetNameForDeployer(deployer), Namespace: deployer.Namespace}, foundDaemonSet)
 	if err != nil && apierrors.IsNotFound(err) {
+		// Make sure the cluster can run DirectPV before creating anything
+		if result, err := r.runPreflightChecks(ctx, deployer); err != nil || !result.IsZero() {
+			return result, err
+		}
+
 		// Define a new DaemonSet
 		daemonSet, err := r.daemonSetForDeployer(deployer)
 		if err != nil {
@@ -232,11 +351,44 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		log.Error(err, "Failed to get DaemonSet")
 		// Let's return the error for the reconciliation be re-trigged again
 		return ctrl.Result{}, err
+	} else {
+		if result, err := r.ensureAdopted(ctx, deployer, foundDaemonSet); err != nil || !result.IsZero() {
+			return result, err
+		}
+
+		// Roll out image changes to the existing DaemonSet, canary nodes first if configured
+		result, err := r.reconcileNodeServerRollout(ctx, deployer, foundDaemonSet)
+		if err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+
+	// Architectures overriding the DirectPV image run a node-server DaemonSet of their own
+	if err := r.reconcileArchDaemonSets(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the node-server DaemonSets of the architectures")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileCSIDriver(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the CSIDriver")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileStorageClasses(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the StorageClasses")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileSnapshotController(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the snapshot CRDs and controller")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileVolumeSnapshotClass(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the VolumeSnapshotClass")
+		return ctrl.Result{}, err
 	}
 
 	// Check if the deployment already exists, if not create a new one
 	foundDeployment := &appsv1.Deployment{}
-	err = r.Get(ctx, types.NamespacedName{Name: deployer.Name, Namespace: "directpv"}, foundDeployment)
+	err = r.Get(ctx, types.NamespacedName{Name: deploymentNameForDeployer(deployer), Namespace: deployer.Namespace}, foundDeployment)
 	if err != nil && apierrors.IsNotFound(err) {
 		// Define a new deployment
 		dep, err := r.deploymentForDeployer(deployer)
@@ -274,12 +426,20 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, err
 	}
 
-	// The CRD API is defining that the Memcached type, have a MemcachedSpec.Size field
-	// to set the quantity of Deployment instances is the desired state on the cluster.
+	if result, err := r.ensureAdopted(ctx, deployer, foundDeployment); err != nil || !result.IsZero() {
+		return result, err
+	}
+	if result, err := r.reconcileControllerRollout(ctx, deployer, foundDeployment); err != nil || !result.IsZero() {
+		return result, err
+	}
+
+	// The controller.replicas field of the Deployer (or the deprecated size) sets the
+	// quantity of Deployment instances in the desired state on the cluster.
 	// Therefore, the following code will ensure the Deployment size is the same as defined
-	// via the Size spec of the Custom Resource which we are reconciling.
-	size := deployer.Spec.Size
-	if *foundDeployment.Spec.Replicas != size {
+	// via the spec of the Custom Resource which we are reconciling.
+	size := controllerReplicasForDeployer(deployer)
+	if *foundDeployment.Spec.Replicas != size && remediationEnforced(deployer) &&
+		!fieldIgnored(deployer, "Deployment", ".spec.replicas") {
 		foundDeployment.Spec.Replicas = &size
 		if err = r.Update(ctx, foundDeployment); err != nil {
 			log.Error(err, "Failed to update Deployment",
@@ -313,6 +473,70 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{Requeue: true}, nil
 	}
 
+	// Without remediation the differences of the workloads are reported instead
+	if err := r.reconcileDrift(ctx, deployer); err != nil {
+		log.Error(err, "Failed to detect the drift of the operand workloads")
+		return ctrl.Result{}, err
+	}
+
+	if foundDaemonSet.Spec.Selector != nil {
+		r.updateProgressing(deployer, foundDaemonSet, foundDeployment)
+		if err := r.updateInstalledVersion(ctx, deployer, foundDaemonSet); err != nil {
+			log.Error(err, "Failed to detect the installed DirectPV version")
+			return ctrl.Result{}, err
+		}
+		if err := r.reconcileMetricsService(ctx, deployer, foundDaemonSet); err != nil {
+			log.Error(err, "Failed to reconcile the node-server metrics Service")
+			return ctrl.Result{}, err
+		}
+		if err := r.reconcileMonitoring(ctx, deployer, foundDaemonSet); err != nil {
+			log.Error(err, "Failed to reconcile the metrics monitor")
+			return ctrl.Result{}, err
+		}
+		if err := r.recordOperandMetrics(ctx, deployer, foundDaemonSet); err != nil {
+			log.Error(err, "Failed to record the operand metrics")
+			return ctrl.Result{}, err
+		}
+	}
+
+	stuckPodDelay, err := r.forceDeleteStuckPods(ctx, deployer)
+	if err != nil {
+		log.Error(err, "Failed to force-delete the stuck operand pods")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileDrives(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the DirectPV drives")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileDriveScrubs(ctx, deployer); err != nil {
+		log.Error(err, "Failed to reconcile the filesystem checks of the drives")
+		return ctrl.Result{}, err
+	}
+
+	cleanupDelay, err := r.purgeReleasedVolumes(ctx, deployer)
+	if err != nil {
+		log.Error(err, "Failed to purge the released DirectPV volumes")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateStorageSummary(ctx, deployer); err != nil {
+		log.Error(err, "Failed to summarize the DirectPV drives and volumes")
+		return ctrl.Result{}, err
+	}
+
+	telemetryDelay, err := r.reconcileTelemetry(ctx, deployer)
+	if err != nil {
+		log.Error(err, "Failed to collect the telemetry report")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.pruneOrphanedObjects(ctx, deployer); err != nil {
+		log.Error(err, "Failed to prune orphaned objects")
+		return ctrl.Result{}, err
+	}
+
 	// The following implementation will update the status
 	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeAvailableDeployer,
 		Status: metav1.ConditionTrue, Reason: "Reconciling",
@@ -323,15 +547,63 @@ func (r *DeployerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	// Come back in time to renew the generated serving certificate, to purge the released
+	// volumes, to check the usage of the drives, to refresh the capacity of the nodes, to
+	// apply the changes queued until the next maintenance window or the end of the rollouts
+	// of the node pools, to send the telemetry, to resync the remote clusters and to
+	// force-delete the pods getting stuck Terminating and to check the progress deadline of
+	// the rollout
+	requeueAfter := certificateRenewalDelay(deployer, time.Now())
+	for _, delay := range []time.Duration{cleanupDelay, capacityAlertDelay(deployer), capacityRefreshDelay(deployer),
+		maintenanceWindowDelay(deployer, time.Now()), nodePoolRolloutDelay(deployer), remoteClusterDelay(deployer), telemetryDelay,
+		stuckPodDelay, progressDeadlineDelay(deployer, time.Now())} {
+		if delay > 0 && (requeueAfter == 0 || delay < requeueAfter) {
+			requeueAfter = delay
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // finalizeMemcached will perform the required operations before delete the CR.
-func (r *DeployerReconciler) doFinalizerOperationsForDeployer(cr *cachev1alpha1.Deployer) {
-	// TODO(user): Add the cleanup steps that the operator
-	// needs to do before the CR can be deleted. Examples
-	// of finalizers include performing backups and deleting
-	// resources that are not owned by this CR, like a PVC.
+// It reports whether they completed, the reconciliation is requeued until they did.
+func (r *DeployerReconciler) doFinalizerOperationsForDeployer(ctx context.Context, cr *cachev1alpha1.Deployer) (bool, error) {
+	// Removing DirectPV while workloads use its volumes would take the storage away from them
+	if blocked, err := r.blockDeletionWhileVolumesBound(ctx, cr); err != nil || blocked {
+		return false, err
+	}
+
+	// The DirectPV drives and volumes are cluster-scoped and not owned by the CR,
+	// they are handled according to the uninstall policy.
+	if err := r.uninstallDrivesAndVolumes(ctx, cr); err != nil {
+		return false, err
+	}
+
+	// The on-disk state is only wiped when purging
+	if uninstallPolicyForDeployer(cr) == cachev1alpha1.UninstallPolicyForce {
+		if done, err := r.cleanupNodes(ctx, cr); err != nil || !done {
+			return false, err
+		}
+	}
+
+	// The devices of the released drives are wiped once DirectPV unmounted them
+	if done, err := r.wipeReleasedDrives(ctx, cr); err != nil || !done {
+		return false, err
+	}
+
+	// The cluster-scoped RBAC objects cannot be owned by the CR
+	if err := r.deleteClusterRBAC(ctx, cr); err != nil {
+		return false, err
+	}
+	if err := r.deleteVolumeSnapshotClass(ctx, cr); err != nil {
+		return false, err
+	}
+	if err := r.deleteStorageClasses(ctx, cr); err != nil {
+		return false, err
+	}
+	// Nothing is garbage collected with the CR in a remote cluster
+	if err := r.deleteRemoteOperands(ctx, cr); err != nil {
+		return false, err
+	}
 
 	// Note: It is not recommended to use finalizers with the purpose of delete resources which are
 	// created and managed in the reconciliation. These ones, such as the Deployment created on this reconcile,
@@ -339,11 +611,14 @@ func (r *DeployerReconciler) doFinalizerOperationsForDeployer(cr *cachev1alpha1.
 	// to set the ownerRef which means that the Deployment will be deleted by the Kubernetes API.
 	// More info: https://kubernetes.io/docs/tasks/administer-cluster/use-cascading-deletion/
 
+	forgetOperandMetrics(cr)
+
 	// The following implementation will raise an event
 	r.Recorder.Event(cr, "Warning", "Deleting",
 		fmt.Sprintf("Custom Resource %s is being deleted from the namespace %s",
 			cr.Name,
 			cr.Namespace))
+	return true, nil
 }
 
 // nameSpaceForDeployer returns a NameSpace Object.
@@ -360,29 +635,49 @@ func (r *DeployerReconciler) nameSpaceForDeployer(memcached *cachev1alpha1.Deplo
 	return namespace, nil
 }
 
+// daemonSetNameForDeployer returns the name of the node-server DaemonSet of the Deployer.
+// The name is derived from the custom resource so that several Deployers can coexist,
+// unless an existing DaemonSet was adopted.
+func daemonSetNameForDeployer(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Status.Adopted != nil && deployer.Status.Adopted.NodeServer != "" {
+		return deployer.Status.Adopted.NodeServer
+	}
+	return deployer.Name + "-node-server"
+}
+
+// deploymentNameForDeployer returns the name of the controller Deployment of the Deployer
+func deploymentNameForDeployer(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Status.Adopted != nil && deployer.Status.Adopted.Controller != "" {
+		return deployer.Status.Adopted.Controller
+	}
+	return deployer.Name
+}
+
 // daemonSetForDeployer returns a Deployer DaemonSet Object.
 func (r *DeployerReconciler) daemonSetForDeployer(
 	memcached *cachev1alpha1.Deployer) (*appsv1.DaemonSet, error) {
-	ls := labelsForMemcached(memcached.Name)
-	controllerImage, err := imageForDeployer()
+	ls := r.labelsForMemcached(memcached.Name)
+	controllerImage, err := r.imageForDeployer(memcached)
 	if err != nil {
 		return nil, err
 	}
-	registrarImage, err := imageForRegistrar()
+	registrarImage, err := r.imageForRegistrar(memcached)
 	if err != nil {
 		return nil, err
 	}
-	livenessProbeImage, err := imageForLivenessProbe()
+	livenessProbeImage, err := r.imageForLivenessProbe(memcached)
 	if err != nil {
 		return nil, err
 	}
 	hostPathTypeToBeUsed := corev1.HostPathDirectoryOrCreate
 	healthZContainerPortName := "healthz"
+	ports := nodeServerPortsForDeployer(memcached)
 	mountPropagationMode := corev1.MountPropagationNone
 	var daemonset = &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "node-server",
+			Name:      daemonSetNameForDeployer(memcached),
 			Namespace: memcached.Namespace,
+			Labels:    ls,
 		},
 		Spec: appsv1.DaemonSetSpec{
 			Selector: &metav1.LabelSelector{
@@ -393,14 +688,15 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 					Labels: ls,
 				},
 				Spec: corev1.PodSpec{
+					NodeSelector:       memcached.Spec.NodeSelector,
 					SecurityContext:    &corev1.PodSecurityContext{},
-					ServiceAccountName: "directpv-min-io",
+					ServiceAccountName: nodeServerServiceAccountName(memcached),
 					Volumes: []corev1.Volume{
 						{
 							Name: "socket-dir",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/var/lib/kubelet/plugins/directpv-min-io",
+									Path: nodeSocketDirForDeployer(memcached),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -409,7 +705,7 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							Name: "mountpoint-dir",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/var/lib/kubelet/pods",
+									Path: path.Join(kubeletDirForDeployer(memcached), "pods"),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -418,7 +714,7 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							Name: "registration-dir",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/var/lib/kubelet/plugins_registry",
+									Path: path.Join(kubeletDirForDeployer(memcached), "plugins_registry"),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -427,7 +723,7 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							Name: "plugins-dir",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/var/lib/kubelet/plugins",
+									Path: kubeletPluginsDirForDeployer(memcached),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -436,7 +732,7 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							Name: "directpv-common-root",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/var/lib/directpv/",
+									Path: dataPathForDeployer(memcached),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -463,7 +759,7 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							Name: "run-udev-data-dir",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/run/udev/data",
+									Path: udevDataPathForDeployer(memcached),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -488,8 +784,8 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							},
 							Args: []string{
 								"--v=3",
-								"--csi-address=unix:///csi/csi.sock",
-								"--kubelet-registration-path=/var/lib/kubelet/plugins/directpv-min-io/csi.sock",
+								"--csi-address=" + csiEndpoint,
+								"--kubelet-registration-path=" + path.Join(nodeSocketDirForDeployer(memcached), csiSocketName),
 							},
 							Env: []corev1.EnvVar{
 								{
@@ -505,7 +801,7 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:             "socket-dir",
-									MountPath:        "/csi",
+									MountPath:        csiSocketDir,
 									MountPropagation: &mountPropagationMode,
 								},
 								{
@@ -524,15 +820,15 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							},
 							Ports: []corev1.ContainerPort{
 								{
-									ContainerPort: 30443,
+									ContainerPort: ports.readiness,
 									Name:          "readinessport",
 								},
 								{
-									ContainerPort: 9898,
+									ContainerPort: ports.healthz,
 									Name:          "healthz",
 								},
 								{
-									ContainerPort: 10443,
+									ContainerPort: ports.metrics,
 									Name:          "metrics",
 								},
 							},
@@ -570,13 +866,13 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 								"--identity=directpv-min-io",
 								"--csi-endpoint=$(CSI_ENDPOINT)",
 								"--kube-node-name=$(KUBE_NODE_NAME)",
-								"--readiness-port=30443",
-								"--metrics-port=10443",
+								fmt.Sprintf("--readiness-port=%d", ports.readiness),
+								fmt.Sprintf("--metrics-port=%d", ports.metrics),
 							},
 							Env: []corev1.EnvVar{
 								{
 									Name:  "CSI_ENDPOINT",
-									Value: "unix:///csi/csi.sock",
+									Value: csiEndpoint,
 								},
 								{
 									Name: "KUBE_NODE_NAME",
@@ -591,15 +887,15 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "socket-dir",
-									MountPath: "/csi",
+									MountPath: csiSocketDir,
 								},
 								{
 									Name:      "mountpoint-dir",
-									MountPath: "/var/lib/kubelet/pods",
+									MountPath: path.Join(kubeletDirForDeployer(memcached), "pods"),
 								},
 								{
 									Name:      "plugins-dir",
-									MountPath: "/var/lib/kubelet/plugins",
+									MountPath: kubeletPluginsDirForDeployer(memcached),
 								},
 								{
 									Name:      "directpv-common-root",
@@ -649,15 +945,15 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "socket-dir",
-									MountPath: "/csi",
+									MountPath: csiSocketDir,
 								},
 								{
 									Name:      "mountpoint-dir",
-									MountPath: "/var/lib/kubelet/pods",
+									MountPath: path.Join(kubeletDirForDeployer(memcached), "pods"),
 								},
 								{
 									Name:      "plugins-dir",
-									MountPath: "/var/lib/kubelet/plugins",
+									MountPath: kubeletPluginsDirForDeployer(memcached),
 								},
 								{
 									Name:      "directpv-common-root",
@@ -689,13 +985,13 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 								Privileged: &[]bool{true}[0],
 							},
 							Args: []string{
-								"--csi-address=/csi/csi.sock",
-								"--health-port=9898",
+								"--csi-address=" + csiSocketPath,
+								fmt.Sprintf("--health-port=%d", ports.healthz),
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "socket-dir",
-									MountPath: "/csi",
+									MountPath: csiSocketDir,
 								},
 							},
 						},
@@ -704,6 +1000,35 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 			},
 		},
 	}
+	if !legacyMountRequired(memcached) {
+		removeLegacyMount(&daemonset.Spec.Template.Spec)
+	}
+	if err := r.applyTLSProxies(memcached, &daemonset.Spec.Template.Spec, "node-server", []tlsEndpoint{
+		{name: "readiness", portName: "readinessport", upstreamPortName: "readiness-http",
+			port: ports.readiness, path: "/ready", flag: "--readiness-port"},
+		{name: "metrics", portName: "metrics", upstreamPortName: "metrics-http",
+			port: ports.metrics, path: "/metrics", authorize: metricsAuthorizationEnabled(memcached), flag: "--metrics-port"},
+	}); err != nil {
+		return nil, err
+	}
+	applyNodeServerArchitectures(memcached, &daemonset.Spec.Template.Spec)
+	applyNodeExclusions(memcached, &daemonset.Spec.Template.Spec)
+	applyNodePools(memcached, &daemonset.Spec.Template.Spec)
+	applyPodSpecOptions(memcached, &daemonset.Spec.Template.Spec)
+	applyDriveQuota(memcached, &daemonset.Spec.Template.Spec)
+	appendArgs(&daemonset.Spec.Template.Spec, "node-server", nodeServerVolumeLimitArgs(memcached.Spec.VolumeLimits))
+	applyNodeServerOptions(memcached, &daemonset.Spec.Template.Spec)
+	applyDriveEncryption(memcached, &daemonset.Spec.Template.Spec)
+	applyVerifiedImages(memcached, &daemonset.Spec.Template.Spec)
+	applyCommonMetadata(memcached, daemonset)
+	applyCommonMetadata(memcached, &daemonset.Spec.Template)
+	if memcached.Spec.NodeServer != nil {
+		applyPodAnnotations(&daemonset.Spec.Template, memcached.Spec.NodeServer.PodAnnotations)
+	}
+	applyRestartedAt(memcached, &daemonset.Spec.Template)
+	if err := setPodTemplateHash(&daemonset.Spec.Template); err != nil {
+		return nil, err
+	}
 	if err := ctrl.SetControllerReference(memcached, daemonset, r.Scheme); err != nil {
 		return nil, err
 	}
@@ -713,27 +1038,32 @@ func (r *DeployerReconciler) daemonSetForDeployer(
 // deploymentForDeployer returns a Deployer Deployment object
 func (r *DeployerReconciler) deploymentForDeployer(
 	memcached *cachev1alpha1.Deployer) (*appsv1.Deployment, error) {
-	ls := labelsForMemcached(memcached.Name)
-	replicas := memcached.Spec.Size
+	ls := r.labelsForMemcached(memcached.Name)
+	replicas := controllerReplicasForDeployer(memcached)
 
 	// Get the images
-	controllerImage, err := imageForDeployer()
+	controllerImage, err := r.imageForDeployer(memcached)
 	if err != nil {
 		return nil, err
 	}
-	resizerImage, err := imageForResizer()
+	resizerImage, err := r.imageForResizer(memcached)
 	if err != nil {
 		return nil, err
 	}
-	provisionerImage, err := imageForProvisioner()
+	provisionerImage, err := r.imageForProvisioner(memcached)
 	if err != nil {
 		return nil, err
 	}
+	var provisionerSidecar, resizerSidecar *cachev1alpha1.ProvisioningSidecarSpec
+	if memcached.Spec.Sidecars != nil {
+		provisionerSidecar, resizerSidecar = memcached.Spec.Sidecars.Provisioner, memcached.Spec.Sidecars.Resizer
+	}
 	hostPathTypeToBeUsed := corev1.HostPathDirectoryOrCreate
 	var dep = &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      memcached.Name,
+			Name:      deploymentNameForDeployer(memcached),
 			Namespace: memcached.Namespace,
+			Labels:    ls,
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replicas,
@@ -745,14 +1075,14 @@ func (r *DeployerReconciler) deploymentForDeployer(
 					Labels: ls,
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: "directpv-min-io",
+					ServiceAccountName: controllerServiceAccountName(memcached),
 					SecurityContext:    &corev1.PodSecurityContext{},
 					Volumes: []corev1.Volume{
 						{
 							Name: "socket-dir",
 							VolumeSource: corev1.VolumeSource{
 								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/var/lib/kubelet/plugins/controller-controller",
+									Path: controllerSocketDirForDeployer(memcached),
 									Type: &hostPathTypeToBeUsed,
 								},
 							},
@@ -764,7 +1094,7 @@ func (r *DeployerReconciler) deploymentForDeployer(
 							Name:  "csi-provisioner",
 							Args: []string{
 								"--v=3",
-								"--timeout=300s",
+								sidecarTimeoutArg(provisionerSidecar),
 								"--csi-address=$(CSI_ENDPOINT)",
 								"--leader-election",
 								"--feature-gates=Topology=true",
@@ -773,13 +1103,13 @@ func (r *DeployerReconciler) deploymentForDeployer(
 							Env: []corev1.EnvVar{
 								{
 									Name:  "CSI_ENDPOINT",
-									Value: "unix:///csi/csi.sock",
+									Value: csiEndpoint,
 								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "socket-dir",
-									MountPath: "/csi",
+									MountPath: csiSocketDir,
 								},
 							},
 						},
@@ -800,6 +1130,20 @@ func (r *DeployerReconciler) deploymentForDeployer(
 									Name:          "healthz",
 								},
 							},
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path:   "/ready",
+										Port:   intstr.FromString("readinessport"),
+										Scheme: "HTTP",
+									},
+								},
+								InitialDelaySeconds: 60,
+								TimeoutSeconds:      10,
+								PeriodSeconds:       10,
+								SuccessThreshold:    1,
+								FailureThreshold:    5,
+							},
 							Args: []string{
 								"controller",
 								"--identity=directpv-min-io",
@@ -811,7 +1155,7 @@ func (r *DeployerReconciler) deploymentForDeployer(
 							Env: []corev1.EnvVar{
 								{
 									Name:  "CSI_ENDPOINT",
-									Value: "unix:///csi/csi.sock",
+									Value: csiEndpoint,
 								},
 								{
 									Name: "KUBE_NODE_NAME",
@@ -826,24 +1170,24 @@ func (r *DeployerReconciler) deploymentForDeployer(
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "socket-dir",
-									MountPath: "/csi",
+									MountPath: csiSocketDir,
 								},
 							},
 						},
 						{
 							Image: resizerImage,
 							Name:  "csi-resizer",
-							Args:  []string{"--v=3", "--timeout=300s", "--csi-address=$(CSI_ENDPOINT)", "--leader-election"},
+							Args:  []string{"--v=3", sidecarTimeoutArg(resizerSidecar), "--csi-address=$(CSI_ENDPOINT)", "--leader-election"},
 							Env: []corev1.EnvVar{
 								{
 									Name:  "CSI_ENDPOINT",
-									Value: "unix:///csi/csi.sock",
+									Value: csiEndpoint,
 								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "socket-dir",
-									MountPath: "/csi",
+									MountPath: csiSocketDir,
 								},
 							},
 						},
@@ -851,7 +1195,40 @@ func (r *DeployerReconciler) deploymentForDeployer(
 				},
 			},
 		},
-	} // Set the ownerRef for the Deployment
+	}
+	if err := r.applySnapshotter(memcached, &dep.Spec.Template.Spec); err != nil {
+		return nil, err
+	}
+	if err := r.applyHealthMonitor(memcached, &dep.Spec.Template.Spec); err != nil {
+		return nil, err
+	}
+	if err := r.applyTLSProxies(memcached, &dep.Spec.Template.Spec, "controller", []tlsEndpoint{
+		{name: "readiness", portName: "readinessport", upstreamPortName: "readiness-http",
+			port: 30443, path: "/ready", flag: "--readiness-port"},
+	}); err != nil {
+		return nil, err
+	}
+	applyControllerArchitectures(memcached, &dep.Spec.Template.Spec, controllerImage)
+	requireNodeSelectorRequirements(&dep.Spec.Template.Spec, linuxNodeRequirement)
+	applyProvisioningSidecars(memcached, &dep.Spec.Template.Spec)
+	applyLeaderElection(memcached, &dep.Spec.Template.Spec)
+	applyPodSpecOptions(memcached, &dep.Spec.Template.Spec)
+	appendArgs(&dep.Spec.Template.Spec, "controller", controllerVolumeLimitArgs(memcached.Spec.VolumeLimits))
+	applyControllerOptions(memcached, &dep.Spec.Template.Spec)
+	applyStorageCapacity(memcached, &dep.Spec.Template.Spec)
+	enforceLeaderElection(memcached, &dep.Spec.Template.Spec)
+	applyVerifiedImages(memcached, &dep.Spec.Template.Spec)
+	applyCommonMetadata(memcached, dep)
+	applyCommonMetadata(memcached, &dep.Spec.Template)
+	if memcached.Spec.Controller != nil {
+		applyPodAnnotations(&dep.Spec.Template, memcached.Spec.Controller.PodAnnotations)
+	}
+	applyRestartedAt(memcached, &dep.Spec.Template)
+	if err := setPodTemplateHash(&dep.Spec.Template); err != nil {
+		return nil, err
+	}
+
+	// Set the ownerRef for the Deployment
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/owners-dependents/
 	if err := ctrl.SetControllerReference(memcached, dep, r.Scheme); err != nil {
 		return nil, err
@@ -861,11 +1238,14 @@ func (r *DeployerReconciler) deploymentForDeployer(
 
 // labelsForMemcached returns the labels for selecting the resources
 // More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
-func labelsForMemcached(name string) map[string]string {
+func (r *DeployerReconciler) labelsForMemcached(name string) map[string]string {
+	// The version is the one of the standard image whether or not FIPS is enabled, the
+	// labels are part of the immutable selectors
 	var imageTag string
-	image, err := imageForDeployer()
-	if err == nil {
-		imageTag = strings.Split(image, ":")[1]
+	image, err := imageFromEnv("DIRECTPV_IMAGE", r.DefaultImages.DirectPV)
+	// The version of a digest reference is not a valid label value, it is left out
+	if version := imageVersion(image); err == nil && len(validation.IsValidLabelValue(version)) == 0 {
+		imageTag = version
 	}
 	return map[string]string{"app.kubernetes.io/name": "Memcached",
 		"app.kubernetes.io/instance":   name,
@@ -875,63 +1255,64 @@ func labelsForMemcached(name string) map[string]string {
 	}
 }
 
-// imageForMemcached gets the Operand image which is managed by this controller
-// from the DIRECTPV_IMAGE environment variable defined in the config/manager/manager.yaml
-func imageForDeployer() (string, error) {
-	var imageEnvVar = "DIRECTPV_IMAGE"
+// imageFromEnv gets an image from the given environment variable defined in the
+// config/manager/manager.yaml, falling back to the default from the manager's config file
+func imageFromEnv(imageEnvVar, defaultImage string) (string, error) {
 	image, found := os.LookupEnv(imageEnvVar)
-	if !found {
-		return "", fmt.Errorf("Unable to find %s environment variable with the image", imageEnvVar)
+	if found {
+		return image, nil
 	}
-	return image, nil
+	if defaultImage != "" {
+		return defaultImage, nil
+	}
+	return "", fmt.Errorf("Unable to find %s environment variable with the image", imageEnvVar)
+}
+
+// imageForDeployer gets the Operand image which is managed by this controller
+// from the DIRECTPV_IMAGE environment variable defined in the config/manager/manager.yaml
+func (r *DeployerReconciler) imageForDeployer(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "DIRECTPV_IMAGE", r.DefaultImages.DirectPV, r.FIPSImages.DirectPV)
 }
 
 // imageForResizer gets the resizer image
-func imageForResizer() (string, error) {
-	var imageEnvVar = "CSI_RESIZER"
-	image, found := os.LookupEnv(imageEnvVar)
-	if !found {
-		return "", fmt.Errorf("Unable to find #{imageEnvVar} environment variable with the image")
-	}
-	return image, nil
+func (r *DeployerReconciler) imageForResizer(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "CSI_RESIZER", r.DefaultImages.CSIResizer, r.FIPSImages.CSIResizer)
 }
 
 // imageForProvisioner gets the provisioner image
-func imageForProvisioner() (string, error) {
-	var imageEnvVar = "CSI_PROVISIONER"
-	image, found := os.LookupEnv(imageEnvVar)
-	if !found {
-		return "", fmt.Errorf("Unable to find #{imageEnvVar} environment variable with the image")
-	}
-	return image, nil
+func (r *DeployerReconciler) imageForProvisioner(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "CSI_PROVISIONER", r.DefaultImages.CSIProvisioner, r.FIPSImages.CSIProvisioner)
 }
 
-// imageForRegistrar gets the provisioner image
-func imageForRegistrar() (string, error) {
-	var imageEnvVar = "CSI_NODE_DRIVER_REGISTRAR"
-	image, found := os.LookupEnv(imageEnvVar)
-	if !found {
-		return "", fmt.Errorf("Unable to find #{imageEnvVar} environment variable with the image")
-	}
-	return image, nil
+// imageForRegistrar gets the node driver registrar image
+func (r *DeployerReconciler) imageForRegistrar(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "CSI_NODE_DRIVER_REGISTRAR", r.DefaultImages.CSINodeDriverRegistrar, r.FIPSImages.CSINodeDriverRegistrar)
 }
 
 // imageForLivenessProbe gets the liveness probe image
-func imageForLivenessProbe() (string, error) {
-	var imageEnvVar = "LIVENESS_PROBE"
-	image, found := os.LookupEnv(imageEnvVar)
-	if !found {
-		return "", fmt.Errorf("Unable to find #{imageEnvVar} environment variable with the image")
-	}
-	return image, nil
+func (r *DeployerReconciler) imageForLivenessProbe(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "LIVENESS_PROBE", r.DefaultImages.LivenessProbe, r.FIPSImages.LivenessProbe)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 // Note that the Deployment will be also watched in order to ensure its
-// desirable state on the cluster
+// desirable state on the cluster. The nodes are watched to refresh the per-node status and the
+// scheduling of the operands. The mutations of the operand objects are audited.
 func (r *DeployerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Client = &auditingClient{Client: r.Client, recorder: r.Recorder}
+	r.remoteClusters = newRemoteClusterCache()
+	r.imageVerifier = newImageVerifier()
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cachev1alpha1.Deployer{}).
+		Owns(&appsv1.DaemonSet{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&batchv1.CronJob{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&corev1.Service{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.deployersForNamespace)).
+		Watches(&source.Kind{Type: &corev1.Node{}}, handler.EnqueueRequestsFromMapFunc(r.deployersForNode),
+			builder.WithPredicates(nodeSchedulingChanged())).
 		Complete(r)
 }
@@ -0,0 +1,62 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// restartedAtAnnotation on the Deployer is copied into the operand pod templates, changing it
+// restarts the operand pods like kubectl rollout restart
+const restartedAtAnnotation = "directpv.min.io/restartedAt"
+
+// applyCommonMetadata adds the common labels and annotations of the Deployer to a rendered
+// object. The labels set by the operator take precedence as selectors and pruning rely on them.
+func applyCommonMetadata(deployer *cachev1alpha1.Deployer, obj metav1.Object) {
+	if len(deployer.Spec.CommonLabels) > 0 {
+		obj.SetLabels(mergeLabels(deployer.Spec.CommonLabels, obj.GetLabels()))
+	}
+	if len(deployer.Spec.CommonAnnotations) > 0 {
+		obj.SetAnnotations(mergeLabels(deployer.Spec.CommonAnnotations, obj.GetAnnotations()))
+	}
+}
+
+// mergeObjectMetadata adds the labels and annotations of the desired object to the live one,
+// keeping the ones set by others like the deprecated.daemonset.template.generation annotation
+func mergeObjectMetadata(live, desired metav1.Object) {
+	live.SetLabels(mergeLabels(live.GetLabels(), desired.GetLabels()))
+	if len(desired.GetAnnotations()) > 0 {
+		live.SetAnnotations(mergeLabels(live.GetAnnotations(), desired.GetAnnotations()))
+	}
+}
+
+// applyPodAnnotations adds the annotations to the pod template
+func applyPodAnnotations(template *corev1.PodTemplateSpec, annotations map[string]string) {
+	if len(annotations) > 0 {
+		template.Annotations = mergeLabels(template.Annotations, annotations)
+	}
+}
+
+// applyRestartedAt copies the restartedAt annotation of the Deployer into the pod template
+func applyRestartedAt(deployer *cachev1alpha1.Deployer, template *corev1.PodTemplateSpec) {
+	if restartedAt := deployer.Annotations[restartedAtAnnotation]; restartedAt != "" {
+		applyPodAnnotations(template, map[string]string{restartedAtAnnotation: restartedAt})
+	}
+}
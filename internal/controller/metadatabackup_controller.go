@@ -0,0 +1,199 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// metadataBackupRetryInterval is how long a failed backup waits before being retried
+const metadataBackupRetryInterval = 5 * time.Minute
+
+// metadataBackupDocument is the content of a backup object
+type metadataBackupDocument struct {
+	// Time the backup was taken
+	Time metav1.Time `json:"time"`
+
+	// Deployer of the backup, without its status
+	Deployer map[string]interface{} `json:"deployer"`
+
+	// Drives are the DirectPVDrives of the cluster
+	Drives []map[string]interface{} `json:"drives"`
+
+	// Volumes are the DirectPVVolumes of the cluster
+	Volumes []map[string]interface{} `json:"volumes"`
+
+	// Nodes are the DirectPVNodes of the cluster, they are not restored as the node-servers
+	// recreate them
+	Nodes []map[string]interface{} `json:"nodes,omitempty"`
+}
+
+// collectMetadata returns the document of the DirectPV objects of the cluster and of the
+// Deployer of the namespace, without the metadata set by the API server
+func collectMetadata(ctx context.Context, c client.Client, namespace, deployerName string) (*metadataBackupDocument, error) {
+	deployer := &cachev1alpha1.Deployer{}
+	if err := c.Get(ctx, types.NamespacedName{Name: deployerName, Namespace: namespace}, deployer); err != nil {
+		return nil, err
+	}
+	deployerObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deployer)
+	if err != nil {
+		return nil, err
+	}
+	delete(deployerObject, "status")
+	document := &metadataBackupDocument{Time: metav1.Now(), Deployer: deployerObject}
+	document.Deployer["apiVersion"] = cachev1alpha1.GroupVersion.String()
+	document.Deployer["kind"] = "Deployer"
+	stripServerMetadata(document.Deployer)
+
+	for _, gvk := range []struct {
+		kind    string
+		objects *[]map[string]interface{}
+	}{
+		{directPVDriveGVK.Kind, &document.Drives},
+		{directPVVolumeGVK.Kind, &document.Volumes},
+		{directPVNodeGVK.Kind, &document.Nodes},
+	} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(directPVDriveGVK.GroupVersion().WithKind(gvk.kind + "List"))
+		if err := c.List(ctx, list); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			stripServerMetadata(list.Items[i].Object)
+			*gvk.objects = append(*gvk.objects, list.Items[i].Object)
+		}
+	}
+	return document, nil
+}
+
+// MetadataBackupReconciler uploads the DirectPV metadata to the buckets of the MetadataBackups
+type MetadataBackupReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=metadatabackups,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=metadatabackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile uploads a backup of the DirectPV drives and volumes, and of the Deployer, once the
+// interval of the MetadataBackup elapsed since the last successful one
+func (r *MetadataBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	backup := &cachev1alpha1.MetadataBackup{}
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	interval := 24 * time.Hour
+	if backup.Spec.Interval != nil && backup.Spec.Interval.Duration > 0 {
+		interval = backup.Spec.Interval.Duration
+	}
+	if last := backup.Status.LastBackupTime; last != nil {
+		if wait := time.Until(last.Add(interval)); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	err := r.backup(ctx, backup)
+	backup.Status.Message = ""
+	if err != nil {
+		log.Error(err, "Failed to back up the DirectPV metadata")
+		backup.Status.Message = err.Error()
+		r.Recorder.Event(backup, "Warning", "BackupFailed", err.Error())
+	} else {
+		r.Recorder.Event(backup, "Normal", "BackedUp", fmt.Sprintf("Uploaded %s with %d drives and %d volumes",
+			backup.Status.LastBackupObject, backup.Status.Drives, backup.Status.Volumes))
+	}
+	if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
+		log.Error(updateErr, "Failed to update MetadataBackup status")
+		return ctrl.Result{}, updateErr
+	}
+	if err != nil {
+		return ctrl.Result{RequeueAfter: metadataBackupRetryInterval}, nil
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// backup uploads the backup document as a timestamped object and as the latest object
+func (r *MetadataBackupReconciler) backup(ctx context.Context, backup *cachev1alpha1.MetadataBackup) error {
+	document, err := collectMetadata(ctx, r.Client, backup.Namespace, backup.Spec.Deployer)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	store, err := newObjectStore(ctx, r.Client, backup.Namespace, backup.Spec.ObjectStore)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.json", backup.Name, document.Time.UTC().Format("20060102T150405Z"))
+	for _, object := range []string{name, latestBackupObjectName(backup.Name)} {
+		if err := store.put(ctx, object, data); err != nil {
+			return err
+		}
+	}
+	log.FromContext(ctx).Info("Uploaded the DirectPV metadata", "Object", store.objectName(name),
+		"Drives", len(document.Drives), "Volumes", len(document.Volumes))
+	backup.Status.LastBackupTime = &document.Time
+	backup.Status.LastBackupObject = name
+	backup.Status.Drives = int32(len(document.Drives))
+	backup.Status.Volumes = int32(len(document.Volumes))
+	return nil
+}
+
+// latestBackupObjectName returns the name of the object holding the latest backup of the
+// MetadataBackup
+func latestBackupObjectName(backup string) string {
+	return backup + "-latest.json"
+}
+
+// stripServerMetadata removes the metadata set by the API server from the object, so it can
+// be created again on another cluster
+func stripServerMetadata(object map[string]interface{}) {
+	for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "generation",
+		"managedFields", "ownerReferences", "deletionTimestamp", "deletionGracePeriodSeconds", "selfLink"} {
+		unstructured.RemoveNestedField(object, "metadata", field)
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. The status updates of the
+// MetadataBackups are ignored, a failed backup is only retried after a delay.
+func (r *MetadataBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.MetadataBackup{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
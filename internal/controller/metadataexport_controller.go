@@ -0,0 +1,194 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// metadataArchiveKey is the key of the gzipped archive in the binary data of the ConfigMaps
+const metadataArchiveKey = "directpv-metadata.json.gz"
+
+// MetadataExportReconciler writes the archives of the MetadataExports
+type MetadataExportReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=metadataexports,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=metadataexports/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update
+
+// Reconcile writes the archive of the DirectPV objects and of the Deployer to the destinations
+// of the MetadataExport, once. Unavailable destinations are retried.
+func (r *MetadataExportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	export := &cachev1alpha1.MetadataExport{}
+	if err := r.Get(ctx, req.NamespacedName, export); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	switch export.Status.Phase {
+	case cachev1alpha1.MetadataExportPhaseCompleted, cachev1alpha1.MetadataExportPhaseFailed:
+		return ctrl.Result{}, nil
+	}
+
+	err := r.export(ctx, export)
+	switch {
+	case err != nil:
+		log.Error(err, "Failed to export the DirectPV metadata")
+		export.Status.Phase = cachev1alpha1.MetadataExportPhasePending
+		export.Status.Message = err.Error()
+	case export.Status.Phase == cachev1alpha1.MetadataExportPhaseCompleted:
+		r.Recorder.Event(export, "Normal", "Exported", export.Status.Message)
+	case export.Status.Phase == cachev1alpha1.MetadataExportPhaseFailed:
+		r.Recorder.Event(export, "Warning", "ExportFailed", export.Status.Message)
+	}
+	if updateErr := r.Status().Update(ctx, export); updateErr != nil {
+		log.Error(updateErr, "Failed to update MetadataExport status")
+		return ctrl.Result{}, updateErr
+	}
+	if err != nil {
+		return ctrl.Result{RequeueAfter: metadataRestoreRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// export writes the archive to the ConfigMap and uploads it to the object store
+func (r *MetadataExportReconciler) export(ctx context.Context, export *cachev1alpha1.MetadataExport) error {
+	if export.Spec.ConfigMap == "" && export.Spec.ObjectStore == nil {
+		export.Status.Phase = cachev1alpha1.MetadataExportPhaseFailed
+		export.Status.Message = "either configMap or objectStore must be set"
+		return nil
+	}
+	document, err := collectMetadata(ctx, r.Client, export.Namespace, export.Spec.Deployer)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	var locations []string
+	if export.Spec.ConfigMap != "" {
+		archive, err := gzipData(data)
+		if err != nil {
+			return err
+		}
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      export.Spec.ConfigMap,
+				Namespace: export.Namespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/name":     "directpv-metadata",
+					"app.kubernetes.io/instance": export.Name,
+					"app.kubernetes.io/part-of":  "directpv-operator",
+				},
+			},
+			BinaryData: map[string][]byte{metadataArchiveKey: archive},
+		}
+		err = r.Create(ctx, configMap)
+		if apierrors.IsAlreadyExists(err) {
+			// Written by a previous attempt when the upload failed
+			found := &corev1.ConfigMap{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(configMap), found); err != nil {
+				return err
+			}
+			if found.Labels["app.kubernetes.io/name"] != "directpv-metadata" ||
+				found.Labels["app.kubernetes.io/instance"] != export.Name {
+				export.Status.Phase = cachev1alpha1.MetadataExportPhaseFailed
+				export.Status.Message = fmt.Sprintf("the ConfigMap %s already exists", configMap.Name)
+				return nil
+			}
+			found.BinaryData = configMap.BinaryData
+			err = r.Update(ctx, found)
+		}
+		if err != nil {
+			return err
+		}
+		locations = append(locations, "ConfigMap "+configMap.Name)
+	}
+	if export.Spec.ObjectStore != nil {
+		store, err := newObjectStore(ctx, r.Client, export.Namespace, *export.Spec.ObjectStore)
+		if err != nil {
+			return err
+		}
+		name := export.Name + ".json"
+		if err := store.put(ctx, name, data); err != nil {
+			return err
+		}
+		locations = append(locations, "object "+store.objectName(name))
+	}
+
+	export.Status.Phase = cachev1alpha1.MetadataExportPhaseCompleted
+	export.Status.CompletionTime = &document.Time
+	export.Status.Nodes = int32(len(document.Nodes))
+	export.Status.Drives = int32(len(document.Drives))
+	export.Status.Volumes = int32(len(document.Volumes))
+	export.Status.Message = fmt.Sprintf("Exported %d drives and %d volumes to the %s", export.Status.Drives,
+		export.Status.Volumes, strings.Join(locations, " and the "))
+	return nil
+}
+
+// gzipData compresses the data with gzip
+func gzipData(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// gunzipData decompresses the gzipped data
+func gunzipData(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MetadataExportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.MetadataExport{}).
+		Complete(r)
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// metadataRestoreRetryInterval is how long a pending restore waits before being retried
+const metadataRestoreRetryInterval = time.Minute
+
+// MetadataRestoreReconciler recreates the DirectPV metadata of the backups of the MetadataRestores
+type MetadataRestoreReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=metadatarestores,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=metadatarestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=deployers,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=directpv.min.io,resources=directpvdrives;directpvvolumes,verbs=get;list;watch;create
+
+// Reconcile reads the backup or the export of the MetadataRestore, checks that its drives
+// match the nodes of the cluster and creates its DirectPV drives and volumes, then its
+// Deployer in the namespace of the MetadataRestore, so that DirectPV starts with the restored
+// drives. The restore runs once, the objects which already exist are kept.
+func (r *MetadataRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	restore := &cachev1alpha1.MetadataRestore{}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	switch restore.Status.Phase {
+	case cachev1alpha1.MetadataRestorePhaseCompleted, cachev1alpha1.MetadataRestorePhaseFailed:
+		return ctrl.Result{}, nil
+	}
+
+	err := r.restore(ctx, restore)
+	switch {
+	case err != nil:
+		log.Error(err, "Failed to restore the DirectPV metadata")
+		restore.Status.Phase = cachev1alpha1.MetadataRestorePhasePending
+		restore.Status.Message = err.Error()
+	case restore.Status.Phase == cachev1alpha1.MetadataRestorePhaseCompleted:
+		r.Recorder.Event(restore, "Normal", "Restored", restore.Status.Message)
+	case restore.Status.Phase == cachev1alpha1.MetadataRestorePhaseFailed:
+		r.Recorder.Event(restore, "Warning", "RestoreFailed", restore.Status.Message)
+	}
+	if updateErr := r.Status().Update(ctx, restore); updateErr != nil {
+		log.Error(updateErr, "Failed to update MetadataRestore status")
+		return ctrl.Result{}, updateErr
+	}
+	if err != nil {
+		return ctrl.Result{RequeueAfter: metadataRestoreRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// restore creates the objects of the archive. Errors reading the archive or creating the
+// objects are returned to retry the restore, an invalid archive fails it.
+func (r *MetadataRestoreReconciler) restore(ctx context.Context, restore *cachev1alpha1.MetadataRestore) error {
+	data, source, err := r.readArchive(ctx, restore)
+	if err != nil || restore.Status.Phase == cachev1alpha1.MetadataRestorePhaseFailed {
+		return err
+	}
+	document := metadataBackupDocument{}
+	if err := json.Unmarshal(data, &document); err != nil || document.Deployer == nil {
+		restore.Status.Phase = cachev1alpha1.MetadataRestorePhaseFailed
+		restore.Status.Message = fmt.Sprintf("%s is not a backup of the DirectPV metadata", source)
+		return nil
+	}
+	if !restore.Spec.SkipIdentityCheck {
+		problems, err := r.checkDriveIdentities(ctx, document.Drives)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			restore.Status.Phase = cachev1alpha1.MetadataRestorePhaseFailed
+			restore.Status.Message = fmt.Sprintf("The drives of %s do not match the cluster: %s", source,
+				strings.Join(problems, "; "))
+			return nil
+		}
+	}
+
+	restore.Status.Drives, restore.Status.Volumes, restore.Status.Skipped = 0, 0, 0
+	for _, objects := range []struct {
+		items   []map[string]interface{}
+		created *int32
+	}{
+		{document.Drives, &restore.Status.Drives},
+		{document.Volumes, &restore.Status.Volumes},
+		{[]map[string]interface{}{document.Deployer}, nil},
+	} {
+		for _, item := range objects.items {
+			obj := &unstructured.Unstructured{Object: item}
+			stripServerMetadata(obj.Object)
+			if obj.GetKind() == "Deployer" {
+				obj.SetNamespace(restore.Namespace)
+			}
+			err := r.Create(ctx, obj)
+			if apierrors.IsAlreadyExists(err) {
+				restore.Status.Skipped++
+				continue
+			}
+			if meta.IsNoMatchError(err) {
+				return fmt.Errorf("the CRD of %s is not installed: %w", obj.GetKind(), err)
+			}
+			if err != nil {
+				return err
+			}
+			log.FromContext(ctx).Info("Restored object", "Kind", obj.GetKind(), "Name", obj.GetName())
+			if objects.created != nil {
+				*objects.created++
+			}
+		}
+	}
+	restore.Status.Phase = cachev1alpha1.MetadataRestorePhaseCompleted
+	restore.Status.BackupTime = &document.Time
+	restore.Status.Message = fmt.Sprintf("Restored %d drives and %d volumes of %s", restore.Status.Drives,
+		restore.Status.Volumes, source)
+	return nil
+}
+
+// readArchive returns the archive of the MetadataRestore, from its ConfigMap or its object
+// store, and a description of its source. An invalid spec fails the restore.
+func (r *MetadataRestoreReconciler) readArchive(ctx context.Context,
+	restore *cachev1alpha1.MetadataRestore) ([]byte, string, error) {
+	if restore.Spec.ConfigMap != "" {
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.ConfigMap, Namespace: restore.Namespace}, configMap); err != nil {
+			return nil, "", err
+		}
+		source := "the ConfigMap " + configMap.Name
+		data, err := gunzipData(configMap.BinaryData[metadataArchiveKey])
+		if err != nil {
+			restore.Status.Phase = cachev1alpha1.MetadataRestorePhaseFailed
+			restore.Status.Message = fmt.Sprintf("%s does not hold a %s archive: %v", source, metadataArchiveKey, err)
+		}
+		return data, source, nil
+	}
+
+	object := restore.Spec.Object
+	if object == "" {
+		object = latestBackupObjectName(restore.Spec.Backup)
+	}
+	if restore.Spec.ObjectStore == nil || (restore.Spec.Object == "" && restore.Spec.Backup == "") {
+		restore.Status.Phase = cachev1alpha1.MetadataRestorePhaseFailed
+		restore.Status.Message = "either configMap, or objectStore with object or backup, must be set"
+		return nil, "", nil
+	}
+	store, err := newObjectStore(ctx, r.Client, restore.Namespace, *restore.Spec.ObjectStore)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := store.get(ctx, object)
+	return data, store.objectName(object), err
+}
+
+// checkDriveIdentities returns the drives of the archive not matching the cluster: their node
+// must exist and, once DirectPV runs on it, report a device holding the filesystem of the drive
+func (r *MetadataRestoreReconciler) checkDriveIdentities(ctx context.Context,
+	drives []map[string]interface{}) ([]string, error) {
+	devices := map[string]map[string]bool{}
+	var problems []string
+	for _, item := range drives {
+		drive := &unstructured.Unstructured{Object: item}
+		node := drive.GetLabels()["directpv.min.io/node"]
+		fsuuid, _, _ := unstructured.NestedString(drive.Object, "status", "fsuuid")
+		fsuuids, found := devices[node]
+		if !found {
+			var err error
+			if fsuuids, err = r.nodeFilesystems(ctx, node); err != nil {
+				return nil, err
+			}
+			devices[node] = fsuuids
+		}
+		switch {
+		case fsuuids == nil:
+			problems = append(problems, fmt.Sprintf("the node %s of the drive %s does not exist", node, drive.GetName()))
+		case len(fsuuids) > 0 && !fsuuids[fsuuid]:
+			problems = append(problems, fmt.Sprintf("no device of the node %s holds the filesystem %s of the drive %s",
+				node, fsuuid, drive.GetName()))
+		}
+	}
+	return problems, nil
+}
+
+// nodeFilesystems returns the filesystem UUIDs of the devices reported by the DirectPVNode of
+// the node, empty when DirectPV does not run on it yet and nil when the node does not exist
+func (r *MetadataRestoreReconciler) nodeFilesystems(ctx context.Context, node string) (map[string]bool, error) {
+	if err := r.Get(ctx, types.NamespacedName{Name: node}, &corev1.Node{}); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	fsuuids := map[string]bool{}
+	directPVNode := &unstructured.Unstructured{}
+	directPVNode.SetGroupVersionKind(directPVNodeGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: node}, directPVNode)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return fsuuids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	items, _, _ := unstructured.NestedSlice(directPVNode.Object, "status", "devices")
+	for _, item := range items {
+		if fields, ok := item.(map[string]interface{}); ok {
+			if fsuuid, _ := fields["fsuuid"].(string); fsuuid != "" {
+				fsuuids[fsuuid] = true
+			}
+		}
+	}
+	return fsuuids, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MetadataRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.MetadataRestore{}).
+		Complete(r)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+
+// metricsServiceName returns the name of the Service exposing the node-server metrics
+func metricsServiceName(deployer *cachev1alpha1.Deployer) string {
+	return deployer.Name + "-node-server-metrics"
+}
+
+// reconcileMetricsService creates the headless Service through which Prometheus discovers the
+// metrics endpoints of the node-server pods. The controller pods carry the same labels but no
+// metrics port, the named target port keeps them out of the endpoints.
+func (r *DeployerReconciler) reconcileMetricsService(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, daemonSet *appsv1.DaemonSet) error {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: metricsServiceName(deployer), Namespace: deployer.Namespace}}
+	return r.createOrUpdateOwned(ctx, deployer, service, func() error {
+		service.Labels = mergeLabels(service.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, service)
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+		service.Spec.Selector = daemonSet.Spec.Selector.MatchLabels
+		service.Spec.Ports = []corev1.ServicePort{{
+			Name:       "metrics",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       nodeServerPortsForDeployer(deployer).metrics,
+			TargetPort: intstr.FromString("metrics"),
+		}}
+		return nil
+	})
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// legacyMountName is the node-server volume holding the legacy direct-csi state
+const legacyMountName = "direct-csi-common-root"
+
+var (
+	legacyDriveGroupKind  = schema.GroupKind{Group: "direct.csi.min.io", Kind: "DirectCSIDrive"}
+	legacyVolumeGroupKind = schema.GroupKind{Group: "direct.csi.min.io", Kind: "DirectCSIVolume"}
+	directPVDriveGVK      = schema.GroupVersionKind{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVDrive"}
+	directPVVolumeGVK     = schema.GroupVersionKind{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVVolume"}
+)
+
+// MigrationReconciler converts the drives and volumes of a legacy direct-csi installation to
+// DirectPV for the Deployers opting in with spec.migrateLegacyDirectCSI
+type MigrationReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=direct.csi.min.io,resources=directcsidrives;directcsivolumes,verbs=get;list;watch
+
+// Reconcile migrates the legacy objects and records the progress in status.migration. Objects
+// already converted are skipped so the migration can be resumed after a failure. Once done, the
+// legacy mount is dropped from the node-server DaemonSet.
+func (r *MigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	deployer := &cachev1alpha1.Deployer{}
+	if err := r.Get(ctx, req.NamespacedName, deployer); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !deployer.Spec.MigrateLegacyDirectCSI || !deployer.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	if legacyMigrationCompleted(deployer) {
+		return ctrl.Result{}, r.removeLegacyMountFromDaemonSet(ctx, deployer)
+	}
+
+	status, err := r.migrate(ctx, deployer)
+	if err != nil {
+		log.Error(err, "Failed to migrate the legacy direct-csi objects")
+		status.Message = err.Error()
+	}
+	deployer.Status.Migration = status
+	if updateErr := r.Status().Update(ctx, deployer); updateErr != nil {
+		log.Error(updateErr, "Failed to update Deployer status")
+		return ctrl.Result{}, updateErr
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if status.Phase == cachev1alpha1.MigrationPhaseBlocked {
+		log.Info("Legacy direct-csi migration blocked", "InvalidVolumes", status.InvalidVolumes)
+		r.Recorder.Event(deployer, "Warning", "MigrationBlocked",
+			fmt.Sprintf("%d legacy volumes failed the safety checks, see ConfigMap %s",
+				status.InvalidVolumes, migrationReportName(deployer)))
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	log.Info("Migrated the legacy direct-csi objects",
+		"Drives", status.MigratedDrives, "Volumes", status.MigratedVolumes)
+	r.Recorder.Event(deployer, "Normal", "MigrationCompleted",
+		fmt.Sprintf("Migrated %d drives and %d volumes from direct-csi", status.MigratedDrives, status.MigratedVolumes))
+	return ctrl.Result{}, r.removeLegacyMountFromDaemonSet(ctx, deployer)
+}
+
+// migrate converts all the legacy drives and volumes. Nothing is converted unless all the
+// legacy volumes pass the safety checks. The returned status is always set, reporting the
+// progress made until the first error.
+func (r *MigrationReconciler) migrate(ctx context.Context, deployer *cachev1alpha1.Deployer) (*cachev1alpha1.MigrationStatus, error) {
+	status := &cachev1alpha1.MigrationStatus{Phase: cachev1alpha1.MigrationPhaseRunning}
+
+	legacyDrives, err := r.listLegacy(ctx, legacyDriveGroupKind)
+	if err != nil {
+		return status, err
+	}
+	legacyVolumes, err := r.listLegacy(ctx, legacyVolumeGroupKind)
+	if err != nil {
+		return status, err
+	}
+	status.TotalDrives = int32(len(legacyDrives))
+	status.TotalVolumes = int32(len(legacyVolumes))
+
+	report, err := r.checkLegacyVolumes(ctx, legacyDrives, legacyVolumes)
+	if err != nil {
+		return status, err
+	}
+	for _, result := range report {
+		if result != migrationCheckPassed {
+			status.InvalidVolumes++
+		}
+	}
+	if err := r.writeMigrationReport(ctx, deployer, report); err != nil {
+		return status, err
+	}
+	if status.InvalidVolumes > 0 {
+		status.Phase = cachev1alpha1.MigrationPhaseBlocked
+		return status, nil
+	}
+
+	// DirectPV drives are named after the filesystem UUID, volumes refer to them by it
+	fsuuids := map[string]string{}
+	for i := range legacyDrives {
+		legacyDrive := &legacyDrives[i]
+		drive, fsuuid := convertLegacyDrive(legacyDrive)
+		if drive == nil {
+			// Drives not in use by direct-csi hold no data and are not migrated
+			status.TotalDrives--
+			continue
+		}
+		if err := r.createIfNotExists(ctx, drive); err != nil {
+			return status, fmt.Errorf("unable to migrate drive %s: %w", legacyDrive.GetName(), err)
+		}
+		fsuuids[legacyDrive.GetName()] = fsuuid
+		status.MigratedDrives++
+	}
+
+	for i := range legacyVolumes {
+		legacyVolume := &legacyVolumes[i]
+		volume, err := convertLegacyVolume(legacyVolume, fsuuids)
+		if err != nil {
+			return status, err
+		}
+		if err := r.createIfNotExists(ctx, volume); err != nil {
+			return status, fmt.Errorf("unable to migrate volume %s: %w", legacyVolume.GetName(), err)
+		}
+		status.MigratedVolumes++
+	}
+
+	status.Phase = cachev1alpha1.MigrationPhaseCompleted
+	return status, nil
+}
+
+// listLegacy lists the legacy objects of the given kind at the version served by the cluster.
+// Nothing is returned when the legacy CRDs are not installed.
+func (r *MigrationReconciler) listLegacy(ctx context.Context, gk schema.GroupKind) ([]unstructured.Unstructured, error) {
+	mapping, err := r.RESTMapper().RESTMapping(gk)
+	if meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(mapping.GroupVersionKind.GroupVersion().WithKind(gk.Kind + "List"))
+	if err := r.List(ctx, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// createIfNotExists creates the object unless an object with the same name exists already.
+// The DirectPV CRDs have no status subresource so the status is stored on create.
+func (r *MigrationReconciler) createIfNotExists(ctx context.Context, obj *unstructured.Unstructured) error {
+	if err := r.Create(ctx, obj); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// convertLegacyDrive returns the DirectPV drive of an in use legacy drive and its filesystem UUID,
+// or nil when the drive is not in use.
+func convertLegacyDrive(legacy *unstructured.Unstructured) (*unstructured.Unstructured, string) {
+	driveStatus, _, _ := unstructured.NestedString(legacy.Object, "status", "driveStatus")
+	fsuuid, _, _ := unstructured.NestedString(legacy.Object, "status", "filesystemUUID")
+	if driveStatus != "InUse" || fsuuid == "" {
+		return nil, ""
+	}
+
+	nodeName, _, _ := unstructured.NestedString(legacy.Object, "status", "nodeName")
+	path, _, _ := unstructured.NestedString(legacy.Object, "status", "path")
+	accessTier, _, _ := unstructured.NestedString(legacy.Object, "status", "accessTier")
+	model, _, _ := unstructured.NestedString(legacy.Object, "status", "modelNumber")
+	totalCapacity, _, _ := unstructured.NestedInt64(legacy.Object, "status", "totalCapacity")
+	freeCapacity, _, _ := unstructured.NestedInt64(legacy.Object, "status", "freeCapacity")
+	allocatedCapacity, _, _ := unstructured.NestedInt64(legacy.Object, "status", "allocatedCapacity")
+	topology, _, _ := unstructured.NestedStringMap(legacy.Object, "status", "topology")
+
+	drive := &unstructured.Unstructured{}
+	drive.SetGroupVersionKind(directPVDriveGVK)
+	drive.SetName(fsuuid)
+	drive.SetLabels(map[string]string{
+		"directpv.min.io/node":        nodeName,
+		"directpv.min.io/drive-name":  strings.TrimPrefix(path, "/dev/"),
+		"directpv.min.io/access-tier": accessTierOrDefault(accessTier),
+		"directpv.min.io/version":     directPVDriveGVK.Version,
+		"directpv.min.io/created-by":  "directpv-operator",
+		"directpv.min.io/migrated":    "true",
+	})
+	drive.Object["status"] = map[string]interface{}{
+		"fsuuid":            fsuuid,
+		"status":            "Ready",
+		"make":              model,
+		"totalCapacity":     totalCapacity,
+		"freeCapacity":      freeCapacity,
+		"allocatedCapacity": allocatedCapacity,
+		"topology":          stringMapToInterface(topology),
+	}
+	return drive, fsuuid
+}
+
+// convertLegacyVolume returns the DirectPV volume of a legacy volume. The drive of the volume
+// must have been migrated.
+func convertLegacyVolume(legacy *unstructured.Unstructured, fsuuids map[string]string) (*unstructured.Unstructured, error) {
+	driveName, _, _ := unstructured.NestedString(legacy.Object, "status", "drive")
+	fsuuid, found := fsuuids[driveName]
+	if !found {
+		return nil, fmt.Errorf("drive %s of volume %s was not migrated", driveName, legacy.GetName())
+	}
+
+	nodeName, _, _ := unstructured.NestedString(legacy.Object, "status", "nodeName")
+	hostPath, _, _ := unstructured.NestedString(legacy.Object, "status", "hostPath")
+	stagingPath, _, _ := unstructured.NestedString(legacy.Object, "status", "stagingPath")
+	containerPath, _, _ := unstructured.NestedString(legacy.Object, "status", "containerPath")
+	totalCapacity, _, _ := unstructured.NestedInt64(legacy.Object, "status", "totalCapacity")
+	availableCapacity, _, _ := unstructured.NestedInt64(legacy.Object, "status", "availableCapacity")
+	usedCapacity, _, _ := unstructured.NestedInt64(legacy.Object, "status", "usedCapacity")
+
+	volume := &unstructured.Unstructured{}
+	volume.SetGroupVersionKind(directPVVolumeGVK)
+	volume.SetName(legacy.GetName())
+	volume.SetFinalizers([]string{"directpv.min.io/data-protection"})
+	volume.SetLabels(map[string]string{
+		"directpv.min.io/node":       nodeName,
+		"directpv.min.io/drive":      fsuuid,
+		"directpv.min.io/version":    directPVVolumeGVK.Version,
+		"directpv.min.io/created-by": "directpv-operator",
+		"directpv.min.io/migrated":   "true",
+	})
+	volume.Object["status"] = map[string]interface{}{
+		"fsuuid":            fsuuid,
+		"status":            "Ready",
+		"dataPath":          hostPath,
+		"stagingTargetPath": stagingPath,
+		"targetPath":        containerPath,
+		"totalCapacity":     totalCapacity,
+		"availableCapacity": availableCapacity,
+		"usedCapacity":      usedCapacity,
+	}
+	return volume, nil
+}
+
+// removeLegacyMountFromDaemonSet drops the legacy mount from the node-server DaemonSet
+func (r *MigrationReconciler) removeLegacyMountFromDaemonSet(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	daemonSet := &appsv1.DaemonSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: daemonSetNameForDeployer(deployer), Namespace: deployer.Namespace}, daemonSet)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if legacyMountRequired(deployer) {
+		return nil
+	}
+	patch := client.MergeFrom(daemonSet.DeepCopy())
+	if !removeLegacyMount(&daemonSet.Spec.Template.Spec) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Removing the legacy direct-csi mount",
+		"DaemonSet.Namespace", daemonSet.Namespace, "DaemonSet.Name", daemonSet.Name)
+	return r.Patch(ctx, daemonSet, patch)
+}
+
+// legacyMigrationCompleted reports whether the Deployer migrated the legacy direct-csi objects
+func legacyMigrationCompleted(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.MigrateLegacyDirectCSI && deployer.Status.Migration != nil &&
+		deployer.Status.Migration.Phase == cachev1alpha1.MigrationPhaseCompleted
+}
+
+// legacyMountRequired reports whether the node-server mounts the legacy direct-csi state: while
+// the legacy objects are migrated, or when the mount is kept explicitly
+func legacyMountRequired(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.MountLegacyDirectCSI ||
+		(deployer.Spec.MigrateLegacyDirectCSI && !legacyMigrationCompleted(deployer))
+}
+
+// removeLegacyMount removes the legacy volume and its mounts from the pod spec and reports
+// whether it was present
+func removeLegacyMount(spec *corev1.PodSpec) bool {
+	removed := false
+	volumes := spec.Volumes[:0]
+	for _, volume := range spec.Volumes {
+		if volume.Name == legacyMountName {
+			removed = true
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+	spec.Volumes = volumes
+
+	for i := range spec.Containers {
+		mounts := spec.Containers[i].VolumeMounts[:0]
+		for _, mount := range spec.Containers[i].VolumeMounts {
+			if mount.Name != legacyMountName {
+				mounts = append(mounts, mount)
+			}
+		}
+		spec.Containers[i].VolumeMounts = mounts
+	}
+	return removed
+}
+
+// accessTierOrDefault returns the access tier, defaulting to the DirectPV default
+func accessTierOrDefault(accessTier string) string {
+	if accessTier == "" {
+		return "Default"
+	}
+	return accessTier
+}
+
+// stringMapToInterface converts a string map for use in unstructured objects
+func stringMapToInterface(m map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("migration").
+		For(&cachev1alpha1.Deployer{}).
+		Complete(r)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// legacyCSIDriverName is the name of the legacy direct-csi CSI driver
+const legacyCSIDriverName = "direct-csi-min-io"
+
+// migrationCheckPassed is the report entry of a legacy volume passing all the safety checks
+const migrationCheckPassed = "OK"
+
+//+kubebuilder:rbac:groups=core,resources=persistentvolumes;persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch
+
+// checkLegacyVolumes verifies every legacy volume is backed by a bound PV and PVC and has a
+// mount path on its drive. It returns the result per volume name, migrationCheckPassed or the
+// reason the volume cannot be migrated safely.
+func (r *MigrationReconciler) checkLegacyVolumes(ctx context.Context,
+	legacyDrives, legacyVolumes []unstructured.Unstructured) (map[string]string, error) {
+	mountpoints := map[string]string{}
+	for i := range legacyDrives {
+		mountpoint, _, _ := unstructured.NestedString(legacyDrives[i].Object, "status", "mountpoint")
+		mountpoints[legacyDrives[i].GetName()] = mountpoint
+	}
+
+	report := map[string]string{}
+	for i := range legacyVolumes {
+		legacyVolume := &legacyVolumes[i]
+		problem, err := r.checkLegacyVolume(ctx, legacyVolume, mountpoints)
+		if err != nil {
+			return nil, err
+		}
+		if problem == "" {
+			problem = migrationCheckPassed
+		}
+		report[legacyVolume.GetName()] = problem
+	}
+	return report, nil
+}
+
+// checkLegacyVolume returns why the legacy volume cannot be migrated safely, or an empty string
+func (r *MigrationReconciler) checkLegacyVolume(ctx context.Context,
+	legacyVolume *unstructured.Unstructured, mountpoints map[string]string) (string, error) {
+	driveName, _, _ := unstructured.NestedString(legacyVolume.Object, "status", "drive")
+	hostPath, _, _ := unstructured.NestedString(legacyVolume.Object, "status", "hostPath")
+	mountpoint, found := mountpoints[driveName]
+	switch {
+	case !found:
+		return fmt.Sprintf("drive %s not found", driveName), nil
+	case hostPath == "":
+		return "no mount path recorded", nil
+	case mountpoint == "" || !strings.HasPrefix(filepath.Clean(hostPath), filepath.Clean(mountpoint)+"/"):
+		return fmt.Sprintf("mount path %s is not on drive %s mounted at %s", hostPath, driveName, mountpoint), nil
+	}
+
+	pv := &corev1.PersistentVolume{}
+	err := r.Get(ctx, types.NamespacedName{Name: legacyVolume.GetName()}, pv)
+	if apierrors.IsNotFound(err) {
+		return "PersistentVolume not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != legacyCSIDriverName || pv.Spec.CSI.VolumeHandle != legacyVolume.GetName() {
+		return fmt.Sprintf("PersistentVolume %s is not provisioned by %s", pv.Name, legacyCSIDriverName), nil
+	}
+	if pv.Spec.ClaimRef == nil {
+		return fmt.Sprintf("PersistentVolume %s is not bound", pv.Name), nil
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	err = r.Get(ctx, types.NamespacedName{Name: pv.Spec.ClaimRef.Name, Namespace: pv.Spec.ClaimRef.Namespace}, pvc)
+	if apierrors.IsNotFound(err) {
+		return fmt.Sprintf("PersistentVolumeClaim %s/%s not found", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pvc.Spec.VolumeName != pv.Name {
+		return fmt.Sprintf("PersistentVolumeClaim %s/%s is bound to %s", pvc.Namespace, pvc.Name, pvc.Spec.VolumeName), nil
+	}
+	return "", nil
+}
+
+// migrationReportName returns the name of the ConfigMap holding the migration report of the Deployer
+func migrationReportName(deployer *cachev1alpha1.Deployer) string {
+	return deployer.Name + "-migration-report"
+}
+
+// writeMigrationReport stores the per-volume results of the safety checks in a ConfigMap
+// owned by the Deployer
+func (r *MigrationReconciler) writeMigrationReport(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, report map[string]string) error {
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: migrationReportName(deployer), Namespace: deployer.Namespace}, configMap)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      migrationReportName(deployer),
+				Namespace: deployer.Namespace,
+			},
+			Data: report,
+		}
+		applyCommonMetadata(deployer, configMap)
+		if err := ctrl.SetControllerReference(deployer, configMap, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, configMap)
+	}
+
+	configMap.Data = report
+	return r.Update(ctx, configMap)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// monitorGVKs are the Prometheus Operator monitor kinds the operator renders
+var monitorGVKs = map[cachev1alpha1.MonitorKind]schema.GroupVersionKind{
+	cachev1alpha1.MonitorKindServiceMonitor: {Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"},
+	cachev1alpha1.MonitorKindPodMonitor:     {Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"},
+}
+
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;podmonitors,verbs=get;list;watch;create;update;patch;delete
+
+// monitoringKindForDeployer returns the monitor kind of the Deployer, or an empty kind when
+// monitoring is disabled
+func monitoringKindForDeployer(deployer *cachev1alpha1.Deployer) cachev1alpha1.MonitorKind {
+	monitoring := deployer.Spec.Monitoring
+	switch {
+	case monitoring == nil || !monitoring.Enabled:
+		return ""
+	case monitoring.Kind == "":
+		return cachev1alpha1.MonitorKindServiceMonitor
+	default:
+		return monitoring.Kind
+	}
+}
+
+// reconcileMonitoring creates the ServiceMonitor or PodMonitor scraping the node-server
+// metrics and removes the monitor objects no longer configured. It does nothing when the
+// Prometheus Operator CRDs are not installed.
+func (r *DeployerReconciler) reconcileMonitoring(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, daemonSet *appsv1.DaemonSet) error {
+	desiredKind := monitoringKindForDeployer(deployer)
+	for kind, gvk := range monitorGVKs {
+		monitor := &unstructured.Unstructured{}
+		monitor.SetGroupVersionKind(gvk)
+		monitor.SetName(metricsServiceName(deployer))
+		monitor.SetNamespace(deployer.Namespace)
+
+		if kind != desiredKind {
+			if err := r.Delete(ctx, monitor); client.IgnoreNotFound(err) != nil && !meta.IsNoMatchError(err) {
+				return err
+			}
+			continue
+		}
+
+		if _, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); meta.IsNoMatchError(err) {
+			log.FromContext(ctx).Info("Skipping the metrics monitor, the Prometheus Operator CRDs are not installed",
+				"Kind", gvk.Kind)
+			continue
+		}
+		spec, err := r.monitorSpecForDeployer(deployer, daemonSet, kind)
+		if err != nil {
+			return err
+		}
+		if err := r.createOrUpdateOwned(ctx, deployer, monitor, func() error {
+			monitor.SetLabels(mergeLabels(monitor.GetLabels(), r.labelsForMemcached(deployer.Name)))
+			applyCommonMetadata(deployer, monitor)
+			return unstructured.SetNestedField(monitor.Object, spec, "spec")
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// monitorSpecForDeployer returns the spec of the monitor object of the given kind
+func (r *DeployerReconciler) monitorSpecForDeployer(deployer *cachev1alpha1.Deployer,
+	daemonSet *appsv1.DaemonSet, kind cachev1alpha1.MonitorKind) (map[string]interface{}, error) {
+	endpoint := map[string]interface{}{"port": "metrics"}
+	if deployer.Spec.Monitoring.Interval != "" {
+		endpoint["interval"] = deployer.Spec.Monitoring.Interval
+	}
+	if len(deployer.Spec.Monitoring.Relabelings) > 0 {
+		relabelings, err := toUnstructuredList(deployer.Spec.Monitoring.Relabelings)
+		if err != nil {
+			return nil, err
+		}
+		endpoint["relabelings"] = relabelings
+	}
+	if tlsEnabledForDeployer(deployer) {
+		endpoint["scheme"] = "https"
+		endpoint["tlsConfig"] = map[string]interface{}{
+			"ca": map[string]interface{}{"secret": map[string]interface{}{
+				"name": operandTLSSecretName(deployer),
+				"key":  "ca.crt",
+			}},
+			"serverName": operandTLSDNSNames(deployer)[1],
+		}
+	}
+	if metricsAuthorizationEnabled(deployer) {
+		if secret := deployer.Spec.Monitoring.BearerTokenSecret; secret != nil {
+			endpoint["bearerTokenSecret"] = map[string]interface{}{"name": secret.Name, "key": secret.Key}
+		} else if kind == cachev1alpha1.MonitorKindServiceMonitor {
+			endpoint["bearerTokenFile"] = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+		}
+	}
+
+	spec := map[string]interface{}{
+		"namespaceSelector": map[string]interface{}{
+			"matchNames": []interface{}{deployer.Namespace},
+		},
+	}
+	if kind == cachev1alpha1.MonitorKindPodMonitor {
+		spec["selector"] = map[string]interface{}{"matchLabels": stringMapToInterface(daemonSet.Spec.Selector.MatchLabels)}
+		spec["podMetricsEndpoints"] = []interface{}{endpoint}
+	} else {
+		spec["selector"] = map[string]interface{}{"matchLabels": map[string]interface{}{
+			"app.kubernetes.io/instance": deployer.Name,
+			"app.kubernetes.io/part-of":  "directpv-operator",
+		}}
+		spec["endpoints"] = []interface{}{endpoint}
+	}
+	return spec, nil
+}
+
+// toUnstructuredList converts typed values to their generic JSON representation
+func toUnstructuredList(values interface{}) ([]interface{}, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	err = json.Unmarshal(data, &list)
+	return list, err
+}
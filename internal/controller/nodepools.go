@@ -0,0 +1,164 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// machineDeploymentLabel is the Cluster API label of the machines, and of their nodes when
+// propagated, naming their MachineDeployment
+const machineDeploymentLabel = "cluster.x-k8s.io/deployment-name"
+
+// nodePoolRolloutPollInterval is how often the MachineDeployments are checked while a
+// node-server rollout waits for them, they are not watched
+const nodePoolRolloutPollInterval = 30 * time.Second
+
+// machineDeploymentGVK is the Cluster API MachineDeployment
+var machineDeploymentGVK = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "MachineDeployment"}
+
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments,verbs=get;list;watch
+
+// nodePoolNames returns the names of the MachineDeployments of the node pools of the Deployer
+func nodePoolNames(deployer *cachev1alpha1.Deployer) []string {
+	var names []string
+	for _, pool := range deployer.Spec.NodePools {
+		names = append(names, pool.MachineDeployment)
+	}
+	return names
+}
+
+// applyNodePools restricts the node-server pods to the nodes of the node pools of the Deployer
+func applyNodePools(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if len(deployer.Spec.NodePools) == 0 {
+		return
+	}
+	requireNodeSelectorRequirements(spec, corev1.NodeSelectorRequirement{
+		Key:      machineDeploymentLabel,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   nodePoolNames(deployer),
+	})
+}
+
+// nodeInNodePools reports whether the node belongs to one of the node pools of the Deployer,
+// any node does without node pools
+func nodeInNodePools(deployer *cachev1alpha1.Deployer, node *corev1.Node) bool {
+	if len(deployer.Spec.NodePools) == 0 {
+		return true
+	}
+	for _, name := range nodePoolNames(deployer) {
+		if node.Labels[machineDeploymentLabel] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// machineDeploymentRollingOut reports whether the MachineDeployment is replacing or adding
+// machines: its spec was not observed yet, old machines remain or new ones are not Ready
+func machineDeploymentRollingOut(machineDeployment *unstructured.Unstructured) bool {
+	observedGeneration, _, _ := unstructured.NestedInt64(machineDeployment.Object, "status", "observedGeneration")
+	desired, found, _ := unstructured.NestedInt64(machineDeployment.Object, "spec", "replicas")
+	if !found {
+		desired = 1
+	}
+	replicas, _, _ := unstructured.NestedInt64(machineDeployment.Object, "status", "replicas")
+	updated, _, _ := unstructured.NestedInt64(machineDeployment.Object, "status", "updatedReplicas")
+	ready, _, _ := unstructured.NestedInt64(machineDeployment.Object, "status", "readyReplicas")
+	return observedGeneration < machineDeployment.GetGeneration() ||
+		updated < desired || replicas > updated || ready < desired
+}
+
+// nodePoolsRollingOut returns the MachineDeployments of the node pools of the Deployer which
+// are rolling out. MachineDeployments which cannot be found, e.g. managed from another
+// cluster, are left out.
+func (r *DeployerReconciler) nodePoolsRollingOut(ctx context.Context, deployer *cachev1alpha1.Deployer) ([]string, error) {
+	if len(deployer.Spec.NodePools) == 0 {
+		return nil, nil
+	}
+	if _, err := r.RESTMapper().RESTMapping(machineDeploymentGVK.GroupKind(), machineDeploymentGVK.Version); meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	var rolling []string
+	for _, pool := range deployer.Spec.NodePools {
+		namespace := pool.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		machineDeployment := &unstructured.Unstructured{}
+		machineDeployment.SetGroupVersionKind(machineDeploymentGVK)
+		err := r.Get(ctx, types.NamespacedName{Name: pool.MachineDeployment, Namespace: namespace}, machineDeployment)
+		if apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Info("MachineDeployment not found, not waiting for its rollouts",
+				"MachineDeployment.Namespace", namespace, "MachineDeployment.Name", pool.MachineDeployment)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if machineDeploymentRollingOut(machineDeployment) {
+			rolling = append(rolling, pool.MachineDeployment)
+		}
+	}
+	return rolling, nil
+}
+
+// holdRolloutForNodePools reports whether the pod template changes of a node-server DaemonSet
+// wait for the MachineDeployments of the Deployer to finish rolling out, so that the new
+// machines are Ready before their pods count as unavailable. The held changes are queued in
+// the status, which is persisted at the end of the reconciliation.
+func (r *DeployerReconciler) holdRolloutForNodePools(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	live, desired client.Object) (bool, error) {
+	changed, err := podTemplateChanged(live, desired)
+	if err != nil || !changed {
+		return false, err
+	}
+	rolling, err := r.nodePoolsRollingOut(ctx, deployer)
+	if err != nil || len(rolling) == 0 {
+		return false, err
+	}
+	changes := []string{fmt.Sprintf("waiting for the MachineDeployments %s to finish rolling out", strings.Join(rolling, ", "))}
+	if !setPendingChanges(deployer, "DaemonSet", live.GetName(), changes) {
+		r.Recorder.Event(deployer, "Normal", "RolloutHeld",
+			fmt.Sprintf("The rollout of the DaemonSet %s waits for the MachineDeployments %s",
+				live.GetName(), strings.Join(rolling, ", ")))
+	}
+	return true, nil
+}
+
+// nodePoolRolloutDelay returns when to check the MachineDeployments again, 0 when no change
+// is queued
+func nodePoolRolloutDelay(deployer *cachev1alpha1.Deployer) time.Duration {
+	if len(deployer.Spec.NodePools) == 0 || deployer.Status.PendingChanges == nil {
+		return 0
+	}
+	return nodePoolRolloutPollInterval
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// nodeRemovalAnnotation marks a node still in the cluster for the removal of its DirectPV objects
+const nodeRemovalAnnotation = "directpv.min.io/remove"
+
+// nodeDeletedSinceAnnotation records on the DirectPV objects of a deleted node when the node was
+// first found deleted
+const nodeDeletedSinceAnnotation = "cache.example.com/node-deleted-since"
+
+const (
+	// defaultDeletedNodeGracePeriod is used when spec.nodeRemoval.gracePeriod is not set
+	defaultDeletedNodeGracePeriod = time.Hour
+	// deletedNodeRecheckInterval is how often the deleted nodes are checked again while no
+	// Deployer enables the removal of their DirectPV objects, the Deployers are not watched
+	deletedNodeRecheckInterval = 10 * time.Minute
+)
+
+// NodeRemovalReconciler removes the DirectPV drives, volumes and node objects of the nodes
+// deleted from the cluster or marked for removal
+type NodeRemovalReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=directpv.min.io,resources=directpvnodes,verbs=get;list;watch;update;patch;delete
+
+// Reconcile removes the DirectPV objects of a node once it was deleted for the grace period of
+// the Deployers enabling spec.nodeRemoval.removeDeletedNodes, or annotated with
+// directpv.min.io/remove=true and excluded with directpv.min.io/exclude=true so that no
+// node-server pod recreates them. The removal waits while volumes of the node are bound to
+// PVCs. DirectPV does not run on the node anymore, the finalizers of the objects are removed.
+func (r *NodeRemovalReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	node := &corev1.Node{}
+	err := r.Get(ctx, req.NamespacedName, node)
+	if apierrors.IsNotFound(err) {
+		node = nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+	volumes, drives, directPVNode, err := r.nodeObjects(ctx, req.Name)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	objects := append(append([]*unstructured.Unstructured{}, objectRefs(volumes)...), objectRefs(drives)...)
+	if directPVNode != nil {
+		objects = append(objects, directPVNode)
+	}
+
+	if node != nil {
+		// The node exists again, the grace period of a later deletion starts over
+		if err := r.clearNodeDeletedSince(ctx, objects); err != nil {
+			return ctrl.Result{}, err
+		}
+		if node.Annotations[nodeRemovalAnnotation] != "true" {
+			return ctrl.Result{}, nil
+		}
+		if node.Labels[nodeExclusionLabel] != "true" {
+			r.Recorder.Event(node, "Warning", "NodeRemovalBlocked", fmt.Sprintf(
+				"Label the node with %s=true to stop DirectPV before removing its drives and volumes", nodeExclusionLabel))
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if len(objects) == 0 {
+		return ctrl.Result{}, nil
+	}
+	if node == nil {
+		if result, err := r.waitForDeletedNode(ctx, req.Name, objects); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+
+	var claims []string
+	for i := range volumes {
+		pv := &corev1.PersistentVolume{}
+		err := r.Get(ctx, types.NamespacedName{Name: volumes[i].GetName()}, pv)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if pv.Spec.ClaimRef != nil {
+			claims = append(claims, pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name)
+		}
+	}
+	if len(claims) > 0 {
+		sort.Strings(claims)
+		log.Info("Removal of the DirectPV objects of the node blocked by bound volumes", "Node", req.Name, "PVCs", claims)
+		if node != nil {
+			r.Recorder.Event(node, "Warning", "VolumesInUse", fmt.Sprintf(
+				"DirectPV volumes of the node are bound to the PVCs %s, delete them to remove the node",
+				strings.Join(claims, ", ")))
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	for i := range volumes {
+		if err := deleteDirectPVObject(ctx, r.Client, &volumes[i], true); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to delete volume %s: %w", volumes[i].GetName(), err)
+		}
+	}
+	for i := range drives {
+		if err := deleteDirectPVObject(ctx, r.Client, &drives[i], true); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to delete drive %s: %w", drives[i].GetName(), err)
+		}
+	}
+	if directPVNode != nil {
+		if err := deleteDirectPVObject(ctx, r.Client, directPVNode, true); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to delete the DirectPVNode %s: %w", req.Name, err)
+		}
+	}
+	log.Info("Removed the DirectPV objects of the node", "Node", req.Name,
+		"Volumes", len(volumes), "Drives", len(drives))
+	if node != nil {
+		r.Recorder.Event(node, "Normal", "DirectPVRemoved", fmt.Sprintf(
+			"Removed %d DirectPV drives and %d volumes of the node", len(drives), len(volumes)))
+	}
+	return ctrl.Result{}, nil
+}
+
+// nodeObjects returns the DirectPV volumes, drives and node object of the node, the node object
+// is nil when missing
+func (r *NodeRemovalReconciler) nodeObjects(ctx context.Context, node string) (volumes,
+	drives []unstructured.Unstructured, directPVNode *unstructured.Unstructured, err error) {
+	if volumes, err = r.listNodeObjects(ctx, directPVVolumeGVK, node); err != nil {
+		return nil, nil, nil, err
+	}
+	if drives, err = r.listNodeObjects(ctx, directPVDriveGVK, node); err != nil {
+		return nil, nil, nil, err
+	}
+	directPVNode = &unstructured.Unstructured{}
+	directPVNode.SetGroupVersionKind(directPVNodeGVK)
+	err = r.Get(ctx, types.NamespacedName{Name: node}, directPVNode)
+	if apierrors.IsNotFound(err) {
+		return volumes, drives, nil, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return volumes, drives, directPVNode, nil
+}
+
+// objectRefs returns pointers to the items of the list
+func objectRefs(items []unstructured.Unstructured) []*unstructured.Unstructured {
+	refs := make([]*unstructured.Unstructured, 0, len(items))
+	for i := range items {
+		refs = append(refs, &items[i])
+	}
+	return refs
+}
+
+// deletedNodeGracePeriod returns how long the nodes stay deleted before their DirectPV objects are
+// removed, the longest grace period of the Deployers enabling it, and whether one does
+func (r *NodeRemovalReconciler) deletedNodeGracePeriod(ctx context.Context) (time.Duration, bool, error) {
+	deployers := &cachev1alpha1.DeployerList{}
+	if err := r.List(ctx, deployers); err != nil {
+		return 0, false, err
+	}
+	var gracePeriod time.Duration
+	enabled := false
+	for i := range deployers.Items {
+		removal := deployers.Items[i].Spec.NodeRemoval
+		if removal == nil || !removal.RemoveDeletedNodes || !deployers.Items[i].DeletionTimestamp.IsZero() {
+			continue
+		}
+		enabled = true
+		period := defaultDeletedNodeGracePeriod
+		if removal.GracePeriod != nil {
+			period = removal.GracePeriod.Duration
+		}
+		if period > gracePeriod {
+			gracePeriod = period
+		}
+	}
+	return gracePeriod, enabled, nil
+}
+
+// waitForDeletedNode holds the removal of the DirectPV objects of a deleted node until a Deployer
+// enables it and the node stayed deleted for the grace period. When the node was first found
+// deleted is recorded on the objects. A non-zero result is returned while waiting.
+func (r *NodeRemovalReconciler) waitForDeletedNode(ctx context.Context, node string,
+	objects []*unstructured.Unstructured) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	gracePeriod, enabled, err := r.deletedNodeGracePeriod(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !enabled {
+		log.Info("Keeping the DirectPV objects of the deleted node, no Deployer enables their removal", "Node", node)
+		return ctrl.Result{RequeueAfter: deletedNodeRecheckInterval}, nil
+	}
+
+	now := time.Now()
+	since := now
+	for _, obj := range objects {
+		deletedSince, err := time.Parse(time.RFC3339, obj.GetAnnotations()[nodeDeletedSinceAnnotation])
+		if err == nil && deletedSince.Before(since) {
+			since = deletedSince
+		}
+	}
+	for _, obj := range objects {
+		if _, found := obj.GetAnnotations()[nodeDeletedSinceAnnotation]; found {
+			continue
+		}
+		patch := client.MergeFrom(obj.DeepCopy())
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[nodeDeletedSinceAnnotation] = since.UTC().Format(time.RFC3339)
+		obj.SetAnnotations(annotations)
+		if err := r.Patch(ctx, obj, patch); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if remaining := time.Until(since.Add(gracePeriod)); remaining > 0 {
+		log.Info("Waiting for the grace period of the deleted node", "Node", node, "Remaining", remaining)
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// clearNodeDeletedSince removes the deletion time of the node from its DirectPV objects
+func (r *NodeRemovalReconciler) clearNodeDeletedSince(ctx context.Context, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		annotations := obj.GetAnnotations()
+		if _, found := annotations[nodeDeletedSinceAnnotation]; !found {
+			continue
+		}
+		patch := client.MergeFrom(obj.DeepCopy())
+		delete(annotations, nodeDeletedSinceAnnotation)
+		obj.SetAnnotations(annotations)
+		if err := r.Patch(ctx, obj, patch); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listNodeObjects lists the DirectPV objects of the given kind located on the node
+func (r *NodeRemovalReconciler) listNodeObjects(ctx context.Context, gvk schema.GroupVersionKind,
+	node string) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.List(ctx, list, client.MatchingLabels{"directpv.min.io/node": node}); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The DirectPVNodes are watched so
+// that the objects of nodes deleted while the operator was not running are removed too, they
+// are named after their node.
+func (r *NodeRemovalReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	directPVNode := &unstructured.Unstructured{}
+	directPVNode.SetGroupVersionKind(directPVNodeGVK)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("noderemoval").
+		For(&corev1.Node{}).
+		Watches(&source.Kind{Type: directPVNode}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// linuxNodeRequirement leaves out the Windows nodes, the node-server and controller pods only
+// run on Linux
+var linuxNodeRequirement = corev1.NodeSelectorRequirement{
+	Key:      corev1.LabelOSStable,
+	Operator: corev1.NodeSelectorOpIn,
+	Values:   []string{"linux"},
+}
+
+// nodeExclusionLabel takes a node out of DirectPV when set to true, without editing the
+// node selector of the Deployer
+const nodeExclusionLabel = "directpv.min.io/exclude"
+
+// nodeExclusionRequirements returns the node selector requirements leaving out the nodes
+// labelled with the exclusion label or the excluded node labels of the Deployer
+func nodeExclusionRequirements(deployer *cachev1alpha1.Deployer) []corev1.NodeSelectorRequirement {
+	requirements := []corev1.NodeSelectorRequirement{{
+		Key:      nodeExclusionLabel,
+		Operator: corev1.NodeSelectorOpNotIn,
+		Values:   []string{"true"},
+	}}
+	var keys []string
+	for key := range deployer.Spec.ExcludedNodeLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := deployer.Spec.ExcludedNodeLabels[key]
+		if value == "" {
+			requirements = append(requirements, corev1.NodeSelectorRequirement{
+				Key:      key,
+				Operator: corev1.NodeSelectorOpDoesNotExist,
+			})
+			continue
+		}
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpNotIn,
+			Values:   []string{value},
+		})
+	}
+	return requirements
+}
+
+// applyNodeExclusions restricts the node-server pods to the Linux nodes not excluded by the Deployer
+func applyNodeExclusions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	requireNodeSelectorRequirements(spec, append([]corev1.NodeSelectorRequirement{linuxNodeRequirement},
+		nodeExclusionRequirements(deployer)...)...)
+}
+
+// nodeSelectedForDeployer reports whether the node-server runs on the node: a Linux node of
+// one of the architectures and node pools of the Deployer, not excluded. The node selector of the
+// Deployer is matched when listing the nodes.
+func nodeSelectedForDeployer(deployer *cachev1alpha1.Deployer, node *corev1.Node) bool {
+	if node.Labels[corev1.LabelOSStable] != "linux" || !nodeArchitectureSupported(deployer, node) ||
+		!nodeInNodePools(deployer, node) || node.Labels[nodeExclusionLabel] == "true" {
+		return false
+	}
+	for key, value := range deployer.Spec.ExcludedNodeLabels {
+		if current, found := node.Labels[key]; found && (value == "" || current == value) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireNodeSelectorRequirements adds the requirements to every required node affinity term of
+// the pod spec, creating a term if there is none
+func requireNodeSelectorRequirements(spec *corev1.PodSpec, requirements ...corev1.NodeSelectorRequirement) {
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := spec.Affinity.NodeAffinity
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		term.MatchExpressions = append(term.MatchExpressions, requirements...)
+	}
+}
+
+// removeNodeSelectorRequirements removes the requirements on the given node label from the
+// required node affinity terms of the pod spec
+func removeNodeSelectorRequirements(spec *corev1.PodSpec, key string) {
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil ||
+		spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return
+	}
+	terms := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for i := range terms {
+		var requirements []corev1.NodeSelectorRequirement
+		for _, requirement := range terms[i].MatchExpressions {
+			if requirement.Key != key {
+				requirements = append(requirements, requirement)
+			}
+		}
+		terms[i].MatchExpressions = requirements
+	}
+}
+
+// deployerForNode returns the Deployer of the namespace running DirectPV on the node, of any
+// namespace when the namespace is empty, nil when there is none
+func deployerForNode(ctx context.Context, c client.Client, namespace,
+	nodeName string) (*cachev1alpha1.Deployer, error) {
+	node := &corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	deployers := &cachev1alpha1.DeployerList{}
+	if err := c.List(ctx, deployers, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deployers.Items {
+		deployer := &deployers.Items[i]
+		if labels.SelectorFromSet(deployer.Spec.NodeSelector).Matches(labels.Set(node.Labels)) &&
+			nodeSelectedForDeployer(deployer, node) {
+			return deployer, nil
+		}
+	}
+	return nil, nil
+}
+
+// deployersForNode maps a node to the Deployers running DirectPV on it. Both the old and the
+// new node of an update are mapped, so that the Deployers a relabelled node leaves are
+// reconciled too.
+func (r *DeployerReconciler) deployersForNode(obj client.Object) []reconcile.Request {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil
+	}
+	deployerList := &cachev1alpha1.DeployerList{}
+	if err := r.List(context.Background(), deployerList); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range deployerList.Items {
+		deployer := &deployerList.Items[i]
+		if labels.SelectorFromSet(deployer.Spec.NodeSelector).Matches(labels.Set(node.Labels)) &&
+			nodeSelectedForDeployer(deployer, node) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: deployer.Name, Namespace: deployer.Namespace}})
+		}
+	}
+	return requests
+}
+
+// nodeSchedulingChanged filters the node updates down to the ones changing where DirectPV
+// runs or can provision: labels, taints, cordoning and readiness. The periodic status
+// updates of the kubelet are left out.
+func nodeSchedulingChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return true
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return true
+			}
+			return !equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels) ||
+				!equality.Semantic.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) ||
+				oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable ||
+				nodeReady(oldNode) != nodeReady(newNode)
+		},
+	}
+}
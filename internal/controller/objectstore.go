@@ -0,0 +1,158 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// objectStoreTimeout bounds the requests to the object storage
+const objectStoreTimeout = time.Minute
+
+// objectStore uploads and downloads objects of a bucket of an S3-compatible object storage,
+// signing the requests with AWS Signature Version 4
+type objectStore struct {
+	spec      cachev1alpha1.ObjectStoreSpec
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// newObjectStore returns the object store of the spec with the credentials of its Secret, in
+// the given namespace
+func newObjectStore(ctx context.Context, c client.Client, namespace string,
+	spec cachev1alpha1.ObjectStoreSpec) (*objectStore, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: spec.CredentialsSecretRef.Name, Namespace: namespace}, secret); err != nil {
+		return nil, err
+	}
+	accessKey, secretKey := string(secret.Data["accessKey"]), string(secret.Data["secretKey"])
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("the Secret %s must hold the accessKey and secretKey keys", secret.Name)
+	}
+	if spec.Region == "" {
+		spec.Region = "us-east-1"
+	}
+	return &objectStore{spec: spec, accessKey: accessKey, secretKey: secretKey,
+		client: &http.Client{Timeout: objectStoreTimeout}}, nil
+}
+
+// objectName returns the name of the object in the bucket, with the prefix of the store
+func (s *objectStore) objectName(name string) string {
+	return strings.TrimPrefix(path.Join(s.spec.Prefix, name), "/")
+}
+
+// put uploads the object
+func (s *objectStore) put(ctx context.Context, name string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, name, data)
+	return err
+}
+
+// get downloads the object
+func (s *objectStore) get(ctx context.Context, name string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, name, nil)
+}
+
+// do sends a signed request for the object and returns the body of the response
+func (s *objectStore) do(ctx context.Context, method, name string, body []byte) ([]byte, error) {
+	endpoint, err := url.Parse(s.spec.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path = path.Join("/", endpoint.Path, s.spec.Bucket, s.objectName(name))
+	request, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(request, body, time.Now().UTC())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, s.objectName(name), response.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// sign adds the AWS Signature Version 4 headers of the request
+func (s *objectStore) sign(request *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		"host:" + request.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.spec.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.spec.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// sha256Hex returns the hex encoded SHA-256 of the data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+var (
+	nodesReadyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "directpv_operator_nodes_ready",
+		Help: "Number of nodes running a ready node-server pod",
+	}, []string{"namespace", "deployer"})
+	nodesDesiredGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "directpv_operator_nodes_desired",
+		Help: "Number of nodes which should run a node-server pod",
+	}, []string{"namespace", "deployer"})
+	operandInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "directpv_operator_operand_info",
+		Help: "Installed DirectPV version, always 1",
+	}, []string{"namespace", "deployer", "version"})
+	drivesTotalGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "directpv_operator_drives_total",
+		Help: "Number of DirectPV drives on the nodes of the Deployer by status",
+	}, []string{"namespace", "deployer", "status"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(nodesReadyGauge, nodesDesiredGauge, operandInfoGauge, drivesTotalGauge)
+}
+
+// recordOperandMetrics exports the health of the node-server pods, the installed version and
+// the drives of the Deployer, for clusters not scraping the node-server pods
+func (r *DeployerReconciler) recordOperandMetrics(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, daemonSet *appsv1.DaemonSet) error {
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return err
+	}
+	drives, err := r.listDirectPVObjects(ctx, directPVDriveGVK, nodes)
+	if err != nil {
+		return err
+	}
+
+	labels := prometheus.Labels{"namespace": deployer.Namespace, "deployer": deployer.Name}
+	nodesReadyGauge.With(labels).Set(float64(daemonSet.Status.NumberReady))
+	nodesDesiredGauge.With(labels).Set(float64(daemonSet.Status.DesiredNumberScheduled))
+
+	operandInfoGauge.DeletePartialMatch(labels)
+	if deployer.Status.InstalledVersion != "" {
+		operandInfoGauge.WithLabelValues(deployer.Namespace, deployer.Name, deployer.Status.InstalledVersion).Set(1)
+	}
+
+	drivesTotalGauge.DeletePartialMatch(labels)
+	for status, count := range countDrivesByStatus(drives) {
+		drivesTotalGauge.WithLabelValues(deployer.Namespace, deployer.Name, status).Set(float64(count))
+	}
+	return nil
+}
+
+// forgetOperandMetrics removes the metrics of a deleted Deployer
+func forgetOperandMetrics(deployer *cachev1alpha1.Deployer) {
+	labels := prometheus.Labels{"namespace": deployer.Namespace, "deployer": deployer.Name}
+	nodesReadyGauge.Delete(labels)
+	nodesDesiredGauge.Delete(labels)
+	operandInfoGauge.DeletePartialMatch(labels)
+	drivesTotalGauge.DeletePartialMatch(labels)
+}
+
+// countDrivesByStatus returns the number of drives per DirectPV drive status
+func countDrivesByStatus(drives []unstructured.Unstructured) map[string]int {
+	counts := map[string]int{}
+	for i := range drives {
+		status, _, _ := unstructured.NestedString(drives[i].Object, "status", "status")
+		if status == "" {
+			status = "Unknown"
+		}
+		counts[status]++
+	}
+	return counts
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// podSecurityModes are the Pod Security Admission modes labelled on the namespace
+var podSecurityModes = []string{"enforce", "audit", "warn"}
+
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;update;patch
+
+// reconcileNamespaceLabels sets the Pod Security Admission labels on the namespace of the
+// Deployer, and restores them when they are changed.
+func (r *DeployerReconciler) reconcileNamespaceLabels(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	if deployer.Spec.PodSecurity != nil && deployer.Spec.PodSecurity.Unmanaged {
+		return nil
+	}
+	level := "privileged"
+	if deployer.Spec.PodSecurity != nil && deployer.Spec.PodSecurity.Level != "" {
+		level = deployer.Spec.PodSecurity.Level
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: deployer.Namespace}, namespace); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(namespace.DeepCopy())
+	changed := false
+	if namespace.Labels == nil {
+		namespace.Labels = map[string]string{}
+	}
+	for _, mode := range podSecurityModes {
+		key := "pod-security.kubernetes.io/" + mode
+		if namespace.Labels[key] != level {
+			namespace.Labels[key] = level
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Setting the Pod Security Admission labels", "Namespace", namespace.Name, "Level", level)
+	return r.Patch(ctx, namespace, patch)
+}
+
+// deployersForNamespace maps a namespace to the Deployers it contains so that changes of its
+// labels are reverted
+func (r *DeployerReconciler) deployersForNamespace(obj client.Object) []reconcile.Request {
+	deployerList := &cachev1alpha1.DeployerList{}
+	if err := r.List(context.Background(), deployerList, client.InNamespace(obj.GetName())); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, deployer := range deployerList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: deployer.Name, Namespace: deployer.Namespace}})
+	}
+	return requests
+}
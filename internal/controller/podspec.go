@@ -0,0 +1,541 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// podTemplateHashAnnotation records the hash of the rendered pod template. The live pod
+// template is defaulted by the API server, comparing the hashes detects configuration changes.
+const podTemplateHashAnnotation = "cache.example.com/pod-template-hash"
+
+// setPodTemplateHash records the hash of the pod template in its annotations
+func setPodTemplateHash(template *corev1.PodTemplateSpec) error {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[podTemplateHashAnnotation] = fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+	return nil
+}
+
+// nonRootUser is the user the unprivileged operand containers run as with the Restricted profile
+const nonRootUser int64 = 65534
+
+// containerProfile describes what an operand container needs with the Restricted profile
+type containerProfile struct {
+	// privileged containers manage the drives and mounts of the node
+	privileged bool
+	// root containers use sockets or host directories owned by root
+	root bool
+}
+
+// restrictedContainerProfiles are the needs of the operand containers, the containers not
+// listed run as non-root without privileges
+var restrictedContainerProfiles = map[string]containerProfile{
+	"node-server":           {privileged: true, root: true},
+	"node-controller":       {privileged: true, root: true},
+	"node-driver-registrar": {root: true},
+	"liveness-probe":        {root: true},
+}
+
+// applyPodSpecOptions applies the pod level settings of the Deployer spec to a rendered operand pod spec
+func applyPodSpecOptions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	applySecurityProfile(deployer, spec)
+	applySELinuxOptions(deployer, spec)
+	applySeccompProfile(deployer, spec)
+	applyDNSOptions(deployer, spec)
+	applyProxy(deployer, spec)
+	applyTrustedCA(deployer, spec)
+	applyFIPS(deployer, spec)
+	applyLogLevels(deployer, spec)
+	if deployer.Spec.LogFormat == cachev1alpha1.LogFormatJSON {
+		for i := range spec.Containers {
+			// The TLS proxies only log text
+			if strings.HasPrefix(spec.Containers[i].Name, tlsProxyContainerPrefix) {
+				continue
+			}
+			spec.Containers[i].Args = append(spec.Containers[i].Args, "--logging-format=json")
+		}
+	}
+	applySidecarOptions(deployer, spec)
+	applyTopology(deployer, spec)
+}
+
+// applyTopology sets the topology of the Deployer on the node-server container and the
+// topology mode of the provisioner
+func applyTopology(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	topology := deployer.Spec.Topology
+	if topology == nil {
+		return
+	}
+	var args []string
+	for _, segment := range []struct{ flag, value string }{
+		{"--zone", topology.Zone},
+		{"--rack", topology.Rack},
+		{"--region", topology.Region},
+	} {
+		if segment.value != "" {
+			args = append(args, segment.flag+"="+segment.value)
+		}
+	}
+	appendArgs(spec, "node-server", args)
+
+	if topology.Strict == nil || *topology.Strict {
+		return
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		if container.Name != "csi-provisioner" {
+			continue
+		}
+		var args []string
+		for _, arg := range container.Args {
+			if arg != "--strict-topology" {
+				args = append(args, arg)
+			}
+		}
+		container.Args = args
+	}
+}
+
+// applyLogLevels replaces the verbosity argument of every container of the pod with the log
+// level of its component
+func applyLogLevels(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	logLevel := deployer.Spec.LogLevel
+	if logLevel == nil {
+		return
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		var override *int32
+		switch container.Name {
+		case "node-server", "node-controller":
+			override = logLevel.NodeServer
+		case "controller":
+			override = logLevel.Controller
+		default:
+			override = logLevel.Sidecars
+		}
+		level := logLevel.Default
+		if override != nil {
+			level = *override
+		}
+		for j, arg := range container.Args {
+			for _, prefix := range []string{"-v=", "--v="} {
+				if strings.HasPrefix(arg, prefix) {
+					container.Args[j] = fmt.Sprintf("%s%d", prefix, level)
+				}
+			}
+		}
+	}
+}
+
+// applySidecarOptions applies the sidecar settings of the Deployer to the sidecars of the pod
+func applySidecarOptions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	sidecars := deployer.Spec.Sidecars
+	if sidecars == nil {
+		return
+	}
+	for name, sidecar := range map[string]*cachev1alpha1.SidecarSpec{
+		"node-driver-registrar": sidecars.NodeDriverRegistrar,
+		"liveness-probe":        sidecars.LivenessProbe,
+		"csi-provisioner":       provisioningSidecarOptions(sidecars.Provisioner),
+		"csi-resizer":           provisioningSidecarOptions(sidecars.Resizer),
+		"csi-snapshotter":       sidecars.Snapshotter,
+		"csi-health-monitor":    sidecars.HealthMonitor,
+	} {
+		if sidecar != nil {
+			appendArgs(spec, name, sidecar.ExtraArgs)
+		}
+	}
+}
+
+// provisioningSidecarOptions returns the common settings of a provisioning sidecar, nil if
+// not set
+func provisioningSidecarOptions(sidecar *cachev1alpha1.ProvisioningSidecarSpec) *cachev1alpha1.SidecarSpec {
+	if sidecar == nil {
+		return nil
+	}
+	return &sidecar.SidecarSpec
+}
+
+// provisioningSidecarArgs returns the arguments tuning the throughput of a provisioning sidecar,
+// workersFlag is its flag of the number of workers
+func provisioningSidecarArgs(sidecar *cachev1alpha1.ProvisioningSidecarSpec, workersFlag string) []string {
+	var args []string
+	if sidecar == nil {
+		return args
+	}
+	for _, flag := range []struct {
+		name  string
+		value *int32
+	}{
+		{workersFlag, sidecar.WorkerThreads},
+		{"--kube-api-qps", sidecar.KubeAPIQPS},
+		{"--kube-api-burst", sidecar.KubeAPIBurst},
+	} {
+		if flag.value != nil {
+			args = append(args, fmt.Sprintf("%s=%d", flag.name, *flag.value))
+		}
+	}
+	return args
+}
+
+// sidecarTimeoutArg returns the timeout argument of a provisioning sidecar
+func sidecarTimeoutArg(sidecar *cachev1alpha1.ProvisioningSidecarSpec) string {
+	if sidecar == nil || sidecar.Timeout == nil {
+		return "--timeout=300s"
+	}
+	return "--timeout=" + sidecar.Timeout.Duration.String()
+}
+
+// applyProvisioningSidecars tunes the throughput of the csi-provisioner and csi-resizer sidecars
+func applyProvisioningSidecars(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	sidecars := deployer.Spec.Sidecars
+	if sidecars == nil {
+		return
+	}
+	appendArgs(spec, "csi-provisioner", provisioningSidecarArgs(sidecars.Provisioner, "--worker-threads"))
+	appendArgs(spec, "csi-resizer", provisioningSidecarArgs(sidecars.Resizer, "--workers"))
+}
+
+// appendArgs appends the arguments to the arguments of the named container
+func appendArgs(spec *corev1.PodSpec, containerName string, args []string) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == containerName {
+			spec.Containers[i].Args = append(spec.Containers[i].Args, args...)
+		}
+	}
+}
+
+// trustedCADir is where the trusted CA bundle is mounted in the operand containers
+const trustedCADir = "/etc/directpv/trusted-ca"
+
+// applyTrustedCA mounts the trusted CA bundle of the Deployer into every container of the pod
+func applyTrustedCA(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	trustedCA := deployer.Spec.TrustedCA
+	if trustedCA == nil || trustedCA.ConfigMapRef.Name == "" {
+		return
+	}
+	key := trustedCA.Key
+	if key == "" {
+		key = "ca-bundle.crt"
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "trusted-ca",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: trustedCA.ConfigMapRef,
+				Items:                []corev1.KeyToPath{{Key: key, Path: key}},
+			},
+		},
+	})
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "trusted-ca",
+			MountPath: trustedCADir,
+			ReadOnly:  true,
+		})
+	}
+	mergeEnv(spec, []corev1.EnvVar{{Name: "SSL_CERT_FILE", Value: trustedCADir + "/" + key}})
+}
+
+// applyProxy sets the proxy environment variables of the Deployer on every container of the
+// pod, in both cases as tools differ in which one they read
+func applyProxy(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	proxy := deployer.Spec.Proxy
+	if proxy == nil {
+		return
+	}
+	var env []corev1.EnvVar
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", proxy.HTTPProxy},
+		{"HTTPS_PROXY", proxy.HTTPSProxy},
+		{"NO_PROXY", proxy.NoProxy},
+	} {
+		if v.value == "" {
+			continue
+		}
+		env = append(env,
+			corev1.EnvVar{Name: v.name, Value: v.value},
+			corev1.EnvVar{Name: strings.ToLower(v.name), Value: v.value})
+	}
+	mergeEnv(spec, env)
+}
+
+// applyDNSOptions sets the DNS policy and configuration of the Deployer on the pod
+func applyDNSOptions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if deployer.Spec.DNSPolicy != "" {
+		spec.DNSPolicy = deployer.Spec.DNSPolicy
+	}
+	if deployer.Spec.DNSConfig != nil {
+		spec.DNSConfig = deployer.Spec.DNSConfig.DeepCopy()
+	}
+}
+
+// applySeccompProfile sets the seccomp profile of the Deployer on the pod
+func applySeccompProfile(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if deployer.Spec.PodSecurityContext == nil || deployer.Spec.PodSecurityContext.SeccompProfile == nil {
+		return
+	}
+	seccomp := deployer.Spec.PodSecurityContext.SeccompProfile
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	spec.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: seccomp.Type}
+	if seccomp.Type == corev1.SeccompProfileTypeLocalhost && seccomp.LocalhostProfile != nil {
+		spec.SecurityContext.SeccompProfile.LocalhostProfile = &[]string{*seccomp.LocalhostProfile}[0]
+	}
+}
+
+// defaultDataPath is the host directory of the DirectPV state
+const defaultDataPath = "/var/lib/directpv/"
+
+// dataPathForDeployer returns the host directory of the DirectPV state of the Deployer. It is
+// mounted as /var/lib/directpv in the node-server containers, the path DirectPV uses.
+func dataPathForDeployer(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Spec.NodeServer != nil && deployer.Spec.NodeServer.DataPath != "" {
+		return deployer.Spec.NodeServer.DataPath
+	}
+	return defaultDataPath
+}
+
+// nodeServerServiceAccountName returns the service account of the pods running on the nodes
+func nodeServerServiceAccountName(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Spec.NodeServer != nil && deployer.Spec.NodeServer.ServiceAccountName != "" {
+		return deployer.Spec.NodeServer.ServiceAccountName
+	}
+	return deployer.Name + "-node-server"
+}
+
+// controllerServiceAccountName returns the service account of the controller pods
+func controllerServiceAccountName(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Spec.Controller != nil && deployer.Spec.Controller.ServiceAccountName != "" {
+		return deployer.Spec.Controller.ServiceAccountName
+	}
+	return deployer.Name + "-controller"
+}
+
+// applyNodeServerOptions applies the node-server settings of the Deployer spec to the
+// rendered node-server pod spec
+func applyNodeServerOptions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if deployer.Spec.NodeServer == nil {
+		return
+	}
+	applyProbeOverrides(spec, "node-server", deployer.Spec.NodeServer.Probes)
+	appendArgs(spec, "node-server", deviceFilterArgs(deployer.Spec.NodeServer.DeviceFilters))
+	appendArgs(spec, "node-server", deployer.Spec.NodeServer.ExtraArgs)
+	mergeEnv(spec, deployer.Spec.NodeServer.Env)
+	appendExtraVolumes(spec, deployer.Spec.NodeServer.ExtraVolumes, deployer.Spec.NodeServer.ExtraVolumeMounts)
+	for i := range deployer.Spec.NodeServer.ExtraContainers {
+		spec.Containers = append(spec.Containers, *deployer.Spec.NodeServer.ExtraContainers[i].DeepCopy())
+	}
+	if deployer.Spec.NodeServer.TerminationGracePeriodSeconds != nil {
+		spec.TerminationGracePeriodSeconds = &[]int64{*deployer.Spec.NodeServer.TerminationGracePeriodSeconds}[0]
+	}
+	if !deployer.Spec.NodeServer.HostNetwork {
+		return
+	}
+	spec.HostNetwork = true
+	// Keep resolving cluster services from the network namespace of the node
+	if spec.DNSPolicy == "" {
+		spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+	// The ports are bound on the node, declare them so the scheduler detects conflicts
+	for i := range spec.Containers {
+		for j := range spec.Containers[i].Ports {
+			port := &spec.Containers[i].Ports[j]
+			port.HostPort = port.ContainerPort
+		}
+	}
+}
+
+// deviceFilterArgs returns the node-server arguments of the device filters, the patterns
+// starting with ! are exclude patterns
+func deviceFilterArgs(filters []string) []string {
+	var include, exclude []string
+	for _, filter := range filters {
+		if strings.HasPrefix(filter, "!") {
+			exclude = append(exclude, strings.TrimPrefix(filter, "!"))
+			continue
+		}
+		include = append(include, filter)
+	}
+	var args []string
+	if len(include) > 0 {
+		args = append(args, "--include-devices="+strings.Join(include, ","))
+	}
+	if len(exclude) > 0 {
+		args = append(args, "--exclude-devices="+strings.Join(exclude, ","))
+	}
+	return args
+}
+
+// applyControllerOptions applies the controller settings of the Deployer spec to the
+// rendered controller pod spec
+func applyControllerOptions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if deployer.Spec.Controller == nil {
+		return
+	}
+	applyProbeOverrides(spec, "controller", deployer.Spec.Controller.Probes)
+	appendArgs(spec, "controller", deployer.Spec.Controller.ExtraArgs)
+	mergeEnv(spec, deployer.Spec.Controller.Env)
+	appendExtraVolumes(spec, deployer.Spec.Controller.ExtraVolumes, deployer.Spec.Controller.ExtraVolumeMounts)
+}
+
+// appendExtraVolumes appends the volumes to the pod and the volume mounts to every container
+func appendExtraVolumes(spec *corev1.PodSpec, volumes []corev1.Volume, mounts []corev1.VolumeMount) {
+	for i := range volumes {
+		spec.Volumes = append(spec.Volumes, *volumes[i].DeepCopy())
+	}
+	for i := range spec.Containers {
+		for j := range mounts {
+			spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, *mounts[j].DeepCopy())
+		}
+	}
+}
+
+// mergeEnv merges the environment variables into every container of the pod, replacing
+// the variables of the same name
+func mergeEnv(spec *corev1.PodSpec, env []corev1.EnvVar) {
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		for _, envVar := range env {
+			replaced := false
+			for j := range container.Env {
+				if container.Env[j].Name == envVar.Name {
+					container.Env[j] = *envVar.DeepCopy()
+					replaced = true
+				}
+			}
+			if !replaced {
+				container.Env = append(container.Env, *envVar.DeepCopy())
+			}
+		}
+	}
+}
+
+// setEnvVar sets the environment variable of the container, replacing the variable of the
+// same name
+func setEnvVar(container *corev1.Container, envVar corev1.EnvVar) {
+	for i := range container.Env {
+		if container.Env[i].Name == envVar.Name {
+			container.Env[i] = envVar
+			return
+		}
+	}
+	container.Env = append(container.Env, envVar)
+}
+
+// applyProbeOverrides applies the probe overrides to the probes of the named container
+func applyProbeOverrides(spec *corev1.PodSpec, containerName string, probes *cachev1alpha1.ProbesSpec) {
+	if probes == nil {
+		return
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		if container.Name != containerName {
+			continue
+		}
+		applyProbeOverride(container.LivenessProbe, probes.Liveness)
+		applyProbeOverride(container.ReadinessProbe, probes.Readiness)
+	}
+}
+
+// applyProbeOverride sets the timings set in the override on the probe
+func applyProbeOverride(probe *corev1.Probe, override *cachev1alpha1.ProbeSpec) {
+	if probe == nil || override == nil {
+		return
+	}
+	if override.InitialDelaySeconds != nil {
+		probe.InitialDelaySeconds = *override.InitialDelaySeconds
+	}
+	if override.PeriodSeconds != nil {
+		probe.PeriodSeconds = *override.PeriodSeconds
+	}
+	if override.TimeoutSeconds != nil {
+		probe.TimeoutSeconds = *override.TimeoutSeconds
+	}
+	if override.SuccessThreshold != nil {
+		probe.SuccessThreshold = *override.SuccessThreshold
+	}
+	if override.FailureThreshold != nil {
+		probe.FailureThreshold = *override.FailureThreshold
+	}
+}
+
+// applySecurityProfile hardens the operand containers according to the security profile of the Deployer
+func applySecurityProfile(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if deployer.Spec.SecurityProfile != cachev1alpha1.SecurityProfileRestricted {
+		return
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		profile := restrictedContainerProfiles[container.Name]
+		securityContext := &corev1.SecurityContext{
+			ReadOnlyRootFilesystem: &[]bool{true}[0],
+		}
+		if profile.privileged {
+			securityContext.Privileged = &[]bool{true}[0]
+		} else {
+			securityContext.Privileged = &[]bool{false}[0]
+			securityContext.AllowPrivilegeEscalation = &[]bool{false}[0]
+			securityContext.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+		}
+		if !profile.root {
+			securityContext.RunAsNonRoot = &[]bool{true}[0]
+			securityContext.RunAsUser = &[]int64{nonRootUser}[0]
+		}
+		container.SecurityContext = securityContext
+	}
+}
+
+// applySELinuxOptions sets the SELinux options of the Deployer on the pod and its containers
+func applySELinuxOptions(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	selinux := deployer.Spec.SELinux
+	if selinux == nil {
+		return
+	}
+	if selinux.PodOptions != nil {
+		if spec.SecurityContext == nil {
+			spec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		spec.SecurityContext.SELinuxOptions = selinux.PodOptions.DeepCopy()
+	}
+	if selinux.ContainerOptions != nil {
+		for i := range spec.Containers {
+			container := &spec.Containers[i]
+			if container.SecurityContext == nil {
+				container.SecurityContext = &corev1.SecurityContext{}
+			}
+			container.SecurityContext.SELinuxOptions = selinux.ContainerOptions.DeepCopy()
+		}
+	}
+}
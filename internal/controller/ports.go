@@ -0,0 +1,218 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// nodeServerPorts are the resolved ports of the node-server pods
+type nodeServerPorts struct {
+	readiness int32
+	healthz   int32
+	metrics   int32
+	// tls binds the readiness and metrics endpoints of DirectPV on their upstream ports
+	// behind the TLS proxies
+	tls bool
+}
+
+// nodeServerPortsForDeployer returns the node-server ports of the Deployer, the DirectPV
+// defaults for the ones not set
+func nodeServerPortsForDeployer(deployer *cachev1alpha1.Deployer) nodeServerPorts {
+	ports := nodeServerPorts{readiness: 30443, healthz: 9898, metrics: 10443, tls: tlsEnabledForDeployer(deployer)}
+	if deployer.Spec.NodeServer == nil || deployer.Spec.NodeServer.Ports == nil {
+		return ports
+	}
+	if spec := deployer.Spec.NodeServer.Ports; spec.Readiness != 0 {
+		ports.readiness = spec.Readiness
+	}
+	if spec := deployer.Spec.NodeServer.Ports; spec.Healthz != 0 {
+		ports.healthz = spec.Healthz
+	}
+	if spec := deployer.Spec.NodeServer.Ports; spec.Metrics != 0 {
+		ports.metrics = spec.Metrics
+	}
+	return ports
+}
+
+// byNumber returns the port names keyed by their number
+func (p nodeServerPorts) byNumber() map[int32]string {
+	ports := map[int32]string{p.readiness: "readiness", p.healthz: "healthz", p.metrics: "metrics"}
+	if p.tls {
+		ports[tlsUpstreamPort(p.readiness)] = "readiness upstream"
+		ports[tlsUpstreamPort(p.metrics)] = "metrics upstream"
+	}
+	return ports
+}
+
+// count returns the number of ports bound by the node-server pods
+func (p nodeServerPorts) count() int {
+	if p.tls {
+		return 5
+	}
+	return 3
+}
+
+// livenessProbeHealthPort returns the --health-port set by the extra arguments of the
+// liveness-probe sidecar, the last one wins, and whether one is set
+func livenessProbeHealthPort(deployer *cachev1alpha1.Deployer) (string, bool) {
+	if deployer.Spec.Sidecars == nil || deployer.Spec.Sidecars.LivenessProbe == nil {
+		return "", false
+	}
+	var port string
+	var found bool
+	for _, arg := range deployer.Spec.Sidecars.LivenessProbe.ExtraArgs {
+		if name, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "="); ok && name == "health-port" {
+			port, found = value, true
+		}
+	}
+	return port, found
+}
+
+// hostNetworkForDeployer reports whether the node-server pods of the Deployer use the host network
+func hostNetworkForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.NodeServer != nil && deployer.Spec.NodeServer.HostNetwork
+}
+
+// checkPortConflicts reports the node-server ports of the Deployer conflicting with each
+// other, the health port of the liveness-probe sidecar differing from the healthz port, the
+// metrics authorization with hostNetwork, DirectPV having no listen address flag to keep the
+// upstream port off the node addresses, or, with hostNetwork, with the ports of other host network node pools sharing nodes
+// with it. Between two conflicting node pools the older one keeps its ports so that only
+// the newer one is blocked. An empty message means there is no conflict.
+func (r *DeployerReconciler) checkPortConflicts(ctx context.Context, deployer *cachev1alpha1.Deployer) (string, error) {
+	ports := nodeServerPortsForDeployer(deployer)
+	if len(ports.byNumber()) < ports.count() {
+		message := fmt.Sprintf("The node-server ports must differ, got readiness %d, healthz %d and metrics %d",
+			ports.readiness, ports.healthz, ports.metrics)
+		if ports.tls {
+			message += fmt.Sprintf(", with TLS the upstream ports %d and %d are bound too",
+				tlsUpstreamPort(ports.readiness), tlsUpstreamPort(ports.metrics))
+		}
+		return message, nil
+	}
+	if port, found := livenessProbeHealthPort(deployer); found && port != strconv.Itoa(int(ports.healthz)) {
+		return fmt.Sprintf("The liveness-probe sidecar serves on --health-port=%s from its extra arguments "+
+			"while the node-server liveness probe checks the healthz port %d, remove the argument and "+
+			"set spec.nodeServer.ports.healthz instead", port, ports.healthz), nil
+	}
+	if !hostNetworkForDeployer(deployer) {
+		return "", nil
+	}
+	if metricsAuthorizationEnabled(deployer) {
+		return fmt.Sprintf("With hostNetwork DirectPV serves the metrics upstream port %d on all the addresses "+
+			"of the node, bypassing the authorization, unset spec.tls.authorizeMetrics or spec.nodeServer.hostNetwork",
+			tlsUpstreamPort(ports.metrics)), nil
+	}
+
+	deployerList := &cachev1alpha1.DeployerList{}
+	if err := r.List(ctx, deployerList); err != nil {
+		return "", err
+	}
+	var nodes map[string]bool
+	var conflicts []string
+	for i := range deployerList.Items {
+		other := &deployerList.Items[i]
+		if other.UID == deployer.UID || !hostNetworkForDeployer(other) ||
+			!other.DeletionTimestamp.IsZero() || !olderDeployer(other, deployer) {
+			continue
+		}
+		var shared []string
+		otherPorts := nodeServerPortsForDeployer(other).byNumber()
+		for number, name := range ports.byNumber() {
+			if _, found := otherPorts[number]; found {
+				shared = append(shared, fmt.Sprintf("%s %d", name, number))
+			}
+		}
+		if len(shared) == 0 {
+			continue
+		}
+
+		if nodes == nil {
+			var err error
+			if nodes, err = r.nodeNamesForDeployer(ctx, deployer); err != nil {
+				return "", err
+			}
+		}
+		otherNodes, err := r.nodeNamesForDeployer(ctx, other)
+		if err != nil {
+			return "", err
+		}
+		if !sharesNode(nodes, otherNodes) {
+			continue
+		}
+		sort.Strings(shared)
+		conflicts = append(conflicts, fmt.Sprintf("%s/%s (%s)", other.Namespace, other.Name, strings.Join(shared, ", ")))
+	}
+	if len(conflicts) == 0 {
+		return "", nil
+	}
+	sort.Strings(conflicts)
+	return fmt.Sprintf("The node-server ports conflict on shared nodes with the host network node pools %s, "+
+		"set different spec.nodeServer.ports", strings.Join(conflicts, "; ")), nil
+}
+
+// reconcilePortConflicts blocks the node-server rollout while its ports conflict, reporting
+// the conflict through the PortConflict condition. A non-zero result is returned while blocked.
+func (r *DeployerReconciler) reconcilePortConflicts(ctx context.Context, deployer *cachev1alpha1.Deployer) (ctrl.Result, error) {
+	message, err := r.checkPortConflicts(ctx, deployer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if message == "" {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typePortConflictDeployer)
+		return ctrl.Result{}, nil
+	}
+
+	log.FromContext(ctx).Info("Node-server ports conflict", "Message", message)
+	r.Recorder.Event(deployer, "Warning", "PortConflict", message)
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typePortConflictDeployer,
+		Status: metav1.ConditionTrue, Reason: "PortConflict", Message: message})
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: preflightRetryInterval}, nil
+}
+
+// olderDeployer reports whether a was created before b, using the names to break ties
+func olderDeployer(a, b *cachev1alpha1.Deployer) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+}
+
+// sharesNode reports whether both sets of node names have a node in common
+func sharesNode(a, b map[string]bool) bool {
+	for name := range a {
+		if b[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// preflightRetryInterval is how often failed or running preflight checks are evaluated again
+const preflightRetryInterval = 30 * time.Second
+
+// preflightFailure describes a failed preflight check. The reason is used for the condition
+// and the message tells the user how to fix it.
+type preflightFailure struct {
+	reason  string
+	message string
+}
+
+// requiredAPIs are the API versions DirectPV depends on
+var requiredAPIs = []schema.GroupVersionKind{
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "storage.k8s.io", Version: "v1", Kind: "CSIDriver"},
+	{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVDrive"},
+	{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVVolume"},
+	{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVNode"},
+	{Group: "directpv.min.io", Version: "v1beta1", Kind: "DirectPVInitRequest"},
+}
+
+// runPreflightChecks verifies the cluster can run DirectPV before any operand is created.
+// Failures are reported through the PreflightPassed condition and block the installation,
+// a non-zero result is returned until all the checks pass.
+func (r *DeployerReconciler) runPreflightChecks(ctx context.Context, deployer *cachev1alpha1.Deployer) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	failure, err := r.checkRequiredAPIs()
+	if err == nil && failure == nil {
+		var image string
+		if image, err = r.imageForDeployer(deployer); err == nil {
+			failure, err = r.checkKubernetesVersion(image)
+		}
+	}
+	if err == nil && failure == nil {
+		var adopted bool
+		if adopted, failure, err = r.checkExistingInstallation(ctx, deployer); adopted {
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+	pending := false
+	if err == nil && failure == nil {
+		pending, failure, err = r.checkNodes(ctx, deployer)
+	}
+	if err != nil {
+		log.Error(err, "Failed to run the preflight checks")
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case failure != nil:
+		log.Info("Preflight check failed", "Reason", failure.reason, "Message", failure.message)
+		if !meta.IsStatusConditionPresentAndEqual(deployer.Status.Conditions, typePreflightDeployer, metav1.ConditionFalse) {
+			r.Recorder.Event(deployer, "Warning", failure.reason, failure.message)
+		}
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typePreflightDeployer,
+			Status: metav1.ConditionFalse, Reason: failure.reason, Message: failure.message})
+	case pending:
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typePreflightDeployer,
+			Status: metav1.ConditionUnknown, Reason: "Checking",
+			Message: "Waiting for the preflight checks to complete on the nodes"})
+	default:
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typePreflightDeployer,
+			Status: metav1.ConditionTrue, Reason: "Passed", Message: "All the preflight checks passed"})
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		log.Error(err, "Failed to update Deployer status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: preflightRetryInterval}, nil
+}
+
+// checkRequiredAPIs verifies the API versions DirectPV depends on are served by the cluster
+func (r *DeployerReconciler) checkRequiredAPIs() (*preflightFailure, error) {
+	var missing []string
+	for _, gvk := range requiredAPIs {
+		if _, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			if !meta.IsNoMatchError(err) {
+				return nil, err
+			}
+			missing = append(missing, gvk.GroupVersion().String()+"/"+gvk.Kind)
+		}
+	}
+	if len(missing) > 0 {
+		return &preflightFailure{reason: "MissingAPI",
+			message: fmt.Sprintf("The cluster does not serve %s, upgrade Kubernetes or install the DirectPV CRDs",
+				strings.Join(missing, ", "))}, nil
+	}
+	return nil, nil
+}
+
+// checkNodes runs a short-lived pod on every node of the Deployer checking the host paths
+// DirectPV depends on. It reports pending until all the pods completed, and removes the pods
+// once they did so the checks are run again on the next attempt.
+func (r *DeployerReconciler) checkNodes(ctx context.Context, deployer *cachev1alpha1.Deployer) (bool, *preflightFailure, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList, client.MatchingLabels(deployer.Spec.NodeSelector)); err != nil {
+		return false, nil, err
+	}
+	if len(nodeList.Items) == 0 {
+		return false, &preflightFailure{reason: "NoNodes",
+			message: "No node matches spec.nodeSelector, label the nodes DirectPV should run on"}, nil
+	}
+
+	image, err := r.imageForDeployer(deployer)
+	if err != nil {
+		return false, nil, err
+	}
+
+	pending := false
+	var pods []*corev1.Pod
+	var failures, udevMissing []string
+	for _, node := range nodeList.Items {
+		// Nodes of other architectures or operating systems and excluded nodes do not run the node-server
+		if !nodeSelectedForDeployer(deployer, &node) {
+			continue
+		}
+		pod := &corev1.Pod{}
+		err := r.Get(ctx, types.NamespacedName{Name: preflightPodName(deployer, node.Name), Namespace: deployer.Namespace}, pod)
+		if apierrors.IsNotFound(err) {
+			nodeImage, err := imageForNode(ctx, r.Client, deployer, node.Name, image)
+			if err != nil {
+				return false, nil, err
+			}
+			pod = r.preflightPodForNode(deployer, node.Name, nodeImage)
+			if err := ctrl.SetControllerReference(deployer, pod, r.Scheme); err != nil {
+				return false, nil, err
+			}
+			if err := r.Create(ctx, pod); err != nil {
+				return false, nil, err
+			}
+			pending = true
+			continue
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		pods = append(pods, pod)
+
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			if terminationMessage(pod) == udevDataMissingMessage {
+				udevMissing = append(udevMissing, node.Name)
+			}
+		case corev1.PodFailed:
+			message := terminationMessage(pod)
+			if message == "" {
+				message = "preflight pod failed"
+			}
+			failures = append(failures, fmt.Sprintf("%s: %s", node.Name, message))
+		default:
+			pending = true
+		}
+	}
+	if pending {
+		return true, nil, nil
+	}
+
+	for _, pod := range pods {
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return false, nil, err
+		}
+	}
+	if udevOptionalForDeployer(deployer) {
+		setUdevDataCondition(deployer, udevMissing)
+	}
+	if len(failures) > 0 {
+		return false, &preflightFailure{reason: "NodeCheckFailed",
+			message: fmt.Sprintf("Nodes are not ready for DirectPV (%s), "+
+				"make sure udev is running and the kubelet uses %s", strings.Join(failures, "; "), kubeletDirForDeployer(deployer))}, nil
+	}
+	return false, nil, nil
+}
+
+// terminationMessage returns the termination message of the containers of the pod
+func terminationMessage(pod *corev1.Pod) string {
+	var message string
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+			message = strings.TrimSpace(status.State.Terminated.Message)
+		}
+	}
+	return message
+}
+
+// preflightPodName returns the name of the preflight pod of the Deployer on the given node
+func preflightPodName(deployer *cachev1alpha1.Deployer, nodeName string) string {
+	return fmt.Sprintf("%s-preflight-%s", deployer.Name, nodeName)
+}
+
+// preflightPodForNode returns a pod checking the host paths used by the node-server on the given node.
+// Only the always present parent directories are mounted so missing paths are detected by the
+// check instead of being created by the kubelet. When udev is optional a missing udev data
+// directory is reported through the termination message of the succeeding pod.
+func (r *DeployerReconciler) preflightPodForNode(deployer *cachev1alpha1.Deployer, nodeName, image string) *corev1.Pod {
+	hostPathType := corev1.HostPathDirectory
+	udevParentType := corev1.HostPathDirectoryOrCreate
+	kubeletDir := kubeletDirForDeployer(deployer)
+	udevDataPath := udevDataPathForDeployer(deployer)
+	udevCheck := fmt.Sprintf(`fail "%s does not exist, udev is not running"`, udevDataPath)
+	if udevOptionalForDeployer(deployer) {
+		udevCheck = fmt.Sprintf(`{ echo "%s" > /dev/termination-log; }`, udevDataMissingMessage)
+	}
+	script := fmt.Sprintf(`fail() { echo "$1" > /dev/termination-log; exit 1; }
+test -d /host/kubelet/%s || fail "%s does not exist"
+test -d /host/udev/%s || %s`, path.Base(kubeletDir), kubeletDir, path.Base(udevDataPath), udevCheck)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      preflightPodName(deployer, nodeName),
+			Namespace: deployer.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "directpv-preflight",
+				"app.kubernetes.io/instance":   deployer.Name,
+				"app.kubernetes.io/part-of":    "directpv-operator",
+				"app.kubernetes.io/created-by": "controller-manager",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           nodeName,
+			RestartPolicy:      corev1.RestartPolicyNever,
+			ServiceAccountName: nodeServerServiceAccountName(deployer),
+			Tolerations:        []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Volumes: []corev1.Volume{
+				{
+					Name: "kubelet",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{Path: path.Dir(kubeletDir), Type: &hostPathType},
+					},
+				},
+				{
+					// The parent of the udev data directory may not exist without udev
+					Name: "udev",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{Path: path.Dir(udevDataPath), Type: &udevParentType},
+					},
+				},
+			},
+			Containers: []corev1.Container{{
+				Name:            "preflight",
+				Image:           image,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Command:         []string{"/bin/sh", "-c", script},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "kubelet", MountPath: "/host/kubelet", ReadOnly: true},
+					{Name: "udev", MountPath: "/host/udev", ReadOnly: true},
+				},
+			}},
+		},
+	}
+	applyCommonMetadata(deployer, pod)
+	return pod
+}
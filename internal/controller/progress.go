@@ -0,0 +1,163 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// defaultProgressDeadline is used when spec.progressDeadline is not set, as for Deployments
+const defaultProgressDeadline = 10 * time.Minute
+
+// progressDeadlineForDeployer returns how long the rollout of a generation of the Deployer may take
+func progressDeadlineForDeployer(deployer *cachev1alpha1.Deployer) time.Duration {
+	if deployer.Spec.ProgressDeadline != nil {
+		return deployer.Spec.ProgressDeadline.Duration
+	}
+	return defaultProgressDeadline
+}
+
+// daemonSetRolloutPending describes what the rollout of the DaemonSet waits for, empty once
+// all its pods run its last generation and are available
+func daemonSetRolloutPending(daemonSet *appsv1.DaemonSet) string {
+	status := daemonSet.Status
+	switch {
+	case status.ObservedGeneration < daemonSet.Generation:
+		return fmt.Sprintf("DaemonSet %s: generation %d not observed yet", daemonSet.Name, daemonSet.Generation)
+	case status.UpdatedNumberScheduled < status.DesiredNumberScheduled || status.NumberAvailable < status.DesiredNumberScheduled:
+		return fmt.Sprintf("DaemonSet %s: %d of %d pods updated and %d available", daemonSet.Name,
+			status.UpdatedNumberScheduled, status.DesiredNumberScheduled, status.NumberAvailable)
+	}
+	return ""
+}
+
+// deploymentRolloutPending describes what the rollout of the Deployment waits for, empty once
+// all its replicas run its last generation and are available. The reason the Deployment gives
+// for failing to create its pods is included.
+func deploymentRolloutPending(deployment *appsv1.Deployment) string {
+	status := deployment.Status
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	var pending string
+	switch {
+	case status.ObservedGeneration < deployment.Generation:
+		pending = fmt.Sprintf("Deployment %s: generation %d not observed yet", deployment.Name, deployment.Generation)
+	case status.UpdatedReplicas < replicas || status.AvailableReplicas < replicas || status.Replicas > status.UpdatedReplicas:
+		pending = fmt.Sprintf("Deployment %s: %d of %d replicas updated and %d available", deployment.Name,
+			status.UpdatedReplicas, replicas, status.AvailableReplicas)
+	default:
+		return ""
+	}
+	for _, condition := range status.Conditions {
+		if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
+			pending += " (" + condition.Message + ")"
+		}
+	}
+	return pending
+}
+
+// updateProgressing tracks the rollout of the generation of the Deployer to the operand
+// workloads through status.rollout and the Progressing condition. A rollout not complete
+// within the progress deadline turns the condition False and the Deployer Degraded with the
+// ProgressDeadlineExceeded reason, as for Deployments. A canary rollout pauses the deadline,
+// it counts again from the promotion of the canary.
+func (r *DeployerReconciler) updateProgressing(deployer *cachev1alpha1.Deployer,
+	daemonSet *appsv1.DaemonSet, deployment *appsv1.Deployment) {
+	now := metav1.Now()
+	rollout := deployer.Status.Rollout
+	if rollout == nil || rollout.Generation != deployer.Generation {
+		rollout = &cachev1alpha1.RolloutStatus{Generation: deployer.Generation, StartTime: now}
+		deployer.Status.Rollout = rollout
+	}
+
+	var pending []string
+	for _, workload := range []string{daemonSetRolloutPending(daemonSet), deploymentRolloutPending(deployment)} {
+		if workload != "" {
+			pending = append(pending, workload)
+		}
+	}
+	if len(pending) == 0 {
+		if rollout.CompletionTime == nil {
+			rollout.CompletionTime = &now
+		}
+		r.clearProgressDeadlineExceeded(deployer)
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeProgressingDeployer,
+			Status: metav1.ConditionTrue, Reason: "RolloutComplete",
+			Message: fmt.Sprintf("Generation %d is rolled out", rollout.Generation)})
+		return
+	}
+	rollout.CompletionTime = nil
+
+	if deployer.Status.Upgrade != nil {
+		rollout.StartTime = now
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeProgressingDeployer,
+			Status: metav1.ConditionTrue, Reason: "CanaryRollout",
+			Message: fmt.Sprintf("Generation %d waits for the canary rollout: %s", rollout.Generation, strings.Join(pending, "; "))})
+		return
+	}
+
+	deadline := rollout.StartTime.Add(progressDeadlineForDeployer(deployer))
+	if now.Time.Before(deadline) {
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeProgressingDeployer,
+			Status: metav1.ConditionTrue, Reason: "RollingOut",
+			Message: fmt.Sprintf("Rolling out generation %d: %s", rollout.Generation, strings.Join(pending, "; "))})
+		return
+	}
+
+	message := fmt.Sprintf("Generation %d is not rolled out after %s: %s", rollout.Generation,
+		progressDeadlineForDeployer(deployer), strings.Join(pending, "; "))
+	if cond := meta.FindStatusCondition(deployer.Status.Conditions, typeProgressingDeployer); cond == nil ||
+		cond.Reason != "ProgressDeadlineExceeded" {
+		r.Recorder.Event(deployer, "Warning", "ProgressDeadlineExceeded", message)
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeProgressingDeployer,
+		Status: metav1.ConditionFalse, Reason: "ProgressDeadlineExceeded", Message: message})
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDegradedDeployer,
+		Status: metav1.ConditionTrue, Reason: "ProgressDeadlineExceeded", Message: message})
+}
+
+// clearProgressDeadlineExceeded removes the Degraded condition set by a stalled rollout
+func (r *DeployerReconciler) clearProgressDeadlineExceeded(deployer *cachev1alpha1.Deployer) {
+	if cond := meta.FindStatusCondition(deployer.Status.Conditions, typeDegradedDeployer); cond != nil &&
+		cond.Reason == "ProgressDeadlineExceeded" {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeDegradedDeployer)
+	}
+}
+
+// progressDeadlineDelay returns when the rollout in progress reaches its deadline, 0 when no
+// rollout is in progress or the deadline is already exceeded
+func progressDeadlineDelay(deployer *cachev1alpha1.Deployer, now time.Time) time.Duration {
+	rollout := deployer.Status.Rollout
+	if rollout == nil || rollout.CompletionTime != nil {
+		return 0
+	}
+	if remaining := rollout.StartTime.Add(progressDeadlineForDeployer(deployer)).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// prunableKind describes a kind of operand object the operator renders and prunes
+type prunableKind struct {
+	kind          string
+	clusterScoped bool
+	newObject     func() client.Object
+	newList       func() client.ObjectList
+}
+
+// prunableKinds are the kinds of operand objects pruned when no longer rendered
+var prunableKinds = []prunableKind{
+	{
+		kind:      "DaemonSet",
+		newObject: func() client.Object { return &appsv1.DaemonSet{} },
+		newList:   func() client.ObjectList { return &appsv1.DaemonSetList{} },
+	},
+	{
+		kind:      "Deployment",
+		newObject: func() client.Object { return &appsv1.Deployment{} },
+		newList:   func() client.ObjectList { return &appsv1.DeploymentList{} },
+	},
+	{
+		kind:      "Service",
+		newObject: func() client.Object { return &corev1.Service{} },
+		newList:   func() client.ObjectList { return &corev1.ServiceList{} },
+	},
+	{
+		kind:      "Secret",
+		newObject: func() client.Object { return &corev1.Secret{} },
+		newList:   func() client.ObjectList { return &corev1.SecretList{} },
+	},
+	{
+		kind:      "ServiceAccount",
+		newObject: func() client.Object { return &corev1.ServiceAccount{} },
+		newList:   func() client.ObjectList { return &corev1.ServiceAccountList{} },
+	},
+	{
+		kind:      "Role",
+		newObject: func() client.Object { return &rbacv1.Role{} },
+		newList:   func() client.ObjectList { return &rbacv1.RoleList{} },
+	},
+	{
+		kind:      "RoleBinding",
+		newObject: func() client.Object { return &rbacv1.RoleBinding{} },
+		newList:   func() client.ObjectList { return &rbacv1.RoleBindingList{} },
+	},
+	{
+		kind:          "ClusterRole",
+		clusterScoped: true,
+		newObject:     func() client.Object { return &rbacv1.ClusterRole{} },
+		newList:       func() client.ObjectList { return &rbacv1.ClusterRoleList{} },
+	},
+	{
+		kind:          "ClusterRoleBinding",
+		clusterScoped: true,
+		newObject:     func() client.Object { return &rbacv1.ClusterRoleBinding{} },
+		newList:       func() client.ObjectList { return &rbacv1.ClusterRoleBindingList{} },
+	},
+	{
+		kind:          "StorageClass",
+		clusterScoped: true,
+		newObject:     func() client.Object { return &storagev1.StorageClass{} },
+		newList:       func() client.ObjectList { return &storagev1.StorageClassList{} },
+	},
+}
+
+// desiredInventoryForDeployer returns the objects currently rendered for the Deployer
+func desiredInventoryForDeployer(deployer *cachev1alpha1.Deployer) []cachev1alpha1.InventoryEntry {
+	inventory := []cachev1alpha1.InventoryEntry{
+		{Kind: "DaemonSet", Namespace: deployer.Namespace, Name: daemonSetNameForDeployer(deployer)},
+		{Kind: "Deployment", Namespace: deployer.Namespace, Name: deploymentNameForDeployer(deployer)},
+	}
+	for _, arch := range archImageOverrides(deployer) {
+		inventory = append(inventory, cachev1alpha1.InventoryEntry{Kind: "DaemonSet", Namespace: deployer.Namespace, Name: archDaemonSetName(deployer, arch.Name)})
+	}
+	inventory = append(inventory, cachev1alpha1.InventoryEntry{Kind: "Service", Namespace: deployer.Namespace, Name: metricsServiceName(deployer)})
+	// With cert-manager the Secret is written by cert-manager, it stays listed so that a
+	// Secret generated before switching to cert-manager is reused rather than pruned
+	if tlsEnabledForDeployer(deployer) {
+		inventory = append(inventory, cachev1alpha1.InventoryEntry{Kind: "Secret", Namespace: deployer.Namespace, Name: operandTLSSecretName(deployer)})
+	}
+	for _, name := range generatedServiceAccounts(deployer) {
+		inventory = append(inventory, cachev1alpha1.InventoryEntry{Kind: "ServiceAccount", Namespace: deployer.Namespace, Name: name})
+	}
+	inventory = append(inventory,
+		cachev1alpha1.InventoryEntry{Kind: "Role", Namespace: deployer.Namespace, Name: deployer.Name + "-controller"},
+		cachev1alpha1.InventoryEntry{Kind: "RoleBinding", Namespace: deployer.Namespace, Name: deployer.Name + "-controller"})
+	components := []string{"node-server", "controller"}
+	if snapshotControllerInstalledForDeployer(deployer) {
+		name := snapshotControllerName(deployer)
+		inventory = append(inventory,
+			cachev1alpha1.InventoryEntry{Kind: "Deployment", Namespace: deployer.Namespace, Name: name},
+			cachev1alpha1.InventoryEntry{Kind: "ServiceAccount", Namespace: deployer.Namespace, Name: name},
+			cachev1alpha1.InventoryEntry{Kind: "Role", Namespace: deployer.Namespace, Name: name},
+			cachev1alpha1.InventoryEntry{Kind: "RoleBinding", Namespace: deployer.Namespace, Name: name})
+		components = append(components, "snapshot-controller")
+	}
+	for _, component := range components {
+		inventory = append(inventory,
+			cachev1alpha1.InventoryEntry{Kind: "ClusterRole", Name: clusterRBACName(deployer, component)},
+			cachev1alpha1.InventoryEntry{Kind: "ClusterRoleBinding", Name: clusterRBACName(deployer, component)})
+	}
+	for _, storageClass := range deployer.Spec.StorageClasses {
+		inventory = append(inventory, cachev1alpha1.InventoryEntry{Kind: "StorageClass", Name: storageClass.Name})
+	}
+	return inventory
+}
+
+// pruneOrphanedObjects deletes the operand objects of the Deployer which are no longer rendered,
+// e.g. after a rename of the node-server DaemonSet, and records the desired inventory in the
+// status. Candidates are the objects labelled for the Deployer and the entries of the previous
+// inventory, only objects controlled by the Deployer (or labelled for it when cluster-scoped)
+// are deleted.
+func (r *DeployerReconciler) pruneOrphanedObjects(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	log := log.FromContext(ctx)
+	desired := desiredInventoryForDeployer(deployer)
+	isDesired := map[cachev1alpha1.InventoryEntry]bool{}
+	for _, entry := range desired {
+		isDesired[entry] = true
+	}
+
+	for _, pk := range prunableKinds {
+		candidates, err := r.pruneCandidates(ctx, deployer, pk)
+		if err != nil {
+			return err
+		}
+		for _, obj := range candidates {
+			entry := cachev1alpha1.InventoryEntry{Kind: pk.kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+			if isDesired[entry] || !r.ownedByDeployer(deployer, obj, pk) {
+				continue
+			}
+			log.Info("Pruning orphaned object", "Kind", pk.kind, "Namespace", entry.Namespace, "Name", entry.Name)
+			if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("unable to prune %s %s: %w", pk.kind, entry.Name, err)
+			}
+		}
+	}
+
+	deployer.Status.Inventory = desired
+	return nil
+}
+
+// pruneCandidates returns the objects of the given kind labelled for the Deployer or listed in
+// its previous inventory
+func (r *DeployerReconciler) pruneCandidates(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, pk prunableKind) ([]client.Object, error) {
+	opts := []client.ListOption{client.MatchingLabels{
+		"app.kubernetes.io/instance": deployer.Name,
+		"app.kubernetes.io/part-of":  "directpv-operator",
+	}}
+	if !pk.clusterScoped {
+		opts = append(opts, client.InNamespace(deployer.Namespace))
+	}
+	list := pk.newList()
+	if err := r.List(ctx, list, opts...); err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var candidates []client.Object
+	for _, item := range items {
+		obj := item.(client.Object)
+		seen[obj.GetNamespace()+"/"+obj.GetName()] = true
+		candidates = append(candidates, obj)
+	}
+
+	for _, entry := range deployer.Status.Inventory {
+		if entry.Kind != pk.kind || seen[entry.Namespace+"/"+entry.Name] {
+			continue
+		}
+		obj := pk.newObject()
+		err := r.Get(ctx, types.NamespacedName{Namespace: entry.Namespace, Name: entry.Name}, obj)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, obj)
+	}
+	return candidates, nil
+}
+
+// ownedByDeployer reports whether the object was rendered for the Deployer. Cluster-scoped
+// objects cannot have an owner reference to the Deployer and are identified by their labels,
+// including the namespace of the Deployer.
+func (r *DeployerReconciler) ownedByDeployer(deployer *cachev1alpha1.Deployer, obj client.Object, pk prunableKind) bool {
+	if pk.clusterScoped {
+		return obj.GetLabels()["app.kubernetes.io/instance"] == deployer.Name &&
+			obj.GetLabels()["app.kubernetes.io/part-of"] == "directpv-operator" &&
+			obj.GetLabels()[deployerNamespaceLabel] == deployer.Namespace
+	}
+	return metav1.IsControlledBy(obj, deployer)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// enforceQuotaParameter is the StorageClass parameter enabling the XFS quota of its volumes.
+	// It has no directpv.min.io/ prefix, DirectPV would select drives labelled with it.
+	enforceQuotaParameter = "enforceQuota"
+	// reservedPercentParameter is the StorageClass parameter of the reserved space of the drives
+	// its volumes are provisioned on
+	reservedPercentParameter = "reservedSpacePercent"
+)
+
+// quotaArgs returns the node-server arguments of the quota settings of the drives
+func quotaArgs(quota *cachev1alpha1.QuotaSpec) []string {
+	if quota == nil {
+		return nil
+	}
+	var args []string
+	if quota.Enforce != nil {
+		args = append(args, fmt.Sprintf("--enforce-quota=%t", *quota.Enforce))
+	}
+	if quota.ReservedPercent != nil {
+		args = append(args, fmt.Sprintf("--reserved-space-percent=%d", *quota.ReservedPercent))
+	}
+	return args
+}
+
+// quotaParameters returns the StorageClass parameters of the quota settings of its volumes
+func quotaParameters(quota *cachev1alpha1.QuotaSpec) map[string]string {
+	if quota == nil {
+		return nil
+	}
+	parameters := map[string]string{}
+	if quota.Enforce != nil {
+		parameters[enforceQuotaParameter] = strconv.FormatBool(*quota.Enforce)
+	}
+	if quota.ReservedPercent != nil {
+		parameters[reservedPercentParameter] = strconv.Itoa(int(*quota.ReservedPercent))
+	}
+	return parameters
+}
+
+// applyDriveQuota passes the quota settings of the drives to the node-server, before its extra
+// arguments so they can still be overridden
+func applyDriveQuota(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) {
+	if deployer.Spec.Drives == nil {
+		return
+	}
+	appendArgs(spec, "node-server", quotaArgs(deployer.Spec.Drives.Quota))
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// deployerNamespaceLabel records the namespace of the Deployer on the cluster-scoped objects
+// rendered for it, as Deployers of the same name may exist in several namespaces
+const deployerNamespaceLabel = "cache.example.com/deployer-namespace"
+
+// directPVResources are the DirectPV custom resources managed by the operands
+var directPVResources = []string{"directpvdrives", "directpvvolumes", "directpvnodes", "directpvinitrequests"}
+
+// nodeServerRules are the cluster permissions of the node-server pods
+var nodeServerRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"directpv.min.io"},
+		Resources: directPVResources,
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"nodes"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"create", "patch"},
+	},
+}
+
+// controllerRules are the cluster permissions of the controller pods, including the CSI
+// provisioner and resizer sidecars
+var controllerRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"directpv.min.io"},
+		Resources: directPVResources,
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"persistentvolumes"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"persistentvolumeclaims"},
+		Verbs:     []string{"get", "list", "watch", "update"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"persistentvolumeclaims/status"},
+		Verbs:     []string{"update", "patch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"nodes"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+	},
+	{
+		APIGroups: []string{"storage.k8s.io"},
+		Resources: []string{"storageclasses", "csinodes", "volumeattachments"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+}
+
+// metricsAuthorizationRules are the permissions the TLS proxies of the node-server pods need to
+// authenticate and authorize the metrics requests
+var metricsAuthorizationRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"authentication.k8s.io"},
+		Resources: []string{"tokenreviews"},
+		Verbs:     []string{"create"},
+	},
+	{
+		APIGroups: []string{"authorization.k8s.io"},
+		Resources: []string{"subjectaccessreviews"},
+		Verbs:     []string{"create"},
+	},
+}
+
+// nodeServerRulesForDeployer returns the cluster permissions of the node-server pods of the Deployer
+func nodeServerRulesForDeployer(deployer *cachev1alpha1.Deployer) []rbacv1.PolicyRule {
+	if !metricsAuthorizationEnabled(deployer) {
+		return nodeServerRules
+	}
+	return append(append([]rbacv1.PolicyRule{}, nodeServerRules...), metricsAuthorizationRules...)
+}
+
+// controllerRulesForDeployer returns the cluster permissions of the controller pods of the
+// Deployer, with the ones of its optional sidecars
+func controllerRulesForDeployer(deployer *cachev1alpha1.Deployer) []rbacv1.PolicyRule {
+	rules := append([]rbacv1.PolicyRule{}, controllerRules...)
+	if snapshotsEnabledForDeployer(deployer) {
+		rules = append(rules, snapshotterRules...)
+	}
+	if healthMonitorEnabledForDeployer(deployer) {
+		rules = append(rules, healthMonitorRules...)
+	}
+	if storageCapacityEnabledForDeployer(deployer) {
+		rules = append(rules, storageCapacityRules...)
+	}
+	return rules
+}
+
+// controllerLeaderElectionRules are the permissions of the controller pods in the namespace
+// of the Deployer, used by the leader election of the sidecars
+var controllerLeaderElectionRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+}
+
+// The operator grants the operands permissions it does not hold itself, which needs the
+// escalate and bind verbs on the roles.
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;clusterroles,verbs=get;list;watch;create;update;patch;delete;escalate;bind
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// clusterRBACName returns the name of the ClusterRole and ClusterRoleBinding of a component
+// of the Deployer, unique across namespaces
+func clusterRBACName(deployer *cachev1alpha1.Deployer, component string) string {
+	return fmt.Sprintf("directpv-operator:%s:%s:%s", deployer.Namespace, deployer.Name, component)
+}
+
+// clusterLabelsForDeployer returns the labels of the cluster-scoped objects of the Deployer
+func (r *DeployerReconciler) clusterLabelsForDeployer(deployer *cachev1alpha1.Deployer) map[string]string {
+	return mergeLabels(r.labelsForMemcached(deployer.Name), map[string]string{deployerNamespaceLabel: deployer.Namespace})
+}
+
+// generatedServiceAccounts returns the names of the service accounts the operator creates
+// for the Deployer, the ones overridden in the spec are provisioned by the user
+func generatedServiceAccounts(deployer *cachev1alpha1.Deployer) []string {
+	var names []string
+	if deployer.Spec.NodeServer == nil || deployer.Spec.NodeServer.ServiceAccountName == "" {
+		names = append(names, nodeServerServiceAccountName(deployer))
+	}
+	if deployer.Spec.Controller == nil || deployer.Spec.Controller.ServiceAccountName == "" {
+		names = append(names, controllerServiceAccountName(deployer))
+	}
+	return names
+}
+
+// reconcileRBAC creates the service accounts of the node-server and controller pods and
+// binds each of them to the permissions its component needs, so a compromised node pod
+// cannot act as the controller. Overridden service accounts are bound but not created.
+func (r *DeployerReconciler) reconcileRBAC(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	for _, name := range generatedServiceAccounts(deployer) {
+		serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: deployer.Namespace}}
+		if err := r.createOrUpdateOwned(ctx, deployer, serviceAccount, func() error {
+			serviceAccount.Labels = mergeLabels(serviceAccount.Labels, r.labelsForMemcached(deployer.Name))
+			applyCommonMetadata(deployer, serviceAccount)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	for component, binding := range map[string]struct {
+		serviceAccount string
+		rules          []rbacv1.PolicyRule
+	}{
+		"node-server": {nodeServerServiceAccountName(deployer), nodeServerRulesForDeployer(deployer)},
+		"controller":  {controllerServiceAccountName(deployer), controllerRulesForDeployer(deployer)},
+	} {
+		if err := r.reconcileClusterRBAC(ctx, deployer, component, binding.serviceAccount, binding.rules); err != nil {
+			return err
+		}
+	}
+
+	role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: deployer.Name + "-controller", Namespace: deployer.Namespace}}
+	if err := r.createOrUpdateOwned(ctx, deployer, role, func() error {
+		role.Labels = mergeLabels(role.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, role)
+		role.Rules = controllerLeaderElectionRules
+		return nil
+	}); err != nil {
+		return err
+	}
+	roleBinding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: role.Name, Namespace: deployer.Namespace}}
+	return r.createOrUpdateOwned(ctx, deployer, roleBinding, func() error {
+		roleBinding.Labels = mergeLabels(roleBinding.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, roleBinding)
+		roleBinding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}
+		roleBinding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind,
+			Name: controllerServiceAccountName(deployer), Namespace: deployer.Namespace}}
+		return nil
+	})
+}
+
+// reconcileClusterRBAC binds the service account of a component to a ClusterRole with the given rules
+func (r *DeployerReconciler) reconcileClusterRBAC(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	component, serviceAccount string, rules []rbacv1.PolicyRule) error {
+	name := clusterRBACName(deployer, component)
+	clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := r.createOrUpdate(ctx, clusterRole, func() error {
+		clusterRole.Labels = mergeLabels(clusterRole.Labels, r.clusterLabelsForDeployer(deployer))
+		applyCommonMetadata(deployer, clusterRole)
+		clusterRole.Rules = rules
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	clusterRoleBinding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	return r.createOrUpdate(ctx, clusterRoleBinding, func() error {
+		clusterRoleBinding.Labels = mergeLabels(clusterRoleBinding.Labels, r.clusterLabelsForDeployer(deployer))
+		applyCommonMetadata(deployer, clusterRoleBinding)
+		clusterRoleBinding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+		clusterRoleBinding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind,
+			Name: serviceAccount, Namespace: deployer.Namespace}}
+		return nil
+	})
+}
+
+// createOrUpdateOwned creates or updates a namespaced object controlled by the Deployer
+func (r *DeployerReconciler) createOrUpdateOwned(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	obj client.Object, mutate controllerutil.MutateFn) error {
+	return r.createOrUpdate(ctx, obj, func() error {
+		if err := mutate(); err != nil {
+			return err
+		}
+		return ctrl.SetControllerReference(deployer, obj, r.Scheme)
+	})
+}
+
+// createOrUpdate creates or updates the object, logging the changes
+func (r *DeployerReconciler) createOrUpdate(ctx context.Context, obj client.Object, mutate controllerutil.MutateFn) error {
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, mutate)
+	if err != nil {
+		return fmt.Errorf("unable to reconcile %T %s: %w", obj, obj.GetName(), err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.FromContext(ctx).Info("Reconciled object", "Type", fmt.Sprintf("%T", obj),
+			"Name", obj.GetName(), "Operation", result)
+	}
+	return nil
+}
+
+// deleteClusterRBAC removes the ClusterRoles and ClusterRoleBindings of the Deployer, they are
+// cluster-scoped and not garbage collected with it
+func (r *DeployerReconciler) deleteClusterRBAC(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	for _, component := range []string{"node-server", "controller", "snapshot-controller"} {
+		name := clusterRBACName(deployer, component)
+		if err := r.Delete(ctx, &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name}}); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err := r.Delete(ctx, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}}); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
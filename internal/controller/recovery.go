@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// defaultStuckPodTimeout is used when spec.recovery.forceDeleteStuckPods.timeout is not set
+const defaultStuckPodTimeout = 5 * time.Minute
+
+// forceDeleteStuckPodsEnabled reports whether the operand pods stuck Terminating are force-deleted
+func forceDeleteStuckPodsEnabled(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.Recovery != nil && deployer.Spec.Recovery.ForceDeleteStuckPods != nil &&
+		deployer.Spec.Recovery.ForceDeleteStuckPods.Enabled
+}
+
+// stuckPodTimeoutForDeployer returns how long the operand pods of the Deployer stay Terminating
+// past their grace period before they are force-deleted
+func stuckPodTimeoutForDeployer(deployer *cachev1alpha1.Deployer) time.Duration {
+	if timeout := deployer.Spec.Recovery.ForceDeleteStuckPods.Timeout; timeout != nil {
+		return timeout.Duration
+	}
+	return defaultStuckPodTimeout
+}
+
+// forceDeleteStuckPods force-deletes the operand pods of the Deployer Terminating past their
+// grace period for longer than the timeout on nodes which are not Ready or gone. The kubelet
+// of such a node cannot confirm the deletion, the pod would block its replacement until the
+// node comes back. The pods on Ready nodes are left to their kubelet. It returns when to check
+// the pods still within the timeout, 0 if none.
+func (r *DeployerReconciler) forceDeleteStuckPods(ctx context.Context, deployer *cachev1alpha1.Deployer) (time.Duration, error) {
+	if !forceDeleteStuckPodsEnabled(deployer) {
+		return 0, nil
+	}
+	log := log.FromContext(ctx)
+	timeout := stuckPodTimeoutForDeployer(deployer)
+
+	// The version label of the pods changes with the upgrades, the pods of the previous
+	// version are the likeliest to be stuck
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(deployer.Namespace), client.MatchingLabels{
+		"app.kubernetes.io/instance": deployer.Name,
+		"app.kubernetes.io/part-of":  "directpv-operator",
+	}); err != nil {
+		return 0, err
+	}
+	var delay time.Duration
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp == nil {
+			continue
+		}
+		if remaining := time.Until(pod.DeletionTimestamp.Add(timeout)); remaining > 0 {
+			if delay == 0 || remaining < delay {
+				delay = remaining
+			}
+			continue
+		}
+		if pod.Spec.NodeName != "" {
+			node := &corev1.Node{}
+			err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return 0, err
+			}
+			if err == nil && nodeReady(node) {
+				continue
+			}
+		}
+
+		log.Info("Force-deleting stuck pod", "Pod.Name", pod.Name, "Node", pod.Spec.NodeName)
+		if err := r.Delete(ctx, pod, client.GracePeriodSeconds(0),
+			client.Preconditions{UID: &pod.UID}); client.IgnoreNotFound(err) != nil {
+			return 0, err
+		}
+		r.Recorder.Event(deployer, "Warning", "StuckPodForceDeleted",
+			fmt.Sprintf("Force-deleted the pod %s Terminating since %s on the node %s which is not Ready",
+				pod.Name, pod.DeletionTimestamp.UTC().Format(time.RFC3339), pod.Spec.NodeName))
+	}
+	return delay, nil
+}
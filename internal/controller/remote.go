@@ -0,0 +1,367 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// remoteOwnerAnnotation replaces the owner reference to the Deployer on the operand objects
+	// of a remote cluster, the Deployer does not exist there. It holds the UID of the Deployer.
+	remoteOwnerAnnotation = "cache.example.com/owner-uid"
+	// defaultKubeconfigSecretKey is the key of the kubeconfig in the Secret, as written by
+	// Cluster API
+	defaultKubeconfigSecretKey = "value"
+	// remoteClusterResyncInterval is how often the Deployers of remote clusters are
+	// reconciled, the objects of the remote clusters are not watched
+	remoteClusterResyncInterval = time.Minute
+	// remoteClusterTimeout bounds the requests to the remote clusters
+	remoteClusterTimeout = 30 * time.Second
+)
+
+// remoteCluster is a client of a remote cluster built from the given version of its
+// kubeconfig Secret
+type remoteCluster struct {
+	secretVersion string
+	client        client.Client
+	serverVersion discovery.ServerVersionInterface
+}
+
+// remoteClusterCache keeps the clients of the remote clusters per kubeconfig Secret
+type remoteClusterCache struct {
+	mu       sync.Mutex
+	clusters map[types.NamespacedName]*remoteCluster
+}
+
+// newRemoteClusterCache returns an empty cache of remote cluster clients
+func newRemoteClusterCache() *remoteClusterCache {
+	return &remoteClusterCache{clusters: map[types.NamespacedName]*remoteCluster{}}
+}
+
+// kubeconfigSecretKey returns the key of the kubeconfig in the Secret of the Deployer
+func kubeconfigSecretKey(deployer *cachev1alpha1.Deployer) string {
+	if key := deployer.Spec.KubeconfigSecret.Key; key != "" {
+		return key
+	}
+	return defaultKubeconfigSecretKey
+}
+
+// remoteClusterFor returns the client of the remote cluster of the Deployer, rebuilt when its
+// kubeconfig Secret changes
+func (r *DeployerReconciler) remoteClusterFor(ctx context.Context, deployer *cachev1alpha1.Deployer) (*remoteCluster, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Name: deployer.Spec.KubeconfigSecret.Name, Namespace: deployer.Namespace}
+	if err := r.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("unable to get the kubeconfig Secret %s: %w", key.Name, err)
+	}
+
+	clusters := r.remoteClusters
+	if clusters == nil {
+		clusters = newRemoteClusterCache()
+	}
+	clusters.mu.Lock()
+	defer clusters.mu.Unlock()
+	if cluster, found := clusters.clusters[key]; found && cluster.secretVersion == secret.ResourceVersion {
+		return cluster, nil
+	}
+
+	kubeconfig, found := secret.Data[kubeconfigSecretKey(deployer)]
+	if !found {
+		return nil, fmt.Errorf("the kubeconfig Secret %s has no key %s", key.Name, kubeconfigSecretKey(deployer))
+	}
+	config, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig in the Secret %s: %w", key.Name, err)
+	}
+	config.Timeout = remoteClusterTimeout
+	mapper, err := apiutil.NewDynamicRESTMapper(config)
+	if err != nil {
+		return nil, err
+	}
+	remoteClient, err := client.New(config, client.Options{Scheme: r.Scheme, Mapper: mapper})
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &remoteCluster{secretVersion: secret.ResourceVersion, client: remoteClient, serverVersion: discoveryClient}
+	clusters.clusters[key] = cluster
+	log.FromContext(ctx).Info("Connected to the remote cluster", "Secret", key.Name, "Host", config.Host)
+	return cluster, nil
+}
+
+// restConfigFromKubeconfig returns the client configuration of the kubeconfig of a remote
+// cluster. Anyone creating a Deployer may provide the kubeconfig, the exec and auth provider
+// plugins would run commands in the operator pod and the credential files would read its
+// files, e.g. its service account token, so only inline tokens and certificates are accepted.
+func restConfigFromKubeconfig(kubeconfig []byte) (*rest.Config, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	for name, authInfo := range config.AuthInfos {
+		switch {
+		case authInfo.Exec != nil:
+			return nil, fmt.Errorf("the user %s uses an exec plugin, only tokens and client certificates are supported", name)
+		case authInfo.AuthProvider != nil:
+			return nil, fmt.Errorf("the user %s uses an auth provider, only tokens and client certificates are supported", name)
+		case authInfo.TokenFile != "" || authInfo.ClientCertificate != "" || authInfo.ClientKey != "":
+			return nil, fmt.Errorf("the user %s references credential files, they must be inline", name)
+		}
+	}
+	for name, cluster := range config.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return nil, fmt.Errorf("the cluster %s references a certificate authority file, it must be inline", name)
+		}
+	}
+	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// remoteReconcilerFor returns a reconciler deploying the operands of the Deployer into its
+// remote cluster. The Deployer, its status, events and audit log stay in this cluster.
+func (r *DeployerReconciler) remoteReconcilerFor(ctx context.Context, deployer *cachev1alpha1.Deployer) (*DeployerReconciler, error) {
+	cluster, err := r.remoteClusterFor(ctx, deployer)
+	if err != nil {
+		return nil, err
+	}
+	hub := r.Client
+	if auditing, ok := hub.(*auditingClient); ok {
+		hub = auditing.Client
+	}
+	remote := *r
+	remote.hub = hub
+	remote.Client = &auditingClient{
+		Client:   &remoteClient{Client: cluster.client, hub: hub, owner: deployer},
+		recorder: r.Recorder,
+	}
+	remote.ServerVersion = cluster.serverVersion
+	return &remote, nil
+}
+
+// hubClient returns the client of the cluster of the Deployer
+func (r *DeployerReconciler) hubClient() client.Client {
+	if r.hub != nil {
+		return r.hub
+	}
+	return r.Client
+}
+
+// remoteClusterDelay returns when to reconcile the Deployer of a remote cluster again, 0 for a
+// Deployer of this cluster
+func remoteClusterDelay(deployer *cachev1alpha1.Deployer) time.Duration {
+	if deployer.Spec.KubeconfigSecret == nil {
+		return 0
+	}
+	return remoteClusterResyncInterval
+}
+
+// setRemoteClusterUnavailable reports in the Available condition that the remote cluster of
+// the Deployer cannot be reached
+func (r *DeployerReconciler) setRemoteClusterUnavailable(ctx context.Context, deployer *cachev1alpha1.Deployer, cause error) error {
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeAvailableDeployer,
+		Status: metav1.ConditionFalse, Reason: "RemoteClusterUnavailable",
+		Message: fmt.Sprintf("Unable to connect to the remote cluster: %v", cause)})
+	return r.Status().Update(ctx, deployer)
+}
+
+// reconcileRemoteNamespace creates the namespace of the Deployer in its remote cluster
+func (r *DeployerReconciler) reconcileRemoteNamespace(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	if r.hub == nil {
+		return nil
+	}
+	namespace := &corev1.Namespace{}
+	err := r.Get(ctx, types.NamespacedName{Name: deployer.Namespace}, namespace)
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: deployer.Namespace}}
+	applyCommonMetadata(deployer, namespace)
+	return r.Create(ctx, namespace)
+}
+
+// deleteRemoteOperands deletes the namespaced operand objects of the Deployer in its remote
+// cluster, they are not garbage collected with it
+func (r *DeployerReconciler) deleteRemoteOperands(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	if r.hub == nil {
+		return nil
+	}
+	for _, pk := range prunableKinds {
+		if pk.clusterScoped {
+			continue
+		}
+		candidates, err := r.pruneCandidates(ctx, deployer, pk)
+		if err != nil {
+			return err
+		}
+		for _, obj := range candidates {
+			if !r.ownedByDeployer(deployer, obj, pk) {
+				continue
+			}
+			if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("unable to delete %s %s: %w", pk.kind, obj.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// remoteClient reads and writes the objects of a remote cluster, except for the objects of the
+// operator API which are the ones of this cluster. The owner references to the Deployer are
+// replaced by the remote owner annotation in the remote cluster, and restored when reading.
+type remoteClient struct {
+	client.Client
+	hub   client.Client
+	owner *cachev1alpha1.Deployer
+}
+
+// clientFor returns the client of the cluster of the object
+func (c *remoteClient) clientFor(obj runtime.Object) client.Client {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err == nil && gvk.Group == cachev1alpha1.GroupVersion.Group {
+		return c.hub
+	}
+	return c.Client
+}
+
+// toRemote replaces the owner reference to the Deployer by the remote owner annotation
+func (c *remoteClient) toRemote(obj client.Object) {
+	var references []metav1.OwnerReference
+	owned := false
+	for _, reference := range obj.GetOwnerReferences() {
+		if reference.UID == c.owner.UID {
+			owned = true
+			continue
+		}
+		references = append(references, reference)
+	}
+	if !owned {
+		return
+	}
+	obj.SetOwnerReferences(references)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[remoteOwnerAnnotation] = string(c.owner.UID)
+	obj.SetAnnotations(annotations)
+}
+
+// fromRemote restores the owner reference to the Deployer recorded by the remote owner annotation
+func (c *remoteClient) fromRemote(obj client.Object) {
+	if obj.GetAnnotations()[remoteOwnerAnnotation] != string(c.owner.UID) {
+		return
+	}
+	for _, reference := range obj.GetOwnerReferences() {
+		if reference.UID == c.owner.UID {
+			return
+		}
+	}
+	reference := metav1.NewControllerRef(c.owner, cachev1alpha1.GroupVersion.WithKind("Deployer"))
+	obj.SetOwnerReferences(append(obj.GetOwnerReferences(), *reference))
+}
+
+// Get reads the object from its cluster
+func (c *remoteClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	target := c.clientFor(obj)
+	if err := target.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	if target != c.hub {
+		c.fromRemote(obj)
+	}
+	return nil
+}
+
+// List lists the objects from their cluster
+func (c *remoteClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	target := c.clientFor(list)
+	if err := target.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	if target == c.hub {
+		return nil
+	}
+	return meta.EachListItem(list, func(item runtime.Object) error {
+		if obj, ok := item.(client.Object); ok {
+			c.fromRemote(obj)
+		}
+		return nil
+	})
+}
+
+// write runs the write of the object through its cluster
+func (c *remoteClient) write(obj client.Object, write func(client.Client) error) error {
+	target := c.clientFor(obj)
+	if target == c.hub {
+		return write(target)
+	}
+	c.toRemote(obj)
+	err := write(target)
+	c.fromRemote(obj)
+	return err
+}
+
+// Create creates the object in its cluster
+func (c *remoteClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.write(obj, func(target client.Client) error { return target.Create(ctx, obj, opts...) })
+}
+
+// Update updates the object in its cluster
+func (c *remoteClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.write(obj, func(target client.Client) error { return target.Update(ctx, obj, opts...) })
+}
+
+// Patch patches the object in its cluster
+func (c *remoteClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.write(obj, func(target client.Client) error { return target.Patch(ctx, obj, patch, opts...) })
+}
+
+// Delete deletes the object from its cluster
+func (c *remoteClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.clientFor(obj).Delete(ctx, obj, opts...)
+}
+
+// DeleteAllOf deletes the objects of the given kind from their cluster
+func (c *remoteClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return c.clientFor(obj).DeleteAllOf(ctx, obj, opts...)
+}
+
+// Status returns the status writer of this cluster, only the status of the Deployer is written
+func (c *remoteClient) Status() client.SubResourceWriter {
+	return c.hub.Status()
+}
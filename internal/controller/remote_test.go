@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+)
+
+// testKubeconfig returns a kubeconfig of the given user and cluster stanzas
+func testKubeconfig(user, cluster string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote.example.com:6443
+` + cluster + `
+users:
+- name: admin
+  user:
+` + user + `
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    user: admin
+current-context: remote
+`)
+}
+
+func TestRestConfigFromKubeconfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		cluster string
+		wantErr bool
+	}{
+		{name: "token", user: "    token: secret"},
+		{name: "client certificate", user: "    client-certificate-data: Y2VydA==\n    client-key-data: a2V5"},
+		{name: "inline certificate authority", user: "    token: secret", cluster: "    certificate-authority-data: Y2E="},
+		{
+			name:    "exec plugin",
+			user:    "    exec:\n      apiVersion: client.authentication.k8s.io/v1\n      command: /bin/sh\n      args: [-c, id]",
+			wantErr: true,
+		},
+		{name: "auth provider", user: "    auth-provider:\n      name: oidc", wantErr: true},
+		{name: "token file", user: "    tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token", wantErr: true},
+		{name: "client certificate files", user: "    client-certificate: /tmp/cert\n    client-key: /tmp/key", wantErr: true},
+		{name: "certificate authority file", user: "    token: secret", cluster: "    certificate-authority: /tmp/ca", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := restConfigFromKubeconfig(testKubeconfig(test.user, test.cluster))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("restConfigFromKubeconfig() error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && config.Host != "https://remote.example.com:6443" {
+				t.Errorf("restConfigFromKubeconfig() host = %s", config.Host)
+			}
+		})
+	}
+	if _, err := restConfigFromKubeconfig([]byte("{")); err == nil {
+		t.Error("restConfigFromKubeconfig() accepted an invalid kubeconfig")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	configv1alpha1 "github.com/example/directpv-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// renderClient is the client of the reconciliation steps run by Render. No object exists, the
+// created ones are recorded in order. Only the methods used by these steps are implemented.
+type renderClient struct {
+	client.Client
+	scheme  *runtime.Scheme
+	created []client.Object
+}
+
+// Get reports every object as not found
+func (c *renderClient) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}, key.Name)
+}
+
+// Create records the object with its apiVersion and kind
+func (c *renderClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	c.created = append(c.created, obj)
+	return nil
+}
+
+// Scheme returns the scheme of the rendered objects
+func (c *renderClient) Scheme() *runtime.Scheme {
+	return c.scheme
+}
+
+// Render returns the objects the Deployer controller creates for the Deployer on a cluster
+// without DirectPV: the service accounts and RBAC, the node-server DaemonSets, the CSIDriver,
+// the StorageClasses and the controller Deployment, in creation order. The objects depending
+// on the state of the cluster, e.g. the serving certificate, the snapshot CRDs or the metrics
+// Service, are not rendered. The defaults of the Deployer CRD are not applied.
+func Render(deployer *cachev1alpha1.Deployer, scheme *runtime.Scheme,
+	images, fipsImages configv1alpha1.OperandImages) ([]client.Object, error) {
+	renderer := &renderClient{scheme: scheme}
+	r := &DeployerReconciler{
+		Client:        renderer,
+		Scheme:        scheme,
+		Recorder:      &record.FakeRecorder{},
+		DefaultImages: images,
+		FIPSImages:    fipsImages,
+	}
+	ctx := context.Background()
+
+	if err := r.reconcileRBAC(ctx, deployer); err != nil {
+		return nil, err
+	}
+	daemonSet, err := r.daemonSetForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, daemonSet); err != nil {
+		return nil, err
+	}
+	for _, step := range []func(context.Context, *cachev1alpha1.Deployer) error{
+		r.reconcileArchDaemonSets,
+		r.reconcileCSIDriver,
+		r.reconcileStorageClasses,
+	} {
+		if err := step(ctx, deployer); err != nil {
+			return nil, err
+		}
+	}
+	deployment, err := r.deploymentForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, deployment); err != nil {
+		return nil, err
+	}
+	return renderer.created, nil
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// typeFilesystemHealthyDrive represents the result of the last filesystem check of a drive
+const typeFilesystemHealthyDrive = "FilesystemHealthy"
+
+// driveScrubScript checks the filesystems of the drives, mounted by DirectPV under the data
+// directory of the host mounted as /host. The result of each drive is reported in the
+// termination message, one <fsuuid>=<result> line per drive.
+const driveScrubScript = `for fsuuid in "$@"; do
+  mountpoint="/host/mnt/$fsuuid"
+  if ! mountpoint -q "$mountpoint"; then
+    echo "$fsuuid=unmounted" >> /dev/termination-log
+  elif xfs_scrub -n "$mountpoint" > "/tmp/$fsuuid.log" 2>&1; then
+    echo "$fsuuid=ok" >> /dev/termination-log
+  else
+    echo "$fsuuid=errors: $(tail -n 1 "/tmp/$fsuuid.log")" >> /dev/termination-log
+  fi
+done`
+
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+
+// scrubCronJobName returns the name of the filesystem check CronJob of the Deployer on the
+// given node
+func scrubCronJobName(deployer *cachev1alpha1.Deployer, nodeName string) string {
+	return fmt.Sprintf("%s-scrub-%s", deployer.Name, nodeName)
+}
+
+// scrubScheduleForDeployer returns the schedule of the filesystem checks, empty when disabled
+func scrubScheduleForDeployer(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Spec.Maintenance == nil {
+		return ""
+	}
+	return deployer.Spec.Maintenance.ScrubSchedule
+}
+
+// idleDrives returns the filesystem UUIDs of the Ready drives without staged or published
+// volumes, by node
+func idleDrives(drives, volumes []unstructured.Unstructured) map[string][]string {
+	busy := map[string]bool{}
+	for i := range volumes {
+		if publishedVolume(&volumes[i]) {
+			busy[volumes[i].GetLabels()["directpv.min.io/drive"]] = true
+		}
+	}
+	idle := map[string][]string{}
+	for i := range drives {
+		status, _, _ := unstructured.NestedString(drives[i].Object, "status", "status")
+		fsuuid, _, _ := unstructured.NestedString(drives[i].Object, "status", "fsuuid")
+		if status != "Ready" || fsuuid == "" || busy[drives[i].GetName()] {
+			continue
+		}
+		node := drives[i].GetLabels()["directpv.min.io/node"]
+		idle[node] = append(idle[node], fsuuid)
+	}
+	for node := range idle {
+		sort.Strings(idle[node])
+	}
+	return idle
+}
+
+// reconcileDriveScrubs keeps a filesystem check CronJob on every node with idle drives and
+// records the results of the completed checks in the drive conditions. The CronJobs of the
+// nodes without idle drives are deleted, and all of them when the checks are disabled.
+func (r *DeployerReconciler) reconcileDriveScrubs(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	schedule := scrubScheduleForDeployer(deployer)
+	var drives []unstructured.Unstructured
+	desired := map[string]bool{}
+	if schedule != "" {
+		nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+		if err != nil {
+			return err
+		}
+		if drives, err = r.listDirectPVObjects(ctx, directPVDriveGVK, nodes); err != nil {
+			return err
+		}
+		volumes, err := r.listDirectPVObjects(ctx, directPVVolumeGVK, nodes)
+		if err != nil {
+			return err
+		}
+		defaultImage, err := r.imageForDeployer(deployer)
+		if err != nil {
+			return err
+		}
+		for node, fsuuids := range idleDrives(drives, volumes) {
+			image, err := imageForNode(ctx, r.Client, deployer, node, defaultImage)
+			if err != nil {
+				return err
+			}
+			if err := r.reconcileScrubCronJob(ctx, deployer, node, image, fsuuids); err != nil {
+				return err
+			}
+			desired[scrubCronJobName(deployer, node)] = true
+		}
+	}
+
+	cronJobs := &batchv1.CronJobList{}
+	if err := r.List(ctx, cronJobs, client.InNamespace(deployer.Namespace), client.MatchingLabels{
+		"app.kubernetes.io/name":     "directpv-scrub",
+		"app.kubernetes.io/instance": deployer.Name,
+	}); err != nil {
+		return err
+	}
+	for i := range cronJobs.Items {
+		if desired[cronJobs.Items[i].Name] || !metav1.IsControlledBy(&cronJobs.Items[i], deployer) {
+			continue
+		}
+		log.FromContext(ctx).Info("Deleting the filesystem check CronJob", "CronJob.Name", cronJobs.Items[i].Name)
+		propagation := metav1.DeletePropagationBackground
+		if err := r.Delete(ctx, &cronJobs.Items[i], &client.DeleteOptions{PropagationPolicy: &propagation}); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	if schedule == "" {
+		deployer.Status.DriveConditions = nil
+		return nil
+	}
+	return r.recordScrubResults(ctx, deployer, drives)
+}
+
+// reconcileScrubCronJob creates or updates the filesystem check CronJob of the node, checking
+// the given drives
+func (r *DeployerReconciler) reconcileScrubCronJob(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	node, image string, fsuuids []string) error {
+	name := scrubCronJobName(deployer, node)
+	job := nodeJobForDeployer(deployer, name, "directpv-scrub", node, image, path.Clean(dataPathForDeployer(deployer)),
+		corev1.MountPropagationHostToContainer, append([]string{"/bin/sh", "-c", driveScrubScript, "scrub"}, fsuuids...))
+	// The checks run again at the next schedule
+	job.Spec.BackoffLimit = &[]int32{0}[0]
+	cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: deployer.Namespace}}
+	return r.createOrUpdateOwned(ctx, deployer, cronJob, func() error {
+		cronJob.Labels = mergeLabels(cronJob.Labels, job.Labels)
+		applyCommonMetadata(deployer, cronJob)
+		cronJob.Spec.Schedule = scrubScheduleForDeployer(deployer)
+		cronJob.Spec.ConcurrencyPolicy = batchv1.ForbidConcurrent
+		cronJob.Spec.SuccessfulJobsHistoryLimit = &[]int32{1}[0]
+		cronJob.Spec.FailedJobsHistoryLimit = &[]int32{1}[0]
+		cronJob.Spec.JobTemplate = batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: job.Labels, Annotations: job.Annotations},
+			Spec:       job.Spec,
+		}
+		return nil
+	})
+}
+
+// recordScrubResults sets the FilesystemHealthy condition of the drives from the termination
+// messages of the completed filesystem check Jobs, the latest Job of a node wins. The
+// conditions of the drives which no longer exist are dropped.
+func (r *DeployerReconciler) recordScrubResults(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	drives []unstructured.Unstructured) error {
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(deployer.Namespace), client.MatchingLabels{
+		"app.kubernetes.io/name":     "directpv-scrub",
+		"app.kubernetes.io/instance": deployer.Name,
+	}); err != nil {
+		return err
+	}
+	sort.Slice(jobs.Items, func(i, j int) bool {
+		return jobs.Items[i].CreationTimestamp.Before(&jobs.Items[j].CreationTimestamp)
+	})
+
+	byFSUUID := map[string]*unstructured.Unstructured{}
+	for i := range drives {
+		fsuuid, _, _ := unstructured.NestedString(drives[i].Object, "status", "fsuuid")
+		byFSUUID[fsuuid] = &drives[i]
+	}
+	exists := map[string]bool{}
+	for i := range drives {
+		exists[drives[i].GetName()] = true
+	}
+	var conditions []cachev1alpha1.DriveConditions
+	index := map[string]int{}
+	for _, entry := range deployer.Status.DriveConditions {
+		if exists[entry.Drive] {
+			index[entry.Drive] = len(conditions)
+			conditions = append(conditions, entry)
+		}
+	}
+
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !jobConditionTrue(job, batchv1.JobComplete) {
+			continue
+		}
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+			return err
+		}
+		for j := range pods.Items {
+			if pods.Items[j].Status.Phase != corev1.PodSucceeded {
+				continue
+			}
+			for _, line := range strings.Split(terminationMessage(&pods.Items[j]), "\n") {
+				fsuuid, result, found := strings.Cut(strings.TrimSpace(line), "=")
+				drive := byFSUUID[fsuuid]
+				if !found || drive == nil {
+					continue
+				}
+				k, known := index[drive.GetName()]
+				if !known {
+					k = len(conditions)
+					index[drive.GetName()] = k
+					conditions = append(conditions, cachev1alpha1.DriveConditions{
+						Drive: drive.GetName(), Node: drive.GetLabels()["directpv.min.io/node"]})
+				}
+				r.setScrubCondition(deployer, &conditions[k], result)
+			}
+		}
+	}
+	deployer.Status.DriveConditions = conditions
+	return nil
+}
+
+// setScrubCondition sets the FilesystemHealthy condition of the drive from the result of its
+// filesystem check and emits an event when errors are found
+func (r *DeployerReconciler) setScrubCondition(deployer *cachev1alpha1.Deployer,
+	drive *cachev1alpha1.DriveConditions, result string) {
+	condition := metav1.Condition{Type: typeFilesystemHealthyDrive, Status: metav1.ConditionTrue,
+		Reason: "ScrubPassed", Message: "xfs_scrub found no error"}
+	switch {
+	case result == "unmounted":
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "NotMounted"
+		condition.Message = "The drive was not mounted during the check"
+	case result != "ok":
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ScrubFailed"
+		condition.Message = "xfs_scrub found " + result
+	}
+	previous := meta.FindStatusCondition(drive.Conditions, typeFilesystemHealthyDrive)
+	if condition.Status == metav1.ConditionFalse && (previous == nil || previous.Status != metav1.ConditionFalse) {
+		r.Recorder.Event(deployer, "Warning", "DriveFilesystemErrors",
+			fmt.Sprintf("The filesystem of the drive %s on %s has %s", drive.Drive, drive.Node, result))
+	}
+	meta.SetStatusCondition(&drive.Conditions, condition)
+}
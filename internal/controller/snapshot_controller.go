@@ -0,0 +1,338 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// snapshotCRDFiles are the VolumeSnapshot CRDs matching the bundled snapshot-controller
+//
+//go:embed snapshotcrds/*.yaml
+var snapshotCRDFiles embed.FS
+
+// snapshotAPIVersion is the VolumeSnapshot API version the bundled snapshot-controller uses
+const snapshotAPIVersion = "v1"
+
+// snapshotControllerRules are the cluster permissions of the snapshot-controller pods
+var snapshotControllerRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"persistentvolumes"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"persistentvolumeclaims"},
+		Verbs:     []string{"get", "list", "watch", "update"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"list", "watch", "create", "update", "patch"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshotclasses"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshotcontents"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshotcontents/status"},
+		Verbs:     []string{"patch"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshots"},
+		Verbs:     []string{"get", "list", "watch", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshots/status"},
+		Verbs:     []string{"update", "patch"},
+	},
+}
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch;create;update
+
+// snapshotControllerInstalledForDeployer reports whether the Deployer installs the snapshot CRDs
+// and the snapshot-controller
+func snapshotControllerInstalledForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	return snapshotsEnabledForDeployer(deployer) && snapshotsSpecForDeployer(deployer).InstallController
+}
+
+// snapshotControllerName returns the name of the snapshot-controller Deployment and of its
+// service account and Role
+func snapshotControllerName(deployer *cachev1alpha1.Deployer) string {
+	return deployer.Name + "-snapshot-controller"
+}
+
+// imageForSnapshotController gets the snapshot-controller image
+func (r *DeployerReconciler) imageForSnapshotController(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "SNAPSHOT_CONTROLLER", r.DefaultImages.SnapshotController, r.FIPSImages.SnapshotController)
+}
+
+// bundledSnapshotCRDs returns the VolumeSnapshot CRDs installed by the operator
+func bundledSnapshotCRDs() ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	entries, err := snapshotCRDFiles.ReadDir("snapshotcrds")
+	if err != nil {
+		return nil, err
+	}
+	var crds []*apiextensionsv1.CustomResourceDefinition
+	for _, entry := range entries {
+		data, err := snapshotCRDFiles.ReadFile(path.Join("snapshotcrds", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(data, crd); err != nil {
+			return nil, fmt.Errorf("unable to decode %s: %w", entry.Name(), err)
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+// servesVersion reports whether the CRD serves the given version
+func servesVersion(crd *apiextensionsv1.CustomResourceDefinition, version string) bool {
+	for _, v := range crd.Spec.Versions {
+		if v.Name == version && v.Served {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileSnapshotCRDs creates the VolumeSnapshot CRDs missing in the cluster and keeps the
+// ones created by the operator at the bundled version. CRDs installed otherwise are left
+// alone, it returns a message listing the ones not serving the version the bundled
+// snapshot-controller needs. The CRDs are never deleted as that would delete the snapshots.
+func (r *DeployerReconciler) reconcileSnapshotCRDs(ctx context.Context, deployer *cachev1alpha1.Deployer) (string, error) {
+	crds, err := bundledSnapshotCRDs()
+	if err != nil {
+		return "", err
+	}
+	var incompatible []string
+	for _, crd := range crds {
+		found := &apiextensionsv1.CustomResourceDefinition{}
+		err := r.Get(ctx, types.NamespacedName{Name: crd.Name}, found)
+		if apierrors.IsNotFound(err) {
+			crd.Labels = map[string]string{"app.kubernetes.io/part-of": "directpv-operator"}
+			log.FromContext(ctx).Info("Creating the snapshot CRD", "Name", crd.Name)
+			if err := r.Create(ctx, crd); err != nil {
+				return "", err
+			}
+			r.Recorder.Event(deployer, "Normal", "SnapshotCRDInstalled", fmt.Sprintf("Created the CRD %s", crd.Name))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if found.Labels["app.kubernetes.io/part-of"] != "directpv-operator" {
+			if !servesVersion(found, snapshotAPIVersion) {
+				incompatible = append(incompatible, found.Name)
+			}
+			continue
+		}
+		if reflect.DeepEqual(found.Spec.Versions, crd.Spec.Versions) &&
+			reflect.DeepEqual(found.Annotations, crd.Annotations) {
+			continue
+		}
+		found.Annotations = crd.Annotations
+		found.Spec.Versions = crd.Spec.Versions
+		log.FromContext(ctx).Info("Updating the snapshot CRD", "Name", crd.Name)
+		if err := r.Update(ctx, found); err != nil {
+			return "", err
+		}
+	}
+	if len(incompatible) == 0 {
+		return "", nil
+	}
+	sort.Strings(incompatible)
+	return fmt.Sprintf("The installed CRDs %s do not serve snapshot.storage.k8s.io/%s needed by the bundled "+
+		"snapshot-controller, upgrade them or disable spec.csi.snapshots.installController",
+		strings.Join(incompatible, ", "), snapshotAPIVersion), nil
+}
+
+// reconcileSnapshotController installs the VolumeSnapshot CRDs and the snapshot-controller
+// Deployment with its RBAC when configured. Incompatible CRDs installed by others block the
+// snapshot-controller and degrade the Deployer. The snapshot-controller objects are pruned
+// once no longer configured.
+func (r *DeployerReconciler) reconcileSnapshotController(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	if !snapshotControllerInstalledForDeployer(deployer) {
+		clearSnapshotCRDsDegraded(deployer)
+		return nil
+	}
+
+	message, err := r.reconcileSnapshotCRDs(ctx, deployer)
+	if err != nil {
+		return err
+	}
+	if message != "" {
+		log.FromContext(ctx).Info("Snapshot CRDs incompatible", "Message", message)
+		r.Recorder.Event(deployer, "Warning", "IncompatibleSnapshotCRDs", message)
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDegradedDeployer,
+			Status: metav1.ConditionTrue, Reason: "IncompatibleSnapshotCRDs", Message: message})
+		return nil
+	}
+	clearSnapshotCRDsDegraded(deployer)
+
+	name := snapshotControllerName(deployer)
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: deployer.Namespace}}
+	if err := r.createOrUpdateOwned(ctx, deployer, serviceAccount, func() error {
+		serviceAccount.Labels = mergeLabels(serviceAccount.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, serviceAccount)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := r.reconcileClusterRBAC(ctx, deployer, "snapshot-controller", name, snapshotControllerRules); err != nil {
+		return err
+	}
+	role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: deployer.Namespace}}
+	if err := r.createOrUpdateOwned(ctx, deployer, role, func() error {
+		role.Labels = mergeLabels(role.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, role)
+		role.Rules = controllerLeaderElectionRules
+		return nil
+	}); err != nil {
+		return err
+	}
+	roleBinding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: deployer.Namespace}}
+	if err := r.createOrUpdateOwned(ctx, deployer, roleBinding, func() error {
+		roleBinding.Labels = mergeLabels(roleBinding.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, roleBinding)
+		roleBinding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name}
+		roleBinding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: deployer.Namespace}}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	desired, err := r.snapshotControllerDeploymentForDeployer(deployer)
+	if err != nil {
+		return err
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: deployer.Namespace}}
+	return r.createOrUpdateOwned(ctx, deployer, deployment, func() error {
+		deployment.Labels = mergeLabels(deployment.Labels, desired.Labels)
+		applyCommonMetadata(deployer, deployment)
+		deployment.Spec.Replicas = desired.Spec.Replicas
+		if deployment.Spec.Selector == nil {
+			deployment.Spec.Selector = desired.Spec.Selector
+		}
+		if !podTemplateHashEqual(&deployment.Spec.Template, &desired.Spec.Template) {
+			if deployment.ResourceVersion != "" {
+				if allowed, err := r.allowDisruptiveChange(deployer, "Deployment", deployment, desired); err != nil || !allowed {
+					return err
+				}
+			}
+			deployment.Spec.Template = desired.Spec.Template
+		}
+		return nil
+	})
+}
+
+// clearSnapshotCRDsDegraded removes the Degraded condition reported for incompatible snapshot CRDs
+func clearSnapshotCRDsDegraded(deployer *cachev1alpha1.Deployer) {
+	if cond := meta.FindStatusCondition(deployer.Status.Conditions, typeDegradedDeployer); cond != nil && cond.Reason == "IncompatibleSnapshotCRDs" {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeDegradedDeployer)
+	}
+}
+
+// snapshotControllerDeploymentForDeployer returns the snapshot-controller Deployment. Its pods
+// are labelled apart from the DirectPV pods so the DirectPV selectors do not match them.
+func (r *DeployerReconciler) snapshotControllerDeploymentForDeployer(deployer *cachev1alpha1.Deployer) (*appsv1.Deployment, error) {
+	image, err := r.imageForSnapshotController(deployer)
+	if err != nil {
+		return nil, err
+	}
+	selector := map[string]string{
+		"app.kubernetes.io/name":     "snapshot-controller",
+		"app.kubernetes.io/instance": deployer.Name,
+	}
+	labels := mergeLabels(r.labelsForMemcached(deployer.Name), selector)
+	delete(labels, "app.kubernetes.io/version")
+	replicas := int32(2)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotControllerName(deployer),
+			Namespace: deployer.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: snapshotControllerName(deployer),
+					SecurityContext:    &corev1.PodSecurityContext{},
+					Containers: []corev1.Container{{
+						Name:            "snapshot-controller",
+						Image:           image,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Args: []string{
+							"--v=3",
+							"--leader-election=true",
+							"--leader-election-namespace=$(NAMESPACE)",
+						},
+						Env: []corev1.EnvVar{{
+							Name: "NAMESPACE",
+							ValueFrom: &corev1.EnvVarSource{
+								FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"},
+							},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	applyPodSpecOptions(deployer, &deployment.Spec.Template.Spec)
+	applyVerifiedImages(deployer, &deployment.Spec.Template.Spec)
+	applyCommonMetadata(deployer, &deployment.Spec.Template)
+	applyRestartedAt(deployer, &deployment.Spec.Template)
+	if err := setPodTemplateHash(&deployment.Spec.Template); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// volumeSnapshotClassGVK is the VolumeSnapshotClass kind of the external-snapshotter CRDs
+var volumeSnapshotClassGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshotClass"}
+
+// snapshotterRules are the cluster permissions of the csi-snapshotter sidecar
+var snapshotterRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshotclasses"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshotcontents"},
+		Verbs:     []string{"get", "list", "watch", "update", "patch"},
+	},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshotcontents/status"},
+		Verbs:     []string{"update", "patch"},
+	},
+}
+
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotclasses,verbs=get;list;watch;create;update;patch;delete
+
+// snapshotsEnabledForDeployer reports whether the Deployer enables volume snapshots
+func snapshotsEnabledForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	if enabled, set := featureGate(deployer, featureGateVolumeSnapshots); set {
+		return enabled
+	}
+	return snapshotsSpecForDeployer(deployer).Enabled
+}
+
+// imageForSnapshotter gets the csi-snapshotter image
+func (r *DeployerReconciler) imageForSnapshotter(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "CSI_SNAPSHOTTER", r.DefaultImages.CSISnapshotter, r.FIPSImages.CSISnapshotter)
+}
+
+// applySnapshotter adds the csi-snapshotter sidecar to the controller pod spec when snapshots
+// are enabled, sharing the CSI socket with the other sidecars
+func (r *DeployerReconciler) applySnapshotter(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec) error {
+	if !snapshotsEnabledForDeployer(deployer) {
+		return nil
+	}
+	image, err := r.imageForSnapshotter(deployer)
+	if err != nil {
+		return err
+	}
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Image: image,
+		Name:  "csi-snapshotter",
+		Args: []string{
+			"--v=3",
+			"--timeout=300s",
+			"--csi-address=$(CSI_ENDPOINT)",
+			"--leader-election",
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "CSI_ENDPOINT",
+				Value: csiEndpoint,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "socket-dir",
+				MountPath: csiSocketDir,
+			},
+		},
+	})
+	return nil
+}
+
+// reconcileVolumeSnapshotClass creates the VolumeSnapshotClass of the driver when snapshots are
+// enabled and removes the one of the Deployer otherwise. It does nothing when the VolumeSnapshot
+// CRDs are not installed.
+func (r *DeployerReconciler) reconcileVolumeSnapshotClass(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	if !snapshotsEnabledForDeployer(deployer) {
+		return r.deleteVolumeSnapshotClass(ctx, deployer)
+	}
+	if _, err := r.RESTMapper().RESTMapping(volumeSnapshotClassGVK.GroupKind(), volumeSnapshotClassGVK.Version); meta.IsNoMatchError(err) {
+		log.FromContext(ctx).Info("Skipping the VolumeSnapshotClass, the VolumeSnapshot CRDs are not installed")
+		return nil
+	}
+
+	deletionPolicy := snapshotsSpecForDeployer(deployer).DeletionPolicy
+	if deletionPolicy == "" {
+		deletionPolicy = "Delete"
+	}
+	class := &unstructured.Unstructured{}
+	class.SetGroupVersionKind(volumeSnapshotClassGVK)
+	class.SetName(csiDriverName)
+	return r.createOrUpdate(ctx, class, func() error {
+		class.SetLabels(mergeLabels(class.GetLabels(), r.clusterLabelsForDeployer(deployer)))
+		applyCommonMetadata(deployer, class)
+		class.Object["driver"] = csiDriverName
+		class.Object["deletionPolicy"] = deletionPolicy
+		return nil
+	})
+}
+
+// deleteVolumeSnapshotClass removes the VolumeSnapshotClass of the driver if it was created for
+// the Deployer, it is cluster-scoped and not garbage collected with it
+func (r *DeployerReconciler) deleteVolumeSnapshotClass(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	class := &unstructured.Unstructured{}
+	class.SetGroupVersionKind(volumeSnapshotClassGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: csiDriverName}, class)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if class.GetLabels()["app.kubernetes.io/instance"] != deployer.Name ||
+		class.GetLabels()[deployerNamespaceLabel] != deployer.Namespace {
+		return nil
+	}
+	log.FromContext(ctx).Info("Deleting the VolumeSnapshotClass", "Name", class.GetName())
+	return client.IgnoreNotFound(r.Delete(ctx, class))
+}
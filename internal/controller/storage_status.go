@@ -0,0 +1,196 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// updateStorageSummary publishes the totals of the DirectPV drives and volumes on the nodes
+// of the Deployer in its status. The status is persisted at the end of the reconciliation.
+func (r *DeployerReconciler) updateStorageSummary(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return err
+	}
+	drives, err := r.listDirectPVObjects(ctx, directPVDriveGVK, nodes)
+	if err != nil {
+		return err
+	}
+	volumes, err := r.listDirectPVObjects(ctx, directPVVolumeGVK, nodes)
+	if err != nil {
+		return err
+	}
+
+	var previous []string
+	if deployer.Status.Storage != nil {
+		previous = deployer.Status.Storage.DrivesUnderPressure
+	}
+	summary := &cachev1alpha1.StorageSummary{Volumes: int32(len(volumes))}
+	var total, free int64
+	for i := range drives {
+		if status, _, _ := unstructured.NestedString(drives[i].Object, "status", "status"); status == "Ready" {
+			summary.DrivesOnline++
+		} else {
+			summary.DrivesDegraded++
+		}
+		totalCapacity, _, _ := unstructured.NestedInt64(drives[i].Object, "status", "totalCapacity")
+		freeCapacity, _, _ := unstructured.NestedInt64(drives[i].Object, "status", "freeCapacity")
+		total += totalCapacity
+		free += freeCapacity
+		if driveUnderPressure(deployer, totalCapacity, freeCapacity) {
+			summary.DrivesUnderPressure = append(summary.DrivesUnderPressure, drives[i].GetName())
+		}
+	}
+	summary.CapacityTotal = *resource.NewQuantity(total, resource.BinarySI)
+	summary.CapacityFree = *resource.NewQuantity(free, resource.BinarySI)
+
+	for i := range volumes {
+		claimed, err := volumeClaimed(ctx, r.Client, volumes[i].GetName())
+		if err != nil {
+			return err
+		}
+		if claimed {
+			summary.VolumesBound++
+		}
+	}
+	deployer.Status.Storage = summary
+	deployer.Status.Capacity = nodeCapacities(drives)
+	r.updateCapacityPressure(deployer, drives, previous)
+	return nil
+}
+
+// defaultCapacityRefreshInterval is how often status.capacity is refreshed without
+// spec.capacityRefreshInterval, the DirectPV drives are not watched
+const defaultCapacityRefreshInterval = 5 * time.Minute
+
+// capacityRefreshDelay returns when to refresh the capacity of the nodes again
+func capacityRefreshDelay(deployer *cachev1alpha1.Deployer) time.Duration {
+	if interval := deployer.Spec.CapacityRefreshInterval; interval != nil && interval.Duration > 0 {
+		return interval.Duration
+	}
+	return defaultCapacityRefreshInterval
+}
+
+// nodeCapacities sums the capacity of the drives of each node, sorted by node name
+func nodeCapacities(drives []unstructured.Unstructured) []cachev1alpha1.NodeCapacity {
+	totals := map[string]*[3]int64{}
+	for i := range drives {
+		node := drives[i].GetLabels()["directpv.min.io/node"]
+		if totals[node] == nil {
+			totals[node] = &[3]int64{}
+		}
+		totalCapacity, _, _ := unstructured.NestedInt64(drives[i].Object, "status", "totalCapacity")
+		allocatedCapacity, _, _ := unstructured.NestedInt64(drives[i].Object, "status", "allocatedCapacity")
+		freeCapacity, _, _ := unstructured.NestedInt64(drives[i].Object, "status", "freeCapacity")
+		totals[node][0] += totalCapacity
+		totals[node][1] += allocatedCapacity
+		totals[node][2] += freeCapacity
+	}
+	var capacities []cachev1alpha1.NodeCapacity
+	for node, total := range totals {
+		capacities = append(capacities, cachev1alpha1.NodeCapacity{
+			Node:      node,
+			Total:     *resource.NewQuantity(total[0], resource.BinarySI),
+			Allocated: *resource.NewQuantity(total[1], resource.BinarySI),
+			Free:      *resource.NewQuantity(total[2], resource.BinarySI),
+		})
+	}
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i].Node < capacities[j].Node })
+	return capacities
+}
+
+// capacityAlertInterval is how often the usage of the drives is checked when an alert is set,
+// the DirectPV drives are not watched
+const capacityAlertInterval = 5 * time.Minute
+
+// capacityAlertDelay returns when to check the usage of the drives again, 0 without alert
+func capacityAlertDelay(deployer *cachev1alpha1.Deployer) time.Duration {
+	if deployer.Spec.Alerts == nil || deployer.Spec.Alerts.DriveUsagePercent == nil {
+		return 0
+	}
+	return capacityAlertInterval
+}
+
+// driveUnderPressure reports whether the allocated capacity of a drive is above the usage
+// threshold of the Deployer
+func driveUnderPressure(deployer *cachev1alpha1.Deployer, total, free int64) bool {
+	if capacityAlertDelay(deployer) == 0 || total <= 0 {
+		return false
+	}
+	return (total-free)*100 >= total*int64(*deployer.Spec.Alerts.DriveUsagePercent)
+}
+
+// updateCapacityPressure sets the CapacityPressure condition from the drives under pressure
+// and emits an event for each drive crossing the threshold since the previous reconciliation
+func (r *DeployerReconciler) updateCapacityPressure(deployer *cachev1alpha1.Deployer,
+	drives []unstructured.Unstructured, previous []string) {
+	if capacityAlertDelay(deployer) == 0 {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeCapacityPressureDeployer)
+		return
+	}
+	threshold := *deployer.Spec.Alerts.DriveUsagePercent
+	if len(deployer.Status.Storage.DrivesUnderPressure) == 0 {
+		if meta.IsStatusConditionTrue(deployer.Status.Conditions, typeCapacityPressureDeployer) {
+			r.Recorder.Event(deployer, "Normal", "CapacityPressureResolved",
+				fmt.Sprintf("No drive is above %d%% usage anymore", threshold))
+		}
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeCapacityPressureDeployer,
+			Status: metav1.ConditionFalse, Reason: "DriveUsageNormal",
+			Message: fmt.Sprintf("All the drives are below %d%% usage", threshold)})
+		return
+	}
+
+	known := map[string]bool{}
+	for _, name := range previous {
+		known[name] = true
+	}
+	pressured := map[string]bool{}
+	for _, name := range deployer.Status.Storage.DrivesUnderPressure {
+		pressured[name] = true
+	}
+	var descriptions []string
+	for i := range drives {
+		drive := &drives[i]
+		if !pressured[drive.GetName()] {
+			continue
+		}
+		total, _, _ := unstructured.NestedInt64(drive.Object, "status", "totalCapacity")
+		free, _, _ := unstructured.NestedInt64(drive.Object, "status", "freeCapacity")
+		description := fmt.Sprintf("%s on %s (%d%%)", drive.GetName(), drive.GetLabels()["directpv.min.io/node"],
+			(total-free)*100/total)
+		descriptions = append(descriptions, description)
+		if !known[drive.GetName()] {
+			r.Recorder.Event(deployer, "Warning", "DriveUsageHigh",
+				fmt.Sprintf("The drive %s is above %d%% usage", description, threshold))
+		}
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeCapacityPressureDeployer,
+		Status: metav1.ConditionTrue, Reason: "DriveUsageHigh",
+		Message: fmt.Sprintf("Drives above %d%% usage: %s", threshold, strings.Join(descriptions, ", "))})
+}
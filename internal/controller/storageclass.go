@@ -0,0 +1,169 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// directPVIdentityTopology is the topology DirectPV reports on every node of the driver
+var directPVIdentityTopology = corev1.TopologySelectorLabelRequirement{
+	Key:    "directpv.min.io/identity",
+	Values: []string{csiDriverName},
+}
+
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch;create;update;patch;delete
+
+// storageClassForDeployer returns a StorageClass of the Deployer. It is cluster-scoped and
+// therefore labelled for the Deployer instead of being owned by it.
+func (r *DeployerReconciler) storageClassForDeployer(deployer *cachev1alpha1.Deployer,
+	spec cachev1alpha1.StorageClassSpec) *storagev1.StorageClass {
+	reclaimPolicy := spec.ReclaimPolicy
+	if reclaimPolicy == "" {
+		reclaimPolicy = corev1.PersistentVolumeReclaimDelete
+	}
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	allowedTopologies := []corev1.TopologySelectorTerm{{
+		MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{directPVIdentityTopology},
+	}}
+	if len(spec.AllowedTopologies) > 0 {
+		allowedTopologies = nil
+		for _, term := range spec.AllowedTopologies {
+			term := term.DeepCopy()
+			term.MatchLabelExpressions = append(term.MatchLabelExpressions, directPVIdentityTopology)
+			allowedTopologies = append(allowedTopologies, *term)
+		}
+	}
+
+	parameters := map[string]string{"fstype": "xfs"}
+	for key, value := range spec.DriveLabels {
+		parameters[driveLabelPrefix+key] = value
+	}
+	for key, value := range quotaParameters(spec.Quota) {
+		parameters[key] = value
+	}
+
+	storageClass := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   spec.Name,
+			Labels: r.clusterLabelsForDeployer(deployer),
+			Annotations: map[string]string{
+				"storageclass.kubernetes.io/is-default-class": fmt.Sprintf("%t", spec.Default),
+			},
+		},
+		Provisioner:          csiDriverName,
+		Parameters:           parameters,
+		ReclaimPolicy:        &reclaimPolicy,
+		AllowVolumeExpansion: &[]bool{true}[0],
+		VolumeBindingMode:    &bindingMode,
+		AllowedTopologies:    allowedTopologies,
+	}
+	applyCommonMetadata(deployer, storageClass)
+	return storageClass
+}
+
+// storageClassImmutableFieldsChanged reports whether the immutable settings of the found
+// StorageClass differ from the desired ones
+func storageClassImmutableFieldsChanged(found, desired *storagev1.StorageClass) bool {
+	return found.Provisioner != desired.Provisioner ||
+		!reflect.DeepEqual(found.Parameters, desired.Parameters) ||
+		!reflect.DeepEqual(found.ReclaimPolicy, desired.ReclaimPolicy) ||
+		!reflect.DeepEqual(found.VolumeBindingMode, desired.VolumeBindingMode) ||
+		!reflect.DeepEqual(found.AllowedTopologies, desired.AllowedTopologies)
+}
+
+// reconcileStorageClasses creates the StorageClasses of the Deployer and keeps them in sync.
+// The StorageClasses are recreated to change their immutable settings, which does not affect
+// the volumes already provisioned. StorageClasses of the same name not created for the
+// Deployer are left alone. The ones removed from the spec are pruned.
+func (r *DeployerReconciler) reconcileStorageClasses(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	log := log.FromContext(ctx)
+	for _, spec := range deployer.Spec.StorageClasses {
+		desired := r.storageClassForDeployer(deployer, spec)
+		found := &storagev1.StorageClass{}
+		err := r.Get(ctx, types.NamespacedName{Name: desired.Name}, found)
+		if apierrors.IsNotFound(err) {
+			log.Info("Creating a new StorageClass", "StorageClass.Name", desired.Name)
+			if err := r.Create(ctx, desired); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !r.ownedByDeployer(deployer, found, prunableKind{clusterScoped: true}) {
+			return fmt.Errorf("the StorageClass %s already exists and was not created for the Deployer", found.Name)
+		}
+		if storageClassImmutableFieldsChanged(found, desired) {
+			log.Info("Recreating the StorageClass to change its immutable settings", "StorageClass.Name", found.Name)
+			if err := r.Delete(ctx, found, client.Preconditions{UID: &found.UID}); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			if err := r.Create(ctx, desired); err != nil {
+				return err
+			}
+			r.Recorder.Event(deployer, "Normal", "StorageClassRecreated",
+				fmt.Sprintf("Recreated the StorageClass %s to change its settings", found.Name))
+			continue
+		}
+
+		original := found.DeepCopy()
+		found.AllowVolumeExpansion = desired.AllowVolumeExpansion
+		mergeObjectMetadata(found, desired)
+		if reflect.DeepEqual(found, original) {
+			continue
+		}
+		log.Info("Updating the StorageClass", "StorageClass.Name", found.Name)
+		if err := r.Patch(ctx, found, client.MergeFrom(original)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStorageClasses removes the StorageClasses created for the Deployer, they are
+// cluster-scoped and not garbage collected with it
+func (r *DeployerReconciler) deleteStorageClasses(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	storageClasses := &storagev1.StorageClassList{}
+	if err := r.List(ctx, storageClasses, client.MatchingLabels{
+		"app.kubernetes.io/instance": deployer.Name,
+		"app.kubernetes.io/part-of":  "directpv-operator",
+		deployerNamespaceLabel:       deployer.Namespace,
+	}); err != nil {
+		return err
+	}
+	for i := range storageClasses.Items {
+		if err := r.Delete(ctx, &storageClasses.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
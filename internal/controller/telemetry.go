@@ -0,0 +1,140 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// OperatorVersion is the version of the operator, set at build time
+var OperatorVersion = "dev"
+
+// defaultTelemetryInterval is the interval between the telemetry reports without
+// spec.telemetry.interval
+const defaultTelemetryInterval = 24 * time.Hour
+
+// telemetryTimeout bounds a telemetry report
+const telemetryTimeout = 10 * time.Second
+
+// telemetryReport is the anonymized install metadata posted to the telemetry endpoint
+type telemetryReport struct {
+	ClusterID       string `json:"clusterID"`
+	OperatorVersion string `json:"operatorVersion"`
+	DirectPVVersion string `json:"directpvVersion,omitempty"`
+	Nodes           int    `json:"nodes"`
+	Drives          int    `json:"drives"`
+}
+
+// telemetryInterval returns the interval between the telemetry reports of the Deployer
+func telemetryInterval(deployer *cachev1alpha1.Deployer) time.Duration {
+	if interval := deployer.Spec.Telemetry.Interval; interval != nil && interval.Duration > 0 {
+		return interval.Duration
+	}
+	return defaultTelemetryInterval
+}
+
+// reconcileTelemetry posts a telemetry report when the interval elapsed since the last one,
+// only when the Deployer opted in. A failed report is recorded in the status and retried at
+// the next interval. It returns when the next report is due, 0 without telemetry. The status
+// is persisted at the end of the reconciliation.
+func (r *DeployerReconciler) reconcileTelemetry(ctx context.Context, deployer *cachev1alpha1.Deployer) (time.Duration, error) {
+	if deployer.Spec.Telemetry == nil || !deployer.Spec.Telemetry.Enabled {
+		deployer.Status.Telemetry = nil
+		return 0, nil
+	}
+	interval := telemetryInterval(deployer)
+	if status := deployer.Status.Telemetry; status != nil && status.LastReportTime != nil {
+		if next := time.Until(status.LastReportTime.Add(interval)); next > 0 {
+			return next, nil
+		}
+	}
+
+	report, err := r.telemetryReportForDeployer(ctx, deployer)
+	if err != nil {
+		return 0, err
+	}
+	now := metav1.Now()
+	deployer.Status.Telemetry = &cachev1alpha1.TelemetryStatus{LastReportTime: &now}
+	if err := postTelemetryReport(ctx, deployer.Spec.Telemetry.Endpoint, report); err != nil {
+		log.FromContext(ctx).Info("Failed to post the telemetry report", "Endpoint", deployer.Spec.Telemetry.Endpoint,
+			"Error", err.Error())
+		deployer.Status.Telemetry.Error = err.Error()
+	}
+	return interval, nil
+}
+
+// telemetryReportForDeployer collects the anonymized install metadata of the Deployer
+func (r *DeployerReconciler) telemetryReportForDeployer(ctx context.Context,
+	deployer *cachev1alpha1.Deployer) (*telemetryReport, error) {
+	// The UID of kube-system is stable for the life of the cluster and reveals nothing about it
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: metav1.NamespaceSystem}, namespace); err != nil {
+		return nil, err
+	}
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return nil, err
+	}
+	drives, err := r.listDirectPVObjects(ctx, directPVDriveGVK, nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &telemetryReport{
+		ClusterID:       fmt.Sprintf("%x", sha256.Sum256([]byte(namespace.UID)))[:16],
+		OperatorVersion: OperatorVersion,
+		DirectPVVersion: deployer.Status.InstalledVersion,
+		Nodes:           len(nodes),
+		Drives:          len(drives),
+	}, nil
+}
+
+// postTelemetryReport posts the report to the endpoint as JSON
+func postTelemetryReport(ctx context.Context, endpoint string, report *telemetryReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, telemetryTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("the telemetry endpoint answered %s", response.Status)
+	}
+	return nil
+}
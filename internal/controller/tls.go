@@ -0,0 +1,393 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+const (
+	// operandTLSDir is where the serving certificate is mounted in the TLS proxies
+	operandTLSDir = "/etc/directpv/tls"
+	// tlsProxyContainerPrefix prefixes the names of the TLS proxy sidecars
+	tlsProxyContainerPrefix = "tls-proxy-"
+	// selfSignedValidity is the validity of the certificates generated by the operator
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenewBefore is how long before expiry the generated certificates are renewed
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// certificateGVK is the cert-manager Certificate kind
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+
+// tlsEndpoint is an HTTP endpoint of an operand container served through a TLS proxy
+type tlsEndpoint struct {
+	// name suffixes the name of the proxy container
+	name string
+	// portName is the name of the public port, moved from the operand to the proxy container
+	portName string
+	// upstreamPortName is the name of the port the operand keeps binding behind the proxy
+	upstreamPortName string
+	port             int32
+	path             string
+	// authorize requires the requests of the path to be authorized, the others pass through
+	authorize bool
+	// flag is the operand argument setting the port of the endpoint
+	flag string
+}
+
+// tlsEnabledForDeployer reports whether the operand endpoints of the Deployer are served over TLS
+func tlsEnabledForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.TLS != nil && deployer.Spec.TLS.Enabled
+}
+
+// certManagerForDeployer reports whether the serving certificate is requested from cert-manager
+func certManagerForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	return tlsEnabledForDeployer(deployer) && deployer.Spec.TLS.CertManager != nil
+}
+
+// operandTLSSecretName returns the name of the Secret holding the serving certificate
+func operandTLSSecretName(deployer *cachev1alpha1.Deployer) string {
+	return deployer.Name + "-operand-tls"
+}
+
+// operandTLSDNSNames returns the names the serving certificate is valid for, the ones of the
+// metrics Service through which Prometheus scrapes the node-server pods
+func operandTLSDNSNames(deployer *cachev1alpha1.Deployer) []string {
+	service := metricsServiceName(deployer)
+	return []string{
+		service,
+		fmt.Sprintf("%s.%s.svc", service, deployer.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, deployer.Namespace),
+	}
+}
+
+// metricsAuthorizationEnabled reports whether the node-server metrics requests are authorized
+func metricsAuthorizationEnabled(deployer *cachev1alpha1.Deployer) bool {
+	return tlsEnabledForDeployer(deployer) && deployer.Spec.TLS.AuthorizeMetrics
+}
+
+// tlsUpstreamPort returns the port an operand endpoint binds when its public port is taken
+// by the TLS proxy
+func tlsUpstreamPort(port int32) int32 {
+	return port + 1
+}
+
+// imageForTLSProxy gets the image of the TLS proxy sidecars
+func (r *DeployerReconciler) imageForTLSProxy(deployer *cachev1alpha1.Deployer) (string, error) {
+	return r.operandImage(deployer, "TLS_PROXY", r.DefaultImages.TLSProxy, r.FIPSImages.TLSProxy)
+}
+
+// applyTLSProxies moves the endpoints of the named container to their upstream ports and puts a
+// proxy sidecar terminating TLS on each public port, the probes of the container switch to
+// HTTPS. The proxies pass the requests of the endpoint paths through without authorization
+// unless the endpoint requires it, those requests are authenticated with a TokenReview and
+// authorized with a SubjectAccessReview.
+func (r *DeployerReconciler) applyTLSProxies(deployer *cachev1alpha1.Deployer, spec *corev1.PodSpec,
+	containerName string, endpoints []tlsEndpoint) error {
+	if !tlsEnabledForDeployer(deployer) {
+		return nil
+	}
+	image, err := r.imageForTLSProxy(deployer)
+	if err != nil {
+		return err
+	}
+
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "operand-tls",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: operandTLSSecretName(deployer)},
+		},
+	})
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == containerName {
+			moveToUpstreamPorts(&spec.Containers[i], endpoints)
+		}
+	}
+	for _, endpoint := range endpoints {
+		spec.Containers = append(spec.Containers, corev1.Container{
+			Name:            tlsProxyContainerPrefix + endpoint.name,
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Args: []string{
+				fmt.Sprintf("--secure-listen-address=0.0.0.0:%d", endpoint.port),
+				fmt.Sprintf("--upstream=http://127.0.0.1:%d/", tlsUpstreamPort(endpoint.port)),
+				"--tls-cert-file=" + operandTLSDir + "/" + corev1.TLSCertKey,
+				"--tls-private-key-file=" + operandTLSDir + "/" + corev1.TLSPrivateKeyKey,
+				"--v=3",
+			},
+			Ports: []corev1.ContainerPort{{
+				ContainerPort: endpoint.port,
+				Name:          endpoint.portName,
+			}},
+			VolumeMounts: []corev1.VolumeMount{{
+				Name:      "operand-tls",
+				MountPath: operandTLSDir,
+				ReadOnly:  true,
+			}},
+		})
+		if !endpoint.authorize {
+			appendArgs(spec, tlsProxyContainerPrefix+endpoint.name, []string{"--ignore-paths=" + endpoint.path})
+		}
+	}
+	return nil
+}
+
+// moveToUpstreamPorts binds the endpoints of the container on their upstream ports and points
+// its probes of these endpoints at the TLS proxies. Named probe ports resolve within the
+// container only, the proxied ports are referenced by number.
+func moveToUpstreamPorts(container *corev1.Container, endpoints []tlsEndpoint) {
+	for _, endpoint := range endpoints {
+		for i, arg := range container.Args {
+			if strings.HasPrefix(arg, endpoint.flag+"=") {
+				container.Args[i] = fmt.Sprintf("%s=%d", endpoint.flag, tlsUpstreamPort(endpoint.port))
+			}
+		}
+		for i := range container.Ports {
+			if container.Ports[i].Name == endpoint.portName {
+				container.Ports[i].Name = endpoint.upstreamPortName
+				container.Ports[i].ContainerPort = tlsUpstreamPort(endpoint.port)
+			}
+		}
+		for _, probe := range []*corev1.Probe{container.LivenessProbe, container.ReadinessProbe, container.StartupProbe} {
+			if probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Port.String() != endpoint.portName {
+				continue
+			}
+			probe.HTTPGet.Port = intstr.FromInt(int(endpoint.port))
+			probe.HTTPGet.Scheme = corev1.URISchemeHTTPS
+		}
+	}
+}
+
+// reconcileOperandTLS provisions the serving certificate of the TLS proxies, through a
+// cert-manager Certificate or as a self-signed certificate generated by the operator and
+// renewed once it gets close to expiry, and reports it in the status. The proxies reload the
+// renewed certificate from the mounted Secret. The Certificate is removed when no longer
+// configured, the generated Secret is pruned with the other operand objects.
+func (r *DeployerReconciler) reconcileOperandTLS(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(operandTLSSecretName(deployer))
+	certificate.SetNamespace(deployer.Namespace)
+	if !certManagerForDeployer(deployer) {
+		if err := r.Delete(ctx, certificate); client.IgnoreNotFound(err) != nil && !meta.IsNoMatchError(err) {
+			return err
+		}
+	}
+
+	var err error
+	switch {
+	case !tlsEnabledForDeployer(deployer):
+		deployer.Status.TLS = nil
+		return nil
+	case certManagerForDeployer(deployer):
+		err = r.reconcileCertificate(ctx, deployer, certificate)
+	default:
+		err = r.reconcileSelfSignedCertificate(ctx, deployer)
+	}
+	if err != nil {
+		return err
+	}
+	return r.updateTLSStatus(ctx, deployer, certificate)
+}
+
+// updateTLSStatus reports the validity of the serving certificate in the status. The renewal
+// time of a cert-manager certificate is the one reported by cert-manager.
+func (r *DeployerReconciler) updateTLSStatus(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, certificate *unstructured.Unstructured) error {
+	status := &cachev1alpha1.TLSStatus{SecretName: operandTLSSecretName(deployer)}
+	deployer.Status.TLS = status
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: status.SecretName, Namespace: deployer.Namespace}, secret)
+	if apierrors.IsNotFound(err) {
+		// cert-manager has not issued the certificate yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil
+	}
+	status.NotBefore = &metav1.Time{Time: cert.NotBefore}
+	status.NotAfter = &metav1.Time{Time: cert.NotAfter}
+	if !certManagerForDeployer(deployer) {
+		status.RenewalTime = &metav1.Time{Time: cert.NotAfter.Add(-selfSignedRenewBefore)}
+	} else if value, _, _ := unstructured.NestedString(certificate.Object, "status", "renewalTime"); value != "" {
+		if renewalTime, err := time.Parse(time.RFC3339, value); err == nil {
+			status.RenewalTime = &metav1.Time{Time: renewalTime}
+		}
+	}
+	return nil
+}
+
+// certificateRenewalDelay returns how long until the operator renews the generated serving
+// certificate, zero when it does not generate one
+func certificateRenewalDelay(deployer *cachev1alpha1.Deployer, now time.Time) time.Duration {
+	if certManagerForDeployer(deployer) || deployer.Status.TLS == nil || deployer.Status.TLS.RenewalTime == nil {
+		return 0
+	}
+	if delay := deployer.Status.TLS.RenewalTime.Sub(now); delay > time.Minute {
+		return delay
+	}
+	return time.Minute
+}
+
+// reconcileCertificate requests the serving certificate from the cert-manager issuer of the Deployer
+func (r *DeployerReconciler) reconcileCertificate(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, certificate *unstructured.Unstructured) error {
+	if _, err := r.RESTMapper().RESTMapping(certificateGVK.GroupKind(), certificateGVK.Version); meta.IsNoMatchError(err) {
+		return fmt.Errorf("spec.tls.certManager is set but the cert-manager CRDs are not installed")
+	}
+	issuerRef := deployer.Spec.TLS.CertManager.IssuerRef
+	kind := issuerRef.Kind
+	if kind == "" {
+		kind = "Issuer"
+	}
+	var dnsNames []interface{}
+	for _, name := range operandTLSDNSNames(deployer) {
+		dnsNames = append(dnsNames, name)
+	}
+	return r.createOrUpdateOwned(ctx, deployer, certificate, func() error {
+		certificate.SetLabels(mergeLabels(certificate.GetLabels(), r.labelsForMemcached(deployer.Name)))
+		applyCommonMetadata(deployer, certificate)
+		return unstructured.SetNestedField(certificate.Object, map[string]interface{}{
+			"secretName": operandTLSSecretName(deployer),
+			"dnsNames":   dnsNames,
+			"issuerRef": map[string]interface{}{
+				"name":  issuerRef.Name,
+				"kind":  kind,
+				"group": certificateGVK.Group,
+			},
+		}, "spec")
+	})
+}
+
+// reconcileSelfSignedCertificate generates the serving certificate into the TLS Secret when it
+// is missing, expires soon or does not cover the names of the metrics Service
+func (r *DeployerReconciler) reconcileSelfSignedCertificate(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	dnsNames := operandTLSDNSNames(deployer)
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: operandTLSSecretName(deployer), Namespace: deployer.Namespace}}
+	return r.createOrUpdateOwned(ctx, deployer, secret, func() error {
+		secret.Labels = mergeLabels(secret.Labels, r.labelsForMemcached(deployer.Name))
+		applyCommonMetadata(deployer, secret)
+		secret.Type = corev1.SecretTypeTLS
+		if certificateValid(secret.Data[corev1.TLSCertKey], dnsNames, time.Now()) {
+			return nil
+		}
+		cert, key, err := generateSelfSignedCertificate(dnsNames, time.Now())
+		if err != nil {
+			return err
+		}
+		if len(secret.Data[corev1.TLSCertKey]) > 0 {
+			r.Recorder.Event(deployer, "Normal", "CertificateRotated",
+				fmt.Sprintf("Renewed the operand serving certificate in Secret %s", secret.Name))
+		}
+		secret.Data = map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+			// The certificate is its own CA, clients verify it against itself
+			"ca.crt": cert,
+		}
+		return nil
+	})
+}
+
+// certificateValid reports whether the PEM encoded certificate covers the names and is not
+// due for renewal
+func certificateValid(data []byte, dnsNames []string, now time.Time) bool {
+	cert, err := parseCertificate(data)
+	if err != nil || now.Add(selfSignedRenewBefore).After(cert.NotAfter) {
+		return false
+	}
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCertificate parses the first certificate of the PEM data
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// generateSelfSignedCertificate returns a PEM encoded self-signed certificate for the names
+// and its private key
+func generateSelfSignedCertificate(dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:              dnsNames,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// defaultUdevDataPath is where udev stores the device properties read by DirectPV
+const defaultUdevDataPath = "/run/udev/data"
+
+// udevDataMissingMessage is the termination message of a preflight pod not finding the udev
+// data when it is optional
+const udevDataMissingMessage = "udev data missing"
+
+// udevDataPathForDeployer returns the udev data directory of the nodes of the Deployer
+func udevDataPathForDeployer(deployer *cachev1alpha1.Deployer) string {
+	if deployer.Spec.NodeServer != nil && deployer.Spec.NodeServer.Udev != nil &&
+		deployer.Spec.NodeServer.Udev.DataPath != "" {
+		return deployer.Spec.NodeServer.Udev.DataPath
+	}
+	return defaultUdevDataPath
+}
+
+// udevOptionalForDeployer reports whether DirectPV is installed on nodes without udev data
+func udevOptionalForDeployer(deployer *cachev1alpha1.Deployer) bool {
+	return deployer.Spec.NodeServer != nil && deployer.Spec.NodeServer.Udev != nil &&
+		deployer.Spec.NodeServer.Udev.Optional
+}
+
+// setUdevDataCondition reports the nodes the preflight checks found without udev data
+func setUdevDataCondition(deployer *cachev1alpha1.Deployer, nodes []string) {
+	if len(nodes) == 0 {
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeUdevDataUnavailableDeployer,
+			Status: metav1.ConditionFalse, Reason: "UdevDataFound",
+			Message: "The udev data is available on all the nodes"})
+		return
+	}
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeUdevDataUnavailableDeployer,
+		Status: metav1.ConditionTrue, Reason: "UdevDataMissing",
+		Message: fmt.Sprintf("%s does not exist on %s, the devices of these nodes may not be discovered",
+			udevDataPathForDeployer(deployer), strings.Join(nodes, ", "))})
+}
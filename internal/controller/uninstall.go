@@ -0,0 +1,213 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// uninstallPolicyForDeployer returns the uninstall policy of the Deployer, Retain by default
+func uninstallPolicyForDeployer(deployer *cachev1alpha1.Deployer) cachev1alpha1.UninstallPolicy {
+	if deployer.Spec.Uninstall == nil || deployer.Spec.Uninstall.Policy == "" {
+		return cachev1alpha1.UninstallPolicyRetain
+	}
+	return deployer.Spec.Uninstall.Policy
+}
+
+// uninstallDrivesAndVolumes applies the uninstall policy to the DirectPV drives and volumes
+// of the nodes of the Deployer.
+func (r *DeployerReconciler) uninstallDrivesAndVolumes(ctx context.Context, deployer *cachev1alpha1.Deployer) error {
+	log := log.FromContext(ctx)
+	policy := uninstallPolicyForDeployer(deployer)
+	if policy == cachev1alpha1.UninstallPolicyRetain {
+		log.Info("Retaining the DirectPV drives and volumes")
+		return nil
+	}
+
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return err
+	}
+	volumes, err := r.listDirectPVObjects(ctx, directPVVolumeGVK, nodes)
+	if err != nil {
+		return err
+	}
+	drives, err := r.listDirectPVObjects(ctx, directPVDriveGVK, nodes)
+	if err != nil {
+		return err
+	}
+
+	force := policy == cachev1alpha1.UninstallPolicyForce
+	drivesInUse := map[string]bool{}
+	for i := range volumes {
+		volume := &volumes[i]
+		if !force {
+			claimed, err := volumeClaimed(ctx, r.Client, volume.GetName())
+			if err != nil {
+				return err
+			}
+			if claimed {
+				log.Info("Retaining volume referenced by a PVC", "Volume", volume.GetName())
+				drivesInUse[volume.GetLabels()["directpv.min.io/drive"]] = true
+				continue
+			}
+		}
+		if err := deleteDirectPVObject(ctx, r.Client, volume, force); err != nil {
+			return fmt.Errorf("unable to delete volume %s: %w", volume.GetName(), err)
+		}
+	}
+
+	var released []*unstructured.Unstructured
+	for i := range drives {
+		if !drivesInUse[drives[i].GetName()] {
+			released = append(released, &drives[i])
+		}
+	}
+	// The devices of the drives are identified before the drives are deleted
+	if err := r.recordDriveWipes(ctx, deployer, released); err != nil {
+		return err
+	}
+	for _, drive := range released {
+		if err := deleteDirectPVObject(ctx, r.Client, drive, force); err != nil {
+			return fmt.Errorf("unable to delete drive %s: %w", drive.GetName(), err)
+		}
+	}
+
+	log.Info("Removed the DirectPV drives and volumes", "Policy", policy,
+		"Volumes", len(volumes), "Drives", len(drives))
+	return nil
+}
+
+// blockDeletionWhileVolumesBound reports whether DirectPV volumes of the nodes of the Deployer
+// are still bound to PVCs, listing the blocking PVCs in the Degraded condition. The Force
+// uninstall policy skips the check.
+func (r *DeployerReconciler) blockDeletionWhileVolumesBound(ctx context.Context, deployer *cachev1alpha1.Deployer) (bool, error) {
+	if uninstallPolicyForDeployer(deployer) == cachev1alpha1.UninstallPolicyForce {
+		return false, nil
+	}
+
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return false, err
+	}
+	volumes, err := r.listDirectPVObjects(ctx, directPVVolumeGVK, nodes)
+	if err != nil {
+		return false, err
+	}
+
+	var claims []string
+	for i := range volumes {
+		pv := &corev1.PersistentVolume{}
+		err := r.Get(ctx, types.NamespacedName{Name: volumes[i].GetName()}, pv)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if pv.Spec.ClaimRef != nil {
+			claims = append(claims, pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name)
+		}
+	}
+	if len(claims) == 0 {
+		return false, nil
+	}
+
+	sort.Strings(claims)
+	message := fmt.Sprintf("Deletion is blocked while DirectPV volumes are bound to the PVCs %s, "+
+		"delete them or set spec.uninstall.policy to Force", strings.Join(claims, ", "))
+	log.FromContext(ctx).Info("Deletion blocked by bound volumes", "PVCs", claims)
+	r.Recorder.Event(deployer, "Warning", "VolumesInUse", message)
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDegradedDeployer,
+		Status: metav1.ConditionTrue, Reason: "VolumesInUse", Message: message})
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// nodeNamesForDeployer returns the names of the nodes of the Deployer running the node-server
+func (r *DeployerReconciler) nodeNamesForDeployer(ctx context.Context, deployer *cachev1alpha1.Deployer) (map[string]bool, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList, client.MatchingLabels(deployer.Spec.NodeSelector)); err != nil {
+		return nil, err
+	}
+	nodes := map[string]bool{}
+	for i := range nodeList.Items {
+		if nodeSelectedForDeployer(deployer, &nodeList.Items[i]) {
+			nodes[nodeList.Items[i].Name] = true
+		}
+	}
+	return nodes, nil
+}
+
+// listDirectPVObjects lists the DirectPV objects of the given kind located on the given nodes
+func (r *DeployerReconciler) listDirectPVObjects(ctx context.Context, gvk schema.GroupVersionKind, nodes map[string]bool) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.List(ctx, list); err != nil {
+		return nil, err
+	}
+	var items []unstructured.Unstructured
+	for _, item := range list.Items {
+		if nodes[item.GetLabels()["directpv.min.io/node"]] {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// volumeClaimed reports whether the PV of the DirectPV volume is bound to a PVC
+func volumeClaimed(ctx context.Context, c client.Client, volumeName string) (bool, error) {
+	pv := &corev1.PersistentVolume{}
+	err := c.Get(ctx, types.NamespacedName{Name: volumeName}, pv)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pv.Spec.ClaimRef != nil, nil
+}
+
+// deleteDirectPVObject deletes the DirectPV object. When forced, its finalizers are removed
+// so it goes away even though DirectPV does not clean it up.
+func deleteDirectPVObject(ctx context.Context, c client.Client, obj *unstructured.Unstructured, force bool) error {
+	if force && len(obj.GetFinalizers()) > 0 {
+		patch := client.MergeFrom(obj.DeepCopy())
+		obj.SetFinalizers(nil)
+		if err := c.Patch(ctx, obj, patch); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, obj))
+}
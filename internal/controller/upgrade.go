@@ -0,0 +1,603 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// defaultCanarySoakPeriod is used when spec.upgrade.canary.soakPeriod is not set
+const defaultCanarySoakPeriod = 10 * time.Minute
+
+// reconcileNodeServerRollout rolls out image changes to an existing node-server DaemonSet.
+// Without a canary configuration the DaemonSet is updated in place and the regular rolling
+// update takes over. With a canary configuration the DaemonSet is switched to the OnDelete
+// strategy, only the pods on the canary nodes are restarted, and the rolling update is
+// resumed once the canary pods stayed healthy for the soak period. Changes made to the pod
+// template outside of the Deployer are reverted, nothing is rolled out in Warn remediation.
+// Rollouts only start in the maintenance windows of the Deployer, once the MachineDeployments
+// of its node pools finished rolling out.
+func (r *DeployerReconciler) reconcileNodeServerRollout(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, found *appsv1.DaemonSet) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if !remediationEnforced(deployer) {
+		return ctrl.Result{}, nil
+	}
+
+	desired, err := r.daemonSetForDeployer(deployer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := preserveIgnoredFields(deployer, "DaemonSet", found, desired); err != nil {
+		return ctrl.Result{}, err
+	}
+	targetImage := nodeServerImage(&desired.Spec.Template)
+
+	if deployer.Status.Upgrade != nil {
+		return r.reconcileCanary(ctx, deployer, found, desired)
+	}
+	if allowed, err := r.allowDisruptiveChange(deployer, "DaemonSet", found, desired); err != nil || !allowed {
+		return ctrl.Result{}, err
+	}
+	if held, err := r.holdRolloutForNodePools(ctx, deployer, found, desired); err != nil || held {
+		return ctrl.Result{}, err
+	}
+
+	if podTemplateImagesEqual(&found.Spec.Template, &desired.Spec.Template) {
+		drifted, err := podTemplateDrifted(found, desired)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if podTemplateHashEqual(&found.Spec.Template, &desired.Spec.Template) && !drifted {
+			return ctrl.Result{}, nil
+		}
+		// Configuration changes are rolled out right away, only new images go through
+		// the upgrade policy and the canary nodes.
+		log.Info("Rolling out configuration changes to the DaemonSet",
+			"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name)
+		applyPodTemplate(&found.Spec.Template, &desired.Spec.Template, found.Spec.Selector)
+		mergeObjectMetadata(found, desired)
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update DaemonSet",
+				"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if held, err := r.holdUpgrade(ctx, deployer); err != nil || held {
+		return ctrl.Result{}, err
+	}
+
+	// Refuse to roll out operand versions not supporting the Kubernetes version of the cluster
+	failure, err := r.checkKubernetesVersion(targetImage)
+	if err != nil {
+		log.Error(err, "Failed to check the Kubernetes version")
+		return ctrl.Result{}, err
+	}
+	if failure != nil {
+		log.Info("Upgrade blocked", "Reason", failure.reason, "Message", failure.message)
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typePreflightDeployer,
+			Status: metav1.ConditionFalse, Reason: failure.reason, Message: failure.message})
+		return ctrl.Result{}, nil
+	}
+
+	canary := canarySpecForDeployer(deployer)
+	if canary == nil {
+		log.Info("Rolling out new images to the DaemonSet",
+			"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name, "Image", targetImage)
+		applyPodTemplate(&found.Spec.Template, &desired.Spec.Template, found.Spec.Selector)
+		mergeObjectMetadata(found, desired)
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update DaemonSet",
+				"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	canaryNodes, err := r.canaryNodesForDeployer(ctx, deployer, canary)
+	if err != nil {
+		log.Error(err, "Failed to select the canary nodes")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Starting canary rollout of new images",
+		"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name,
+		"Image", targetImage, "CanaryNodes", canaryNodes)
+	found.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType}
+	applyPodTemplate(&found.Spec.Template, &desired.Spec.Template, found.Spec.Selector)
+	mergeObjectMetadata(found, desired)
+	if err := r.Update(ctx, found); err != nil {
+		log.Error(err, "Failed to update DaemonSet",
+			"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name)
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	deployer.Status.Upgrade = &cachev1alpha1.UpgradeStatus{
+		Phase:       cachev1alpha1.UpgradePhaseCanary,
+		TargetImage: targetImage,
+		CanaryNodes: canaryNodes,
+		StartTime:   &now,
+	}
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		log.Error(err, "Failed to update Deployer status")
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(deployer, "Normal", "CanaryStarted",
+		fmt.Sprintf("Rolling out %s to canary nodes %s", targetImage, strings.Join(canaryNodes, ", ")))
+
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// reconcileCanary drives an ongoing canary rollout recorded in the Deployer status.
+func (r *DeployerReconciler) reconcileCanary(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	found, desired *appsv1.DaemonSet) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	upgrade := deployer.Status.Upgrade
+	targetImage := nodeServerImage(&desired.Spec.Template)
+
+	// A new target image, or removing the canary configuration, supersedes the current rollout.
+	canary := canarySpecForDeployer(deployer)
+	if targetImage != upgrade.TargetImage || canary == nil {
+		log.Info("Canary rollout superseded", "PreviousImage", upgrade.TargetImage, "Image", targetImage)
+		if canary == nil {
+			found.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}
+			applyPodTemplate(&found.Spec.Template, &desired.Spec.Template, found.Spec.Selector)
+			mergeObjectMetadata(found, desired)
+			if err := r.Update(ctx, found); err != nil {
+				log.Error(err, "Failed to update DaemonSet",
+					"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		return r.finishCanary(ctx, deployer)
+	}
+
+	if upgrade.Phase == cachev1alpha1.UpgradePhaseHalted {
+		// Stay on the old images on the remaining nodes until the user acts.
+		return ctrl.Result{}, nil
+	}
+
+	pods, err := r.podsForDaemonSet(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to list the DaemonSet pods")
+		return ctrl.Result{}, err
+	}
+
+	// Restart the pods of the canary nodes which still run the old images
+	canaryNodes := map[string]bool{}
+	for _, node := range upgrade.CanaryNodes {
+		canaryNodes[node] = true
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if !canaryNodes[pod.Spec.NodeName] || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if podImage(pod, "node-server") != targetImage {
+			log.Info("Restarting canary pod", "Pod.Name", pod.Name, "Node", pod.Spec.NodeName)
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "Failed to delete canary pod", "Pod.Name", pod.Name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	soakPeriod := defaultCanarySoakPeriod
+	if canary.SoakPeriod != nil {
+		soakPeriod = canary.SoakPeriod.Duration
+	}
+	if remaining := time.Until(upgrade.StartTime.Add(soakPeriod)); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	if unhealthy := unhealthyCanaryNodes(pods, upgrade.CanaryNodes, targetImage); len(unhealthy) > 0 {
+		message := fmt.Sprintf("Canary rollout of %s halted, unhealthy canary nodes: %s",
+			targetImage, strings.Join(unhealthy, ", "))
+		log.Info(message)
+		upgrade.Phase = cachev1alpha1.UpgradePhaseHalted
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeDegradedDeployer,
+			Status: metav1.ConditionTrue, Reason: "CanaryFailed", Message: message})
+		if err := r.Status().Update(ctx, deployer); err != nil {
+			log.Error(err, "Failed to update Deployer status")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(deployer, "Warning", "CanaryFailed", message)
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("Canary nodes healthy, rolling out to the remaining nodes", "Image", targetImage)
+	found.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}
+	if err := r.Update(ctx, found); err != nil {
+		log.Error(err, "Failed to update DaemonSet",
+			"DaemonSet.Namespace", found.Namespace, "DaemonSet.Name", found.Name)
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(deployer, "Normal", "CanaryPromoted",
+		fmt.Sprintf("Canary nodes healthy, rolling out %s to the remaining nodes", targetImage))
+	return r.finishCanary(ctx, deployer)
+}
+
+// finishCanary clears the rollout status and the Degraded condition set by a halted canary.
+func (r *DeployerReconciler) finishCanary(ctx context.Context, deployer *cachev1alpha1.Deployer) (ctrl.Result, error) {
+	deployer.Status.Upgrade = nil
+	if cond := meta.FindStatusCondition(deployer.Status.Conditions, typeDegradedDeployer); cond != nil && cond.Reason == "CanaryFailed" {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeDegradedDeployer)
+	}
+	if err := r.Status().Update(ctx, deployer); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update Deployer status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// updateInstalledVersion records the DirectPV version running on the node-server pods and
+// sets the UpgradeAvailable condition when it differs from the version bundled with the operator.
+// While a rollout is in progress the version running on most of the pods is reported.
+func (r *DeployerReconciler) updateInstalledVersion(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, daemonSet *appsv1.DaemonSet) error {
+	pods, err := r.podsForDaemonSet(ctx, daemonSet)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for i := range pods {
+		if image := podImage(&pods[i], "node-server"); image != "" {
+			counts[imageVersion(image)]++
+		}
+	}
+	installed := ""
+	for version, count := range counts {
+		if count > counts[installed] || (count == counts[installed] && version < installed) {
+			installed = version
+		}
+	}
+	if installed == "" {
+		return nil
+	}
+	deployer.Status.InstalledVersion = installed
+
+	bundledImage, err := r.imageForDeployer(deployer)
+	if err != nil {
+		return err
+	}
+	if bundled := imageVersion(bundledImage); bundled != installed {
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeUpgradeAvailableDeployer,
+			Status: metav1.ConditionTrue, Reason: "NewVersion",
+			Message: fmt.Sprintf("DirectPV %s is available, %s is installed", bundled, installed)})
+	} else {
+		meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeUpgradeAvailableDeployer,
+			Status: metav1.ConditionFalse, Reason: "UpToDate",
+			Message: fmt.Sprintf("DirectPV %s is installed", installed)})
+	}
+	return nil
+}
+
+// imageVersion returns the tag of the image reference, or its digest when it is not tagged
+func imageVersion(image string) string {
+	name, digest, pinned := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[i+1:]
+	}
+	if pinned {
+		return digest
+	}
+	return "latest"
+}
+
+// canarySpecForDeployer returns the canary configuration of the Deployer, if any
+func canarySpecForDeployer(deployer *cachev1alpha1.Deployer) *cachev1alpha1.CanarySpec {
+	if deployer.Spec.Upgrade == nil {
+		return nil
+	}
+	return deployer.Spec.Upgrade.Canary
+}
+
+// canaryNodesForDeployer returns the sorted names of the canary nodes among the nodes of the Deployer.
+func (r *DeployerReconciler) canaryNodesForDeployer(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, canary *cachev1alpha1.CanarySpec) ([]string, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList, client.MatchingLabels(deployer.Spec.NodeSelector)); err != nil {
+		return nil, err
+	}
+
+	selector := labels.Everything()
+	if canary.NodeSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(canary.NodeSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	// The nodes of the architectures overriding the image are not updated by the canary
+	archs := map[string]bool{}
+	for _, arch := range defaultImageArchitectures(deployer) {
+		archs[arch] = true
+	}
+	var nodes []string
+	for _, node := range nodeList.Items {
+		if archs[node.Labels[corev1.LabelArchStable]] && nodeSelectedForDeployer(deployer, &node) &&
+			selector.Matches(labels.Set(node.Labels)) {
+			nodes = append(nodes, node.Name)
+		}
+	}
+	sort.Strings(nodes)
+
+	if canary.NodeSelector == nil && len(nodes) > 0 {
+		// Without a selector or percentage a single node is used as canary
+		count := 1
+		if canary.Percentage > 0 {
+			count = (len(nodes)*int(canary.Percentage) + 99) / 100
+		}
+		nodes = nodes[:count]
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no canary nodes found for Deployer %s", deployer.Name)
+	}
+	return nodes, nil
+}
+
+// podsForDaemonSet lists the pods managed by the given DaemonSet. The selector of the
+// node-server DaemonSet also matches the pods of the architecture DaemonSets, only the pods
+// it controls are returned.
+func (r *DeployerReconciler) podsForDaemonSet(ctx context.Context, daemonSet *appsv1.DaemonSet) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(daemonSet.Namespace),
+		client.MatchingLabels(daemonSet.Spec.Selector.MatchLabels)); err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for i := range podList.Items {
+		if metav1.IsControlledBy(&podList.Items[i], daemonSet) {
+			pods = append(pods, podList.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+// unhealthyCanaryNodes returns the canary nodes without a ready pod running the target
+// image, or whose pod restarted during the soak period.
+func unhealthyCanaryNodes(pods []corev1.Pod, canaryNodes []string, targetImage string) []string {
+	healthy := map[string]bool{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || podImage(pod, "node-server") != targetImage {
+			continue
+		}
+		ready, restarted := false, false
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				ready = true
+			}
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.RestartCount > 0 {
+				restarted = true
+			}
+		}
+		if ready && !restarted {
+			healthy[pod.Spec.NodeName] = true
+		}
+	}
+
+	var unhealthy []string
+	for _, node := range canaryNodes {
+		if !healthy[node] {
+			unhealthy = append(unhealthy, node)
+		}
+	}
+	return unhealthy
+}
+
+// nodeServerImage returns the image of the node-server container of the pod template
+func nodeServerImage(template *corev1.PodTemplateSpec) string {
+	for _, container := range template.Spec.Containers {
+		if container.Name == "node-server" {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// podImage returns the image of the named container of the pod
+func podImage(pod *corev1.Pod, name string) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// reconcileControllerRollout rolls out changes of the controller Deployment pod template. It
+// waits while an upgrade is pending approval or a node-server upgrade is in progress so both
+// workloads move to a new version together. Changes made to the pod template outside of the Deployer are
+// reverted, nothing is rolled out in Warn remediation or outside of the maintenance windows.
+func (r *DeployerReconciler) reconcileControllerRollout(ctx context.Context,
+	deployer *cachev1alpha1.Deployer, found *appsv1.Deployment) (ctrl.Result, error) {
+	if !remediationEnforced(deployer) || deployer.Status.Upgrade != nil {
+		return ctrl.Result{}, nil
+	}
+	if held, err := r.holdUpgrade(ctx, deployer); err != nil || held {
+		return ctrl.Result{}, err
+	}
+
+	desired, err := r.deploymentForDeployer(deployer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := preserveIgnoredFields(deployer, "Deployment", found, desired); err != nil {
+		return ctrl.Result{}, err
+	}
+	if allowed, err := r.allowDisruptiveChange(deployer, "Deployment", found, desired); err != nil || !allowed {
+		return ctrl.Result{}, err
+	}
+	drifted, err := podTemplateDrifted(found, desired)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if podTemplateImagesEqual(&found.Spec.Template, &desired.Spec.Template) &&
+		podTemplateHashEqual(&found.Spec.Template, &desired.Spec.Template) && !drifted {
+		return ctrl.Result{}, nil
+	}
+
+	log.FromContext(ctx).Info("Rolling out changes to the Deployment",
+		"Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+	applyPodTemplate(&found.Spec.Template, &desired.Spec.Template, found.Spec.Selector)
+	mergeObjectMetadata(found, desired)
+	if err := r.Update(ctx, found); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update Deployment",
+			"Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// holdUpgrade reports whether the image changes of the operand workloads wait for approval
+// in Manual upgrade policy. The approval is the hash of the images of all the containers of
+// the node-server DaemonSets and of the controller Deployment so that new sidecar or
+// controller images are approved like a new DirectPV image. The pending images are reported
+// in status.pendingImages and by the UpgradePending condition, persisted with the other
+// status changes at the end of the reconciliation.
+func (r *DeployerReconciler) holdUpgrade(ctx context.Context, deployer *cachev1alpha1.Deployer) (bool, error) {
+	workloads, err := r.operandWorkloads(deployer)
+	if err != nil {
+		return false, err
+	}
+	pending := false
+	var images []string
+	for _, desired := range workloads {
+		live := desired.DeepCopyObject().(client.Object)
+		err := r.Get(ctx, client.ObjectKeyFromObject(desired), live)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		// New workloads are created with the desired images
+		if err == nil && !podTemplateImagesEqual(podTemplate(live), podTemplate(desired)) {
+			pending = true
+		}
+		template := podTemplate(desired)
+		for _, container := range append(template.Spec.InitContainers, template.Spec.Containers...) {
+			images = append(images, fmt.Sprintf("%s/%s=%s", desired.GetName(), container.Name, container.Image))
+		}
+	}
+	sort.Strings(images)
+	hash := operandImagesHash(images)
+
+	if !pending || deployer.Spec.UpgradePolicy != cachev1alpha1.UpgradePolicyManual || deployer.Spec.ApprovedImage == hash {
+		meta.RemoveStatusCondition(&deployer.Status.Conditions, typeUpgradePendingDeployer)
+		deployer.Status.PendingImages = nil
+		return false, nil
+	}
+	if !meta.IsStatusConditionTrue(deployer.Status.Conditions, typeUpgradePendingDeployer) ||
+		!reflect.DeepEqual(deployer.Status.PendingImages, images) {
+		log.FromContext(ctx).Info("Upgrade pending approval", "Hash", hash, "Images", images)
+		r.Recorder.Event(deployer, "Normal", "UpgradePending",
+			fmt.Sprintf("Upgrade to the operand images %s is pending approval", hash))
+	}
+	deployer.Status.PendingImages = images
+	meta.SetStatusCondition(&deployer.Status.Conditions, metav1.Condition{Type: typeUpgradePendingDeployer,
+		Status: metav1.ConditionTrue, Reason: "AwaitingApproval",
+		Message: fmt.Sprintf("Upgrade to the operand images listed in status.pendingImages is pending, "+
+			"set spec.approvedImage to %s to approve it", hash)})
+	return true, nil
+}
+
+// operandWorkloads returns the desired node-server DaemonSets and controller Deployment of the Deployer
+func (r *DeployerReconciler) operandWorkloads(deployer *cachev1alpha1.Deployer) ([]client.Object, error) {
+	daemonSet, err := r.daemonSetForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	workloads := []client.Object{daemonSet}
+	for _, arch := range archImageOverrides(deployer) {
+		archDaemonSet, err := r.archDaemonSetForDeployer(deployer, arch)
+		if err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, archDaemonSet)
+	}
+	deployment, err := r.deploymentForDeployer(deployer)
+	if err != nil {
+		return nil, err
+	}
+	return append(workloads, deployment), nil
+}
+
+// operandImagesHash returns the hash of the sorted operand images approving their rollout
+func operandImagesHash(images []string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(images, "\n"))))[:16]
+}
+
+// podTemplateHashEqual reports whether both pod templates were rendered from the same configuration
+func podTemplateHashEqual(a, b *corev1.PodTemplateSpec) bool {
+	return a.Annotations[podTemplateHashAnnotation] == b.Annotations[podTemplateHashAnnotation]
+}
+
+// podTemplateImagesEqual reports whether the containers of both pod templates run the same
+// images. Containers present in only one of them, like added sidecars, are configuration
+// changes and are not compared.
+func podTemplateImagesEqual(a, b *corev1.PodTemplateSpec) bool {
+	images := map[string]string{}
+	for _, container := range a.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	for _, container := range b.Spec.Containers {
+		if image, found := images[container.Name]; found && image != container.Image {
+			return false
+		}
+	}
+	return true
+}
+
+// applyPodTemplate copies the desired pod template into the live one. The labels required
+// by the immutable selector of the live object are kept so the update is always accepted.
+func applyPodTemplate(live, desired *corev1.PodTemplateSpec, selector *metav1.LabelSelector) {
+	podLabels := map[string]string{}
+	for k, v := range desired.Labels {
+		podLabels[k] = v
+	}
+	if selector != nil {
+		for k, v := range selector.MatchLabels {
+			podLabels[k] = v
+		}
+	}
+	live.Labels = podLabels
+	live.Annotations = desired.Annotations
+	live.Spec = desired.Spec
+}
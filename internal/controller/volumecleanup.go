@@ -0,0 +1,136 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// volumeReleasedSinceAnnotation records when a DirectPV volume was first found released
+const volumeReleasedSinceAnnotation = "cache.example.com/released-since"
+
+// defaultVolumeCleanupTTL is used when spec.volumeCleanup.ttl is not set
+const defaultVolumeCleanupTTL = 24 * time.Hour
+
+// volumeCleanupTTLForDeployer returns how long volumes stay released before being purged
+func volumeCleanupTTLForDeployer(deployer *cachev1alpha1.Deployer) time.Duration {
+	if deployer.Spec.VolumeCleanup.TTL != nil {
+		return deployer.Spec.VolumeCleanup.TTL.Duration
+	}
+	return defaultVolumeCleanupTTL
+}
+
+// volumeReleased reports whether the PersistentVolume of the DirectPV volume was deleted or
+// released by its claim
+func volumeReleased(ctx context.Context, c client.Client, volumeName string) (bool, error) {
+	pv := &corev1.PersistentVolume{}
+	err := c.Get(ctx, types.NamespacedName{Name: volumeName}, pv)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pv.Status.Phase == corev1.VolumeReleased, nil
+}
+
+// purgeReleasedVolumes deletes the DirectPV volumes of the nodes of the Deployer released for
+// longer than the TTL. DirectPV then removes their directories and returns their capacity to
+// the drives. Released volumes are annotated with the time they were first found released,
+// the annotation is dropped when the volume is bound again. It returns the delay until the
+// next released volume expires, zero if none is pending.
+func (r *DeployerReconciler) purgeReleasedVolumes(ctx context.Context, deployer *cachev1alpha1.Deployer) (time.Duration, error) {
+	if deployer.Spec.VolumeCleanup == nil || !deployer.Spec.VolumeCleanup.Enabled {
+		return 0, nil
+	}
+	log := log.FromContext(ctx)
+
+	nodes, err := r.nodeNamesForDeployer(ctx, deployer)
+	if err != nil {
+		return 0, err
+	}
+	volumes, err := r.listDirectPVObjects(ctx, directPVVolumeGVK, nodes)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl := volumeCleanupTTLForDeployer(deployer)
+	now := time.Now()
+	var next time.Duration
+	for i := range volumes {
+		volume := &volumes[i]
+		if !volume.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		released, err := volumeReleased(ctx, r.Client, volume.GetName())
+		if err != nil {
+			return 0, err
+		}
+		since, annotated := volume.GetAnnotations()[volumeReleasedSinceAnnotation]
+		if !released {
+			if annotated {
+				patch := client.MergeFrom(volume.DeepCopy())
+				unstructured.RemoveNestedField(volume.Object, "metadata", "annotations", volumeReleasedSinceAnnotation)
+				if err := r.Patch(ctx, volume, patch); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		}
+
+		releasedAt, err := time.Parse(time.RFC3339, since)
+		if !annotated || err != nil {
+			releasedAt = now
+			patch := client.MergeFrom(volume.DeepCopy())
+			annotations := volume.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[volumeReleasedSinceAnnotation] = releasedAt.UTC().Format(time.RFC3339)
+			volume.SetAnnotations(annotations)
+			if err := r.Patch(ctx, volume, patch); err != nil {
+				return 0, err
+			}
+		}
+		if remaining := releasedAt.Add(ttl).Sub(now); remaining > 0 {
+			if next == 0 || remaining < next {
+				next = remaining
+			}
+			continue
+		}
+
+		log.Info("Purging released DirectPV volume", "Volume", volume.GetName(),
+			"Node", volume.GetLabels()["directpv.min.io/node"])
+		if err := client.IgnoreNotFound(r.Delete(ctx, volume)); err != nil {
+			return 0, err
+		}
+		r.Recorder.Event(deployer, "Normal", "ReleasedVolumePurged",
+			fmt.Sprintf("Purged the volume %s released since %s", volume.GetName(), releasedAt.UTC().Format(time.RFC3339)))
+	}
+	return next, nil
+}
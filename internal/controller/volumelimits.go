@@ -0,0 +1,46 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// controllerVolumeLimitArgs returns the controller arguments of the volume limits, the
+// controller refuses to provision volumes beyond them
+func controllerVolumeLimitArgs(limits *cachev1alpha1.VolumeLimitsSpec) []string {
+	if limits == nil {
+		return nil
+	}
+	var args []string
+	if limits.MaxPerDrive != nil {
+		args = append(args, fmt.Sprintf("--max-volumes-per-drive=%d", *limits.MaxPerDrive))
+	}
+	return append(args, nodeServerVolumeLimitArgs(limits)...)
+}
+
+// nodeServerVolumeLimitArgs returns the node-server arguments of the volume limits. The
+// node-server reports the limit of the node in NodeGetInfo, the kubelet publishes it in the
+// allocatable count of the CSINode when the node-server registers.
+func nodeServerVolumeLimitArgs(limits *cachev1alpha1.VolumeLimitsSpec) []string {
+	if limits == nil || limits.MaxPerNode == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("--max-volumes-per-node=%d", *limits.MaxPerNode)}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1alpha1 "github.com/example/directpv-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// volumeCopyScript copies the data of a volume directory to another one, preserving owners,
+// modes and timestamps
+const volumeCopyScript = `set -e
+mkdir -p "$2"
+cp -a "$1/." "$2/"`
+
+// volumeRemoveScript removes the data of a volume directory
+const volumeRemoveScript = `rm -rf "$1"`
+
+// driveVolumeFinalizerPrefix prefixes the finalizers DirectPV adds to a drive for its volumes
+const driveVolumeFinalizerPrefix = "directpv.min.io.volume/"
+
+// volumeMigrationPollInterval is how often the migration Jobs are checked
+const volumeMigrationPollInterval = 10 * time.Second
+
+// VolumeMigrationReconciler moves DirectPV volumes between the drives of a node
+type VolumeMigrationReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// DefaultImages are the operand images loaded from the manager's config file
+	DefaultImages configv1alpha1.OperandImages
+}
+
+//+kubebuilder:rbac:groups=cache.example.com,resources=volumemigrations,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cache.example.com,resources=volumemigrations/status,verbs=get;update;patch
+
+// Reconcile moves the volume of the VolumeMigration once no pod uses it: a Job copies its
+// data to the target drive, the DirectPV volume and drives are switched to the target drive
+// and a second Job removes the data from the source drive.
+func (r *VolumeMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	migration := &cachev1alpha1.VolumeMigration{}
+	if err := r.Get(ctx, req.NamespacedName, migration); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	switch migration.Status.Phase {
+	case cachev1alpha1.VolumeMigrationPhaseCompleted, cachev1alpha1.VolumeMigrationPhaseFailed:
+		return ctrl.Result{}, nil
+	}
+
+	before := migration.Status.Phase
+	requeueAfter, err := r.migrate(ctx, migration)
+	if err != nil {
+		log.Error(err, "Failed to migrate the volume")
+		migration.Status.Message = err.Error()
+	}
+	if migration.Status.Phase != before {
+		switch migration.Status.Phase {
+		case cachev1alpha1.VolumeMigrationPhaseCompleted:
+			r.Recorder.Event(migration, "Normal", "VolumeMigrated", fmt.Sprintf("Moved the volume %s from the drive %s to %s",
+				migration.Spec.Volume, migration.Status.SourceDrive, migration.Spec.TargetDrive))
+		case cachev1alpha1.VolumeMigrationPhaseFailed:
+			r.Recorder.Event(migration, "Warning", "VolumeMigrationFailed", migration.Status.Message)
+		}
+	}
+	if updateErr := r.Status().Update(ctx, migration); updateErr != nil {
+		log.Error(updateErr, "Failed to update VolumeMigration status")
+		return ctrl.Result{}, updateErr
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, err
+}
+
+// failVolumeMigration moves the VolumeMigration to the Failed phase
+func failVolumeMigration(migration *cachev1alpha1.VolumeMigration, format string, args ...interface{}) {
+	migration.Status.Phase = cachev1alpha1.VolumeMigrationPhaseFailed
+	migration.Status.Message = fmt.Sprintf(format, args...)
+}
+
+// migrate runs the next step of the VolumeMigration and returns when to check it again
+func (r *VolumeMigrationReconciler) migrate(ctx context.Context, migration *cachev1alpha1.VolumeMigration) (time.Duration, error) {
+	status := &migration.Status
+	volume := &unstructured.Unstructured{}
+	volume.SetGroupVersionKind(directPVVolumeGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: migration.Spec.Volume}, volume); err != nil {
+		if apierrors.IsNotFound(err) {
+			failVolumeMigration(migration, "the DirectPV volume %s does not exist", migration.Spec.Volume)
+			return 0, nil
+		}
+		return 0, err
+	}
+	if status.Phase == "" {
+		status.Phase = cachev1alpha1.VolumeMigrationPhasePending
+		status.Node = volume.GetLabels()["directpv.min.io/node"]
+		status.SourceDrive = volume.GetLabels()["directpv.min.io/drive"]
+	}
+
+	deployer, err := deployerForNode(ctx, r.Client, migration.Namespace, status.Node)
+	if err != nil {
+		return 0, err
+	}
+	if deployer == nil {
+		failVolumeMigration(migration, "no Deployer of the namespace runs DirectPV on the node %s", status.Node)
+		return 0, nil
+	}
+
+	switch status.Phase {
+	case cachev1alpha1.VolumeMigrationPhaseCleaningUp:
+		job, err := r.getJob(ctx, migration, "cleanup")
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case job == nil:
+		case jobConditionTrue(job, batchv1.JobComplete):
+			status.Phase = cachev1alpha1.VolumeMigrationPhaseCompleted
+			status.Message = "The volume was moved to the target drive"
+			return 0, nil
+		case jobConditionTrue(job, batchv1.JobFailed):
+			failVolumeMigration(migration, "the volume was moved but its data could not be removed from the "+
+				"source drive, check the logs of the Job %s", job.Name)
+			return 0, nil
+		}
+		return volumeMigrationPollInterval, nil
+	}
+
+	source, target, err := r.migrationDrives(ctx, migration, volume)
+	if err != nil || source == nil || target == nil {
+		return 0, err
+	}
+	if publishedVolume(volume) {
+		if status.Phase == cachev1alpha1.VolumeMigrationPhaseCopying {
+			failVolumeMigration(migration, "the volume was used by a pod while being copied, its data stays on the source drive")
+			return 0, nil
+		}
+		status.Message = "Waiting for the pods using the volume to stop"
+		return time.Minute, nil
+	}
+
+	// The volume is already switched when the cleanup Job could not be created
+	switched := volume.GetLabels()["directpv.min.io/drive"] == target.GetName()
+	sourcePath, targetPath, err := volumeMigrationPaths(volume, source, target)
+	if switched {
+		targetPath, sourcePath, err = volumeMigrationPaths(volume, target, source)
+	}
+	if err != nil {
+		failVolumeMigration(migration, "%s", err)
+		return 0, nil
+	}
+	if status.Phase == cachev1alpha1.VolumeMigrationPhasePending {
+		if err := r.createJob(ctx, migration, deployer, "copy", volumeCopyScript, sourcePath, targetPath); err != nil {
+			return 0, err
+		}
+		status.Phase = cachev1alpha1.VolumeMigrationPhaseCopying
+		status.Message = "Copying the data of the volume to the target drive"
+		return volumeMigrationPollInterval, nil
+	}
+
+	job, err := r.getJob(ctx, migration, "copy")
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case job == nil:
+		failVolumeMigration(migration, "the copy Job was deleted before completing")
+		return 0, nil
+	case jobConditionTrue(job, batchv1.JobFailed):
+		failVolumeMigration(migration, "the data of the volume could not be copied, check the logs of the Job %s", job.Name)
+		return 0, nil
+	case !jobConditionTrue(job, batchv1.JobComplete):
+		return volumeMigrationPollInterval, nil
+	}
+
+	if !switched {
+		if err := r.switchDrive(ctx, volume, source, target); err != nil {
+			return 0, err
+		}
+	}
+	if err := r.createJob(ctx, migration, deployer, "cleanup", volumeRemoveScript, sourcePath); err != nil {
+		return 0, err
+	}
+	status.Phase = cachev1alpha1.VolumeMigrationPhaseCleaningUp
+	status.Message = "Removing the data of the volume from the source drive"
+	return volumeMigrationPollInterval, nil
+}
+
+// migrationDrives returns the source and target DirectPV drives of the migration, failing it
+// when the target drive cannot hold the volume
+func (r *VolumeMigrationReconciler) migrationDrives(ctx context.Context, migration *cachev1alpha1.VolumeMigration,
+	volume *unstructured.Unstructured) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	if migration.Spec.TargetDrive == migration.Status.SourceDrive {
+		failVolumeMigration(migration, "the volume is already on the drive %s", migration.Spec.TargetDrive)
+		return nil, nil, nil
+	}
+	drives := map[string]*unstructured.Unstructured{}
+	for _, name := range []string{migration.Status.SourceDrive, migration.Spec.TargetDrive} {
+		drive := &unstructured.Unstructured{}
+		drive.SetGroupVersionKind(directPVDriveGVK)
+		if err := r.Get(ctx, types.NamespacedName{Name: name}, drive); err != nil {
+			if apierrors.IsNotFound(err) {
+				failVolumeMigration(migration, "the DirectPV drive %s does not exist", name)
+				return nil, nil, nil
+			}
+			return nil, nil, err
+		}
+		drives[name] = drive
+	}
+	source, target := drives[migration.Status.SourceDrive], drives[migration.Spec.TargetDrive]
+
+	if node := target.GetLabels()["directpv.min.io/node"]; node != migration.Status.Node {
+		failVolumeMigration(migration, "the drive %s is on the node %s, not on the node %s of the volume",
+			target.GetName(), node, migration.Status.Node)
+		return nil, nil, nil
+	}
+	if driveStatus, _, _ := unstructured.NestedString(target.Object, "status", "status"); driveStatus != "Ready" {
+		failVolumeMigration(migration, "the drive %s is %s", target.GetName(), driveStatus)
+		return nil, nil, nil
+	}
+	if migration.Status.Phase == cachev1alpha1.VolumeMigrationPhasePending {
+		size, _, _ := unstructured.NestedInt64(volume.Object, "status", "totalCapacity")
+		free, _, _ := unstructured.NestedInt64(target.Object, "status", "freeCapacity")
+		if free < size {
+			failVolumeMigration(migration, "the drive %s has %d bytes free, the volume needs %d", target.GetName(), free, size)
+			return nil, nil, nil
+		}
+	}
+	return source, target, nil
+}
+
+// publishedVolume reports whether the volume is staged or published for a pod
+func publishedVolume(volume *unstructured.Unstructured) bool {
+	stagingTargetPath, _, _ := unstructured.NestedString(volume.Object, "status", "stagingTargetPath")
+	targetPath, _, _ := unstructured.NestedString(volume.Object, "status", "targetPath")
+	return stagingTargetPath != "" || targetPath != ""
+}
+
+// volumeMigrationPaths returns the directories of the volume on the drive it is on and on the
+// other drive, within the data directory of the host mounted as /host by the migration Jobs.
+// The other directory is the current one on the drive mount of the other drive.
+func volumeMigrationPaths(volume, source, target *unstructured.Unstructured) (string, string, error) {
+	dataPath, _, _ := unstructured.NestedString(volume.Object, "status", "dataPath")
+	sourceFSUUID, _, _ := unstructured.NestedString(source.Object, "status", "fsuuid")
+	targetFSUUID, _, _ := unstructured.NestedString(target.Object, "status", "fsuuid")
+	relativePath := strings.TrimPrefix(path.Clean(dataPath), path.Clean(defaultDataPath)+"/")
+	if relativePath == path.Clean(dataPath) || sourceFSUUID == "" || targetFSUUID == "" ||
+		!strings.Contains(relativePath, sourceFSUUID) {
+		return "", "", fmt.Errorf("the data path %s of the volume is not on the drive %s", dataPath, source.GetName())
+	}
+	return path.Join("/host", relativePath), path.Join("/host", strings.ReplaceAll(relativePath, sourceFSUUID, targetFSUUID)), nil
+}
+
+// switchDrive moves the DirectPV volume to the target drive: the capacity of the volume, and
+// the finalizer protecting it, are moved from the source drive to the target drive
+func (r *VolumeMigrationReconciler) switchDrive(ctx context.Context, volume, source, target *unstructured.Unstructured) error {
+	size, _, _ := unstructured.NestedInt64(volume.Object, "status", "totalCapacity")
+	finalizer := driveVolumeFinalizerPrefix + volume.GetName()
+	for _, drive := range []*unstructured.Unstructured{source, target} {
+		patch := client.MergeFrom(drive.DeepCopy())
+		delta := size
+		if drive == source {
+			delta = -size
+			controllerutil.RemoveFinalizer(drive, finalizer)
+		} else {
+			controllerutil.AddFinalizer(drive, finalizer)
+		}
+		allocated, _, _ := unstructured.NestedInt64(drive.Object, "status", "allocatedCapacity")
+		free, _, _ := unstructured.NestedInt64(drive.Object, "status", "freeCapacity")
+		if err := unstructured.SetNestedField(drive.Object, allocated+delta, "status", "allocatedCapacity"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(drive.Object, free-delta, "status", "freeCapacity"); err != nil {
+			return err
+		}
+		if err := r.Patch(ctx, drive, patch); err != nil {
+			return err
+		}
+	}
+
+	dataPath, _, _ := unstructured.NestedString(volume.Object, "status", "dataPath")
+	sourceFSUUID, _, _ := unstructured.NestedString(source.Object, "status", "fsuuid")
+	targetFSUUID, _, _ := unstructured.NestedString(target.Object, "status", "fsuuid")
+	patch := client.MergeFrom(volume.DeepCopy())
+	volumeLabels := volume.GetLabels()
+	volumeLabels["directpv.min.io/drive"] = target.GetName()
+	if driveName, found := target.GetLabels()["directpv.min.io/drive-name"]; found {
+		volumeLabels["directpv.min.io/drive-name"] = driveName
+	}
+	volume.SetLabels(volumeLabels)
+	if err := unstructured.SetNestedField(volume.Object, targetFSUUID, "status", "fsuuid"); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(volume.Object, strings.ReplaceAll(dataPath, sourceFSUUID, targetFSUUID),
+		"status", "dataPath"); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Switching DirectPV volume to the target drive", "Volume", volume.GetName(),
+		"Source", source.GetName(), "Target", target.GetName())
+	return r.Patch(ctx, volume, patch)
+}
+
+// migrationJobName returns the name of a Job of the VolumeMigration
+func migrationJobName(migration *cachev1alpha1.VolumeMigration, step string) string {
+	return fmt.Sprintf("%s-%s", migration.Name, step)
+}
+
+// getJob returns the Job of the given step of the VolumeMigration, nil when it does not exist
+func (r *VolumeMigrationReconciler) getJob(ctx context.Context, migration *cachev1alpha1.VolumeMigration,
+	step string) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: migrationJobName(migration, step), Namespace: migration.Namespace}, job)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// createJob creates the Job of the given step of the VolumeMigration on the node of the volume,
+// it runs the script with the data directory of the host mounted as /host
+func (r *VolumeMigrationReconciler) createJob(ctx context.Context, migration *cachev1alpha1.VolumeMigration,
+	deployer *cachev1alpha1.Deployer, step, script string, args ...string) error {
+	defaultImage, err := imageFromEnv("DIRECTPV_IMAGE", r.DefaultImages.DirectPV)
+	if err != nil {
+		return err
+	}
+	image, err := imageForNode(ctx, r.Client, deployer, migration.Status.Node, defaultImage)
+	if err != nil {
+		return err
+	}
+	command := append([]string{"/bin/sh", "-c", script, step}, args...)
+	job := nodeJobForDeployer(deployer, migrationJobName(migration, step), "directpv-volume-"+step,
+		migration.Status.Node, image, path.Clean(dataPathForDeployer(deployer)), corev1.MountPropagationHostToContainer, command)
+	job.Labels["app.kubernetes.io/instance"] = migration.Name
+	if err := ctrl.SetControllerReference(migration, job, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Creating volume migration Job", "Job.Name", job.Name, "Node", migration.Status.Node)
+	if err := r.Create(ctx, job); client.IgnoreAlreadyExists(err) != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *VolumeMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.VolumeMigration{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
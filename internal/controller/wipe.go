@@ -0,0 +1,221 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+// driveWipeScript wipes a device of the host, whose /dev is mounted as /host. The device must
+// still hold the filesystem of the released drive, device names are not stable across reboots.
+// An empty filesystem UUID would match any device without a filesystem and is refused.
+const driveWipeScript = `set -e
+device="/host/$1"
+if [ -z "$2" ]; then
+  echo "the filesystem UUID of the released drive on $device is unknown" >&2
+  exit 1
+fi
+if [ "$(blkid -s UUID -o value "$device")" != "$2" ]; then
+  echo "$device does not hold the filesystem $2 of the released drive" >&2
+  exit 1
+fi
+case "$3" in
+Quick)
+  wipefs --all "$device"
+  blkdiscard "$device" || echo "$device does not support discards"
+  ;;
+Secure)
+  shred --iterations=1 --zero --verbose "$device"
+  ;;
+esac`
+
+// wipePolicyForDeployer returns the wipe policy of the released drives, None by default
+func wipePolicyForDeployer(deployer *cachev1alpha1.Deployer) cachev1alpha1.WipePolicy {
+	if deployer.Spec.Drives == nil || deployer.Spec.Drives.WipePolicy == "" {
+		return cachev1alpha1.WipePolicyNone
+	}
+	return deployer.Spec.Drives.WipePolicy
+}
+
+// driveWipeForDeployer returns the pending wipe of the drive according to the wipe policy of
+// the Deployer, nil when it is not wiped. The wipe fails without the filesystem UUID of the
+// drive since the device could not be identified.
+func driveWipeForDeployer(deployer *cachev1alpha1.Deployer, drive *unstructured.Unstructured) *cachev1alpha1.DriveWipeStatus {
+	policy := wipePolicyForDeployer(deployer)
+	if policy == cachev1alpha1.WipePolicyNone {
+		return nil
+	}
+	fsuuid, _, _ := unstructured.NestedString(drive.Object, "status", "fsuuid")
+	wipe := &cachev1alpha1.DriveWipeStatus{
+		Drive:  drive.GetName(),
+		Node:   drive.GetLabels()["directpv.min.io/node"],
+		Device: drive.GetLabels()["directpv.min.io/drive-name"],
+		FSUUID: fsuuid,
+		Policy: policy,
+		State:  cachev1alpha1.DriveWipeStatePending,
+	}
+	if fsuuid == "" {
+		wipe.State = cachev1alpha1.DriveWipeStateFailed
+		wipe.Message = "The drive has no filesystem UUID to identify its device"
+	}
+	return wipe
+}
+
+// driveWipeJobName returns the name of the wipe Job of the drive, truncated with a hash of the
+// whole drive identifier to keep the name unique within the limits of the pod labels
+func driveWipeJobName(deployer *cachev1alpha1.Deployer, driveID string) string {
+	return truncatedName(fmt.Sprintf("%s-wipe-%s", deployer.Name, driveID), maxJobNameLength)
+}
+
+// wipeDrive runs the wipe of a released drive with a Job owned by the given object and updates
+// the state of the wipe from the Job. The drive must have been deleted by DirectPV, its
+// device is then unmounted.
+func wipeDrive(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object,
+	deployer *cachev1alpha1.Deployer, wipe *cachev1alpha1.DriveWipeStatus, defaultImage string) error {
+	if wipe.State != cachev1alpha1.DriveWipeStatePending {
+		return nil
+	}
+	if wipe.FSUUID == "" {
+		wipe.State = cachev1alpha1.DriveWipeStateFailed
+		wipe.Message = "The drive has no filesystem UUID to identify its device"
+		return nil
+	}
+	drive := &unstructured.Unstructured{}
+	drive.SetGroupVersionKind(directPVDriveGVK)
+	err := c.Get(ctx, types.NamespacedName{Name: wipe.Drive}, drive)
+	if err == nil {
+		// Not released yet
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	job := &batchv1.Job{}
+	name := driveWipeJobName(deployer, wipe.Drive)
+	err = c.Get(ctx, types.NamespacedName{Name: name, Namespace: deployer.Namespace}, job)
+	if apierrors.IsNotFound(err) {
+		image, err := imageForNode(ctx, c, deployer, wipe.Node, defaultImage)
+		if err != nil {
+			return err
+		}
+		job = nodeJobForDeployer(deployer, name, "directpv-wipe", wipe.Node, image, "/dev",
+			corev1.MountPropagationNone, []string{"/bin/sh", "-c", driveWipeScript, "wipe", wipe.Device, wipe.FSUUID, string(wipe.Policy)})
+		if err := ctrl.SetControllerReference(owner, job, scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Creating drive wipe Job", "Job.Name", job.Name, "Node", wipe.Node, "Device", wipe.Device)
+		return c.Create(ctx, job)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case jobConditionTrue(job, batchv1.JobComplete):
+		wipe.State = cachev1alpha1.DriveWipeStateCompleted
+		now := metav1.Now()
+		wipe.CompletionTime = &now
+	case jobConditionTrue(job, batchv1.JobFailed):
+		wipe.State = cachev1alpha1.DriveWipeStateFailed
+		wipe.Message = "The wipe Job failed"
+	default:
+		return nil
+	}
+	propagation := metav1.DeletePropagationBackground
+	return client.IgnoreNotFound(c.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation}))
+}
+
+// recordDriveWipes records the pending wipes of the drives released by the uninstallation in
+// the status of the Deployer, before the drives are deleted
+func (r *DeployerReconciler) recordDriveWipes(ctx context.Context, deployer *cachev1alpha1.Deployer,
+	drives []*unstructured.Unstructured) error {
+	recorded := map[string]bool{}
+	for _, wipe := range deployer.Status.DriveWipes {
+		recorded[wipe.Drive] = true
+	}
+	changed := false
+	for _, drive := range drives {
+		if recorded[drive.GetName()] {
+			continue
+		}
+		if wipe := driveWipeForDeployer(deployer, drive); wipe != nil {
+			deployer.Status.DriveWipes = append(deployer.Status.DriveWipes, *wipe)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return r.Status().Update(ctx, deployer)
+}
+
+// wipeReleasedDrives wipes the drives released by the uninstallation. It reports whether all
+// the wipes completed, the wipes are skipped once the wipe policy is set to None.
+func (r *DeployerReconciler) wipeReleasedDrives(ctx context.Context, deployer *cachev1alpha1.Deployer) (bool, error) {
+	if wipePolicyForDeployer(deployer) == cachev1alpha1.WipePolicyNone || len(deployer.Status.DriveWipes) == 0 {
+		return true, nil
+	}
+	defaultImage, err := r.imageForDeployer(deployer)
+	if err != nil {
+		return false, err
+	}
+
+	before := deployer.Status.DeepCopy()
+	done := true
+	var failed []string
+	for i := range deployer.Status.DriveWipes {
+		wipe := &deployer.Status.DriveWipes[i]
+		if err := wipeDrive(ctx, r.Client, r.Scheme, deployer, deployer, wipe, defaultImage); err != nil {
+			return false, err
+		}
+		switch wipe.State {
+		case cachev1alpha1.DriveWipeStatePending:
+			done = false
+		case cachev1alpha1.DriveWipeStateFailed:
+			failed = append(failed, wipe.Node+"/"+wipe.Device)
+		}
+	}
+	if !reflect.DeepEqual(deployer.Status.DriveWipes, before.DriveWipes) {
+		if err := r.Status().Update(ctx, deployer); err != nil {
+			return false, err
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return false, fmt.Errorf("wiping the drives %s failed, check the logs of the wipe Jobs "+
+			"or set spec.drives.wipePolicy to None to skip it", strings.Join(failed, ", "))
+	}
+	return done, nil
+}
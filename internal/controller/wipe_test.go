@@ -0,0 +1,43 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/directpv-operator/api/v1alpha1"
+)
+
+func TestDriveWipeJobName(t *testing.T) {
+	deployer := &cachev1alpha1.Deployer{ObjectMeta: metav1.ObjectMeta{Name: "directpv"}}
+	if got, want := driveWipeJobName(deployer, "d2f1"), "directpv-wipe-d2f1"; got != want {
+		t.Errorf("driveWipeJobName() = %q, want %q", got, want)
+	}
+
+	// Drive identifiers sharing their first characters must not share the wipe Job
+	first := driveWipeJobName(deployer, "7d2f1c3a-0b6e-4a8f-9c1d-5e2b7a9f0c11")
+	second := driveWipeJobName(deployer, "7d2f1c3a-0b6e-4a8f-9c1d-5e2b7a9f0c12")
+	if first == second {
+		t.Errorf("driveWipeJobName() = %q for two drives", first)
+	}
+	deployer.Name = "directpv-with-a-rather-long-deployer-name"
+	if got := driveWipeJobName(deployer, "7d2f1c3a-0b6e-4a8f-9c1d-5e2b7a9f0c11"); len(got) > maxJobNameLength {
+		t.Errorf("driveWipeJobName() = %q is longer than %d characters", got, maxJobNameLength)
+	}
+}